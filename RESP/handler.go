@@ -47,11 +47,11 @@ func (h *RESPHandler) Handle(value RESPValue) Reply {
             // 检查参数数量是否正确
             return &ErrorReply{Value: "ERR wrong number of arguments for 'get' command"}
         }
-        // 查找并返回键对应的值，如果不存在则返回空字符串
+        // 查找并返回键对应的值，如果不存在则返回 nil
         if val, ok := h.data[value.Array[1].Str]; ok {
             return &BulkStringReply{Value: val}
         }
-        return &BulkStringReply{Value: ""}
+        return &NullBulkReply{}
     default:
         // 未知命令：返回错误信息
         return &ErrorReply{Value: fmt.Sprintf("ERR unknown command '%s'", command)}
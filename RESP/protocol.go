@@ -2,9 +2,11 @@ package resp
 
 import (
     "bufio"
+    "bytes"
     "errors"
     "fmt"
     "io"
+    "math"
     "strconv"
 )
 
@@ -15,134 +17,340 @@ const (
     Integer      = ':'
     BulkString   = '$'
     Array        = '*'
+
+    // RESP3 新增的类型
+    Null           = '_'
+    Double         = ','
+    Boolean        = '#'
+    BigNumber      = '('
+    VerbatimString = '='
+    Map            = '%'
+    Set            = '~'
+    Attribute      = '|'
+    Push           = '>'
+)
+
+// 协议的长度限制
+const (
+    PROTO_INLINE_MAX_SIZE   = 64 * 1024              // 内联命令和长度行的最大长度
+    PROTO_MAX_BULK_LEN      = 512 * 1024 * 1024      // proto-max-bulk-len 的默认值
+    PROTO_MAX_BULK_LEN_MAX  = 4 * 1024 * 1024 * 1024 // proto-max-bulk-len 允许设置的最大值
+    PROTO_MAX_MULTIBULK_LEN = math.MaxInt32          // 数组的最大元素数量
+    PROTO_MBULK_BIG_ARG     = 32 * 1024              // 超过这个长度的批量字符串随着数据到达逐步分配内存
+    protoMaxPrealloc        = 1024                   // 数组预先分配的最大元素数量
 )
 
 // 常见错误
 var (
     ErrInvalidSyntax = errors.New("invalid RESP syntax")
     ErrUnexpectedEOF = errors.New("unexpected end of input")
+    ErrLineTooLong   = errors.New("line too long")
 )
 
+// ProtocolError 表示客户端发送的请求不符合协议，服务器回复错误后关闭连接
+type ProtocolError struct {
+    Msg string
+}
+
+func (e *ProtocolError) Error() string {
+    return "Protocol error: " + e.Msg
+}
+
+// Limits 是解析时接受的最大长度，超过时返回错误，不会按对方声明的长度分配内存
+type Limits struct {
+    MaxBulkLen      int64 // 批量字符串的最大长度，对应 proto-max-bulk-len
+    MaxMultibulkLen int64 // 数组、Map 等聚合类型的最大元素数量
+}
+
+// DefaultLimits 是 Redis 的默认限制
+var DefaultLimits = Limits{MaxBulkLen: PROTO_MAX_BULK_LEN, MaxMultibulkLen: PROTO_MAX_MULTIBULK_LEN}
+
 // RESPValue 表示一个 RESP 值
+// Map 和 Attribute 的键值依次存放在 Array 中，Verbatim string 的格式（如 txt）存放在 Format 中
+// RESP2 的 $-1 和 *-1 设置 IsNull，与空字符串和空数组区分
 type RESPValue struct {
-    Type  byte
-    Str   string
-    Num   int64
-    Array []RESPValue
+    Type   byte
+    Str    string
+    Num    int64
+    Float  float64
+    Format string
+    Array  []RESPValue
+    IsNull bool
 }
 
-// ParseRESP 从 reader 中解析一个 RESP 值
+// ParseRESP 从 reader 中解析一个 RESP 值，使用默认的长度限制
 func ParseRESP(reader *bufio.Reader) (RESPValue, error) {
+    return ParseRESPWithLimits(reader, DefaultLimits)
+}
+
+// ParseRESPWithLimits 从 reader 中解析一个 RESP 值，长度超过 limits 时返回 ErrInvalidSyntax
+func ParseRESPWithLimits(reader *bufio.Reader, limits Limits) (RESPValue, error) {
     typ, err := reader.ReadByte()
     if err != nil {
         return RESPValue{}, fmt.Errorf("read type byte: %w", err)
     }
 
     switch typ {
-    case SimpleString, Error:
+    case SimpleString, Error, BigNumber:
         return parseLineResp(typ, reader)
     case Integer:
         return parseIntegerResp(reader)
     case BulkString:
-        return parseBulkStringResp(reader)
+        return parseBulkStringResp(reader, limits)
     case Array:
-        return parseArrayResp(reader)
+        return parseAggregateResp(typ, reader, limits, 1)
+    case Null:
+        line, err := readLine(reader)
+        if err != nil {
+            return RESPValue{}, fmt.Errorf("read null: %w", err)
+        }
+        if line != "" {
+            return RESPValue{}, fmt.Errorf("%w: invalid null", ErrInvalidSyntax)
+        }
+        return RESPValue{Type: Null}, nil
+    case Double:
+        return parseDoubleResp(reader)
+    case Boolean:
+        return parseBooleanResp(reader)
+    case VerbatimString:
+        return parseVerbatimResp(reader, limits)
+    case Map, Attribute:
+        return parseAggregateResp(typ, reader, limits, 2)
+    case Set, Push:
+        return parseAggregateResp(typ, reader, limits, 1)
     default:
-        return RESPValue{}, fmt.Errorf("%w: unknown type %c", ErrInvalidSyntax, typ)
+        return RESPValue{}, fmt.Errorf("%w: unknown type %q", ErrInvalidSyntax, typ)
+    }
+}
+
+// 读取以 CRLF 结尾的一行并去掉 CRLF。行超过 PROTO_INLINE_MAX_SIZE 时返回 ErrLineTooLong，
+// 对方不发送换行符也不会无限制地读取。
+func readLine(reader *bufio.Reader) (string, error) {
+    line, err := readRawLine(reader)
+    if err != nil {
+        return "", err
+    }
+    if len(line) < 2 || line[len(line)-2] != '\r' {
+        return "", fmt.Errorf("%w: line not terminated by CRLF", ErrInvalidSyntax)
+    }
+    return string(line[:len(line)-2]), nil
+}
+
+// 读取到换行符为止的一行，包含换行符。
+func readRawLine(reader *bufio.Reader) ([]byte, error) {
+    var line []byte
+    for {
+        chunk, err := reader.ReadSlice('\n')
+        if len(line)+len(chunk) > PROTO_INLINE_MAX_SIZE {
+            return nil, ErrLineTooLong
+        }
+        line = append(line, chunk...)
+        if err == bufio.ErrBufferFull {
+            continue
+        }
+        if err != nil {
+            if err == io.EOF && len(line) > 0 {
+                err = ErrUnexpectedEOF
+            }
+            return nil, err
+        }
+        return line, nil
+    }
+}
+
+// 读取长度行并解析为整数，长度为 -1 表示空值，不能超过 max。
+func readLength(reader *bufio.Reader, max int64) (int64, error) {
+    line, err := readLine(reader)
+    if err != nil {
+        return 0, err
+    }
+    length, err := strconv.ParseInt(line, 10, 64)
+    if err != nil || length < -1 || length > max {
+        return 0, fmt.Errorf("%w: invalid length %q", ErrInvalidSyntax, line)
+    }
+    return length, nil
+}
+
+// 读取 length 字节的批量数据和之后的 CRLF。较长的数据随着到达逐步分配内存，
+// 对方声明了很大的长度却不发送数据时不会一次分配全部内存。
+func readBulk(reader *bufio.Reader, length int64) ([]byte, error) {
+    var buf []byte
+    if length <= PROTO_MBULK_BIG_ARG {
+        buf = make([]byte, length+2)
+        if _, err := io.ReadFull(reader, buf); err != nil {
+            return nil, unexpectedEOF(err)
+        }
+    } else {
+        var b bytes.Buffer
+        b.Grow(PROTO_MBULK_BIG_ARG)
+        if _, err := io.CopyN(&b, reader, length+2); err != nil {
+            return nil, unexpectedEOF(err)
+        }
+        buf = b.Bytes()
     }
+    if buf[length] != '\r' || buf[length+1] != '\n' {
+        return nil, fmt.Errorf("%w: bulk string not terminated by CRLF", ErrInvalidSyntax)
+    }
+    return buf[:length], nil
+}
+
+// 数据读到一半遇到 EOF 时返回 ErrUnexpectedEOF。
+func unexpectedEOF(err error) error {
+    if err == io.EOF || err == io.ErrUnexpectedEOF {
+        return ErrUnexpectedEOF
+    }
+    return err
 }
 
 func parseLineResp(typ byte, reader *bufio.Reader) (RESPValue, error) {
-    line, err := reader.ReadString('\n')
+    line, err := readLine(reader)
     if err != nil {
         return RESPValue{}, fmt.Errorf("read line: %w", err)
     }
-    return RESPValue{Type: typ, Str: line[:len(line)-2]}, nil
+    return RESPValue{Type: typ, Str: line}, nil
 }
 
 func parseIntegerResp(reader *bufio.Reader) (RESPValue, error) {
-    line, err := reader.ReadString('\n')
+    line, err := readLine(reader)
     if err != nil {
         return RESPValue{}, fmt.Errorf("read integer line: %w", err)
     }
-    num, err := strconv.ParseInt(line[:len(line)-2], 10, 64)
+    num, err := strconv.ParseInt(line, 10, 64)
     if err != nil {
-        return RESPValue{}, fmt.Errorf("parse integer: %w", err)
+        return RESPValue{}, fmt.Errorf("%w: parse integer: %v", ErrInvalidSyntax, err)
     }
     return RESPValue{Type: Integer, Num: num}, nil
 }
 
-func parseBulkStringResp(reader *bufio.Reader) (RESPValue, error) {
-    line, err := reader.ReadString('\n')
+func parseBulkStringResp(reader *bufio.Reader, limits Limits) (RESPValue, error) {
+    length, err := readLength(reader, limits.MaxBulkLen)
     if err != nil {
         return RESPValue{}, fmt.Errorf("read bulk string length: %w", err)
     }
-    length, err := strconv.Atoi(line[:len(line)-2])
-    if err != nil {
-        return RESPValue{}, fmt.Errorf("parse bulk string length: %w", err)
-    }
     if length == -1 {
-        return RESPValue{Type: BulkString, Str: ""}, nil
+        return RESPValue{Type: BulkString, IsNull: true}, nil
     }
-    buf := make([]byte, length+2) // +2 for CRLF
-    _, err = io.ReadFull(reader, buf)
+    buf, err := readBulk(reader, length)
     if err != nil {
         return RESPValue{}, fmt.Errorf("read bulk string content: %w", err)
     }
-    return RESPValue{Type: BulkString, Str: string(buf[:length])}, nil
+    return RESPValue{Type: BulkString, Str: string(buf)}, nil
 }
 
-func parseArrayResp(reader *bufio.Reader) (RESPValue, error) {
-    line, err := reader.ReadString('\n')
+// 解析数组、Map、Set、Attribute 和 Push，Map 和 Attribute 的每个元素是一对键值
+func parseAggregateResp(typ byte, reader *bufio.Reader, limits Limits, itemsPerEntry int64) (RESPValue, error) {
+    length, err := readLength(reader, limits.MaxMultibulkLen)
     if err != nil {
-        return RESPValue{}, fmt.Errorf("read array length: %w", err)
-    }
-    length, err := strconv.Atoi(line[:len(line)-2])
-    if err != nil {
-        return RESPValue{}, fmt.Errorf("parse array length: %w", err)
+        return RESPValue{}, fmt.Errorf("read aggregate length: %w", err)
     }
     if length == -1 {
-        return RESPValue{Type: Array, Array: nil}, nil
+        if typ != Array {
+            return RESPValue{}, fmt.Errorf("%w: invalid length -1", ErrInvalidSyntax)
+        }
+        return RESPValue{Type: Array, IsNull: true}, nil
     }
-    array := make([]RESPValue, length)
-    for i := 0; i < length; i++ {
-        value, err := ParseRESP(reader)
+    length *= itemsPerEntry
+    array := make([]RESPValue, 0, min(length, protoMaxPrealloc))
+    for i := int64(0); i < length; i++ {
+        value, err := ParseRESPWithLimits(reader, limits)
         if err != nil {
-            return RESPValue{}, fmt.Errorf("parse array element %d: %w", i, err)
+            return RESPValue{}, fmt.Errorf("parse element %d: %w", i, err)
         }
-        array[i] = value
+        array = append(array, value)
+    }
+    return RESPValue{Type: typ, Array: array}, nil
+}
+
+func parseDoubleResp(reader *bufio.Reader) (RESPValue, error) {
+    line, err := readLine(reader)
+    if err != nil {
+        return RESPValue{}, fmt.Errorf("read double line: %w", err)
+    }
+    num, err := strconv.ParseFloat(line, 64)
+    if err != nil && !errors.Is(err, strconv.ErrRange) {
+        return RESPValue{}, fmt.Errorf("%w: parse double: %v", ErrInvalidSyntax, err)
+    }
+    return RESPValue{Type: Double, Str: line, Float: num}, nil
+}
+
+func parseBooleanResp(reader *bufio.Reader) (RESPValue, error) {
+    line, err := readLine(reader)
+    if err != nil {
+        return RESPValue{}, fmt.Errorf("read boolean line: %w", err)
+    }
+    switch line {
+    case "t":
+        return RESPValue{Type: Boolean, Num: 1}, nil
+    case "f":
+        return RESPValue{Type: Boolean, Num: 0}, nil
+    }
+    return RESPValue{}, fmt.Errorf("%w: invalid boolean", ErrInvalidSyntax)
+}
+
+// Verbatim string 的内容以三个字符的格式和冒号开头，例如 txt:
+func parseVerbatimResp(reader *bufio.Reader, limits Limits) (RESPValue, error) {
+    value, err := parseBulkStringResp(reader, limits)
+    if err != nil {
+        return RESPValue{}, err
+    }
+    if value.IsNull || len(value.Str) < 4 || value.Str[3] != ':' {
+        return RESPValue{}, fmt.Errorf("%w: invalid verbatim string", ErrInvalidSyntax)
     }
-    return RESPValue{Type: Array, Array: array}, nil
+    return RESPValue{Type: VerbatimString, Format: value.Str[:3], Str: value.Str[4:]}, nil
 }
 
-// WriteTo 将 RESPValue 写入 io.Writer
+// WriteTo 将 RESPValue 写入 io.Writer，编码到池中的缓冲区后一次写入
 func (v RESPValue) WriteTo(w io.Writer) (int64, error) {
-    var total int64
+    bp := GetBuffer()
+    defer PutBuffer(bp)
+    b, err := v.AppendRESP((*bp)[:0])
+    *bp = b
+    if err != nil {
+        return 0, err
+    }
+    n, err := w.Write(b)
+    return int64(n), err
+}
+
+// AppendRESP 把 RESPValue 编码后追加到 dst
+func (v RESPValue) AppendRESP(dst []byte) ([]byte, error) {
+    if v.IsNull && (v.Type == BulkString || v.Type == Array) {
+        return appendPrefixedInt(dst, v.Type, -1), nil
+    }
     switch v.Type {
-    case SimpleString, Error:
-        n, err := fmt.Fprintf(w, "%c%s\r\n", v.Type, v.Str)
-        return int64(n), err
+    case SimpleString, Error, BigNumber:
+        return appendLine(dst, v.Type, v.Str), nil
     case Integer:
-        n, err := fmt.Fprintf(w, "%c%d\r\n", v.Type, v.Num)
-        return int64(n), err
+        return AppendInteger(dst, v.Num), nil
     case BulkString:
-        n, err := fmt.Fprintf(w, "%c%d\r\n%s\r\n", v.Type, len(v.Str), v.Str)
-        return int64(n), err
-    case Array:
-        n, err := fmt.Fprintf(w, "%c%d\r\n", v.Type, len(v.Array))
-        total += int64(n)
-        if err != nil {
-            return total, err
+        return AppendBulkString(dst, v.Str), nil
+    case Null:
+        return append(dst, "_\r\n"...), nil
+    case Double:
+        return AppendDouble(dst, v.Float), nil
+    case Boolean:
+        return (&BooleanReply{Value: v.Num != 0}).AppendRESP(dst), nil
+    case VerbatimString:
+        return AppendVerbatim(dst, v.Format, v.Str), nil
+    case Array, Map, Set, Attribute, Push:
+        length := len(v.Array)
+        if v.Type == Map || v.Type == Attribute {
+            length /= 2
         }
+        dst = AppendAggregateLen(dst, v.Type, length)
         for _, item := range v.Array {
-            n, err := item.WriteTo(w)
-            total += n
-            if err != nil {
-                return total, err
+            var err error
+            if dst, err = item.AppendRESP(dst); err != nil {
+                return dst, err
             }
         }
-        return total, nil
+        return dst, nil
     default:
-        return 0, fmt.Errorf("%w: unknown type %c", ErrInvalidSyntax, v.Type)
+        return dst, fmt.Errorf("%w: unknown type %c", ErrInvalidSyntax, v.Type)
     }
 }
+
+// FormatDouble 按 Redis 的格式输出浮点数：无穷大为 inf 和 -inf，其他为最短的十进制表示
+func FormatDouble(f float64) string {
+    return string(appendDoubleValue(nil, f))
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+    "bufio"
+    "bytes"
+    "testing"
+)
+
+// 任意输入都不能让 ParseRESPWithLimits panic；解析成功的值可以编码，编码结果再次解析和编码后保持不变。
+func FuzzParseRESP(f *testing.F) {
+    for _, seed := range []string{
+        "+OK\r\n",
+        "-ERR bad\r\n",
+        ":-42\r\n",
+        "$5\r\nhello\r\n",
+        "$0\r\n\r\n",
+        "$-1\r\n",
+        "*2\r\n$1\r\na\r\n:1\r\n",
+        "*-1\r\n",
+        "_\r\n",
+        ",3.14\r\n",
+        ",-inf\r\n",
+        ",nan\r\n",
+        "#t\r\n",
+        "(12345678901234567890\r\n",
+        "=8\r\ntxt:abcd\r\n",
+        "%1\r\n+k\r\n*1\r\n_\r\n",
+        "~2\r\n:1\r\n:2\r\n",
+        "|1\r\n+a\r\n+b\r\n",
+        ">2\r\n$7\r\nmessage\r\n$1\r\nx\r\n",
+        "$9223372036854775807\r\n",
+        "*9223372036854775807\r\n",
+    } {
+        f.Add([]byte(seed))
+    }
+    limits := Limits{MaxBulkLen: 1 << 20, MaxMultibulkLen: 1 << 10}
+    f.Fuzz(func(t *testing.T, data []byte) {
+        v, err := ParseRESPWithLimits(bufio.NewReader(bytes.NewReader(data)), limits)
+        if err != nil {
+            return
+        }
+        enc, err := v.AppendRESP(nil)
+        if err != nil {
+            t.Fatalf("encoding value parsed from %q: %v", data, err)
+        }
+        v2, err := ParseRESPWithLimits(bufio.NewReader(bytes.NewReader(enc)), limits)
+        if err != nil {
+            t.Fatalf("re-parsing %q: %v", enc, err)
+        }
+        enc2, err := v2.AppendRESP(nil)
+        if err != nil || !bytes.Equal(enc, enc2) {
+            t.Fatalf("encoding is not stable: %q then %q (%v)", enc, enc2, err)
+        }
+    })
+}
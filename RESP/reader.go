@@ -0,0 +1,233 @@
+package main
+
+import (
+    "bytes"
+    "fmt"
+    "io"
+    "math"
+    "strings"
+)
+
+// 读缓冲区的初始大小，与 Redis 的 PROTO_IOBUF_LEN 相同
+const PROTO_IOBUF_LEN = 16 * 1024
+
+// Reader 读取客户端发送的命令。以 * 开头的是 RESP 数组，元素必须是批量字符串；
+// 其他为内联命令，按 splitArgs 的规则拆分参数。
+// 数组形式的命令的参数以 []byte 返回，指向 Reader 内部的读缓冲区，在下一次调用 ReadCommand 之前有效，
+// 读取命令时不为每个参数分配内存。
+type Reader struct {
+    Limits Limits // 参数长度和数量的上限
+
+    rd   io.Reader
+    buf  []byte // buf[r:w] 是已经读入还没有解析的数据
+    r, w int
+    offs []int    // 正在解析的命令中每个参数在 buf 中的起止位置
+    args [][]byte // 上一次返回的参数，重复使用
+}
+
+// NewReader 创建一个从 rd 读取命令的 Reader，使用默认的长度限制
+func NewReader(rd io.Reader) *Reader {
+    return &Reader{Limits: DefaultLimits, rd: rd, buf: make([]byte, PROTO_IOBUF_LEN)}
+}
+
+// ReadCommand 读取一条命令。请求不符合协议时返回 *ProtocolError，空的请求返回没有元素的参数列表。
+func (r *Reader) ReadCommand() ([][]byte, error) {
+    r.compact()
+    if err := r.need(r.r + 1); err != nil {
+        return nil, err
+    }
+    if r.buf[r.r] != Array {
+        return r.readInline()
+    }
+    count, pos, err := r.readLength(r.r+1, r.Limits.MaxMultibulkLen, "mbulk count", "multibulk length")
+    if err != nil {
+        return nil, err
+    }
+    r.offs = r.offs[:0]
+    for i := int64(0); i < count; i++ {
+        if err := r.need(pos + 1); err != nil {
+            return nil, err
+        }
+        if r.buf[pos] != BulkString {
+            return nil, &ProtocolError{Msg: fmt.Sprintf("expected '$', got '%c'", r.buf[pos])}
+        }
+        length, next, err := r.readLength(pos+1, r.Limits.MaxBulkLen, "bulk count", "bulk length")
+        if err != nil {
+            return nil, err
+        }
+        // 长度来自客户端，先确认加上当前位置不会溢出
+        if length < 0 || length > int64(math.MaxInt-2-next) {
+            return nil, &ProtocolError{Msg: "invalid bulk length"}
+        }
+        end := next + int(length)
+        if err := r.need(end + 2); err != nil {
+            return nil, err
+        }
+        if r.buf[end] != '\r' || r.buf[end+1] != '\n' {
+            return nil, &ProtocolError{Msg: "invalid bulk terminator"}
+        }
+        r.offs = append(r.offs, next, end)
+        pos = end + 2
+    }
+    r.r = pos
+    // 解析过程中缓冲区可能扩容，参数的位置确定之后再取出切片
+    r.args = r.args[:0]
+    for i := 0; i < len(r.offs); i += 2 {
+        r.args = append(r.args, r.buf[r.offs[i]:r.offs[i+1]:r.offs[i+1]])
+    }
+    return r.args, nil
+}
+
+// 读取一行内联命令，行尾可以是 CRLF 或者只有 LF。内联命令很少使用，参数直接分配内存。
+func (r *Reader) readInline() ([][]byte, error) {
+    start, end, err := r.readLine(r.r)
+    if err == ErrLineTooLong {
+        return nil, &ProtocolError{Msg: "too big inline request"}
+    }
+    if err != nil {
+        return nil, err
+    }
+    r.r = end + 1
+    args, err := splitArgs(string(bytes.TrimRight(r.buf[start:end], "\r")))
+    if err != nil {
+        return nil, &ProtocolError{Msg: "unbalanced quotes in request"}
+    }
+    r.args = r.args[:0]
+    for _, arg := range args {
+        r.args = append(r.args, []byte(arg))
+    }
+    return r.args, nil
+}
+
+// 读取从 pos 开始、以 CRLF 结尾的长度行，返回长度和下一行的位置，出错时返回 Redis 的协议错误。
+func (r *Reader) readLength(pos int, max int64, countName, lengthName string) (int64, int, error) {
+    start, end, err := r.readLine(pos)
+    if err == ErrLineTooLong {
+        return 0, 0, &ProtocolError{Msg: "too big " + countName + " string"}
+    }
+    if err != nil {
+        return 0, 0, err
+    }
+    if end == start || r.buf[end-1] != '\r' {
+        return 0, 0, &ProtocolError{Msg: "invalid " + lengthName}
+    }
+    n, ok := parseInt(r.buf[start : end-1])
+    if !ok || n > max {
+        return 0, 0, &ProtocolError{Msg: "invalid " + lengthName}
+    }
+    return n, end + 1, nil
+}
+
+// 找到从 pos 开始的一行，返回这一行的起始位置和换行符的位置。
+// 一行超过 PROTO_INLINE_MAX_SIZE 还没有换行符时返回 ErrLineTooLong。
+func (r *Reader) readLine(pos int) (int, int, error) {
+    searched := pos
+    for {
+        if i := bytes.IndexByte(r.buf[searched:r.w], '\n'); i >= 0 {
+            return pos, searched + i, nil
+        }
+        if r.w-pos > PROTO_INLINE_MAX_SIZE {
+            return 0, 0, ErrLineTooLong
+        }
+        searched = r.w
+        if err := r.fill(); err != nil {
+            return 0, 0, err
+        }
+    }
+}
+
+// 读入数据直到 buf[:n] 都已经读入。
+func (r *Reader) need(n int) error {
+    for r.w < n {
+        if err := r.fill(); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// 从 rd 读入更多数据，缓冲区满时容量加倍。缓冲区随着数据到达逐步扩大，
+// 对方声明了很大的长度却不发送数据时不会一次分配全部内存。
+func (r *Reader) fill() error {
+    if r.w == len(r.buf) {
+        buf := make([]byte, 2*len(r.buf))
+        copy(buf, r.buf[:r.w])
+        r.buf = buf
+    }
+    n, err := r.rd.Read(r.buf[r.w:])
+    r.w += n
+    if n > 0 {
+        return nil
+    }
+    if err == nil {
+        err = io.ErrNoProgress
+    }
+    if err == io.EOF && r.w > r.r {
+        err = ErrUnexpectedEOF // 命令读到一半连接关闭
+    }
+    return err
+}
+
+// 丢弃已经解析的数据。没有剩余数据时从头开始使用缓冲区，剩余空间不多时把剩余数据移到开头；
+// 读取大参数之后扩大的缓冲区在空闲时换回初始大小。
+func (r *Reader) compact() {
+    if r.r == r.w {
+        r.r, r.w = 0, 0
+    }
+    if len(r.buf) > PROTO_IOBUF_LEN && r.w-r.r <= PROTO_IOBUF_LEN/2 {
+        buf := make([]byte, PROTO_IOBUF_LEN)
+        r.w = copy(buf, r.buf[r.r:r.w])
+        r.buf, r.r = buf, 0
+        return
+    }
+    if r.r > 0 && len(r.buf)-r.w < PROTO_IOBUF_LEN/4 {
+        r.w = copy(r.buf, r.buf[r.r:r.w])
+        r.r = 0
+    }
+}
+
+// 按 Redis string2ll 的规则解析十进制整数，不分配内存
+func parseInt(b []byte) (int64, bool) {
+    if len(b) == 0 {
+        return 0, false
+    }
+    neg := b[0] == '-'
+    if neg {
+        b = b[1:]
+        if len(b) == 0 {
+            return 0, false
+        }
+    }
+    var n int64
+    for _, c := range b {
+        d := int64(c) - '0'
+        if d < 0 || d > 9 || n > (math.MaxInt64-d)/10 {
+            return 0, false
+        }
+        n = n*10 + d
+    }
+    if neg {
+        n = -n
+    }
+    return n, true
+}
+
+// ArgsToStrings 把 ReadCommand 返回的参数复制为字符串。所有参数共用一次分配的内存，
+// 复制之后可以继续读取下一条命令。
+func ArgsToStrings(args [][]byte) []string {
+    size := 0
+    for _, arg := range args {
+        size += len(arg)
+    }
+    var b strings.Builder
+    b.Grow(size)
+    for _, arg := range args {
+        b.Write(arg)
+    }
+    all := b.String()
+    strs := make([]string, len(args))
+    for i, arg := range args {
+        strs[i], all = all[:len(arg)], all[len(arg):]
+    }
+    return strs
+}
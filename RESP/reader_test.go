@@ -0,0 +1,125 @@
+package main
+
+import (
+    "bufio"
+    "bytes"
+    "errors"
+    "math"
+    "reflect"
+    "strings"
+    "testing"
+)
+
+// 接近 MaxInt64 的批量长度是协议错误，计算参数结束位置时不能溢出。
+func TestReadCommandHugeBulkLength(t *testing.T) {
+    for _, length := range []string{"9223372036854775807", "9223372036854775800", "4611686018427387904"} {
+        r := NewReader(strings.NewReader("*1\r\n$" + length + "\r\nabc"))
+        r.Limits.MaxBulkLen = math.MaxInt64
+        _, err := r.ReadCommand()
+        var perr *ProtocolError
+        if !errors.As(err, &perr) && err != ErrUnexpectedEOF {
+            t.Errorf("length %s: got %v, want a protocol error or unexpected EOF", length, err)
+        }
+    }
+}
+
+// 任意输入都不能让 ReadCommand panic；成功读出的参数重新编码为数组形式后读取，得到相同的参数。
+func FuzzReadCommand(f *testing.F) {
+    for _, seed := range []string{
+        "*1\r\n$4\r\nPING\r\n",
+        "*3\r\n$3\r\nSET\r\n$1\r\nk\r\n$0\r\n\r\n",
+        "*2\r\n$3\r\nGET\r\n$3\r\na\r\n\r\n*1\r\n$4\r\nPING\r\n",
+        "PING\r\n",
+        "SET k \"a b\\x41\" 'c d'\n",
+        "\r\n",
+        "*0\r\n",
+        "*-1\r\n",
+        "*1\r\n$-1\r\n",
+        "*1\r\n$3\r\nab",
+        "*1\r\n$9223372036854775807\r\nabc",
+        "*9223372036854775807\r\n",
+        "*1\r\n+OK\r\n",
+    } {
+        f.Add([]byte(seed))
+    }
+    f.Fuzz(func(t *testing.T, data []byte) {
+        r := NewReader(bytes.NewReader(data))
+        r.Limits.MaxBulkLen = math.MaxInt64
+        for {
+            args, err := r.ReadCommand()
+            if err != nil {
+                return
+            }
+            want := ArgsToStrings(args)
+            enc := AppendAggregateLen(nil, Array, len(args))
+            for _, arg := range args {
+                enc = AppendBulk(enc, arg)
+            }
+            got, err := NewReader(bytes.NewReader(enc)).ReadCommand()
+            if err != nil {
+                t.Fatalf("re-reading %q: %v", enc, err)
+            }
+            if !reflect.DeepEqual(ArgsToStrings(got), want) {
+                t.Fatalf("round trip of %q: got %q, want %q", enc, ArgsToStrings(got), want)
+            }
+        }
+    })
+}
+
+// 不断重复同一段数据的 io.Reader，用于基准测试中模拟持续到达的请求。
+type repeatReader struct {
+    data []byte
+    off  int
+}
+
+func (r *repeatReader) Read(p []byte) (int, error) {
+    n := 0
+    for n < len(p) {
+        c := copy(p[n:], r.data[r.off:])
+        n += c
+        r.off = (r.off + c) % len(r.data)
+    }
+    return n, nil
+}
+
+// 比较原来基于 bufio 的解析和 Reader 读取 SET 命令的开销，Copy 包含交给连接协程前的复制。
+func BenchmarkReadCommand(b *testing.B) {
+    cmd := []byte("*3\r\n$3\r\nSET\r\n$16\r\nkey:000000000001\r\n$32\r\n" + strings.Repeat("v", 32) + "\r\n")
+    b.Run("ParseRESP", func(b *testing.B) {
+        reader := bufio.NewReader(&repeatReader{data: cmd})
+        b.ReportAllocs()
+        b.SetBytes(int64(len(cmd)))
+        for i := 0; i < b.N; i++ {
+            v, err := ParseRESPWithLimits(reader, DefaultLimits)
+            if err != nil {
+                b.Fatal(err)
+            }
+            args := make([]string, len(v.Array))
+            for j, arg := range v.Array {
+                args[j] = arg.Str
+            }
+        }
+    })
+    b.Run("Reader", func(b *testing.B) {
+        r := NewReader(&repeatReader{data: cmd})
+        b.ReportAllocs()
+        b.SetBytes(int64(len(cmd)))
+        for i := 0; i < b.N; i++ {
+            if _, err := r.ReadCommand(); err != nil {
+                b.Fatal(err)
+            }
+        }
+    })
+    b.Run("ReaderCopy", func(b *testing.B) {
+        r := NewReader(&repeatReader{data: cmd})
+        b.ReportAllocs()
+        b.SetBytes(int64(len(cmd)))
+        for i := 0; i < b.N; i++ {
+            args, err := r.ReadCommand()
+            if err != nil {
+                b.Fatal(err)
+            }
+            ArgsToStrings(args)
+        }
+    })
+}
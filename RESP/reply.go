@@ -1,13 +1,24 @@
 package main
 
 import (
-    "fmt"
     "io"
 )
 
 // Reply 接口定义了所有 RESP 回复类型必须实现的方法
+// AppendRESP 把回复编码后追加到 dst，WriteTo 借助池中的缓冲区编码后一次写入
 type Reply interface {
     WriteTo(w io.Writer) (int64, error)
+    AppendRESP(dst []byte) []byte
+}
+
+// 把回复编码到池中的缓冲区后一次写入 w
+func writeReply(w io.Writer, r Reply) (int64, error) {
+    bp := GetBuffer()
+    b := r.AppendRESP((*bp)[:0])
+    n, err := w.Write(b)
+    *bp = b
+    PutBuffer(bp)
+    return int64(n), err
 }
 
 // 表示简单字符串回复
@@ -17,8 +28,12 @@ type SimpleStringReply struct {
 
 // 将简单字符串回复写入 io.Writer
 func (r *SimpleStringReply) WriteTo(w io.Writer) (int64, error) {
-    n, err := fmt.Fprintf(w, "+%s\r\n", r.Value)
-    return int64(n), err
+    return writeReply(w, r)
+}
+
+// 将简单字符串回复追加到 dst
+func (r *SimpleStringReply) AppendRESP(dst []byte) []byte {
+    return AppendSimpleString(dst, r.Value)
 }
 
 // 表示错误回复
@@ -28,8 +43,12 @@ type ErrorReply struct {
 
 // 将错误回复写入 io.Writer
 func (r *ErrorReply) WriteTo(w io.Writer) (int64, error) {
-    n, err := fmt.Fprintf(w, "-%s\r\n", r.Value)
-    return int64(n), err
+    return writeReply(w, r)
+}
+
+// 将错误回复追加到 dst
+func (r *ErrorReply) AppendRESP(dst []byte) []byte {
+    return AppendError(dst, r.Value)
 }
 
 // 表示整数回复
@@ -39,8 +58,12 @@ type IntegerReply struct {
 
 // 将整数回复写入 io.Writer
 func (r *IntegerReply) WriteTo(w io.Writer) (int64, error) {
-    n, err := fmt.Fprintf(w, ":%d\r\n", r.Value)
-    return int64(n), err
+    return writeReply(w, r)
+}
+
+// 将整数回复追加到 dst
+func (r *IntegerReply) AppendRESP(dst []byte) []byte {
+    return AppendInteger(dst, r.Value)
 }
 
 // 表示批量字符串回复
@@ -48,16 +71,27 @@ type BulkStringReply struct {
     Value string
 }
 
-// 将批量字符串回复写入 io.Writer
+// 将批量字符串回复写入 io.Writer，空字符串写作 $0，nil 使用 NullBulkReply
 func (r *BulkStringReply) WriteTo(w io.Writer) (int64, error) {
-    // 处理空字符串的特殊情况
-    if r.Value == "" {
-        n, err := w.Write([]byte("$-1\r\n"))
-        return int64(n), err
-    }
-    // 写入字符串长度和内容
-    n, err := fmt.Fprintf(w, "$%d\r\n%s\r\n", len(r.Value), r.Value)
-    return int64(n), err
+    return writeReply(w, r)
+}
+
+// 将批量字符串回复追加到 dst
+func (r *BulkStringReply) AppendRESP(dst []byte) []byte {
+    return AppendBulkString(dst, r.Value)
+}
+
+// 表示空的批量字符串回复，即 nil
+type NullBulkReply struct{}
+
+// 将空的批量字符串回复写入 io.Writer
+func (r *NullBulkReply) WriteTo(w io.Writer) (int64, error) {
+    return writeReply(w, r)
+}
+
+// 将空的批量字符串回复追加到 dst
+func (r *NullBulkReply) AppendRESP(dst []byte) []byte {
+    return append(dst, "$-1\r\n"...)
 }
 
 // 表示数组回复
@@ -67,18 +101,242 @@ type ArrayReply struct {
 
 // 将数组回复写入 io.Writer
 func (r *ArrayReply) WriteTo(w io.Writer) (int64, error) {
-    // 写入数组长度
-    n, err := fmt.Fprintf(w, "*%d\r\n", len(r.Value))
-    if err != nil {
-        return int64(n), err
+    return writeReply(w, r)
+}
+
+// 将数组回复追加到 dst
+func (r *ArrayReply) AppendRESP(dst []byte) []byte {
+    return appendAggregate(dst, Array, len(r.Value), r.Value)
+}
+
+// 表示 RESP3 的空值
+type NullReply struct{}
+
+// 将空值回复写入 io.Writer
+func (r *NullReply) WriteTo(w io.Writer) (int64, error) {
+    return writeReply(w, r)
+}
+
+// 将空值回复追加到 dst
+func (r *NullReply) AppendRESP(dst []byte) []byte {
+    return append(dst, "_\r\n"...)
+}
+
+// 表示空的数组回复，RESP2 中写作 *-1，RESP3 中与空值相同
+type NullArrayReply struct{}
+
+// 将空的数组回复写入 io.Writer
+func (r *NullArrayReply) WriteTo(w io.Writer) (int64, error) {
+    return writeReply(w, r)
+}
+
+// 将空的数组回复追加到 dst
+func (r *NullArrayReply) AppendRESP(dst []byte) []byte {
+    return append(dst, "_\r\n"...)
+}
+
+// 表示浮点数回复
+type DoubleReply struct {
+    Value float64
+}
+
+// 将浮点数回复写入 io.Writer
+func (r *DoubleReply) WriteTo(w io.Writer) (int64, error) {
+    return writeReply(w, r)
+}
+
+// 将浮点数回复追加到 dst
+func (r *DoubleReply) AppendRESP(dst []byte) []byte {
+    return AppendDouble(dst, r.Value)
+}
+
+// 表示布尔回复
+type BooleanReply struct {
+    Value bool
+}
+
+// 将布尔回复写入 io.Writer
+func (r *BooleanReply) WriteTo(w io.Writer) (int64, error) {
+    return writeReply(w, r)
+}
+
+// 将布尔回复追加到 dst
+func (r *BooleanReply) AppendRESP(dst []byte) []byte {
+    if r.Value {
+        return append(dst, "#t\r\n"...)
+    }
+    return append(dst, "#f\r\n"...)
+}
+
+// 表示大整数回复，Value 是十进制表示
+type BigNumberReply struct {
+    Value string
+}
+
+// 将大整数回复写入 io.Writer
+func (r *BigNumberReply) WriteTo(w io.Writer) (int64, error) {
+    return writeReply(w, r)
+}
+
+// 将大整数回复追加到 dst
+func (r *BigNumberReply) AppendRESP(dst []byte) []byte {
+    return appendLine(dst, BigNumber, r.Value)
+}
+
+// 表示带格式的字符串回复，Format 为三个字符，例如 txt 表示纯文本
+type VerbatimStringReply struct {
+    Format string
+    Value  string
+}
+
+// 将带格式的字符串回复写入 io.Writer
+func (r *VerbatimStringReply) WriteTo(w io.Writer) (int64, error) {
+    return writeReply(w, r)
+}
+
+// 将带格式的字符串回复追加到 dst
+func (r *VerbatimStringReply) AppendRESP(dst []byte) []byte {
+    return AppendVerbatim(dst, r.Format, r.Value)
+}
+
+// 表示字典回复，Value 中依次存放键和值
+type MapReply struct {
+    Value []Reply
+}
+
+// 将字典回复写入 io.Writer
+func (r *MapReply) WriteTo(w io.Writer) (int64, error) {
+    return writeReply(w, r)
+}
+
+// 将字典回复追加到 dst
+func (r *MapReply) AppendRESP(dst []byte) []byte {
+    return appendAggregate(dst, Map, len(r.Value)/2, r.Value)
+}
+
+// 表示集合回复
+type SetReply struct {
+    Value []Reply
+}
+
+// 将集合回复写入 io.Writer
+func (r *SetReply) WriteTo(w io.Writer) (int64, error) {
+    return writeReply(w, r)
+}
+
+// 将集合回复追加到 dst
+func (r *SetReply) AppendRESP(dst []byte) []byte {
+    return appendAggregate(dst, Set, len(r.Value), r.Value)
+}
+
+// 表示属性回复，它附加在紧随其后的回复之前，Value 中依次存放键和值
+type AttributeReply struct {
+    Value []Reply
+}
+
+// 将属性回复写入 io.Writer
+func (r *AttributeReply) WriteTo(w io.Writer) (int64, error) {
+    return writeReply(w, r)
+}
+
+// 将属性回复追加到 dst
+func (r *AttributeReply) AppendRESP(dst []byte) []byte {
+    return appendAggregate(dst, Attribute, len(r.Value)/2, r.Value)
+}
+
+// 表示推送消息，例如订阅频道收到的消息和客户端缓存的失效消息
+type PushReply struct {
+    Value []Reply
+}
+
+// 将推送消息写入 io.Writer
+func (r *PushReply) WriteTo(w io.Writer) (int64, error) {
+    return writeReply(w, r)
+}
+
+// 将推送消息追加到 dst
+func (r *PushReply) AppendRESP(dst []byte) []byte {
+    return appendAggregate(dst, Push, len(r.Value), r.Value)
+}
+
+// 追加聚合类型的头部和其中的元素
+func appendAggregate(dst []byte, typ byte, length int, items []Reply) []byte {
+    dst = AppendAggregateLen(dst, typ, length)
+    for _, reply := range items {
+        dst = reply.AppendRESP(dst)
+    }
+    return dst
+}
+
+// ToRESP2 转换 NullArrayReply 的结果，写作 *-1
+type nullArrayRESP2 struct{}
+
+func (r *nullArrayRESP2) WriteTo(w io.Writer) (int64, error) {
+    return writeReply(w, r)
+}
+
+func (r *nullArrayRESP2) AppendRESP(dst []byte) []byte {
+    return append(dst, "*-1\r\n"...)
+}
+
+// ToRESP2 把回复转换为 RESP2 客户端能够理解的形式：字典、集合和推送消息转换为数组，
+// 空值转换为空的批量字符串，浮点数和大整数转换为批量字符串，布尔值转换为 1 和 0。
+// 属性在 RESP2 中没有对应的类型，直接丢弃，此时返回 nil。不需要转换的数组原样返回。
+func ToRESP2(r Reply) Reply {
+    switch v := r.(type) {
+    case *NullReply:
+        return &NullBulkReply{}
+    case *NullArrayReply:
+        return &nullArrayRESP2{}
+    case *DoubleReply:
+        return &BulkStringReply{Value: FormatDouble(v.Value)}
+    case *BooleanReply:
+        if v.Value {
+            return &IntegerReply{Value: 1}
+        }
+        return &IntegerReply{Value: 0}
+    case *BigNumberReply:
+        return &BulkStringReply{Value: v.Value}
+    case *VerbatimStringReply:
+        return &BulkStringReply{Value: v.Value}
+    case *MapReply:
+        items, _ := itemsToRESP2(v.Value)
+        return &ArrayReply{Value: items}
+    case *SetReply:
+        items, _ := itemsToRESP2(v.Value)
+        return &ArrayReply{Value: items}
+    case *PushReply:
+        items, _ := itemsToRESP2(v.Value)
+        return &ArrayReply{Value: items}
+    case *ArrayReply:
+        if items, changed := itemsToRESP2(v.Value); changed {
+            return &ArrayReply{Value: items}
+        }
+        return v
+    case *AttributeReply:
+        return nil
     }
-    // 遍历并写入数组中的每个元素
-    for _, reply := range r.Value {
-        m, err := reply.WriteTo(w)
-        n += int(m)
-        if err != nil {
-            return int64(n), err
+    return r
+}
+
+// 转换聚合类型中的元素，没有元素需要转换时返回原来的切片，不分配内存
+func itemsToRESP2(items []Reply) ([]Reply, bool) {
+    var converted []Reply
+    for i, item := range items {
+        c := ToRESP2(item)
+        if converted == nil && c == item {
+            continue
         }
+        if converted == nil {
+            converted = make([]Reply, i, len(items))
+            copy(converted, items[:i])
+        }
+        if c != nil {
+            converted = append(converted, c)
+        }
+    }
+    if converted == nil {
+        return items, false
     }
-    return int64(n), nil
+    return converted, true
 }
@@ -0,0 +1,118 @@
+package main
+
+import (
+    "math"
+    "strconv"
+    "sync"
+)
+
+// 回复缓冲区的初始容量，与 Redis 的 PROTO_REPLY_CHUNK_BYTES 相同
+const PROTO_REPLY_CHUNK_BYTES = 16 * 1024
+
+// 放回缓冲池的缓冲区的最大容量，编码过大回复的缓冲区直接丢弃，不长期占用内存
+const protoMaxPooledBuffer = 64 * 1024
+
+var bufferPool = sync.Pool{
+    New: func() interface{} {
+        b := make([]byte, 0, PROTO_REPLY_CHUNK_BYTES)
+        return &b
+    },
+}
+
+// GetBuffer 从缓冲池中取出一个缓冲区，用完后调用 PutBuffer 放回
+func GetBuffer() *[]byte {
+    return bufferPool.Get().(*[]byte)
+}
+
+// PutBuffer 把缓冲区放回缓冲池，之后不能再使用其中的数据
+func PutBuffer(b *[]byte) {
+    if cap(*b) > protoMaxPooledBuffer {
+        return
+    }
+    *b = (*b)[:0]
+    bufferPool.Put(b)
+}
+
+// 追加一行：类型字节、内容和 CRLF。内容中的 CR 和 LF 换成空格，否则客户端会提前把它们当作行尾，
+// 与 Redis 的 addReplyErrorLength 相同，错误信息中回显的客户端参数不会破坏回复流
+func appendLine(dst []byte, typ byte, s string) []byte {
+    dst = append(dst, typ)
+    start := len(dst)
+    dst = append(dst, s...)
+    for i := start; i < len(dst); i++ {
+        if dst[i] == '\r' || dst[i] == '\n' {
+            dst[i] = ' '
+        }
+    }
+    return append(dst, '\r', '\n')
+}
+
+// 追加类型字节、整数和 CRLF，用于整数回复和各种长度
+func appendPrefixedInt(dst []byte, typ byte, n int64) []byte {
+    dst = append(dst, typ)
+    dst = strconv.AppendInt(dst, n, 10)
+    return append(dst, '\r', '\n')
+}
+
+// AppendSimpleString 追加简单字符串
+func AppendSimpleString(dst []byte, s string) []byte {
+    return appendLine(dst, SimpleString, s)
+}
+
+// AppendError 追加错误，s 以错误码开头，例如 ERR
+func AppendError(dst []byte, s string) []byte {
+    return appendLine(dst, Error, s)
+}
+
+// AppendInteger 追加整数
+func AppendInteger(dst []byte, n int64) []byte {
+    return appendPrefixedInt(dst, Integer, n)
+}
+
+// AppendBulkString 追加批量字符串
+func AppendBulkString(dst []byte, s string) []byte {
+    dst = appendPrefixedInt(dst, BulkString, int64(len(s)))
+    dst = append(dst, s...)
+    return append(dst, '\r', '\n')
+}
+
+// AppendBulk 追加以 []byte 表示的批量字符串
+func AppendBulk(dst []byte, b []byte) []byte {
+    dst = appendPrefixedInt(dst, BulkString, int64(len(b)))
+    dst = append(dst, b...)
+    return append(dst, '\r', '\n')
+}
+
+// AppendAggregateLen 追加数组、Map 等聚合类型的头部，Map 和 Attribute 的 n 是键值对的数量
+func AppendAggregateLen(dst []byte, typ byte, n int) []byte {
+    return appendPrefixedInt(dst, typ, int64(n))
+}
+
+// AppendDouble 追加浮点数
+func AppendDouble(dst []byte, f float64) []byte {
+    dst = append(dst, Double)
+    dst = appendDoubleValue(dst, f)
+    return append(dst, '\r', '\n')
+}
+
+// AppendVerbatim 追加带格式的字符串，format 为三个字符，例如 txt
+func AppendVerbatim(dst []byte, format, s string) []byte {
+    dst = appendPrefixedInt(dst, VerbatimString, int64(len(format)+1+len(s)))
+    dst = append(dst, format...)
+    dst = append(dst, ':')
+    dst = append(dst, s...)
+    return append(dst, '\r', '\n')
+}
+
+// 按 FormatDouble 的格式追加浮点数
+func appendDoubleValue(dst []byte, f float64) []byte {
+    switch {
+    case math.IsInf(f, 1):
+        return append(dst, "inf"...)
+    case math.IsInf(f, -1):
+        return append(dst, "-inf"...)
+    case math.IsNaN(f):
+        return append(dst, "nan"...)
+    }
+    return strconv.AppendFloat(dst, f, 'g', -1, 64)
+}
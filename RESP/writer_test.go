@@ -0,0 +1,98 @@
+package main
+
+import (
+    "bufio"
+    "bytes"
+    "math"
+    "strings"
+    "testing"
+)
+
+// 单行回复中的 CR 和 LF 换成空格，回显的客户端参数不能伪造出额外的回复。
+func TestAppendLineStripsNewlines(t *testing.T) {
+    tests := []struct {
+        got  []byte
+        want string
+    }{
+        {AppendError(nil, "ERR unknown command 'FOO\r\n+INJECTED'"), "-ERR unknown command 'FOO  +INJECTED'\r\n"},
+        {AppendSimpleString(nil, "a\nb\rc"), "+a b c\r\n"},
+        {(&BigNumberReply{Value: "1\r\n"}).AppendRESP(nil), "(1  \r\n"},
+        {AppendBulkString(nil, "a\r\nb"), "$4\r\na\r\nb\r\n"},
+    }
+    for _, tt := range tests {
+        if string(tt.got) != tt.want {
+            t.Errorf("got %q, want %q", tt.got, tt.want)
+        }
+    }
+}
+
+// 各种回复编码后由 ParseRESP 解析，得到原来的值；单行回复中的 CR 和 LF 变为空格。
+func FuzzReplyRoundTrip(f *testing.F) {
+    f.Add("hello", int64(42), 3.14)
+    f.Add("", int64(0), 0.0)
+    f.Add("a\r\nb\x00", int64(math.MinInt64), math.Inf(-1))
+    f.Add("$-1\r\n", int64(math.MaxInt64), math.NaN())
+    f.Fuzz(func(t *testing.T, s string, n int64, d float64) {
+        if len(s) > PROTO_INLINE_MAX_SIZE/2 {
+            t.Skip()
+        }
+        line := strings.NewReplacer("\r", " ", "\n", " ").Replace(s)
+        replies := []Reply{
+            &SimpleStringReply{Value: s},
+            &ErrorReply{Value: s},
+            &BulkStringReply{Value: s},
+            &IntegerReply{Value: n},
+            &DoubleReply{Value: d},
+            &VerbatimStringReply{Format: "txt", Value: s},
+            &MapReply{Value: []Reply{&BulkStringReply{Value: s}, &IntegerReply{Value: n}}},
+            ToRESP2(&DoubleReply{Value: d}),
+        }
+        var enc []byte
+        for _, r := range replies {
+            enc = r.AppendRESP(enc)
+        }
+        reader := bufio.NewReader(bytes.NewReader(enc))
+        parse := func() RESPValue {
+            v, err := ParseRESP(reader)
+            if err != nil {
+                t.Fatalf("parsing %q: %v", enc, err)
+            }
+            return v
+        }
+        if v := parse(); v.Type != SimpleString || v.Str != line {
+            t.Errorf("simple string: got %+v, want %q", v, line)
+        }
+        if v := parse(); v.Type != Error || v.Str != line {
+            t.Errorf("error: got %+v, want %q", v, line)
+        }
+        if v := parse(); v.Type != BulkString || v.IsNull || v.Str != s {
+            t.Errorf("bulk string: got %+v, want %q", v, s)
+        }
+        if v := parse(); v.Type != Integer || v.Num != n {
+            t.Errorf("integer: got %+v, want %d", v, n)
+        }
+        if v := parse(); v.Type != Double || !sameDouble(v.Float, d) {
+            t.Errorf("double: got %+v, want %v", v, d)
+        }
+        if v := parse(); v.Type != VerbatimString || v.Format != "txt" || v.Str != s {
+            t.Errorf("verbatim string: got %+v, want %q", v, s)
+        }
+        if v := parse(); v.Type != Map || len(v.Array) != 2 || v.Array[0].Str != s || v.Array[1].Num != n {
+            t.Errorf("map: got %+v", v)
+        }
+        if v := parse(); v.Type != BulkString || v.Str != FormatDouble(d) {
+            t.Errorf("RESP2 double: got %+v, want %q", v, FormatDouble(d))
+        }
+        if _, err := reader.ReadByte(); err == nil {
+            t.Errorf("trailing data after the replies in %q", enc)
+        }
+    })
+}
+
+// 两个浮点数是否相同，NaN 与 NaN 相同。
+func sameDouble(a, b float64) bool {
+    if math.IsNaN(a) || math.IsNaN(b) {
+        return math.IsNaN(a) && math.IsNaN(b)
+    }
+    return math.Float64bits(a) == math.Float64bits(b)
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+var errRewriteInProgress = errors.New("ERR Background append only file rewriting already in progress")
+
+// 启动后台 AOF 重写：复制当前数据并让 AOF 切换到新的增量文件，然后在新的协程中生成新的基础文件。
+// 调用方需持有 s.mu。
+func (s *redisServer) rewriteAppendOnlyFileBackground() error {
+	if s.aofChildRunning {
+		return errRewriteInProgress
+	}
+	data, expires, functions, err := s.db.startAOFRewrite()
+	if err != nil {
+		fmt.Println("Can't open new incr AOF for the rewrite:", err)
+		return err
+	}
+	useRDB := s.config.aofUseRDBPreamble
+	tmp := filepath.Join(s.db.aofDir, fmt.Sprintf("temp-rewriteaof-bg-%d.aof", os.Getpid()))
+	s.aofChildRunning = true
+	s.aofRewriteScheduled = false
+	s.aofRewriteTimeStart = time.Now()
+	fmt.Println("Background append only file rewriting started")
+
+	go func() {
+		err := writeRewriteTempFile(tmp, data, expires, functions, useRDB)
+		if err == nil {
+			err = s.db.finishAOFRewrite(tmp, useRDB)
+		}
+		if err != nil {
+			s.db.abortAOFRewrite()
+			os.Remove(tmp)
+		}
+		s.backgroundRewriteDone(err)
+	}()
+	return nil
+}
+
+// 把重写结果写入临时文件并刷盘。
+func writeRewriteTempFile(tmp string, data map[string]*robj, expires map[string]time.Time, functions []string, useRDB bool) error {
+	if err := os.MkdirAll(filepath.Dir(tmp), 0755); err != nil {
+		return err
+	}
+	file, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if err = writeAOFBase(file, data, expires, functions, useRDB); err == nil {
+		err = file.Sync()
+	}
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// 后台重写结束后更新状态。
+func (s *redisServer) backgroundRewriteDone(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.aofChildRunning = false
+	s.aofRewriteTimeLast = time.Since(s.aofRewriteTimeStart)
+	if err != nil {
+		fmt.Println("Background AOF rewrite error:", err)
+		s.lastBgrewriteOK = false
+		// 运行时刚开启 AOF 时必须完成一次重写，失败后交给 aofCron 重试
+		if s.config.appendonly && s.db.aofWaitingRewrite() {
+			s.aofRewriteScheduled = true
+		}
+		return
+	}
+	fmt.Println("Background AOF rewrite terminated with success")
+	s.lastBgrewriteOK = true
+	s.aofRewrites++
+}
+
+// 由 serverCron 调用：执行被推迟的重写，并在 AOF 增长到阈值时自动重写。
+func (s *redisServer) aofCron() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.hasActiveChild() {
+		return
+	}
+	if s.aofRewriteScheduled {
+		s.rewriteAppendOnlyFileBackground()
+		return
+	}
+
+	aof := s.db.appendOnly()
+	if !s.config.appendonly || aof == nil || s.config.aofRewritePerc == 0 {
+		return
+	}
+	size := aof.currentSize()
+	if size < s.config.aofRewriteMinSize {
+		return
+	}
+	base := aof.rewriteBaseSize()
+	if base == 0 {
+		base = 1
+	}
+	growth := size*100/base - 100
+	if growth >= int64(s.config.aofRewritePerc) {
+		fmt.Printf("Starting automatic rewriting of AOF on %d%% growth\n", growth)
+		s.rewriteAppendOnlyFileBackground()
+	}
+}
+
+// 运行时开启 AOF：先完成一次重写生成完整的文件，之后的写命令再追加到这个文件中。调用方需持有 s.mu。
+func (s *redisServer) startAppendOnly() {
+	if s.db.aofEnabled() {
+		return
+	}
+	s.db.startAppendOnly(s.config.appendfsync)
+	if s.hasActiveChild() {
+		s.aofRewriteScheduled = true
+		return
+	}
+	if s.rewriteAppendOnlyFileBackground() != nil {
+		s.aofRewriteScheduled = true // 交给 aofCron 重试
+	}
+}
+
+// 运行时关闭 AOF。调用方需持有 s.mu。
+func (s *redisServer) stopAppendOnly() {
+	s.db.stopAppendOnly()
+	s.aofRewriteScheduled = false
+}
+
+// BGREWRITEAOF：在后台重写 AOF；有后台保存正在进行时推迟到它结束后执行。
+func bgrewriteaofCommand(c *redisClient, args []string) {
+	if len(args) != 1 {
+		c.writeError("ERR wrong number of arguments for 'BGREWRITEAOF' command")
+		return
+	}
+	s := c.server
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch {
+	case s.aofChildRunning:
+		c.writeError(errRewriteInProgress.Error())
+	case s.hasActiveChild():
+		s.aofRewriteScheduled = true
+		c.writeStatus("Background append only file rewriting scheduled")
+	default:
+		if err := s.rewriteAppendOnlyFileBackground(); err != nil {
+			c.writeError(err.Error())
+			return
+		}
+		c.writeStatus("Background append only file rewriting started")
+	}
+}
@@ -0,0 +1,762 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// 单条 save 规则：seconds 秒内至少发生 changes 次修改时自动执行 BGSAVE。
+type saveParam struct {
+	seconds int
+	changes int
+}
+
+// 客户端类别，每类客户端有各自的输出缓冲区上限
+const (
+	CLIENT_TYPE_NORMAL = 0 // 普通客户端
+	CLIENT_TYPE_SLAVE  = 1 // 从节点
+	CLIENT_TYPE_PUBSUB = 2 // 订阅了频道或模式的客户端
+	CLIENT_TYPE_COUNT  = 3
+	CLIENT_TYPE_MASTER = 3 // 连接主节点的客户端，没有输出缓冲区上限
+)
+
+var clientTypeNames = [CLIENT_TYPE_COUNT]string{"normal", "slave", "pubsub"}
+
+// 按名称查找客户端类别，名称不存在时返回 -1。
+func getClientTypeByName(name string) int {
+	switch strings.ToLower(name) {
+	case "normal":
+		return CLIENT_TYPE_NORMAL
+	case "replica", "slave":
+		return CLIENT_TYPE_SLAVE
+	case "pubsub":
+		return CLIENT_TYPE_PUBSUB
+	case "master":
+		return CLIENT_TYPE_MASTER
+	}
+	return -1
+}
+
+// 一类客户端的输出缓冲区上限：超过 hard 时立即断开，持续超过 soft 达到 softSeconds 秒后断开，0 表示不限制。
+type clientBufferLimit struct {
+	hard        int64
+	soft        int64
+	softSeconds int
+}
+
+// 服务器配置，来源依次为配置文件、命令行参数和运行时的 CONFIG SET。
+type redisConfig struct {
+	bindaddr             []string    // 监听的地址，带有 "-" 前缀的地址绑定失败时跳过
+	port                 int         // 监听的 TCP 端口，0 表示不监听 TCP
+	unixsocket           string      // 监听的 Unix 套接字路径，为空表示不监听
+	unixsocketperm       os.FileMode // Unix 套接字文件的权限，0 表示不修改
+	tlsPort              int         // 监听的 TLS 端口，0 表示不开启 TLS
+	tlsCertFile          string      // 服务器证书
+	tlsKeyFile           string      // 服务器证书的私钥
+	tlsCACertFile        string      // 验证客户端证书使用的 CA 证书
+	tlsAuthClients       string      // 是否要求客户端证书（TLS_CLIENT_AUTH_*）
+	tlsProtocols         string      // 允许的 TLS 版本，为空表示 TLSv1.2 和 TLSv1.3
+	tlsCiphers           string      // TLSv1.2 及以下版本允许的加密套件，为空表示 Go 的默认值
+	dir                  string      // 工作目录，持久化文件都保存在这里
+	dbfilename           string      // RDB 文件名
+	appendfilename       string      // AOF 文件名前缀
+	appenddirname        string      // 多文件 AOF 所在的目录
+	saveParams           []saveParam // 自动快照规则
+	appendonly           bool        // 是否开启 AOF
+	appendfsync          string      // AOF 的 fsync 策略
+	aofLoadTruncated     bool        // AOF 末尾命令不完整时是否截断后继续加载
+	aofRewritePerc       int         // AOF 比上次重写后增长多少百分比时自动重写，0 表示关闭
+	aofRewriteMinSize    int64       // 自动重写要求的最小文件大小
+	aofUseRDBPreamble    bool        // 重写时基础文件是否采用 RDB 格式
+	maxmemory            int64       // 数据占用内存的上限，0 表示不限制
+	maxmemoryPolicy      int         // 达到上限时的淘汰策略
+	maxmemorySamples     int         // 每次淘汰时取样的键数量
+	lfuLogFactor         int         // LFU 计数器的对数增长因子
+	lfuDecayTime         int         // LFU 计数器每衰减 1 需要经过的分钟数
+	busyReplyThreshold   int         // 脚本执行超过这个毫秒数后，其他命令返回 BUSY 错误
+	notifyKeyspaceEvents int         // 开启的键空间通知类别（NOTIFY_*），0 表示关闭
+	protoMaxBulkLen      int64       // 请求中单个参数的最大长度
+	maxclients           int         // 同时连接的客户端数量上限
+	maxidletime          int         // 客户端空闲超过这个秒数后断开，0 表示不限制
+	tcpKeepalive         int         // TCP keepalive 探测的间隔秒数，0 表示关闭
+
+	clientOutputBufferLimits [CLIENT_TYPE_COUNT]clientBufferLimit // 各类客户端的输出缓冲区上限
+}
+
+// 创建一份带有默认值的配置。
+func newRedisConfig() *redisConfig {
+	return &redisConfig{
+		bindaddr:           []string{"127.0.0.1", "-::1"},
+		tlsAuthClients:     TLS_CLIENT_AUTH_YES,
+		port:               6379,
+		dir:                ".",
+		dbfilename:         "dump.rdb",
+		appendfilename:     "appendonly.aof",
+		appenddirname:      "appendonlydir",
+		saveParams:         []saveParam{{3600, 1}, {300, 100}, {60, 10000}},
+		appendonly:         false,
+		appendfsync:        AOF_FSYNC_EVERYSEC,
+		aofLoadTruncated:   true,
+		aofRewritePerc:     100,
+		aofRewriteMinSize:  64 << 20,
+		aofUseRDBPreamble:  true,
+		maxmemory:          0,
+		maxmemoryPolicy:    MAXMEMORY_NO_EVICTION,
+		maxmemorySamples:   5,
+		protoMaxBulkLen:    PROTO_MAX_BULK_LEN,
+		maxclients:         10000,
+		maxidletime:        0,
+		tcpKeepalive:       300,
+		lfuLogFactor:       10,
+		lfuDecayTime:       1,
+		busyReplyThreshold: 5000,
+
+		clientOutputBufferLimits: [CLIENT_TYPE_COUNT]clientBufferLimit{
+			CLIENT_TYPE_NORMAL: {0, 0, 0},
+			CLIENT_TYPE_SLAVE:  {256 << 20, 64 << 20, 60},
+			CLIENT_TYPE_PUBSUB: {32 << 20, 8 << 20, 60},
+		},
+	}
+}
+
+// 表示一个配置项，包含名称以及读取、修改它的方法。
+type configEntry struct {
+	name      string
+	alias     string                                     // 与 name 等价的旧名称，可以为空
+	immutable bool                                       // 为 true 时只能在启动时设置
+	get       func(cfg *redisConfig) string              // 读取当前值
+	set       func(cfg *redisConfig, value string) error // 校验并修改值
+	apply     func(s *redisServer) error                 // 运行时修改后需要同步的服务器状态，失败时 CONFIG SET 恢复原来的值，可以为空
+}
+
+// 定义了支持的配置项。
+var configs = []*configEntry{
+	{
+		name:      "bind",
+		immutable: true,
+		get:       func(cfg *redisConfig) string { return strings.Join(cfg.bindaddr, " ") },
+		set: func(cfg *redisConfig, value string) error {
+			addrs := strings.Fields(value)
+			if len(addrs) > CONFIG_BINDADDR_MAX {
+				return errors.New("Too many bind addresses specified.")
+			}
+			cfg.bindaddr = addrs
+			return nil
+		},
+	},
+	{
+		name:      "port",
+		immutable: true,
+		get:       func(cfg *redisConfig) string { return strconv.Itoa(cfg.port) },
+		set: func(cfg *redisConfig, value string) error {
+			return setIntConfig(&cfg.port, value, 0, 65535)
+		},
+	},
+	{
+		name:      "unixsocket",
+		immutable: true,
+		get:       func(cfg *redisConfig) string { return cfg.unixsocket },
+		set: func(cfg *redisConfig, value string) error {
+			cfg.unixsocket = value
+			return nil
+		},
+	},
+	{
+		name:      "unixsocketperm",
+		immutable: true,
+		get:       func(cfg *redisConfig) string { return strconv.FormatUint(uint64(cfg.unixsocketperm), 8) },
+		set: func(cfg *redisConfig, value string) error {
+			perm, err := strconv.ParseUint(value, 8, 32)
+			if err != nil || perm > 0777 {
+				return errors.New("argument must be an octal number between 0 and 777")
+			}
+			cfg.unixsocketperm = os.FileMode(perm)
+			return nil
+		},
+	},
+	{
+		name:      "tls-port",
+		immutable: true,
+		get:       func(cfg *redisConfig) string { return strconv.Itoa(cfg.tlsPort) },
+		set: func(cfg *redisConfig, value string) error {
+			return setIntConfig(&cfg.tlsPort, value, 0, 65535)
+		},
+	},
+	{
+		name: "tls-cert-file",
+		get:  func(cfg *redisConfig) string { return cfg.tlsCertFile },
+		set: func(cfg *redisConfig, value string) error {
+			cfg.tlsCertFile = value
+			return nil
+		},
+		apply: (*redisServer).applyTLSConfig,
+	},
+	{
+		name: "tls-key-file",
+		get:  func(cfg *redisConfig) string { return cfg.tlsKeyFile },
+		set: func(cfg *redisConfig, value string) error {
+			cfg.tlsKeyFile = value
+			return nil
+		},
+		apply: (*redisServer).applyTLSConfig,
+	},
+	{
+		name: "tls-ca-cert-file",
+		get:  func(cfg *redisConfig) string { return cfg.tlsCACertFile },
+		set: func(cfg *redisConfig, value string) error {
+			cfg.tlsCACertFile = value
+			return nil
+		},
+		apply: (*redisServer).applyTLSConfig,
+	},
+	{
+		name: "tls-auth-clients",
+		get:  func(cfg *redisConfig) string { return cfg.tlsAuthClients },
+		set: func(cfg *redisConfig, value string) error {
+			switch value = strings.ToLower(value); value {
+			case TLS_CLIENT_AUTH_YES, TLS_CLIENT_AUTH_NO, TLS_CLIENT_AUTH_OPTIONAL:
+				cfg.tlsAuthClients = value
+				return nil
+			}
+			return errors.New("argument(s) must be one of the following: no, yes, optional")
+		},
+		apply: (*redisServer).applyTLSConfig,
+	},
+	{
+		name: "tls-protocols",
+		get:  func(cfg *redisConfig) string { return cfg.tlsProtocols },
+		set: func(cfg *redisConfig, value string) error {
+			if _, _, err := parseTLSProtocols(value); err != nil {
+				return err
+			}
+			cfg.tlsProtocols = value
+			return nil
+		},
+		apply: (*redisServer).applyTLSConfig,
+	},
+	{
+		name: "tls-ciphers",
+		get:  func(cfg *redisConfig) string { return cfg.tlsCiphers },
+		set: func(cfg *redisConfig, value string) error {
+			if _, err := parseTLSCiphers(value); err != nil {
+				return err
+			}
+			cfg.tlsCiphers = value
+			return nil
+		},
+		apply: (*redisServer).applyTLSConfig,
+	},
+	{
+		name: "maxclients",
+		get:  func(cfg *redisConfig) string { return strconv.Itoa(cfg.maxclients) },
+		set: func(cfg *redisConfig, value string) error {
+			return setIntConfig(&cfg.maxclients, value, 1, math.MaxInt32)
+		},
+	},
+	{
+		name: "timeout",
+		get:  func(cfg *redisConfig) string { return strconv.Itoa(cfg.maxidletime) },
+		set: func(cfg *redisConfig, value string) error {
+			return setIntConfig(&cfg.maxidletime, value, 0, math.MaxInt32)
+		},
+	},
+	{
+		name: "tcp-keepalive",
+		get:  func(cfg *redisConfig) string { return strconv.Itoa(cfg.tcpKeepalive) },
+		set: func(cfg *redisConfig, value string) error {
+			return setIntConfig(&cfg.tcpKeepalive, value, 0, math.MaxInt32)
+		},
+	},
+	{
+		name: "dir",
+		get: func(cfg *redisConfig) string {
+			if wd, err := os.Getwd(); err == nil {
+				return wd
+			}
+			return cfg.dir
+		},
+		set: func(cfg *redisConfig, value string) error {
+			if err := os.Chdir(value); err != nil {
+				return err
+			}
+			cfg.dir = value
+			return nil
+		},
+	},
+	{
+		name: "dbfilename",
+		get:  func(cfg *redisConfig) string { return cfg.dbfilename },
+		set: func(cfg *redisConfig, value string) error {
+			if value == "" || strings.ContainsRune(value, os.PathSeparator) {
+				return errors.New("dbfilename can't be a path, just a filename")
+			}
+			cfg.dbfilename = value
+			return nil
+		},
+		apply: func(s *redisServer) error {
+			s.db.setRDBFilename(s.config.dbfilename)
+			return nil
+		},
+	},
+	{
+		name:      "appendfilename",
+		immutable: true,
+		get:       func(cfg *redisConfig) string { return cfg.appendfilename },
+		set: func(cfg *redisConfig, value string) error {
+			if value == "" || strings.ContainsRune(value, os.PathSeparator) {
+				return errors.New("appendfilename can't be a path, just a filename")
+			}
+			cfg.appendfilename = value
+			return nil
+		},
+	},
+	{
+		name:      "appenddirname",
+		immutable: true,
+		get:       func(cfg *redisConfig) string { return cfg.appenddirname },
+		set: func(cfg *redisConfig, value string) error {
+			if value == "" || strings.ContainsRune(value, os.PathSeparator) {
+				return errors.New("appenddirname can't be a path, just a dirname")
+			}
+			cfg.appenddirname = value
+			return nil
+		},
+	},
+	{
+		name: "save",
+		get: func(cfg *redisConfig) string {
+			parts := make([]string, 0, len(cfg.saveParams)*2)
+			for _, p := range cfg.saveParams {
+				parts = append(parts, strconv.Itoa(p.seconds), strconv.Itoa(p.changes))
+			}
+			return strings.Join(parts, " ")
+		},
+		set: func(cfg *redisConfig, value string) error {
+			fields := strings.Fields(value)
+			if len(fields)%2 != 0 {
+				return errors.New("invalid save parameters")
+			}
+			params := make([]saveParam, 0, len(fields)/2)
+			for i := 0; i < len(fields); i += 2 {
+				seconds, err1 := strconv.Atoi(fields[i])
+				changes, err2 := strconv.Atoi(fields[i+1])
+				if err1 != nil || err2 != nil || seconds < 1 || changes < 0 {
+					return errors.New("invalid save parameters")
+				}
+				params = append(params, saveParam{seconds, changes})
+			}
+			cfg.saveParams = params
+			return nil
+		},
+	},
+	{
+		name: "appendonly",
+		get:  func(cfg *redisConfig) string { return yesNo(cfg.appendonly) },
+		set: func(cfg *redisConfig, value string) error {
+			return setBoolConfig(&cfg.appendonly, value)
+		},
+		apply: func(s *redisServer) error {
+			if s.config.appendonly {
+				s.startAppendOnly()
+			} else {
+				s.stopAppendOnly()
+			}
+			return nil
+		},
+	},
+	{
+		name: "appendfsync",
+		get:  func(cfg *redisConfig) string { return cfg.appendfsync },
+		set: func(cfg *redisConfig, value string) error {
+			value = strings.ToLower(value)
+			switch value {
+			case AOF_FSYNC_ALWAYS, AOF_FSYNC_EVERYSEC, AOF_FSYNC_NO:
+				cfg.appendfsync = value
+				return nil
+			}
+			return errors.New("argument(s) must be one of the following: always, everysec, no")
+		},
+		apply: func(s *redisServer) error {
+			if aof := s.db.appendOnly(); aof != nil {
+				aof.setFsyncPolicy(s.config.appendfsync)
+			}
+			return nil
+		},
+	},
+	{
+		name: "aof-load-truncated",
+		get:  func(cfg *redisConfig) string { return yesNo(cfg.aofLoadTruncated) },
+		set: func(cfg *redisConfig, value string) error {
+			return setBoolConfig(&cfg.aofLoadTruncated, value)
+		},
+	},
+	{
+		name: "aof-use-rdb-preamble",
+		get:  func(cfg *redisConfig) string { return yesNo(cfg.aofUseRDBPreamble) },
+		set: func(cfg *redisConfig, value string) error {
+			return setBoolConfig(&cfg.aofUseRDBPreamble, value)
+		},
+	},
+	{
+		name: "maxmemory",
+		get:  func(cfg *redisConfig) string { return strconv.FormatInt(cfg.maxmemory, 10) },
+		set: func(cfg *redisConfig, value string) error {
+			return setMemoryConfig(&cfg.maxmemory, value)
+		},
+	},
+	{
+		name: "maxmemory-policy",
+		get:  func(cfg *redisConfig) string { return maxmemoryPolicyName(cfg.maxmemoryPolicy) },
+		set: func(cfg *redisConfig, value string) error {
+			for _, p := range maxmemoryPolicies {
+				if strings.EqualFold(p.name, value) {
+					cfg.maxmemoryPolicy = p.policy
+					return nil
+				}
+			}
+			return errors.New("argument(s) must be one of the following: volatile-lru, volatile-lfu, " +
+				"volatile-random, volatile-ttl, allkeys-lru, allkeys-lfu, allkeys-random, noeviction")
+		},
+		apply: (*redisServer).applyEvictionConfig,
+	},
+	{
+		name: "maxmemory-samples",
+		get:  func(cfg *redisConfig) string { return strconv.Itoa(cfg.maxmemorySamples) },
+		set: func(cfg *redisConfig, value string) error {
+			return setIntConfig(&cfg.maxmemorySamples, value, 1, 64)
+		},
+	},
+	{
+		name: "lfu-log-factor",
+		get:  func(cfg *redisConfig) string { return strconv.Itoa(cfg.lfuLogFactor) },
+		set: func(cfg *redisConfig, value string) error {
+			return setIntConfig(&cfg.lfuLogFactor, value, 0, math.MaxInt32)
+		},
+		apply: (*redisServer).applyEvictionConfig,
+	},
+	{
+		name: "lfu-decay-time",
+		get:  func(cfg *redisConfig) string { return strconv.Itoa(cfg.lfuDecayTime) },
+		set: func(cfg *redisConfig, value string) error {
+			return setIntConfig(&cfg.lfuDecayTime, value, 0, math.MaxInt32)
+		},
+		apply: (*redisServer).applyEvictionConfig,
+	},
+	{
+		name: "proto-max-bulk-len",
+		get:  func(cfg *redisConfig) string { return strconv.FormatInt(cfg.protoMaxBulkLen, 10) },
+		set: func(cfg *redisConfig, value string) error {
+			n, err := parseMemory(value)
+			if err != nil {
+				return err
+			}
+			if n < 1024*1024 || n > PROTO_MAX_BULK_LEN_MAX {
+				return fmt.Errorf("argument must be between %d and %d inclusive", 1024*1024, int64(PROTO_MAX_BULK_LEN_MAX))
+			}
+			cfg.protoMaxBulkLen = n
+			return nil
+		},
+		apply: (*redisServer).applyProtoConfig,
+	},
+	{
+		name:  "busy-reply-threshold",
+		alias: "lua-time-limit",
+		get:   func(cfg *redisConfig) string { return strconv.Itoa(cfg.busyReplyThreshold) },
+		set: func(cfg *redisConfig, value string) error {
+			return setIntConfig(&cfg.busyReplyThreshold, value, 0, math.MaxInt32)
+		},
+	},
+	{
+		name: "auto-aof-rewrite-percentage",
+		get:  func(cfg *redisConfig) string { return strconv.Itoa(cfg.aofRewritePerc) },
+		set: func(cfg *redisConfig, value string) error {
+			return setIntConfig(&cfg.aofRewritePerc, value, 0, math.MaxInt32)
+		},
+	},
+	{
+		name: "auto-aof-rewrite-min-size",
+		get:  func(cfg *redisConfig) string { return strconv.FormatInt(cfg.aofRewriteMinSize, 10) },
+		set: func(cfg *redisConfig, value string) error {
+			return setMemoryConfig(&cfg.aofRewriteMinSize, value)
+		},
+	},
+	{
+		name: "client-output-buffer-limit",
+		get: func(cfg *redisConfig) string {
+			parts := make([]string, 0, CLIENT_TYPE_COUNT*4)
+			for class, limit := range cfg.clientOutputBufferLimits {
+				parts = append(parts, clientTypeNames[class], strconv.FormatInt(limit.hard, 10),
+					strconv.FormatInt(limit.soft, 10), strconv.Itoa(limit.softSeconds))
+			}
+			return strings.Join(parts, " ")
+		},
+		set:   setClientOutputBufferLimits,
+		apply: (*redisServer).applyClientOutputBufferConfig,
+	},
+	{
+		name: "notify-keyspace-events",
+		get:  func(cfg *redisConfig) string { return keyspaceEventsFlagsToString(cfg.notifyKeyspaceEvents) },
+		set: func(cfg *redisConfig, value string) error {
+			flags := keyspaceEventsStringToFlags(value)
+			if flags == -1 {
+				return errors.New("Invalid event class character. Use 'Ag$lshzxeKEtmdn'.")
+			}
+			cfg.notifyKeyspaceEvents = flags
+			return nil
+		},
+		apply: (*redisServer).applyNotifyConfig,
+	},
+}
+
+// 解析 <class> <hard limit> <soft limit> <soft seconds> [...]，只修改给出的类别。
+// class 为 normal、replica（slave）或 pubsub。
+func setClientOutputBufferLimits(cfg *redisConfig, value string) error {
+	fields := strings.Fields(value)
+	if len(fields) == 0 || len(fields)%4 != 0 {
+		return errors.New("Wrong number of arguments in buffer limit configuration.")
+	}
+	limits := cfg.clientOutputBufferLimits
+	for i := 0; i < len(fields); i += 4 {
+		class := getClientTypeByName(fields[i])
+		if class == -1 || class == CLIENT_TYPE_MASTER {
+			return errors.New("Invalid client class specified in buffer limit configuration.")
+		}
+		hard, err1 := parseMemory(fields[i+1])
+		soft, err2 := parseMemory(fields[i+2])
+		seconds, err3 := strconv.Atoi(fields[i+3])
+		if err1 != nil || err2 != nil || err3 != nil || seconds < 0 {
+			return errors.New("Error in hard, soft or soft_seconds setting in buffer limit configuration.")
+		}
+		limits[class] = clientBufferLimit{hard: hard, soft: soft, softSeconds: seconds}
+	}
+	cfg.clientOutputBufferLimits = limits
+	return nil
+}
+
+// 解析带单位的内存大小配置，例如 64mb、1gb、100k。
+func setMemoryConfig(dst *int64, value string) error {
+	n, err := parseMemory(value)
+	if err != nil {
+		return err
+	}
+	*dst = n
+	return nil
+}
+
+// 把 "1gb" 之类带单位的大小转换为字节数，k/m/g 以 1000 为底，kb/mb/gb 以 1024 为底，与 Redis 一致。
+func parseMemory(value string) (int64, error) {
+	value = strings.ToLower(value)
+	units := []struct {
+		suffix string
+		mul    int64
+	}{
+		{"kb", 1024}, {"mb", 1024 * 1024}, {"gb", 1024 * 1024 * 1024},
+		{"k", 1000}, {"m", 1000 * 1000}, {"g", 1000 * 1000 * 1000}, {"b", 1},
+	}
+	mul := int64(1)
+	for _, u := range units {
+		if strings.HasSuffix(value, u.suffix) {
+			value, mul = strings.TrimSuffix(value, u.suffix), u.mul
+			break
+		}
+	}
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || n < 0 {
+		return 0, errors.New("argument must be a memory value")
+	}
+	return n * mul, nil
+}
+
+// 解析 yes/no 形式的布尔配置。
+func setBoolConfig(dst *bool, value string) error {
+	switch strings.ToLower(value) {
+	case "yes":
+		*dst = true
+	case "no":
+		*dst = false
+	default:
+		return errors.New("argument must be 'yes' or 'no'")
+	}
+	return nil
+}
+
+func yesNo(b bool) string {
+	if b {
+		return "yes"
+	}
+	return "no"
+}
+
+// 解析整数配置并检查取值范围。
+func setIntConfig(dst *int, value string, min, max int) error {
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return errors.New("argument couldn't be parsed into an integer")
+	}
+	if n < min || n > max {
+		return fmt.Errorf("argument must be between %d and %d inclusive", min, max)
+	}
+	*dst = n
+	return nil
+}
+
+// 按名称查找配置项，名称不区分大小写。
+func lookupConfig(name string) *configEntry {
+	name = strings.ToLower(name)
+	for _, entry := range configs {
+		if entry.name == name || (entry.alias != "" && entry.alias == name) {
+			return entry
+		}
+	}
+	return nil
+}
+
+// 解析命令行参数：第一个参数可以是配置文件路径，其后的 --name value... 会覆盖文件中的同名配置。
+func (cfg *redisConfig) loadFromArgs(args []string) error {
+	var lines [][]string
+	if len(args) > 0 && !strings.HasPrefix(args[0], "--") {
+		content, err := os.ReadFile(args[0])
+		if err != nil {
+			return fmt.Errorf("can't open config file '%s': %w", args[0], err)
+		}
+		for i, line := range strings.Split(string(content), "\n") {
+			line = strings.TrimSpace(line)
+			if line == "" || line[0] == '#' {
+				continue
+			}
+			fields, err := splitArgs(line)
+			if err != nil {
+				return fmt.Errorf("config file line %d: %w", i+1, err)
+			}
+			lines = append(lines, fields)
+		}
+		args = args[1:]
+	}
+	for _, arg := range args {
+		if strings.HasPrefix(arg, "--") {
+			lines = append(lines, []string{arg[2:]})
+		} else if len(lines) > 0 {
+			lines[len(lines)-1] = append(lines[len(lines)-1], arg)
+		} else {
+			return fmt.Errorf("invalid argument '%s'", arg)
+		}
+	}
+	return cfg.load(lines)
+}
+
+// 依次应用每一行配置。save 可以出现多次，所有规则会合并，第一次出现时替换默认规则。
+func (cfg *redisConfig) load(lines [][]string) error {
+	var saveArgs []string
+	seenSave := false
+	for _, line := range lines {
+		entry := lookupConfig(line[0])
+		if entry == nil {
+			return fmt.Errorf("bad directive or wrong number of arguments: '%s'", line[0])
+		}
+		if entry.name == "save" {
+			seenSave = true
+			saveArgs = append(saveArgs, line[1:]...)
+			continue
+		}
+		if err := entry.set(cfg, strings.Join(line[1:], " ")); err != nil {
+			return fmt.Errorf("'%s': %w", strings.Join(line, " "), err)
+		}
+	}
+	if seenSave {
+		if err := lookupConfig("save").set(cfg, strings.Join(saveArgs, " ")); err != nil {
+			return fmt.Errorf("'save %s': %w", strings.Join(saveArgs, " "), err)
+		}
+	}
+	return nil
+}
+
+// CONFIG GET pattern [pattern ...] / CONFIG SET name value [name value ...]
+func configCommand(c *redisClient, args []string) {
+	if len(args) < 2 {
+		c.writeError("ERR wrong number of arguments for 'CONFIG' command")
+		return
+	}
+	s := c.server
+	switch strings.ToUpper(args[1]) {
+	case "GET":
+		if len(args) < 3 {
+			c.writeError("ERR wrong number of arguments for 'CONFIG|GET' command")
+			return
+		}
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		var items []Reply
+		for _, entry := range configs {
+		patterns:
+			for _, pattern := range args[2:] {
+				for _, name := range []string{entry.name, entry.alias} {
+					if name != "" && stringMatch(pattern, name, true) {
+						items = append(items, &BulkStringReply{Value: name}, &BulkStringReply{Value: entry.get(s.config)})
+						break patterns
+					}
+				}
+			}
+		}
+		c.writeReply(&MapReply{Value: items})
+	case "SET":
+		if len(args) < 4 || len(args)%2 != 0 {
+			c.writeError("ERR wrong number of arguments for 'CONFIG|SET' command")
+			return
+		}
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		// 所有参数一起生效：任何一项修改或同步失败时恢复所有参数原来的值。
+		// 例如 tls-cert-file 和 tls-key-file 需要同时修改，单独修改其中一个时证书和私钥不匹配
+		entries := make([]*configEntry, 0, len(args)/2-1)
+		for i := 2; i < len(args); i += 2 {
+			entry := lookupConfig(args[i])
+			if entry == nil {
+				c.writeError(fmt.Sprintf("ERR Unknown option or number of arguments for CONFIG SET - '%s'", args[i]))
+				return
+			}
+			if entry.immutable {
+				c.writeError(fmt.Sprintf("ERR CONFIG SET failed (possibly related to argument '%s') - can't set immutable config", args[i]))
+				return
+			}
+			entries = append(entries, entry)
+		}
+		old := make([]string, len(entries))
+		for i, entry := range entries {
+			old[i] = entry.get(s.config)
+		}
+		restore := func() {
+			for i := len(entries) - 1; i >= 0; i-- {
+				entries[i].set(s.config, old[i])
+			}
+		}
+		for i, entry := range entries {
+			if err := entry.set(s.config, args[3+2*i]); err != nil {
+				restore()
+				c.writeError(fmt.Sprintf("ERR CONFIG SET failed (possibly related to argument '%s') - %v", args[2+2*i], err))
+				return
+			}
+		}
+		for i, entry := range entries {
+			if entry.apply == nil {
+				continue
+			}
+			if err := entry.apply(s); err != nil {
+				restore()
+				for _, e := range entries[:i] {
+					if e.apply != nil {
+						e.apply(s)
+					}
+				}
+				c.writeError(fmt.Sprintf("ERR CONFIG SET failed (possibly related to argument '%s') - %v", args[2+2*i], err))
+				return
+			}
+		}
+		c.writeStatus("OK")
+	default:
+		c.writeError(fmt.Sprintf("ERR unknown subcommand '%s'. Try CONFIG HELP.", args[1]))
+	}
+}
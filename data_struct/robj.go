@@ -2,9 +2,19 @@ package main
 
 import (
 	"fmt"
+	"strconv"
 	"time"
 )
 
+// 对象类型，编号与 Redis 的 OBJ_* 保持一致
+const (
+	OBJ_STRING uint8 = 0 // 字符串，ptr 为 string
+	OBJ_LIST   uint8 = 1 // 列表，ptr 为 []string
+	OBJ_SET    uint8 = 2 // 集合，ptr 为 []string
+	OBJ_ZSET   uint8 = 3 // 有序集合，ptr 为 map[string]float64
+	OBJ_HASH   uint8 = 4 // 哈希，ptr 为 map[string]string
+)
+
 // robj 结构体定义
 type robj struct {
 	rtype    uint8       
@@ -18,7 +28,7 @@ type robj struct {
 func createObject(t uint8, ptr interface{}) *robj {
 	return &robj{
 		rtype:    t,
-		encoding: 0,    // 编码方式默认值为 0
+		encoding: objectChooseEncoding(t, ptr), // 按内容选择与 Redis 一致的编码
 		refcount: 1,    
 		ptr:      ptr,  // 实际数据指针
 		lru:      lruClock(), // 设置 LRU 时钟
@@ -30,3 +40,207 @@ func lruClock() uint32 {
 	// 当前时间戳（精确到秒），可以根据需要自定义
 	return uint32(time.Now().Unix())
 }
+
+// 返回对象类型的名称，与 TYPE 命令的输出一致。
+func objTypeName(t uint8) string {
+	switch t {
+	case OBJ_STRING:
+		return "string"
+	case OBJ_LIST:
+		return "list"
+	case OBJ_SET:
+		return "set"
+	case OBJ_ZSET:
+		return "zset"
+	case OBJ_HASH:
+		return "hash"
+	}
+	return "unknown"
+}
+
+// 返回对象的元素数量，字符串返回字节数。
+func objectLength(o *robj) int64 {
+	switch v := o.ptr.(type) {
+	case string:
+		return int64(len(v))
+	case []string:
+		return int64(len(v))
+	case map[string]float64:
+		return int64(len(v))
+	case map[string]string:
+		return int64(len(v))
+	}
+	return 0
+}
+
+// 对象编码，编号与 Redis 的 OBJ_ENCODING_* 保持一致。数据在 Go 中的实际存储方式不变，
+// 编码用于 OBJECT ENCODING 和内存统计，表示同样的数据在 Redis 中会采用的编码。
+const (
+	OBJ_ENCODING_RAW       uint8 = 0  // 普通字符串
+	OBJ_ENCODING_INT       uint8 = 1  // 可以表示为整数的字符串
+	OBJ_ENCODING_HT        uint8 = 2  // 哈希表
+	OBJ_ENCODING_INTSET    uint8 = 6  // 整数集合
+	OBJ_ENCODING_SKIPLIST  uint8 = 7  // 跳表
+	OBJ_ENCODING_EMBSTR    uint8 = 8  // 短字符串
+	OBJ_ENCODING_QUICKLIST uint8 = 9  // 快速列表
+	OBJ_ENCODING_LISTPACK  uint8 = 11 // 紧凑列表
+)
+
+// 选择编码时使用的阈值，与 Redis 的默认配置相同
+const (
+	OBJ_ENCODING_EMBSTR_SIZE_LIMIT = 44
+	LIST_MAX_LISTPACK_SIZE         = 8 * 1024 // list-max-listpack-size -2
+	SET_MAX_INTSET_ENTRIES         = 512
+	MAX_LISTPACK_ENTRIES           = 128 // hash/set/zset-max-listpack-entries
+	MAX_LISTPACK_VALUE             = 64  // hash/set/zset-max-listpack-value
+)
+
+// 按对象内容选择编码。
+func objectChooseEncoding(t uint8, ptr interface{}) uint8 {
+	switch v := ptr.(type) {
+	case string:
+		if len(v) <= 20 {
+			// 只有与整数的十进制表示完全一致的字符串才能编码为整数
+			if n, err := strconv.ParseInt(v, 10, 64); err == nil && strconv.FormatInt(n, 10) == v {
+				return OBJ_ENCODING_INT
+			}
+		}
+		if len(v) <= OBJ_ENCODING_EMBSTR_SIZE_LIMIT {
+			return OBJ_ENCODING_EMBSTR
+		}
+		return OBJ_ENCODING_RAW
+	case []string:
+		if t == OBJ_LIST {
+			total := 0
+			for _, item := range v {
+				total += len(item)
+			}
+			if total <= LIST_MAX_LISTPACK_SIZE {
+				return OBJ_ENCODING_LISTPACK
+			}
+			return OBJ_ENCODING_QUICKLIST
+		}
+		intset := len(v) <= SET_MAX_INTSET_ENTRIES
+		small := len(v) <= MAX_LISTPACK_ENTRIES
+		for _, item := range v {
+			if intset {
+				if _, err := strconv.ParseInt(item, 10, 64); err != nil {
+					intset = false
+				}
+			}
+			if len(item) > MAX_LISTPACK_VALUE {
+				small = false
+			}
+		}
+		switch {
+		case intset:
+			return OBJ_ENCODING_INTSET
+		case small:
+			return OBJ_ENCODING_LISTPACK
+		}
+		return OBJ_ENCODING_HT
+	case map[string]float64:
+		if len(v) > MAX_LISTPACK_ENTRIES {
+			return OBJ_ENCODING_SKIPLIST
+		}
+		for member := range v {
+			if len(member) > MAX_LISTPACK_VALUE {
+				return OBJ_ENCODING_SKIPLIST
+			}
+		}
+		return OBJ_ENCODING_LISTPACK
+	case map[string]string:
+		if len(v) > MAX_LISTPACK_ENTRIES {
+			return OBJ_ENCODING_HT
+		}
+		for field, value := range v {
+			if len(field) > MAX_LISTPACK_VALUE || len(value) > MAX_LISTPACK_VALUE {
+				return OBJ_ENCODING_HT
+			}
+		}
+		return OBJ_ENCODING_LISTPACK
+	}
+	return OBJ_ENCODING_RAW
+}
+
+// 返回编码的名称，与 OBJECT ENCODING 的输出一致。
+func strEncoding(encoding uint8) string {
+	switch encoding {
+	case OBJ_ENCODING_RAW:
+		return "raw"
+	case OBJ_ENCODING_INT:
+		return "int"
+	case OBJ_ENCODING_HT:
+		return "hashtable"
+	case OBJ_ENCODING_INTSET:
+		return "intset"
+	case OBJ_ENCODING_SKIPLIST:
+		return "skiplist"
+	case OBJ_ENCODING_EMBSTR:
+		return "embstr"
+	case OBJ_ENCODING_QUICKLIST:
+		return "quicklist"
+	case OBJ_ENCODING_LISTPACK:
+		return "listpack"
+	}
+	return "unknown"
+}
+
+// 估算内存时使用的 Go 运行时开销（64 位平台）
+const (
+	robjSize         = 32 // robj 结构体本身
+	stringHeaderSize = 16 // string 头部：指针和长度
+	sliceHeaderSize  = 24 // slice 头部：指针、长度和容量
+	mapEntryOverhead = 48 // map 中一个元素摊销后的桶、哈希和溢出开销
+	float64Size      = 8
+)
+
+// 估算对象占用的内存，包括 robj 本身和它引用的数据。
+// samples 大于 0 时只统计前 samples 个元素并按元素数量推算，用于 MEMORY USAGE 快速估算大对象；
+// 为 0 时统计全部元素，结果是确定的，内存统计的增减都使用这种方式。
+func objectComputeSize(o *robj, samples int) int64 {
+	size := int64(robjSize)
+	// 按取样的平均值推算全部元素
+	extrapolate := func(sampled, seen, total int64) int64 {
+		if seen == 0 || seen == total {
+			return sampled
+		}
+		return sampled * total / seen
+	}
+	switch v := o.ptr.(type) {
+	case string:
+		size += stringHeaderSize + int64(len(v))
+	case []string:
+		size += sliceHeaderSize + int64(cap(v))*stringHeaderSize
+		var sampled, seen int64
+		for _, item := range v {
+			if samples > 0 && seen == int64(samples) {
+				break
+			}
+			sampled += int64(len(item))
+			seen++
+		}
+		size += extrapolate(sampled, seen, int64(len(v)))
+	case map[string]float64:
+		var sampled, seen int64
+		for member := range v {
+			if samples > 0 && seen == int64(samples) {
+				break
+			}
+			sampled += mapEntryOverhead + stringHeaderSize + int64(len(member)) + float64Size
+			seen++
+		}
+		size += extrapolate(sampled, seen, int64(len(v)))
+	case map[string]string:
+		var sampled, seen int64
+		for field, value := range v {
+			if samples > 0 && seen == int64(samples) {
+				break
+			}
+			sampled += mapEntryOverhead + 2*stringHeaderSize + int64(len(field)) + int64(len(value))
+			seen++
+		}
+		size += extrapolate(sampled, seen, int64(len(v)))
+	}
+	return size
+}
@@ -0,0 +1,515 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// appendfsync 的三种策略
+const (
+	AOF_FSYNC_ALWAYS   = "always"   // 每次写入后立即 fsync，最安全也最慢
+	AOF_FSYNC_EVERYSEC = "everysec" // 后台协程每秒 fsync 一次，最多丢失一秒的数据
+	AOF_FSYNC_NO       = "no"       // 从不主动 fsync，由操作系统决定何时落盘
+)
+
+// AOF 写缓冲区的大小，缓冲区满时直接写出，否则等到回复发出之前或下一次 serverCron
+const AOF_WRITE_BUFFER_SIZE = 64 * 1024
+
+// aofWriter 管理 appenddirname 目录中的多文件 AOF：清单记录一个基础文件和若干增量文件，
+// 写命令以 RESP 多条批量回复的格式追加到最后一个增量文件中。
+type aofWriter struct {
+	mu          sync.Mutex
+	dir         string       // AOF 文件所在的目录
+	filename    string       // 文件名前缀，即 appendfilename
+	manifest    *aofManifest // 当前生效的清单
+	file        *os.File     // 正在追加的增量文件
+	w           *bufio.Writer
+	fsync       string    // 当前的 appendfsync 策略
+	selectedDB  int       // 当前增量文件中最后一次 SELECT 的数据库，-1 表示还没有写过
+	size        int64     // 基础文件和所有增量文件的总大小
+	unsynced    bool      // 是否有尚未 fsync 的写入
+	lastFsync   time.Time // 最近一次 fsync 的时间
+	lastErr     error     // 最近一次写入或 fsync 的错误
+	stopFsyncCh chan struct{}
+
+	// AOF 重写状态
+	baseSize    int64    // 上一次重写完成（或启动）时的总大小，用于自动重写
+	rewriting   bool     // 是否正在重写
+	rewriteIncr *aofInfo // 重写开始时打开的增量文件，重写完成后只保留它和新的基础文件
+	waitRewrite bool     // 刚开启 AOF、等待第一次重写完成，此时磁盘上的清单还不包含当前的增量文件
+}
+
+// 服务器启动时打开 AOF：清单不存在时用当前数据生成基础文件，然后继续追加最后一个增量文件，
+// 没有增量文件时新建一个。
+func openAOF(dir, filename, fsync string, useRDB bool, data map[string]*robj, expires map[string]time.Time, functions []string) (*aofWriter, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	m, err := loadAOFManifest(dir, filename)
+	if os.IsNotExist(err) {
+		m, err = &aofManifest{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	dirty := false
+	if m.base == nil && len(m.incrList) == 0 {
+		base := m.newBaseFile(filename, useRDB)
+		err := atomicWriteFile(filepath.Join(dir, base.fileName), TEMP_FILE_NAME_PREFIX+base.fileName, func(w io.Writer) error {
+			return writeAOFBase(w, data, expires, functions, useRDB)
+		})
+		if err != nil {
+			return nil, err
+		}
+		fmt.Printf("Creating AOF base file %s on server start\n", base.fileName)
+		dirty = true
+	}
+	incr := m.lastIncr()
+	if incr == nil {
+		incr = m.newIncrFile(filename)
+		fmt.Printf("Creating AOF incr file %s on server start\n", incr.fileName)
+		dirty = true
+	}
+	file, err := os.OpenFile(filepath.Join(dir, incr.fileName), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if dirty {
+		if err := m.persist(dir, filename); err != nil {
+			file.Close()
+			return nil, err
+		}
+	}
+	if err := m.deleteHistoryFiles(dir, filename); err != nil {
+		fmt.Println("Error persisting the AOF manifest:", err)
+	}
+
+	size := aofFilesSize(dir, m)
+	a := &aofWriter{
+		dir:         dir,
+		filename:    filename,
+		manifest:    m,
+		file:        file,
+		w:           bufio.NewWriterSize(file, AOF_WRITE_BUFFER_SIZE),
+		fsync:       fsync,
+		selectedDB:  -1,
+		size:        size,
+		baseSize:    size,
+		lastFsync:   time.Now(),
+		stopFsyncCh: make(chan struct{}),
+	}
+	go a.fsyncLoop()
+	return a, nil
+}
+
+// 运行时开启 AOF 时使用：在第一次重写开始之前不打开任何文件，重写完成后才写入新的清单。
+// 磁盘上已有的清单只用来延续文件序号。
+func newAOFWriterWaitRewrite(dir, filename, fsync string) *aofWriter {
+	m, err := loadAOFManifest(dir, filename)
+	if err != nil {
+		m = &aofManifest{}
+	}
+	a := &aofWriter{
+		dir:         dir,
+		filename:    filename,
+		manifest:    m,
+		fsync:       fsync,
+		selectedDB:  -1,
+		lastFsync:   time.Now(),
+		stopFsyncCh: make(chan struct{}),
+		waitRewrite: true,
+	}
+	go a.fsyncLoop()
+	return a
+}
+
+// 返回清单中基础文件和所有增量文件的总大小。
+func aofFilesSize(dir string, m *aofManifest) int64 {
+	var size int64
+	files := m.incrList
+	if m.base != nil {
+		files = append([]*aofInfo{m.base}, files...)
+	}
+	for _, ai := range files {
+		if info, err := os.Stat(filepath.Join(dir, ai.fileName)); err == nil {
+			size += info.Size()
+		}
+	}
+	return size
+}
+
+// 把一条命令按 RESP 多条批量回复的格式追加到 buf。
+func catAppendOnlyGenericCommand(buf []byte, args []string) []byte {
+	buf = append(buf, '*')
+	buf = strconv.AppendInt(buf, int64(len(args)), 10)
+	buf = append(buf, '\r', '\n')
+	for _, arg := range args {
+		buf = append(buf, '$')
+		buf = strconv.AppendInt(buf, int64(len(arg)), 10)
+		buf = append(buf, '\r', '\n')
+		buf = append(buf, arg...)
+		buf = append(buf, '\r', '\n')
+	}
+	return buf
+}
+
+// 把数据库 dbid 上执行的一条写命令追加到当前增量文件；数据库发生变化时先写入 SELECT。
+func (a *aofWriter) feed(dbid int, args []string) error {
+	var buf []byte
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.file == nil {
+		return nil // 等待第一次重写开始，重写会从那一刻的数据生成基础文件
+	}
+	if dbid != a.selectedDB {
+		buf = catAppendOnlyGenericCommand(buf, []string{"SELECT", strconv.Itoa(dbid)})
+		a.selectedDB = dbid
+	}
+	buf = catAppendOnlyGenericCommand(buf, args)
+
+	// 只写入缓冲区，在回复发出之前由客户端的写出协程交给操作系统；always 策略下立即写出并 fsync
+	if _, err := a.w.Write(buf); err != nil {
+		a.lastErr = err
+		return err
+	}
+	// 大于缓冲区剩余空间的写入由 bufio 直接写到文件，缓冲区可能仍然是空的
+	a.unsynced = true
+	a.size += int64(len(buf))
+	if a.fsync == AOF_FSYNC_ALWAYS {
+		return a.fsyncLocked()
+	}
+	return nil
+}
+
+// 把缓冲区中的命令写入增量文件。
+func (a *aofWriter) flush() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.flushLocked()
+}
+
+// 把缓冲区中的命令写入增量文件，调用方需持有 a.mu。
+func (a *aofWriter) flushLocked() error {
+	if a.w == nil || a.w.Buffered() == 0 {
+		return nil
+	}
+	if err := a.w.Flush(); err != nil {
+		a.lastErr = err
+		return err
+	}
+	a.unsynced = true
+	return nil
+}
+
+// 写出缓冲区并把文件内容刷到磁盘，调用方需持有 a.mu。
+func (a *aofWriter) fsyncLocked() error {
+	if err := a.flushLocked(); err != nil {
+		return err
+	}
+	if !a.unsynced || a.file == nil {
+		return nil
+	}
+	if err := a.file.Sync(); err != nil {
+		a.lastErr = err
+		return err
+	}
+	a.unsynced = false
+	a.lastFsync = time.Now()
+	a.lastErr = nil
+	return nil
+}
+
+// everysec 策略下每秒 fsync 一次。
+func (a *aofWriter) fsyncLoop() {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			a.mu.Lock()
+			if a.fsync == AOF_FSYNC_EVERYSEC {
+				if err := a.fsyncLocked(); err != nil {
+					fmt.Println("Error syncing AOF file:", err)
+				}
+			}
+			a.mu.Unlock()
+		case <-a.stopFsyncCh:
+			return
+		}
+	}
+}
+
+// 修改 fsync 策略。
+func (a *aofWriter) setFsyncPolicy(fsync string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.fsync = fsync
+	if fsync == AOF_FSYNC_ALWAYS {
+		a.fsyncLocked()
+	}
+}
+
+// 返回基础文件和增量文件的总大小。
+func (a *aofWriter) currentSize() int64 {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.size
+}
+
+// 返回最近一次写入或 fsync 的错误，没有错误时返回 nil。
+func (a *aofWriter) lastWriteError() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.lastErr
+}
+
+// 刷盘并关闭当前的增量文件。
+func (a *aofWriter) close() error {
+	close(a.stopFsyncCh)
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.closeFileLocked()
+}
+
+// 刷盘并关闭当前文件，调用方需持有 a.mu。
+func (a *aofWriter) closeFileLocked() error {
+	if a.file == nil {
+		return nil
+	}
+	a.fsyncLocked()
+	err := a.file.Close()
+	a.file, a.w = nil, nil
+	return err
+}
+
+// 返回上一次重写完成时的总大小。
+func (a *aofWriter) rewriteBaseSize() int64 {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.baseSize
+}
+
+// 开始重写：之后的写命令追加到一个新的增量文件中，重写结果只需覆盖在此之前的数据。
+// 调用方需保证此时没有并发的 feed（持有数据库写锁）。
+func (a *aofWriter) startRewrite() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if err := os.MkdirAll(a.dir, 0755); err != nil {
+		return err
+	}
+	m := a.manifest.dup()
+	incr := m.newIncrFile(a.filename)
+	file, err := os.OpenFile(filepath.Join(a.dir, incr.fileName), os.O_APPEND|os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	// 等待第一次重写时新的增量文件要等重写成功后才写入清单
+	if !a.waitRewrite {
+		if err := m.persist(a.dir, a.filename); err != nil {
+			file.Close()
+			os.Remove(filepath.Join(a.dir, incr.fileName))
+			return err
+		}
+	}
+	a.closeFileLocked()
+	a.manifest = m
+	a.file = file
+	a.w = bufio.NewWriterSize(file, AOF_WRITE_BUFFER_SIZE)
+	a.selectedDB = -1
+	a.rewriting = true
+	a.rewriteIncr = incr
+	return nil
+}
+
+// 放弃本次重写；新的增量文件已经在清单中，继续追加即可。
+func (a *aofWriter) abortRewrite() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.rewriting = false
+	a.rewriteIncr = nil
+}
+
+// 把重写生成的临时文件 tmp 作为新的基础文件，清单中只保留它和重写开始时打开的增量文件，
+// 其余文件变为历史文件并被删除。
+func (a *aofWriter) finishRewrite(tmp string, useRDB bool) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if !a.rewriting {
+		return errors.New("AOF rewrite was not started for this writer")
+	}
+	m, err := installAOFBase(a.dir, a.filename, a.manifest, tmp, useRDB, a.rewriteIncr)
+	if err != nil {
+		return err
+	}
+	a.manifest = m
+	a.rewriting = false
+	a.rewriteIncr = nil
+	a.waitRewrite = false
+	a.flushLocked()
+	a.size = aofFilesSize(a.dir, m)
+	a.baseSize = a.size
+	if err := m.deleteHistoryFiles(a.dir, a.filename); err != nil {
+		fmt.Println("Error persisting the AOF manifest:", err)
+	}
+	return nil
+}
+
+// 把 tmp 重命名为 m 的下一个基础文件，除 keep 以外的增量文件都标记为历史文件，然后原子地切换清单。
+// 返回新的清单；出错时 m 保持不变。
+func installAOFBase(dir, filename string, m *aofManifest, tmp string, useRDB bool, keep *aofInfo) (*aofManifest, error) {
+	m = m.dup()
+	oldBase := m.base
+	base := m.newBaseFile(filename, useRDB)
+	if err := os.Rename(tmp, filepath.Join(dir, base.fileName)); err != nil {
+		return nil, err
+	}
+	m.markHistory(oldBase, keep)
+	if err := m.persist(dir, filename); err != nil {
+		os.Remove(filepath.Join(dir, base.fileName))
+		return nil, err
+	}
+	return m, nil
+}
+
+// 生成 AOF 基础文件：useRDB 为 true 时写入 RDB 格式（aof-use-rdb-preamble），加载更快，否则写入命令。
+func writeAOFBase(w io.Writer, data map[string]*robj, expires map[string]time.Time, functions []string, useRDB bool) error {
+	if useRDB {
+		return rdbSaveSnapshot(w, data, expires, functions, true)
+	}
+	return rewriteAppendOnlyFile(w, data, expires, functions)
+}
+
+// 用最少的命令重建当前数据：每个函数库一条 FUNCTION LOAD，每个键一条 SET，带过期时间的键再加一条 PEXPIREAT。
+// 目前只有字符串类型有对应的写命令，其他类型需要使用 RDB 格式的基础文件。
+func rewriteAppendOnlyFile(w io.Writer, data map[string]*robj, expires map[string]time.Time, functions []string) error {
+	bw := bufio.NewWriter(w)
+	var buf []byte
+	for _, code := range functions {
+		buf = catAppendOnlyGenericCommand(buf, []string{"FUNCTION", "LOAD", code})
+	}
+	buf = catAppendOnlyGenericCommand(buf, []string{"SELECT", "0"})
+	for key, o := range data {
+		if o.rtype != OBJ_STRING {
+			return fmt.Errorf("can't rewrite key '%s' of type %s as commands", key, objTypeName(o.rtype))
+		}
+		buf = catAppendOnlyGenericCommand(buf, []string{"SET", key, o.ptr.(string)})
+		if t, ok := expires[key]; ok {
+			buf = catAppendOnlyGenericCommand(buf, []string{"PEXPIREAT", key, strconv.FormatInt(t.UnixMilli(), 10)})
+		}
+		if len(buf) >= 64*1024 {
+			if _, err := bw.Write(buf); err != nil {
+				return err
+			}
+			buf = buf[:0]
+		}
+	}
+	if _, err := bw.Write(buf); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// aofError 记录加载 AOF 失败时出错的文件和出错命令的起始偏移量。
+type aofError struct {
+	file   string
+	offset int64
+	err    error
+}
+
+func (e *aofError) Error() string {
+	return fmt.Sprintf("bad file format reading the append only file %s at offset %d: %v", e.file, e.offset, e.err)
+}
+
+func (e *aofError) Unwrap() error {
+	return e.err
+}
+
+var errAOFBadFormat = errors.New("invalid RESP multibulk")
+
+var errAOFIncompleteMulti = errors.New("unexpected end of file inside MULTI/EXEC")
+
+// aofReader 按顺序读取 AOF 文件中以 RESP 多条批量回复格式保存的命令，并跟踪当前偏移量。
+type aofReader struct {
+	r      *bufio.Reader
+	offset int64
+}
+
+// 创建一个新的 AOF 读取器。
+func newAOFReader(r io.Reader) *aofReader {
+	return &aofReader{r: bufio.NewReader(r)}
+}
+
+// 读取一行并去掉结尾的 \r\n；行不完整时返回 io.ErrUnexpectedEOF。
+func (r *aofReader) readLine() (string, error) {
+	line, err := r.r.ReadString('\n')
+	r.offset += int64(len(line))
+	if err != nil {
+		if err == io.EOF && line == "" {
+			return "", io.EOF
+		}
+		return "", io.ErrUnexpectedEOF
+	}
+	if len(line) < 2 || line[len(line)-2] != '\r' {
+		return "", errAOFBadFormat
+	}
+	return line[:len(line)-2], nil
+}
+
+// 读取 prefix 开头的长度行，例如 "*3" 或 "$5"。
+func (r *aofReader) readLength(prefix byte) (int, error) {
+	line, err := r.readLine()
+	if err != nil {
+		return 0, err
+	}
+	if len(line) < 2 || line[0] != prefix {
+		return 0, errAOFBadFormat
+	}
+	n, err := strconv.Atoi(line[1:])
+	if err != nil || n < 0 {
+		return 0, errAOFBadFormat
+	}
+	return n, nil
+}
+
+// 读取下一条命令。文件正好在命令边界结束时返回 io.EOF，最后一条命令不完整时返回 io.ErrUnexpectedEOF。
+func (r *aofReader) next() ([]string, error) {
+	argc, err := r.readLength('*')
+	if err != nil {
+		return nil, err
+	}
+	if argc < 1 {
+		return nil, errAOFBadFormat
+	}
+	args := make([]string, 0, min(argc, 1024))
+	for i := 0; i < argc; i++ {
+		n, err := r.readLength('$')
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return nil, err
+		}
+		// 长度可能来自损坏的文件，逐步读取而不是按长度一次分配
+		var arg bytes.Buffer
+		read, err := io.CopyN(&arg, r.r, int64(n)+2)
+		r.offset += read
+		if err != nil {
+			return nil, io.ErrUnexpectedEOF
+		}
+		data := arg.Bytes()
+		if data[n] != '\r' || data[n+1] != '\n' {
+			return nil, errAOFBadFormat
+		}
+		args = append(args, string(data[:n]))
+	}
+	return args, nil
+}
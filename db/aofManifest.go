@@ -0,0 +1,240 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// 多文件 AOF 中各类文件名的后缀
+const (
+	BASE_FILE_SUFFIX      = ".base"
+	INCR_FILE_SUFFIX      = ".incr"
+	RDB_FORMAT_SUFFIX     = ".rdb"
+	AOF_FORMAT_SUFFIX     = ".aof"
+	MANIFEST_NAME_SUFFIX  = ".manifest"
+	TEMP_FILE_NAME_PREFIX = "temp-"
+)
+
+// AOF 清单中记录的文件类型
+const (
+	AOF_FILE_TYPE_BASE = 'b' // 基础文件，重写生成的完整数据
+	AOF_FILE_TYPE_HIST = 'h' // 历史文件，等待删除
+	AOF_FILE_TYPE_INCR = 'i' // 增量文件，基础文件之后追加的写命令
+)
+
+var errAOFManifestFormat = errors.New("invalid AOF manifest")
+
+// aofInfo 描述清单中的一个文件。
+type aofInfo struct {
+	fileName string
+	fileSeq  int64
+	fileType byte
+}
+
+// aofManifest 记录组成 AOF 的基础文件和按顺序排列的增量文件，加载时先读基础文件，再依次读增量文件。
+type aofManifest struct {
+	base        *aofInfo
+	incrList    []*aofInfo
+	history     []*aofInfo
+	currBaseSeq int64 // 最近一个基础文件的序号
+	currIncrSeq int64 // 最近一个增量文件的序号
+}
+
+// 返回清单文件名，例如 appendonly.aof.manifest。
+func aofManifestName(filename string) string {
+	return filename + MANIFEST_NAME_SUFFIX
+}
+
+// 从 dir 中读取 filename 对应的清单文件；清单不存在时返回的错误满足 os.IsNotExist。
+func loadAOFManifest(dir, filename string) (*aofManifest, error) {
+	file, err := os.Open(filepath.Join(dir, aofManifestName(filename)))
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return parseAOFManifest(file)
+}
+
+// 解析清单内容，每行的格式为 "file <name> seq <seq> type <b|h|i>"，字段顺序不限。
+func parseAOFManifest(r io.Reader) (*aofManifest, error) {
+	m := &aofManifest{}
+	scanner := bufio.NewScanner(r)
+	lineno := 0
+	for scanner.Scan() {
+		lineno++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		argv, err := splitArgs(line)
+		if err != nil || len(argv) < 6 || len(argv)%2 != 0 {
+			return nil, fmt.Errorf("%w: line %d", errAOFManifestFormat, lineno)
+		}
+		ai := &aofInfo{}
+		for i := 0; i < len(argv); i += 2 {
+			switch strings.ToLower(argv[i]) {
+			case "file":
+				ai.fileName = argv[i+1]
+			case "seq":
+				ai.fileSeq, err = strconv.ParseInt(argv[i+1], 10, 64)
+			case "type":
+				if len(argv[i+1]) == 1 {
+					ai.fileType = argv[i+1][0]
+				}
+			}
+			// 未知的字段留给以后的版本，直接忽略
+		}
+		if err != nil || ai.fileName == "" || ai.fileSeq <= 0 ||
+			strings.ContainsRune(ai.fileName, os.PathSeparator) {
+			return nil, fmt.Errorf("%w: line %d", errAOFManifestFormat, lineno)
+		}
+		switch ai.fileType {
+		case AOF_FILE_TYPE_BASE:
+			if m.base != nil {
+				return nil, fmt.Errorf("%w: found duplicate base file information", errAOFManifestFormat)
+			}
+			m.base = ai
+			m.currBaseSeq = ai.fileSeq
+		case AOF_FILE_TYPE_HIST:
+			m.history = append(m.history, ai)
+		case AOF_FILE_TYPE_INCR:
+			if ai.fileSeq <= m.currIncrSeq {
+				return nil, fmt.Errorf("%w: found a non-monotonic sequence number", errAOFManifestFormat)
+			}
+			m.incrList = append(m.incrList, ai)
+			m.currIncrSeq = ai.fileSeq
+		default:
+			return nil, fmt.Errorf("%w: unknown file type at line %d", errAOFManifestFormat, lineno)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if m.base == nil && len(m.incrList) == 0 {
+		return nil, fmt.Errorf("%w: no base or incr file", errAOFManifestFormat)
+	}
+	return m, nil
+}
+
+// 按清单格式输出内容：基础文件在前，然后是历史文件和增量文件。
+func (m *aofManifest) encode() []byte {
+	var buf []byte
+	write := func(ai *aofInfo) {
+		buf = append(buf, "file "...)
+		buf = append(buf, quoteManifestName(ai.fileName)...)
+		buf = append(buf, " seq "...)
+		buf = strconv.AppendInt(buf, ai.fileSeq, 10)
+		buf = append(buf, " type "...)
+		buf = append(buf, ai.fileType, '\n')
+	}
+	if m.base != nil {
+		write(m.base)
+	}
+	for _, ai := range m.history {
+		write(ai)
+	}
+	for _, ai := range m.incrList {
+		write(ai)
+	}
+	return buf
+}
+
+// 文件名中带有空白或引号时加上引号，以便 splitArgs 能原样解析回来。
+func quoteManifestName(name string) string {
+	if strings.ContainsAny(name, " \t\r\n\"'\\") {
+		return strconv.Quote(name)
+	}
+	return name
+}
+
+// 复制一份清单，修改副本并持久化成功后再替换原来的清单，失败时内存中的状态保持不变。
+func (m *aofManifest) dup() *aofManifest {
+	n := *m
+	n.incrList = append([]*aofInfo(nil), m.incrList...)
+	n.history = append([]*aofInfo(nil), m.history...)
+	return &n
+}
+
+// 为新的基础文件分配文件名和序号，useRDB 表示基础文件采用 RDB 格式。
+func (m *aofManifest) newBaseFile(filename string, useRDB bool) *aofInfo {
+	m.currBaseSeq++
+	format := AOF_FORMAT_SUFFIX
+	if useRDB {
+		format = RDB_FORMAT_SUFFIX
+	}
+	m.base = &aofInfo{
+		fileName: fmt.Sprintf("%s.%d%s%s", filename, m.currBaseSeq, BASE_FILE_SUFFIX, format),
+		fileSeq:  m.currBaseSeq,
+		fileType: AOF_FILE_TYPE_BASE,
+	}
+	return m.base
+}
+
+// 为新的增量文件分配文件名和序号，并追加到增量文件列表末尾。
+func (m *aofManifest) newIncrFile(filename string) *aofInfo {
+	m.currIncrSeq++
+	ai := &aofInfo{
+		fileName: fmt.Sprintf("%s.%d%s%s", filename, m.currIncrSeq, INCR_FILE_SUFFIX, AOF_FORMAT_SUFFIX),
+		fileSeq:  m.currIncrSeq,
+		fileType: AOF_FILE_TYPE_INCR,
+	}
+	m.incrList = append(m.incrList, ai)
+	return ai
+}
+
+// 返回最后一个增量文件，没有增量文件时返回 nil。
+func (m *aofManifest) lastIncr() *aofInfo {
+	if len(m.incrList) == 0 {
+		return nil
+	}
+	return m.incrList[len(m.incrList)-1]
+}
+
+// 把除 keep 以外的基础文件和增量文件都标记为历史文件，它们的数据已经包含在新的基础文件中。
+func (m *aofManifest) markHistory(oldBase *aofInfo, keep *aofInfo) {
+	if oldBase != nil {
+		m.history = append(m.history, &aofInfo{fileName: oldBase.fileName, fileSeq: oldBase.fileSeq, fileType: AOF_FILE_TYPE_HIST})
+	}
+	var incrList []*aofInfo
+	for _, ai := range m.incrList {
+		if ai == keep {
+			incrList = append(incrList, ai)
+			continue
+		}
+		m.history = append(m.history, &aofInfo{fileName: ai.fileName, fileSeq: ai.fileSeq, fileType: AOF_FILE_TYPE_HIST})
+	}
+	m.incrList = incrList
+}
+
+// 原子地把清单写入 dir：先写临时文件并刷盘，再重命名覆盖旧的清单。
+func (m *aofManifest) persist(dir, filename string) error {
+	name := aofManifestName(filename)
+	return atomicWriteFile(filepath.Join(dir, name), TEMP_FILE_NAME_PREFIX+name, func(w io.Writer) error {
+		_, err := w.Write(m.encode())
+		return err
+	})
+}
+
+// 删除历史文件并把它们从清单中移除，然后持久化清单。删除失败的文件留到下次再删。
+func (m *aofManifest) deleteHistoryFiles(dir, filename string) error {
+	if len(m.history) == 0 {
+		return nil
+	}
+	var remain []*aofInfo
+	for _, ai := range m.history {
+		if err := os.Remove(filepath.Join(dir, ai.fileName)); err != nil && !os.IsNotExist(err) {
+			fmt.Printf("Can't remove AOF history file %s: %v\n", ai.fileName, err)
+			remain = append(remain, ai)
+			continue
+		}
+		fmt.Printf("Removing the history file %s\n", ai.fileName)
+	}
+	m.history = remain
+	return m.persist(dir, filename)
+}
@@ -0,0 +1,32 @@
+package main
+
+// Redis 在 RDB 文件末尾使用的 CRC64 校验（Jones 多项式，输入输出反射，初值和结果都不取反）。
+// 标准库 hash/crc64 会对初值和结果取反，算出来的值与 Redis 不一致，因此这里单独实现。
+const crc64JonesPoly = 0x95ac9329ac4bc9b5 // 0xad93d23594c935a9 的位反转形式
+
+var crc64Table = makeCRC64Table()
+
+// 生成按字节查表用的 CRC64 表。
+func makeCRC64Table() *[256]uint64 {
+	t := new([256]uint64)
+	for i := range t {
+		crc := uint64(i)
+		for j := 0; j < 8; j++ {
+			if crc&1 == 1 {
+				crc = crc>>1 ^ crc64JonesPoly
+			} else {
+				crc >>= 1
+			}
+		}
+		t[i] = crc
+	}
+	return t
+}
+
+// 在已有校验值 crc 的基础上继续累计 p 的 CRC64。
+func crc64Update(crc uint64, p []byte) uint64 {
+	for _, b := range p {
+		crc = crc64Table[byte(crc)^b] ^ crc>>8
+	}
+	return crc
+}
@@ -2,29 +2,70 @@ package main
 
 import (
 	"bufio"
-	"encoding/gob"
 	"fmt"
+	"io"
 	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 // 表示一个 Redis 数据库，包含键值对存储、过期时间存储以及持久化文件路径。
 type redisDb struct {
-	data     map[string]string // 存储键值对
+	data     map[string]*robj // 存储键值对
 	expires  map[string]time.Time // 存储键的过期时间
 	mu       sync.RWMutex // 互斥锁，用于并发控制
 	rdbFile  string // RDB 持久化文件路径
-	aofFile  string // AOF 持久化文件路径
+	aofDir   string // 多文件 AOF 所在的目录
+	aofFile  string // AOF 文件名前缀，基础文件、增量文件和清单都以它命名
+	dirty    int64 // 自上次保存以来的修改次数
+	aof      *aofWriter // 开启 AOF 时的写入器，关闭时为 nil
+	aofPending int32 // AOF 缓冲区中是否可能有尚未写出的命令，原子地访问
+
+	usedMemory      int64 // 所有键值对和过期时间估算占用的内存
+	expiresMemory   int64 // 其中过期时间占用的部分
+	maxmemoryPolicy int   // 内存淘汰策略，决定访问键时更新 LRU 时钟还是 LFU 计数器
+	lfuLogFactor    int   // LFU 计数器的对数增长因子
+	lfuDecayTime    int   // LFU 计数器每衰减 1 需要经过的分钟数
+	evictionPool    []evictionPoolEntry // 按空闲程度排序的淘汰候选键
+
+	execMu      sync.RWMutex // 命令执行锁：普通命令和后台任务持有读锁，EXEC 持有写锁以保证事务的原子性
+	watchedKeys map[string]map[*redisClient]struct{} // 每个键被哪些客户端 WATCH
+	multiDepth  int // 正在写入 AOF 的事务嵌套层数
+
+	functions        map[string]string // 函数库名 → 代码，随数据一起保存到 RDB 和 AOF
+	functionsVersion int64 // 函数库每次变化时加一，脚本引擎据此判断是否需要重新编译
+
+	notifyKeyspaceEvents int // 开启的键空间通知类别（NOTIFY_*）
+	notifyPublish        func(channel, message string) // 发布键空间通知的方法，由服务器设置
+	trackingInvalidate   func(key string) // 键过期或被淘汰时通知客户端缓存失效，由服务器设置
+	writePaused          func() bool // CLIENT PAUSE WRITE 期间返回 true，此时不删除过期键，由服务器设置
 }
 
+// 查找键的选项
+const (
+	LOOKUP_NONE    = 0
+	LOOKUP_NOTOUCH = 1 << 0 // 不更新键的访问时间和访问频率（CLIENT NO-TOUCH）
+)
+
 // 创建一个新的 Redis 数据库实例。
-func newRedisDb(rdbFile, aofFile string) *redisDb {
+func newRedisDb(rdbFile, aofDir, aofFile string) *redisDb {
 	return &redisDb{
-		data:    make(map[string]string),
+		data:    make(map[string]*robj),
 		expires: make(map[string]time.Time),
+		watchedKeys: make(map[string]map[*redisClient]struct{}),
+		functions:   make(map[string]string),
 		rdbFile: rdbFile,
+		aofDir:  aofDir,
 		aofFile: aofFile,
+
+		maxmemoryPolicy: MAXMEMORY_NO_EVICTION,
+		lfuLogFactor:    10,
+		lfuDecayTime:    1,
 	}
 }
 
@@ -32,92 +73,668 @@ func newRedisDb(rdbFile, aofFile string) *redisDb {
 func (db *redisDb) setKey(key, value string) {
 	db.mu.Lock()
 	defer db.mu.Unlock()
-	db.data[key] = value
-	db.saveAOF(fmt.Sprintf("SET %s %s", key, value)) // 记录到 AOF 文件
+	if db.lookupKey(key, LOOKUP_NONE) == nil {
+		db.notifyKeyspaceEvent(NOTIFY_NEW, "new", key)
+	}
+	db.dbSet(key, db.newObject(OBJ_STRING, value))
+	db.dirty++
+	db.feedAppendOnlyFile("SET", key, value) // 记录到 AOF 文件
+	db.notifyKeyspaceEvent(NOTIFY_STRING, "set", key)
+}
+
+// 获取一个键对应的值，除非 flags 包含 LOOKUP_NOTOUCH，同时更新键的访问时间或访问频率。
+// 更新访问信息会修改对象，所以这里需要写锁。键不存在时第二个返回值为 false，与空字符串区分。
+func (db *redisDb) getKey(key string, flags int) (string, bool) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	o := db.lookupKey(key, flags)
+	if o == nil {
+		db.notifyKeyspaceEvent(NOTIFY_KEY_MISS, "keymiss", key)
+		return "", false
+	}
+	if o.rtype != OBJ_STRING {
+		return "", false
+	}
+	return o.ptr.(string), true
+}
+
+// 查找一个键并更新它的访问信息，已经过期的键会先被删除。调用方需持有写锁。
+func (db *redisDb) lookupKey(key string, flags int) *robj {
+	if db.keyIsExpired(key) {
+		// 暂停写入期间数据不能变化，过期的键视为不存在但暂不删除
+		if !db.isWritePaused() {
+			db.deleteExpiredKey(key)
+		}
+		return nil
+	}
+	o := db.data[key]
+	if o != nil && flags&LOOKUP_NOTOUCH == 0 {
+		db.touchObject(o)
+	}
+	return o
+}
+
+// 写入一个键值对并更新内存统计，调用方需持有写锁。
+func (db *redisDb) dbSet(key string, o *robj) {
+	if old, ok := db.data[key]; ok {
+		db.usedMemory -= keyMemoryUsage(key, old)
+	}
+	db.data[key] = o
+	db.usedMemory += keyMemoryUsage(key, o)
+	db.touchWatchedKey(key)
+}
+
+// 删除一个键及其过期时间并更新内存统计，键存在时返回 true，调用方需持有写锁。
+func (db *redisDb) dbDelete(key string) bool {
+	o, ok := db.data[key]
+	if !ok {
+		return false
+	}
+	delete(db.data, key)
+	db.usedMemory -= keyMemoryUsage(key, o)
+	db.touchWatchedKey(key)
+	db.removeExpire(key)
+	return true
+}
+
+// 设置过期时间并更新内存统计，调用方需持有写锁。
+func (db *redisDb) dbSetExpire(key string, when time.Time) {
+	if _, ok := db.expires[key]; !ok {
+		db.usedMemory += expireMemoryUsage(key)
+		db.expiresMemory += expireMemoryUsage(key)
+	}
+	db.expires[key] = when
+	db.touchWatchedKey(key)
+}
+
+// 移除过期时间并更新内存统计，调用方需持有写锁。
+func (db *redisDb) removeExpire(key string) {
+	if _, ok := db.expires[key]; ok {
+		delete(db.expires, key)
+		db.usedMemory -= expireMemoryUsage(key)
+		db.expiresMemory -= expireMemoryUsage(key)
+		db.touchWatchedKey(key)
+	}
+}
+
+// 返回所有键值对和过期时间估算占用的内存。
+func (db *redisDb) memoryUsed() int64 {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return db.usedMemory
+}
+
+// dbMemoryStats 是 MEMORY STATS 和 INFO 使用的数据库内存统计。
+type dbMemoryStats struct {
+	keys            int64 // 键的数量
+	expires         int64 // 设置了过期时间的键的数量
+	used            int64 // 所有键值对和过期时间估算占用的内存
+	overheadMain    int64 // data 中 map 元素本身的开销
+	overheadExpires int64 // expires 占用的全部内存
+}
+
+// 返回数据库的内存统计。
+func (db *redisDb) memoryStats() dbMemoryStats {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return dbMemoryStats{
+		keys:            int64(len(db.data)),
+		expires:         int64(len(db.expires)),
+		used:            db.usedMemory,
+		overheadMain:    int64(len(db.data)) * mapEntryOverhead,
+		overheadExpires: db.expiresMemory,
+	}
+}
+
+// 估算一个键占用的内存，samples 为 0 时统计全部元素。不更新键的访问信息，键不存在时返回 false。
+func (db *redisDb) memoryUsage(key string, samples int) (int64, bool) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	o, ok := db.data[key]
+	if !ok {
+		return 0, false
+	}
+	return keyEntryOverhead(key) + objectComputeSize(o, samples), true
+}
+
+// keyInfo 是分析键空间时一个键的统计信息。
+type keyInfo struct {
+	key    string
+	rtype  uint8
+	length int64  // 元素数量，字符串为字节数
+	memory int64  // 估算占用的内存
+	freq   uint32 // LFU 访问频率，只在 LFU 策略下有意义
+}
+
+// 返回当前所有键名的快照，供分批分析键空间使用。
+func (db *redisDb) keyNames() []string {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	keys := make([]string, 0, len(db.data))
+	for key := range db.data {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// 返回一批键的统计信息，已经被删除的键被跳过。不更新键的访问信息。
+func (db *redisDb) keyStats(keys []string, samples int) []keyInfo {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	infos := make([]keyInfo, 0, len(keys))
+	for _, key := range keys {
+		o, ok := db.data[key]
+		if !ok {
+			continue
+		}
+		info := keyInfo{
+			key:    key,
+			rtype:  o.rtype,
+			length: objectLength(o),
+			memory: keyEntryOverhead(key) + objectComputeSize(o, samples),
+		}
+		if db.maxmemoryPolicy&MAXMEMORY_FLAG_LFU != 0 {
+			info.freq = lfuDecrAndReturn(o, db.lfuDecayTime)
+		}
+		infos = append(infos, info)
+	}
+	return infos
 }
 
-// 获取一个键对应的值。
-func (db *redisDb) getKey(key string) string {
+// 判断当前淘汰策略是否记录 LFU 访问频率。
+func (db *redisDb) lfuEnabled() bool {
 	db.mu.RLock()
 	defer db.mu.RUnlock()
-	return db.data[key]
+	return db.maxmemoryPolicy&MAXMEMORY_FLAG_LFU != 0
 }
 
 // 删除一个键，并将操作记录到 AOF 文件。
 func (db *redisDb) deleteKey(key string) {
 	db.mu.Lock()
 	defer db.mu.Unlock()
-	delete(db.data, key)
-	delete(db.expires, key)
-	db.saveAOF(fmt.Sprintf("DEL %s", key)) // 记录到 AOF 文件
+	if db.dbDelete(key) {
+		db.dirty++
+		db.notifyKeyspaceEvent(NOTIFY_GENERIC, "del", key)
+	}
+	db.removeExpire(key)
+	db.feedAppendOnlyFile("DEL", key) // 记录到 AOF 文件
+}
+
+// 删除一个已经过期的键，以 DEL 的形式写入 AOF。调用方需持有写锁。
+func (db *redisDb) deleteExpiredKey(key string) {
+	if db.dbDelete(key) {
+		db.notifyKeyspaceEvent(NOTIFY_EXPIRED, "expired", key)
+		db.invalidateTrackedKey(key)
+	}
+	db.removeExpire(key)
+	db.dirty++
+	db.feedAppendOnlyFile("DEL", key)
+}
+
+// 是否处于 CLIENT PAUSE WRITE 期间。
+func (db *redisDb) isWritePaused() bool {
+	return db.writePaused != nil && db.writePaused()
 }
 
-// 为一个键设置过期时间，并将操作记录到 AOF 文件。
-func (db *redisDb) setExpire(key string, expireTime time.Duration) {
+// 键过期或被淘汰时通知客户端缓存失效。命令修改的键由命令执行之后统一处理。调用方需持有写锁。
+func (db *redisDb) invalidateTrackedKey(key string) {
+	if db.trackingInvalidate != nil {
+		db.trackingInvalidate(key)
+	}
+}
+
+// 把 src 重命名为 dst，过期时间随键一起转移，dst 已存在时被覆盖。src 不存在时返回 false。
+func (db *redisDb) renameKey(src, dst string) bool {
 	db.mu.Lock()
 	defer db.mu.Unlock()
-	db.expires[key] = time.Now().Add(expireTime)
-	db.saveAOF(fmt.Sprintf("EXPIRE %s %d", key, int(expireTime.Seconds()))) // 记录到 AOF 文件
+	o := db.lookupKey(src, LOOKUP_NONE)
+	if o == nil {
+		return false
+	}
+	if src == dst {
+		return true
+	}
+	when, hasExpire := db.expires[src]
+	db.dbDelete(dst)
+	db.dbSet(dst, o)
+	if hasExpire {
+		db.dbSetExpire(dst, when)
+	}
+	db.dbDelete(src)
+	db.dirty++
+	db.feedAppendOnlyFile("RENAME", src, dst) // 记录到 AOF 文件
+	db.notifyKeyspaceEvent(NOTIFY_GENERIC, "rename_from", src)
+	db.notifyKeyspaceEvent(NOTIFY_GENERIC, "rename_to", dst)
+	return true
 }
 
-// 将当前数据库状态保存到 RDB 文件。
-func (db *redisDb) saveRDB() error {
+// 为一个键设置过期时间，并将操作记录到 AOF 文件。键不存在时什么也不做并返回 false。
+func (db *redisDb) setExpire(key string, expireTime time.Duration) bool {
+	return db.setExpireAt(key, time.Now().Add(expireTime))
+}
+
+// 为一个键设置绝对过期时间。AOF 中统一记录为 PEXPIREAT，重放时不会延长键的生存时间。
+// 键不存在时什么也不做并返回 false，不会留下没有对应值的过期时间。
+func (db *redisDb) setExpireAt(key string, when time.Time) bool {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.lookupKey(key, LOOKUP_NONE) == nil {
+		return false
+	}
+	db.dbSetExpire(key, when)
+	db.dirty++
+	db.feedAppendOnlyFile("PEXPIREAT", key, strconv.FormatInt(when.UnixMilli(), 10)) // 记录到 AOF 文件
+	db.notifyKeyspaceEvent(NOTIFY_GENERIC, "expire", key)
+	return true
+}
+
+// 返回自上次保存以来的修改次数。
+func (db *redisDb) dirtyCount() int64 {
 	db.mu.RLock()
 	defer db.mu.RUnlock()
-	file, err := os.Create(db.rdbFile) // 创建或覆盖 RDB 文件
+	return db.dirty
+}
+
+// 保存成功后扣除这次保存已经包含的修改次数，保存期间发生的修改仍然计入。
+func (db *redisDb) clearDirty(saved int64) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.dirty -= saved
+}
+
+// 复制一份当前数据作为时间点一致的快照，返回复制时的修改次数。
+// 后台保存使用这份副本，复制完成后客户端可以继续写入。
+func (db *redisDb) snapshot() (map[string]*robj, map[string]time.Time, []string, int64) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	data, expires, functions := db.copyDataLocked()
+	return data, expires, functions, db.dirty
+}
+
+// 复制键值、过期时间和函数库代码，调用方需持有锁。
+func (db *redisDb) copyDataLocked() (map[string]*robj, map[string]time.Time, []string) {
+	// 对象在写入后不会被原地修改，副本可以和数据库共享同一个对象
+	data := make(map[string]*robj, len(db.data))
+	for key, o := range db.data {
+		data[key] = o
+	}
+	expires := make(map[string]time.Time, len(db.expires))
+	for key, t := range db.expires {
+		expires[key] = t
+	}
+	return data, expires, db.functionCodesLocked()
+}
+
+// 返回 RDB 文件的路径。
+func (db *redisDb) rdbFilename() string {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return db.rdbFile
+}
+
+// 修改 RDB 文件的路径（CONFIG SET dbfilename），之后的保存使用新的文件名。
+func (db *redisDb) setRDBFilename(filename string) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.rdbFile = filename
+}
+
+// 将当前数据库状态保存到 RDB 文件，保存期间阻塞所有写操作。
+func (db *redisDb) saveRDB() error {
+	db.mu.RLock()
+	dirty := db.dirty
+	err := rdbSaveFile(db.rdbFile, db.data, db.expires, db.functionCodesLocked())
+	db.mu.RUnlock()
 	if err != nil {
 		return err
 	}
-	defer file.Close()
-	encoder := gob.NewEncoder(file) // 使用 gob 编码器
-	return encoder.Encode(db.data) // 将数据编码并写入文件
+	db.clearDirty(dirty)
+	return nil
+}
+
+// 临时 RDB 文件的序号，保证每次保存使用不同的临时文件
+var rdbTempFileSeq int64
+
+// 把数据以 RDB 格式写入临时文件，再原子地替换 filename，保存中途崩溃不会破坏原来的文件。
+func rdbSaveFile(filename string, data map[string]*robj, expires map[string]time.Time, functions []string) error {
+	tmpName := fmt.Sprintf("temp-%d-%d.rdb", os.Getpid(), atomic.AddInt64(&rdbTempFileSeq, 1))
+	return atomicWriteFile(filename, tmpName, func(w io.Writer) error {
+		return rdbSaveSnapshot(w, data, expires, functions, false)
+	})
+}
+
+// 按 RDB 格式把一份键值数据写入 w，包含文件头、AUX 字段、函数库、数据库 0 的全部键以及校验和。
+// aofBase 表示这份数据用作 AOF 的基础文件。
+func rdbSaveSnapshot(w io.Writer, data map[string]*robj, expires map[string]time.Time, functions []string, aofBase bool) error {
+	enc := newRDBEncoder(w)
+	enc.writeHeader()
+	rdbSaveInfoAuxFields(enc, aofBase)
+	for _, code := range functions {
+		enc.writeFunction(code)
+	}
+
+	volatile := 0
+	for key := range expires {
+		if _, ok := data[key]; ok {
+			volatile++
+		}
+	}
+	enc.writeSelectDB(0)
+	enc.writeResizeDB(len(data), volatile)
+	for key, o := range data {
+		expireAt := int64(-1)
+		if t, ok := expires[key]; ok {
+			expireAt = t.UnixMilli()
+		}
+		if err := enc.writeObject(key, o, expireAt); err != nil {
+			return err
+		}
+	}
+	return enc.writeEOF()
+}
+
+// 写入描述本服务器和保存时刻的 AUX 字段。
+func rdbSaveInfoAuxFields(enc *rdbEncoder, aofBase bool) {
+	enc.writeAux("redis-ver", REDIS_VERSION)
+	enc.writeAux("redis-bits", strconv.Itoa(strconv.IntSize))
+	enc.writeAux("ctime", strconv.FormatInt(time.Now().Unix(), 10))
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	enc.writeAux("used-mem", strconv.FormatUint(mem.HeapAlloc, 10))
+	enc.writeAux("aof-base", strconv.Itoa(boolToInt(aofBase)))
 }
 
 // loadRDB 从 RDB 文件加载数据到内存。
+// 文件可以来自本服务器，也可以来自真实的 Redis；已过期的键会被跳过，
+// 文件中有数据库 0 以外的键时加载失败。
 func (db *redisDb) loadRDB() error {
-	file, err := os.Open(db.rdbFile) // 打开 RDB 文件
+	file, err := os.Open(db.rdbFilename()) // 打开 RDB 文件
 	if err != nil {
 		return err
 	}
 	defer file.Close()
-	decoder := gob.NewDecoder(file) // 使用 gob 解码器
-	return decoder.Decode(&db.data) // 将文件内容解码到内存
+	return db.loadRDBFrom(newRDBDecoder(file))
+}
+
+// 从 dec 读取一份完整的 RDB 数据载入内存，用于 RDB 文件和 RDB 格式的 AOF 基础文件。
+func (db *redisDb) loadRDBFrom(dec *rdbDecoder) error {
+	if _, err := dec.readHeader(); err != nil {
+		return err
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	now := time.Now().UnixMilli()
+	dbid := 0
+	for {
+		entry, err := dec.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if entry.opcode == RDB_OPCODE_SELECTDB {
+			dbid = entry.dbid
+		}
+		if entry.opcode == RDB_OPCODE_FUNCTION2 {
+			// 函数库只保存代码，由脚本引擎在第一次使用前编译
+			_, name, err := functionExtractLibraryMetadata(entry.value)
+			if err != nil {
+				return dec.fail(err)
+			}
+			db.functions[name] = entry.value
+			db.functionsVersion++
+			continue
+		}
+		if !entry.isKey() {
+			continue
+		}
+		if dbid != 0 {
+			// 只有 0 号数据库，不能把其他数据库的键合并进来，也不能悄悄丢掉
+			return dec.fail(fmt.Errorf("key '%s' is in db %d, but only db 0 is supported", entry.key, dbid))
+		}
+		if entry.expireAt != -1 && entry.expireAt < now {
+			continue // 已经过期的键不再载入
+		}
+		db.loadObjectAccess(entry)
+		db.dbSet(entry.key, entry.obj)
+		if entry.expireAt != -1 {
+			db.dbSetExpire(entry.key, time.UnixMilli(entry.expireAt))
+		}
+	}
+	return nil
 }
 
-// 将命令追加到 AOF 文件。
-func (db *redisDb) saveAOF(command string) {
-	file, err := os.OpenFile(db.aofFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644) // 打开 AOF 文件
+// 打开 AOF，此后的写命令都会追加到最后一个增量文件中；还没有 AOF 时用当前数据生成基础文件。
+func (db *redisDb) openAOF(fsync string, useRDB bool) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	aof, err := openAOF(db.aofDir, db.aofFile, fsync, useRDB, db.data, db.expires, db.functionCodesLocked())
 	if err != nil {
-		fmt.Println("Error opening AOF file:", err)
-		return
+		return err
+	}
+	db.aof = aof
+	return nil
+}
+
+// 返回当前的 AOF 写入器，没有开启 AOF 时返回 nil。
+func (db *redisDb) appendOnly() *aofWriter {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return db.aof
+}
+
+// 判断是否开启了 AOF。
+func (db *redisDb) aofEnabled() bool {
+	return db.appendOnly() != nil
+}
+
+// 判断 AOF 是否刚在运行时开启、还在等待第一次重写完成。
+func (db *redisDb) aofWaitingRewrite() bool {
+	aof := db.appendOnly()
+	if aof == nil {
+		return false
+	}
+	aof.mu.Lock()
+	defer aof.mu.Unlock()
+	return aof.waitRewrite
+}
+
+// 运行时开启 AOF：文件要等第一次重写完成后才会生成，调用方随后需要启动一次重写。
+func (db *redisDb) startAppendOnly(fsync string) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.aof == nil {
+		db.aof = newAOFWriterWaitRewrite(db.aofDir, db.aofFile, fsync)
+	}
+}
+
+// 运行时关闭 AOF。
+func (db *redisDb) stopAppendOnly() {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.aof != nil {
+		db.aof.close()
+		db.aof = nil
+	}
+}
+
+// 开始 AOF 重写：在写锁下复制数据，同时让 AOF 切换到新的增量文件，保证两者正好衔接。
+func (db *redisDb) startAOFRewrite() (map[string]*robj, map[string]time.Time, []string, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.aof != nil {
+		if err := db.aof.startRewrite(); err != nil {
+			return nil, nil, nil, err
+		}
+	}
+	data, expires, functions := db.copyDataLocked()
+	return data, expires, functions, nil
+}
+
+// 完成 AOF 重写：把临时文件 tmp 作为新的基础文件写入清单。
+func (db *redisDb) finishAOFRewrite(tmp string, useRDB bool) error {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	if db.aof != nil {
+		return db.aof.finishRewrite(tmp, useRDB)
+	}
+	// 没有开启 AOF，新的基础文件已经包含全部数据，原有的增量文件都不再需要
+	m, err := loadAOFManifest(db.aofDir, db.aofFile)
+	if os.IsNotExist(err) {
+		m, err = &aofManifest{}, nil
 	}
-	defer file.Close()
-	_, err = file.WriteString(command + "\n") // 将命令写入文件
 	if err != nil {
+		return err
+	}
+	if m, err = installAOFBase(db.aofDir, db.aofFile, m, tmp, useRDB, nil); err != nil {
+		return err
+	}
+	return m.deleteHistoryFiles(db.aofDir, db.aofFile)
+}
+
+// 放弃 AOF 重写。
+func (db *redisDb) abortAOFRewrite() {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	if db.aof != nil {
+		db.aof.abortRewrite()
+	}
+}
+
+// 把 AOF 缓冲区中的命令写入文件。客户端的写出协程在发出回复之前调用，保证确认过的写命令已经交给操作系统，
+// 与 Redis 在 beforeSleep 中先写 AOF 再写回复一致；serverCron 也会调用。
+func (db *redisDb) flushAppendOnlyFile() {
+	if atomic.SwapInt32(&db.aofPending, 0) == 0 {
+		return
+	}
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	if db.aof == nil {
+		return
+	}
+	if err := db.aof.flush(); err != nil {
 		fmt.Println("Error writing to AOF file:", err)
 	}
 }
 
-// loadAOF 从 AOF 文件加载命令并执行。
-func (db *redisDb) loadAOF() error {
-	file, err := os.Open(db.aofFile) // 打开 AOF 文件
+// 将命令追加到 AOF 文件，调用方需持有写锁；没有开启 AOF 时什么也不做。
+func (db *redisDb) feedAppendOnlyFile(args ...string) {
+	if db.aof == nil {
+		return
+	}
+	if err := db.aof.feed(0, args); err != nil {
+		fmt.Println("Error writing to AOF file:", err)
+	}
+	atomic.StoreInt32(&db.aofPending, 1)
+}
+
+// loadAOF 按清单依次加载基础文件和各个增量文件，文件中的命令交给 exec 执行。
+// 只有旧版的单个 AOF 文件时先把它升级为多文件格式。
+// 最后一个文件的最后一条命令不完整（例如写入时进程崩溃）时，如果 loadTruncated 为 true，
+// 会截掉这条不完整的命令并正常完成加载，否则返回错误。
+func (db *redisDb) loadAOF(exec func(args []string) error, loadTruncated bool) error {
+	m, err := loadAOFManifest(db.aofDir, db.aofFile)
+	if os.IsNotExist(err) {
+		m, err = db.upgradeAOF()
+	}
+	if err != nil {
+		return err
+	}
+	files := m.incrList
+	if m.base != nil {
+		files = append([]*aofInfo{m.base}, files...)
+	}
+	for i, ai := range files {
+		last := i == len(files)-1
+		if err := db.loadAppendOnlyFile(filepath.Join(db.aofDir, ai.fileName), exec, loadTruncated && last); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 把旧版的单个 AOF 文件移动到 AOF 目录中作为基础文件，并生成清单。
+// 先写清单再移动文件，与 Redis 的升级顺序一致。
+func (db *redisDb) upgradeAOF() (*aofManifest, error) {
+	if _, err := os.Stat(db.aofFile); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(db.aofDir, 0755); err != nil {
+		return nil, err
+	}
+	m := &aofManifest{
+		base:        &aofInfo{fileName: db.aofFile, fileSeq: 1, fileType: AOF_FILE_TYPE_BASE},
+		currBaseSeq: 1,
+	}
+	if err := m.persist(db.aofDir, db.aofFile); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(db.aofFile, filepath.Join(db.aofDir, db.aofFile)); err != nil {
+		return nil, err
+	}
+	fsyncDir(db.aofDir)
+	fmt.Printf("Successfully migrated an old-style AOF into the AOF directory %s\n", db.aofDir)
+	return m, nil
+}
+
+// 加载一个 AOF 文件。文件以 RDB 头开始时（RDB 格式的基础文件或旧版带 RDB 前导的 AOF）
+// 先按 RDB 载入，再继续读取之后的命令。
+func (db *redisDb) loadAppendOnlyFile(filename string, exec func(args []string) error, loadTruncated bool) error {
+	file, err := os.Open(filename)
 	if err != nil {
 		return err
 	}
 	defer file.Close()
-	scanner := bufio.NewScanner(file) // 创建扫描器
-	for scanner.Scan() {
-		line := scanner.Text()
-		args := strings.Fields(line)
-		cmd := strings.ToUpper(args[0])
-		for _, cmdDef := range commands {
-			if cmd == cmdDef.name {
-				cmdDef.handler(nil, args) // 执行命令
-				break
+
+	// RDB 解码器和命令读取器共用同一个缓冲读取器，RDB 之后的内容不会丢失
+	br := bufio.NewReader(file)
+	reader := newAOFReader(br)
+	if sig, _ := br.Peek(5); string(sig) == "REDIS" {
+		dec := newRDBDecoder(br)
+		if err := db.loadRDBFrom(dec); err != nil {
+			return fmt.Errorf("loading the RDB preamble of %s: %w", filename, err)
+		}
+		reader.offset = dec.offset
+	}
+	validBeforeMulti := int64(-1) // 未完成的 MULTI 开始的位置，不在事务中时为 -1
+	for {
+		start := reader.offset
+		args, err := reader.next()
+		if err == io.EOF && validBeforeMulti == -1 {
+			return nil
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			if !loadTruncated {
+				if err == io.EOF {
+					err = errAOFIncompleteMulti
+				}
+				return &aofError{file: filename, offset: start, err: err}
+			}
+			if validBeforeMulti != -1 {
+				// 事务没有写完，整个事务都不执行，连同 MULTI 一起截掉
+				fmt.Println("Revert incomplete MULTI/EXEC transaction in AOF file")
+				start = validBeforeMulti
+			} else {
+				fmt.Printf("!!! Warning: short read while loading the AOF file %s!!!\n", filename)
 			}
+			fmt.Printf("AOF loaded anyway because aof-load-truncated is enabled, truncating to offset %d\n", start)
+			// 截掉不完整的命令，之后追加的命令才能接在完整的记录后面
+			return os.Truncate(filename, start)
+		}
+		if err != nil {
+			return &aofError{file: filename, offset: start, err: err}
+		}
+		switch {
+		case strings.EqualFold(args[0], "MULTI"):
+			validBeforeMulti = start
+		case strings.EqualFold(args[0], "EXEC"):
+			validBeforeMulti = -1
+		}
+		if err := exec(args); err != nil {
+			return &aofError{file: filename, offset: start, err: err}
 		}
 	}
-	return scanner.Err()
 }
 
 // 定期清理过期的键。
@@ -125,14 +742,18 @@ func (db *redisDb) cleanExpiredKeys() {
 	ticker := time.NewTicker(1 * time.Second) // 每秒触发一次
 	defer ticker.Stop()
 	for range ticker.C {
+		if db.isWritePaused() {
+			continue
+		}
+		db.lockShared()
 		db.mu.Lock()
 		now := time.Now()
 		for key, expireTime := range db.expires {
 			if expireTime.Before(now) { // 如果键已过期
-				delete(db.data, key)
-				delete(db.expires, key)
+				db.deleteExpiredKey(key)
 			}
 		}
 		db.mu.Unlock()
+		db.unlockShared()
 	}
 }
\ No newline at end of file
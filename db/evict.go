@@ -0,0 +1,299 @@
+package main
+
+import (
+	"math"
+	"math/rand"
+	"time"
+)
+
+// maxmemory 淘汰策略，编码方式与 Redis 相同：低位是标志，高位区分具体策略
+const (
+	MAXMEMORY_FLAG_LRU     = 1 << 0
+	MAXMEMORY_FLAG_LFU     = 1 << 1
+	MAXMEMORY_FLAG_ALLKEYS = 1 << 2
+
+	MAXMEMORY_VOLATILE_LRU    = 0<<8 | MAXMEMORY_FLAG_LRU
+	MAXMEMORY_VOLATILE_LFU    = 1<<8 | MAXMEMORY_FLAG_LFU
+	MAXMEMORY_VOLATILE_TTL    = 2 << 8
+	MAXMEMORY_VOLATILE_RANDOM = 3 << 8
+	MAXMEMORY_ALLKEYS_LRU     = 4<<8 | MAXMEMORY_FLAG_LRU | MAXMEMORY_FLAG_ALLKEYS
+	MAXMEMORY_ALLKEYS_LFU     = 5<<8 | MAXMEMORY_FLAG_LFU | MAXMEMORY_FLAG_ALLKEYS
+	MAXMEMORY_ALLKEYS_RANDOM  = 6<<8 | MAXMEMORY_FLAG_ALLKEYS
+	MAXMEMORY_NO_EVICTION     = 7 << 8
+)
+
+// maxmemory-policy 配置值与策略的对应关系
+var maxmemoryPolicies = []struct {
+	name   string
+	policy int
+}{
+	{"volatile-lru", MAXMEMORY_VOLATILE_LRU},
+	{"volatile-lfu", MAXMEMORY_VOLATILE_LFU},
+	{"volatile-random", MAXMEMORY_VOLATILE_RANDOM},
+	{"volatile-ttl", MAXMEMORY_VOLATILE_TTL},
+	{"allkeys-lru", MAXMEMORY_ALLKEYS_LRU},
+	{"allkeys-lfu", MAXMEMORY_ALLKEYS_LFU},
+	{"allkeys-random", MAXMEMORY_ALLKEYS_RANDOM},
+	{"noeviction", MAXMEMORY_NO_EVICTION},
+}
+
+// 返回策略的配置名。
+func maxmemoryPolicyName(policy int) string {
+	for _, p := range maxmemoryPolicies {
+		if p.policy == policy {
+			return p.name
+		}
+	}
+	return "unknown"
+}
+
+// 淘汰池大小和 LFU 计数器初始值
+const (
+	EVPOOL_SIZE  = 16 // 淘汰池的大小
+	LFU_INIT_VAL = 5  // 新对象的 LFU 计数器初始值，避免刚写入的键马上被淘汰
+)
+
+// 估算一个键值对占用的内存：map 元素、键名以及对象本身。
+func keyMemoryUsage(key string, o *robj) int64 {
+	return keyEntryOverhead(key) + objectComputeSize(o, 0)
+}
+
+// 估算键在 data 中占用的 map 元素和键名的内存。
+func keyEntryOverhead(key string) int64 {
+	return mapEntryOverhead + stringHeaderSize + int64(len(key))
+}
+
+// 估算一个过期时间占用的内存：expires 中的 map 元素、键名和时间。
+func expireMemoryUsage(key string) int64 {
+	return mapEntryOverhead + stringHeaderSize + int64(len(key)) + 24
+}
+
+// LFU 模式下 robj.lru 的高 16 位保存最近一次衰减的时间（分钟），低 8 位保存对数计数器。
+func lfuGetTimeInMinutes() uint32 {
+	return uint32(time.Now().Unix()/60) & 65535
+}
+
+// 返回距离 ldt 经过的分钟数，考虑 16 位时间回绕。
+func lfuTimeElapsed(ldt uint32) uint32 {
+	now := lfuGetTimeInMinutes()
+	if now >= ldt {
+		return now - ldt
+	}
+	return 65535 - ldt + now
+}
+
+// 以对数方式增加计数器：计数器越大，增加的概率越低。
+func lfuLogIncr(counter uint32, logFactor int) uint32 {
+	if counter == 255 {
+		return 255
+	}
+	baseval := float64(counter) - LFU_INIT_VAL
+	if baseval < 0 {
+		baseval = 0
+	}
+	p := 1.0 / (baseval*float64(logFactor) + 1)
+	if rand.Float64() < p {
+		counter++
+	}
+	return counter
+}
+
+// 按经过的时间衰减计数器并返回衰减后的值，不修改对象。
+func lfuDecrAndReturn(o *robj, decayTime int) uint32 {
+	ldt := o.lru >> 8
+	counter := o.lru & 255
+	if decayTime > 0 {
+		periods := lfuTimeElapsed(ldt) / uint32(decayTime)
+		if periods >= counter {
+			return 0
+		}
+		counter -= periods
+	}
+	return counter
+}
+
+// 创建一个对象，LFU 策略下用初始计数器代替 LRU 时钟。调用方需持有写锁。
+func (db *redisDb) newObject(t uint8, ptr interface{}) *robj {
+	o := createObject(t, ptr)
+	if db.maxmemoryPolicy&MAXMEMORY_FLAG_LFU != 0 {
+		o.lru = lfuGetTimeInMinutes()<<8 | LFU_INIT_VAL
+	}
+	return o
+}
+
+// 访问对象时更新 LRU 时钟或 LFU 计数器，调用方需持有写锁。
+func (db *redisDb) touchObject(o *robj) {
+	if db.maxmemoryPolicy&MAXMEMORY_FLAG_LFU != 0 {
+		counter := lfuDecrAndReturn(o, db.lfuDecayTime)
+		counter = lfuLogIncr(counter, db.lfuLogFactor)
+		o.lru = lfuGetTimeInMinutes()<<8 | counter
+		return
+	}
+	o.lru = lruClock()
+}
+
+// 用 RDB 中保存的 IDLE 或 FREQ 恢复对象的访问信息，调用方需持有写锁。
+func (db *redisDb) loadObjectAccess(entry *rdbEntry) {
+	o := entry.obj
+	if db.maxmemoryPolicy&MAXMEMORY_FLAG_LFU != 0 {
+		o.lru = lfuGetTimeInMinutes()<<8 | LFU_INIT_VAL
+		if entry.lfuFreq != -1 {
+			o.lru = lfuGetTimeInMinutes()<<8 | uint32(entry.lfuFreq)
+		}
+		return
+	}
+	if entry.lruIdle != -1 {
+		o.lru = lruClock() - uint32(min(entry.lruIdle, int64(lruClock())))
+	}
+}
+
+// 修改淘汰策略和 LFU 参数。
+func (db *redisDb) setEvictionParams(policy, lfuLogFactor, lfuDecayTime int) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if policy != db.maxmemoryPolicy {
+		db.evictionPool = nil // 池中的空闲值按旧策略计算，不能再用来比较
+	}
+	db.maxmemoryPolicy = policy
+	db.lfuLogFactor = lfuLogFactor
+	db.lfuDecayTime = lfuDecayTime
+}
+
+// evictionPoolEntry 是淘汰池中的一个候选键，idle 越大越应该被淘汰。
+type evictionPoolEntry struct {
+	idle uint64
+	key  string
+}
+
+// 按当前策略计算对象的空闲程度：LRU 为空闲秒数，LFU 为 255 减去访问频率，TTL 为越早过期越大。
+func (db *redisDb) evictionIdle(key string, o *robj) uint64 {
+	switch {
+	case db.maxmemoryPolicy&MAXMEMORY_FLAG_LRU != 0:
+		now := lruClock()
+		if now >= o.lru {
+			return uint64(now - o.lru)
+		}
+		return 0
+	case db.maxmemoryPolicy&MAXMEMORY_FLAG_LFU != 0:
+		return 255 - uint64(lfuDecrAndReturn(o, db.lfuDecayTime))
+	default: // MAXMEMORY_VOLATILE_TTL
+		return math.MaxUint64 - uint64(db.expires[key].UnixMilli())
+	}
+}
+
+// 随机取样最多 n 个键。Go 的 map 每次遍历的起点是随机的，取前 n 个即可近似随机取样。
+func (db *redisDb) sampleKeys(n int) []string {
+	keys := make([]string, 0, n)
+	if db.maxmemoryPolicy&MAXMEMORY_FLAG_ALLKEYS != 0 {
+		for key := range db.data {
+			if len(keys) == n {
+				break
+			}
+			keys = append(keys, key)
+		}
+		return keys
+	}
+	for key := range db.expires {
+		if len(keys) == n {
+			break
+		}
+		if _, ok := db.data[key]; !ok {
+			continue // 没有对应值的过期时间，删除它不会释放数据占用的内存
+		}
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// 取样若干个键放入淘汰池，池按 idle 从小到大排列，满了以后只接纳比最小值更空闲的键。
+func (db *redisDb) evictionPoolPopulate(samples int) {
+	for _, key := range db.sampleKeys(samples) {
+		o := db.data[key]
+		if o == nil {
+			continue
+		}
+		idle := db.evictionIdle(key, o)
+
+		dup := false
+		for _, e := range db.evictionPool {
+			if e.key == key {
+				dup = true
+				break
+			}
+		}
+		if dup {
+			continue
+		}
+		k := 0
+		for k < len(db.evictionPool) && db.evictionPool[k].idle < idle {
+			k++
+		}
+		entry := evictionPoolEntry{idle: idle, key: key}
+		if len(db.evictionPool) < EVPOOL_SIZE {
+			db.evictionPool = append(db.evictionPool, evictionPoolEntry{})
+			copy(db.evictionPool[k+1:], db.evictionPool[k:])
+			db.evictionPool[k] = entry
+			continue
+		}
+		if k == 0 {
+			continue // 比池中所有键都不空闲
+		}
+		// 池已满，丢掉最不空闲的第一个元素，为新元素腾出位置
+		copy(db.evictionPool, db.evictionPool[1:k])
+		db.evictionPool[k-1] = entry
+	}
+}
+
+// 按策略选出一个要淘汰的键，没有可淘汰的键时返回 false。调用方需持有写锁。
+func (db *redisDb) evictionSelectKey(samples int) (string, bool) {
+	if db.maxmemoryPolicy&(MAXMEMORY_FLAG_LRU|MAXMEMORY_FLAG_LFU) != 0 || db.maxmemoryPolicy == MAXMEMORY_VOLATILE_TTL {
+		for {
+			db.evictionPoolPopulate(samples)
+			if len(db.evictionPool) == 0 {
+				return "", false
+			}
+			// 从最空闲的一端取出，键可能已经被删除，继续尝试下一个
+			for len(db.evictionPool) > 0 {
+				last := len(db.evictionPool) - 1
+				key := db.evictionPool[last].key
+				db.evictionPool = db.evictionPool[:last]
+				if _, ok := db.data[key]; ok {
+					if db.maxmemoryPolicy&MAXMEMORY_FLAG_ALLKEYS != 0 {
+						return key, true
+					}
+					if _, ok := db.expires[key]; ok {
+						return key, true
+					}
+				}
+			}
+		}
+	}
+	keys := db.sampleKeys(1)
+	if len(keys) == 0 {
+		return "", false
+	}
+	return keys[0], true
+}
+
+// 淘汰键直到估算的内存不超过 maxmemory，返回淘汰的键数量；无法再淘汰时 ok 为 false。
+// 被淘汰的键以 DEL 的形式写入 AOF。
+func (db *redisDb) performEvictions(maxmemory int64, samples int) (evicted int64, ok bool) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	for db.usedMemory > maxmemory {
+		if db.maxmemoryPolicy == MAXMEMORY_NO_EVICTION {
+			return evicted, false
+		}
+		key, found := db.evictionSelectKey(samples)
+		if !found {
+			return evicted, false
+		}
+		db.dbDelete(key)
+		db.notifyKeyspaceEvent(NOTIFY_EVICTED, "evicted", key)
+		db.invalidateTrackedKey(key)
+		db.dirty++
+		db.feedAppendOnlyFile("DEL", key)
+		evicted++
+	}
+	return evicted, true
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// 不存在的键不能设置过期时间。
+func TestSetExpireMissingKey(t *testing.T) {
+	db := newRedisDb("", t.TempDir(), "appendonly.aof")
+	if db.setExpire("missing", time.Hour) {
+		t.Error("setExpire on a missing key returned true")
+	}
+	if len(db.expires) != 0 || db.dirty != 0 {
+		t.Errorf("missing key left %d expires and dirty %d", len(db.expires), db.dirty)
+	}
+	db.setKey("k", "v")
+	if !db.setExpire("k", time.Hour) {
+		t.Error("setExpire on an existing key returned false")
+	}
+}
+
+// volatile 策略只淘汰有值的键，没有对应值的过期时间不算作淘汰。
+func TestEvictionSkipsBareExpires(t *testing.T) {
+	for _, policy := range []int{MAXMEMORY_VOLATILE_LRU, MAXMEMORY_VOLATILE_LFU, MAXMEMORY_VOLATILE_TTL, MAXMEMORY_VOLATILE_RANDOM} {
+		db := newRedisDb("", t.TempDir(), "appendonly.aof")
+		db.setEvictionParams(policy, 10, 1)
+		db.setKey("persistent", "v")
+		db.mu.Lock()
+		db.dbSetExpire("bare", time.Now().Add(time.Hour))
+		dirty := db.dirty
+		db.mu.Unlock()
+
+		evicted, ok := db.performEvictions(0, 5)
+		if ok || evicted != 0 {
+			t.Errorf("policy %d: evicted %d keys, ok %v; want nothing evicted", policy, evicted, ok)
+		}
+		if db.dirty != dirty {
+			t.Errorf("policy %d: dirty changed from %d to %d", policy, dirty, db.dirty)
+		}
+
+		db.setKey("volatile", "v")
+		db.setExpire("volatile", time.Hour)
+		if evicted, _ := db.performEvictions(0, 5); evicted != 1 {
+			t.Errorf("policy %d: evicted %d keys, want only the volatile key", policy, evicted)
+		}
+		if _, ok := db.data["volatile"]; ok {
+			t.Errorf("policy %d: volatile key was not evicted", policy)
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+var errFunctionsPayload = errors.New("ERR payload version or checksum are wrong")
+
+// 解析函数库代码的第一行 "#!<engine> name=<library>"，返回引擎名和库名。
+func functionExtractLibraryMetadata(code string) (engine, name string, err error) {
+	if !strings.HasPrefix(code, "#!") {
+		return "", "", errors.New("ERR Missing library metadata")
+	}
+	line, _, _ := strings.Cut(code[2:], "\n")
+	parts := strings.Fields(line)
+	if len(parts) == 0 {
+		return "", "", errors.New("ERR Engine name was not given")
+	}
+	engine = parts[0]
+	for _, part := range parts[1:] {
+		value, ok := strings.CutPrefix(part, "name=")
+		if !ok {
+			return "", "", fmt.Errorf("ERR Invalid metadata value given: %s", part)
+		}
+		name = value
+	}
+	if name == "" {
+		return "", "", errors.New("ERR Library name was not given")
+	}
+	return engine, name, nil
+}
+
+// 返回所有函数库的代码和当前的版本号，版本号在函数库每次变化时增加。
+func (db *redisDb) functionLibraries() (map[string]string, int64) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	libs := make(map[string]string, len(db.functions))
+	for name, code := range db.functions {
+		libs[name] = code
+	}
+	return libs, db.functionsVersion
+}
+
+// 按库名排序返回所有函数库的代码，用于保存，调用方需持有锁。
+func (db *redisDb) functionCodesLocked() []string {
+	names := make([]string, 0, len(db.functions))
+	for name := range db.functions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	codes := make([]string, len(names))
+	for i, name := range names {
+		codes[i] = db.functions[name]
+	}
+	return codes
+}
+
+// 加入或替换一个函数库并写入 AOF，返回新的版本号。
+// AOF 中总是带 REPLACE，重放时不会因为库已经存在而失败。
+func (db *redisDb) setFunctionLibrary(name, code string) int64 {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.functions[name] = code
+	db.functionsVersion++
+	db.dirty++
+	db.feedAppendOnlyFile("FUNCTION", "LOAD", "REPLACE", code)
+	return db.functionsVersion
+}
+
+// 删除一个函数库并写入 AOF，返回新的版本号。
+func (db *redisDb) deleteFunctionLibrary(name string) int64 {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	delete(db.functions, name)
+	db.functionsVersion++
+	db.dirty++
+	db.feedAppendOnlyFile("FUNCTION", "DELETE", name)
+	return db.functionsVersion
+}
+
+// 删除所有函数库并写入 AOF，返回新的版本号。
+func (db *redisDb) flushFunctions() int64 {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.functions = make(map[string]string)
+	db.functionsVersion++
+	db.dirty++
+	db.feedAppendOnlyFile("FUNCTION", "FLUSH")
+	return db.functionsVersion
+}
+
+// 生成 FUNCTION DUMP 的内容：每个函数库一条 FUNCTION2 记录，
+// 之后是两字节的 RDB 版本号和八字节的 CRC64，与 DUMP 命令的格式相同。
+func functionsDump(codes []string) []byte {
+	var buf bytes.Buffer
+	enc := newRDBEncoder(&buf)
+	for _, code := range codes {
+		enc.writeFunction(code)
+	}
+	footer := make([]byte, 2)
+	binary.LittleEndian.PutUint16(footer, RDB_VERSION)
+	enc.write(footer)
+	footer = make([]byte, 8)
+	binary.LittleEndian.PutUint64(footer, enc.crc)
+	enc.w.Write(footer)
+	enc.w.Flush()
+	return buf.Bytes()
+}
+
+// 校验并解析 FUNCTION DUMP 生成的内容，返回其中的函数库代码。
+func functionsRestorePayload(payload []byte) ([]string, error) {
+	if len(payload) < 10 {
+		return nil, errFunctionsPayload
+	}
+	body := payload[:len(payload)-10]
+	version := binary.LittleEndian.Uint16(payload[len(payload)-10:])
+	crc := binary.LittleEndian.Uint64(payload[len(payload)-8:])
+	if version > RDB_VERSION || (crc != 0 && crc != crc64Update(0, payload[:len(payload)-8])) {
+		return nil, errFunctionsPayload
+	}
+
+	dec := newRDBDecoder(bytes.NewReader(body))
+	dec.version = int(version)
+	var codes []string
+	for dec.offset < int64(len(body)) {
+		entry, err := dec.next()
+		if err != nil {
+			return nil, errors.New("ERR function restore failed: " + err.Error())
+		}
+		if entry.opcode != RDB_OPCODE_FUNCTION2 {
+			return nil, errors.New("ERR given type is not a function")
+		}
+		codes = append(codes, entry.value)
+	}
+	return codes, nil
+}
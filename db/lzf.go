@@ -0,0 +1,161 @@
+package main
+
+import "errors"
+
+// LZF 压缩算法（与 Redis 自带的 liblzf 格式兼容），RDB 用它压缩较长的字符串。
+const (
+	lzfHashLog = 14                  // 哈希表大小的对数
+	lzfMaxLit  = 1 << 5              // 一段字面量的最大长度
+	lzfMaxOff  = 1 << 13             // 回溯引用的最大偏移
+	lzfMaxRef  = (1 << 8) + (1 << 3) // 一次回溯引用的最大长度
+)
+
+var errLZFCorrupt = errors.New("lzf: corrupt input")
+
+// 计算三个字节的哈希值，用于在哈希表里查找可复用的前缀。
+func lzfHash(a, b, c byte) uint32 {
+	v := uint32(a)<<16 | uint32(b)<<8 | uint32(c)
+	return (v * 2654435761) >> (32 - lzfHashLog)
+}
+
+// 压缩 in，返回压缩后的数据；如果压缩后不能比原数据小，则返回 nil。
+func lzfCompress(in []byte) []byte {
+	if len(in) < 4 {
+		return nil
+	}
+	var htab [1 << lzfHashLog]int // 保存位置 +1，0 表示空槽
+	out := make([]byte, 1, len(in))
+	litPos, lit := 0, 0 // 当前字面量段的控制字节位置和长度
+
+	ip := 0
+	for ip+2 < len(in) {
+		h := lzfHash(in[ip], in[ip+1], in[ip+2])
+		ref := htab[h] - 1
+		htab[h] = ip + 1
+		if n := lzfMatchLen(in, ref, ip); n >= 3 {
+			off := ip - ref - 1
+			// 结束当前字面量段
+			if lit > 0 {
+				out[litPos] = byte(lit - 1)
+			} else {
+				out = out[:len(out)-1]
+			}
+
+			if n-2 < 7 {
+				out = append(out, byte(off>>8)+byte((n-2)<<5))
+			} else {
+				out = append(out, byte(off>>8)+7<<5, byte(n-2-7))
+			}
+			out = append(out, byte(off))
+			if len(out) >= len(in) {
+				return nil
+			}
+
+			ip += n
+			litPos, lit = len(out), 0
+			out = append(out, 0)
+			continue
+		}
+
+		out = append(out, in[ip])
+		ip++
+		lit++
+		if lit == lzfMaxLit {
+			out[litPos] = byte(lit - 1)
+			litPos, lit = len(out), 0
+			out = append(out, 0)
+		}
+		if len(out) >= len(in) {
+			return nil
+		}
+	}
+
+	for ; ip < len(in); ip++ {
+		out = append(out, in[ip])
+		lit++
+		if lit == lzfMaxLit {
+			out[litPos] = byte(lit - 1)
+			litPos, lit = len(out), 0
+			out = append(out, 0)
+		}
+	}
+	if lit > 0 {
+		out[litPos] = byte(lit - 1)
+	} else {
+		out = out[:len(out)-1]
+	}
+	if len(out) >= len(in) {
+		return nil
+	}
+	return out
+}
+
+// 返回 ip 处与 ref 处相同前缀的长度，不足 3 个字节或超出可引用范围时返回 0。
+func lzfMatchLen(in []byte, ref, ip int) int {
+	if ref < 0 || ip-ref-1 >= lzfMaxOff {
+		return 0
+	}
+	// 最后两个字节留给字面量，和 liblzf 的行为一致
+	maxLen := len(in) - ip - 2
+	if maxLen > lzfMaxRef {
+		maxLen = lzfMaxRef
+	}
+	n := 0
+	for n < maxLen && in[ref+n] == in[ip+n] {
+		n++
+	}
+	if n < 3 {
+		return 0
+	}
+	return n
+}
+
+// 解压 in，outLen 是压缩前的长度。outLen 来自文件，先确认它可能由 in 解压得到再分配内存：
+// 一次回溯引用至少占两个字节，展开后不超过 lzfMaxRef 个字节。
+func lzfDecompress(in []byte, outLen int) ([]byte, error) {
+	if outLen < 0 || outLen > len(in)*lzfMaxRef {
+		return nil, errLZFCorrupt
+	}
+	out := make([]byte, 0, outLen)
+	for i := 0; i < len(in); {
+		ctrl := int(in[i])
+		i++
+		if ctrl < lzfMaxLit {
+			// 字面量：后面跟着 ctrl+1 个原样字节
+			n := ctrl + 1
+			if i+n > len(in) || len(out)+n > outLen {
+				return nil, errLZFCorrupt
+			}
+			out = append(out, in[i:i+n]...)
+			i += n
+			continue
+		}
+
+		// 回溯引用：从已解压的数据里复制
+		n := ctrl >> 5
+		ref := len(out) - (ctrl&0x1f)<<8 - 1
+		if n == 7 {
+			if i >= len(in) {
+				return nil, errLZFCorrupt
+			}
+			n += int(in[i])
+			i++
+		}
+		if i >= len(in) {
+			return nil, errLZFCorrupt
+		}
+		ref -= int(in[i])
+		i++
+		n += 2
+		if ref < 0 || len(out)+n > outLen {
+			return nil, errLZFCorrupt
+		}
+		for k := 0; k < n; k++ {
+			out = append(out, out[ref+k]) // 引用区间可能与输出重叠，只能逐字节复制
+		}
+	}
+	if len(out) != outLen {
+		return nil, errLZFCorrupt
+	}
+	return out, nil
+}
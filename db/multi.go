@@ -0,0 +1,112 @@
+package main
+
+import "time"
+
+// watchedKey 是客户端 WATCH 的一个键，expired 记录 WATCH 时键是否已经逻辑过期。
+type watchedKey struct {
+	key     string
+	expired bool
+}
+
+// 以共享方式执行普通命令或后台任务，与 EXEC 互斥。
+func (db *redisDb) lockShared() {
+	db.execMu.RLock()
+}
+
+func (db *redisDb) unlockShared() {
+	db.execMu.RUnlock()
+}
+
+// 独占执行 EXEC 等命令，期间不会有其他命令或后台任务修改数据。
+func (db *redisDb) lockExclusive() {
+	db.execMu.Lock()
+}
+
+func (db *redisDb) unlockExclusive() {
+	db.execMu.Unlock()
+}
+
+// 判断键是否已经过期但还没有被删除，调用方需持有读锁。
+func (db *redisDb) keyIsExpired(key string) bool {
+	when, ok := db.expires[key]
+	return ok && when.Before(time.Now())
+}
+
+// 让客户端 WATCH 一组键，已经 WATCH 的键会被跳过。
+func (db *redisDb) watchKeys(c *redisClient, keys []string) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	for _, key := range keys {
+		clients := db.watchedKeys[key]
+		if _, ok := clients[c]; ok {
+			continue
+		}
+		if clients == nil {
+			clients = make(map[*redisClient]struct{})
+			db.watchedKeys[key] = clients
+		}
+		clients[c] = struct{}{}
+		c.watchedKeys = append(c.watchedKeys, watchedKey{key: key, expired: db.keyIsExpired(key)})
+	}
+}
+
+// 取消客户端 WATCH 的所有键并清除修改标记。
+func (db *redisDb) unwatchAllKeys(c *redisClient) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	for _, wk := range c.watchedKeys {
+		clients := db.watchedKeys[wk.key]
+		delete(clients, c)
+		if len(clients) == 0 {
+			delete(db.watchedKeys, wk.key)
+		}
+	}
+	c.watchedKeys = nil
+	c.dirtyCAS = false
+}
+
+// 键被修改时标记所有 WATCH 它的客户端，这些客户端之后的 EXEC 会失败。调用方需持有写锁。
+func (db *redisDb) touchWatchedKey(key string) {
+	if len(db.watchedKeys) == 0 {
+		return
+	}
+	for c := range db.watchedKeys[key] {
+		c.dirtyCAS = true
+	}
+}
+
+// 判断客户端 WATCH 的键是否被修改过，或者在 WATCH 之后过期。
+func (db *redisDb) watchedKeysDirty(c *redisClient) bool {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	if c.dirtyCAS {
+		return true
+	}
+	for _, wk := range c.watchedKeys {
+		if !wk.expired && db.keyIsExpired(wk.key) {
+			return true
+		}
+	}
+	return false
+}
+
+// 开始把一组命令作为事务写入 AOF。EXEC 和脚本可能嵌套（事务中执行脚本），
+// 只有最外层写入 MULTI 和 EXEC。
+func (db *redisDb) propagateMulti() {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.multiDepth == 0 {
+		db.feedAppendOnlyFile("MULTI")
+	}
+	db.multiDepth++
+}
+
+// 结束 propagateMulti 开始的事务。
+func (db *redisDb) propagateExec() {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.multiDepth--
+	if db.multiDepth == 0 {
+		db.feedAppendOnlyFile("EXEC")
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import "strings"
+
+// 键空间通知的事件类别，编码方式与 Redis 相同
+const (
+	NOTIFY_KEYSPACE = 1 << 0  // K：发布到 __keyspace@<db>__:<key>
+	NOTIFY_KEYEVENT = 1 << 1  // E：发布到 __keyevent@<db>__:<event>
+	NOTIFY_GENERIC  = 1 << 2  // g：DEL、EXPIRE、RENAME 等与类型无关的命令
+	NOTIFY_STRING   = 1 << 3  // $：字符串命令
+	NOTIFY_LIST     = 1 << 4  // l：列表命令
+	NOTIFY_SET      = 1 << 5  // s：集合命令
+	NOTIFY_HASH     = 1 << 6  // h：哈希命令
+	NOTIFY_ZSET     = 1 << 7  // z：有序集合命令
+	NOTIFY_EXPIRED  = 1 << 8  // x：键过期被删除
+	NOTIFY_EVICTED  = 1 << 9  // e：键因 maxmemory 被淘汰
+	NOTIFY_STREAM   = 1 << 10 // t：流命令
+	NOTIFY_KEY_MISS = 1 << 11 // m：读取的键不存在
+	NOTIFY_MODULE   = 1 << 13 // d：模块命令
+	NOTIFY_NEW      = 1 << 14 // n：创建新键
+
+	// A：除 m 和 n 之外的所有类别
+	NOTIFY_ALL = NOTIFY_GENERIC | NOTIFY_STRING | NOTIFY_LIST | NOTIFY_SET | NOTIFY_HASH | NOTIFY_ZSET |
+		NOTIFY_EXPIRED | NOTIFY_EVICTED | NOTIFY_STREAM | NOTIFY_MODULE
+)
+
+// 事件类别与 notify-keyspace-events 配置中字符的对应关系，按 Redis 输出配置值时的顺序排列
+var keyspaceEventClasses = []struct {
+	char byte
+	flag int
+}{
+	{'g', NOTIFY_GENERIC},
+	{'$', NOTIFY_STRING},
+	{'l', NOTIFY_LIST},
+	{'s', NOTIFY_SET},
+	{'h', NOTIFY_HASH},
+	{'z', NOTIFY_ZSET},
+	{'x', NOTIFY_EXPIRED},
+	{'e', NOTIFY_EVICTED},
+	{'t', NOTIFY_STREAM},
+	{'d', NOTIFY_MODULE},
+	{'K', NOTIFY_KEYSPACE},
+	{'E', NOTIFY_KEYEVENT},
+	{'m', NOTIFY_KEY_MISS},
+	{'n', NOTIFY_NEW},
+}
+
+// 把 notify-keyspace-events 的配置值解析为事件类别，包含未知字符时返回 -1。
+func keyspaceEventsStringToFlags(classes string) int {
+	flags := 0
+	for i := 0; i < len(classes); i++ {
+		if classes[i] == 'A' {
+			flags |= NOTIFY_ALL
+			continue
+		}
+		found := false
+		for _, ec := range keyspaceEventClasses {
+			if ec.char == classes[i] {
+				flags |= ec.flag
+				found = true
+				break
+			}
+		}
+		if !found {
+			return -1
+		}
+	}
+	return flags
+}
+
+// 把事件类别转换回配置值，包含全部类别时用 A 表示。
+func keyspaceEventsFlagsToString(flags int) string {
+	var b strings.Builder
+	if flags&NOTIFY_ALL == NOTIFY_ALL {
+		b.WriteByte('A')
+		flags &^= NOTIFY_ALL
+	}
+	for _, ec := range keyspaceEventClasses {
+		if flags&ec.flag != 0 {
+			b.WriteByte(ec.char)
+		}
+	}
+	return b.String()
+}
+
+// 修改开启的事件类别。
+func (db *redisDb) setNotifyKeyspaceEvents(flags int) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.notifyKeyspaceEvents = flags
+}
+
+// 发布一个键空间事件：事件类别开启时，按 K 和 E 分别发布到 __keyspace@0__:<key> 和
+// __keyevent@0__:<event>。目前只有 0 号数据库。调用方需持有锁。
+func (db *redisDb) notifyKeyspaceEvent(class int, event, key string) {
+	flags := db.notifyKeyspaceEvents
+	if flags&class == 0 || db.notifyPublish == nil {
+		return
+	}
+	if flags&NOTIFY_KEYSPACE != 0 {
+		db.notifyPublish("__keyspace@0__:"+key, event)
+	}
+	if flags&NOTIFY_KEYEVENT != 0 {
+		db.notifyPublish("__keyevent@0__:"+event, key)
+	}
+}
@@ -0,0 +1,966 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+)
+
+// RDB 文件格式版本，与 Redis 7.2 相同
+const RDB_VERSION = 11
+
+// 对象在 RDB 文件中的类型编号
+const (
+	RDB_TYPE_STRING             = 0
+	RDB_TYPE_LIST               = 1
+	RDB_TYPE_SET                = 2
+	RDB_TYPE_ZSET               = 3
+	RDB_TYPE_HASH               = 4
+	RDB_TYPE_ZSET_2             = 5 // 分值以二进制 double 保存
+	RDB_TYPE_MODULE_2           = 7
+	RDB_TYPE_HASH_ZIPMAP        = 9
+	RDB_TYPE_LIST_ZIPLIST       = 10
+	RDB_TYPE_SET_INTSET         = 11
+	RDB_TYPE_ZSET_ZIPLIST       = 12
+	RDB_TYPE_HASH_ZIPLIST       = 13
+	RDB_TYPE_LIST_QUICKLIST     = 14
+	RDB_TYPE_STREAM_LISTPACKS   = 15
+	RDB_TYPE_HASH_LISTPACK      = 16
+	RDB_TYPE_ZSET_LISTPACK      = 17
+	RDB_TYPE_LIST_QUICKLIST_2   = 18
+	RDB_TYPE_STREAM_LISTPACKS_2 = 19
+	RDB_TYPE_SET_LISTPACK       = 20
+	RDB_TYPE_STREAM_LISTPACKS_3 = 21
+)
+
+// RDB 文件中的特殊操作码
+const (
+	RDB_OPCODE_FUNCTION2       = 245 // 函数库
+	RDB_OPCODE_FUNCTION_PRE_GA = 246 // 7.0 RC 版本的函数库格式，不再支持
+	RDB_OPCODE_MODULE_AUX      = 247 // 模块辅助数据
+	RDB_OPCODE_IDLE            = 248 // 下一个键的 LRU 空闲时间
+	RDB_OPCODE_FREQ            = 249 // 下一个键的 LFU 访问频率
+	RDB_OPCODE_AUX             = 250 // 辅助字段
+	RDB_OPCODE_RESIZEDB        = 251 // 数据库大小提示
+	RDB_OPCODE_EXPIRETIME_MS   = 252 // 毫秒级过期时间
+	RDB_OPCODE_EXPIRETIME      = 253 // 秒级过期时间（旧格式）
+	RDB_OPCODE_SELECTDB        = 254 // 切换数据库
+	RDB_OPCODE_EOF             = 255 // 文件结束
+)
+
+// 长度编码的前两个比特
+const (
+	RDB_6BITLEN  = 0
+	RDB_14BITLEN = 1
+	RDB_32BITLEN = 0x80
+	RDB_64BITLEN = 0x81
+	RDB_ENCVAL   = 3
+)
+
+// 压缩字符串解压后的最大长度，与 Redis 字符串的上限相同
+const RDB_MAX_STRING_LEN = 512 * 1024 * 1024
+
+// RDB_ENCVAL 之后的特殊字符串编码
+const (
+	RDB_ENC_INT8  = 0
+	RDB_ENC_INT16 = 1
+	RDB_ENC_INT32 = 2
+	RDB_ENC_LZF   = 3
+)
+
+// quicklist2 中每个节点的容器类型
+const (
+	QUICKLIST_NODE_CONTAINER_PLAIN  = 1
+	QUICKLIST_NODE_CONTAINER_PACKED = 2
+)
+
+var (
+	errRDBInvalidFormat = errors.New("invalid RDB format")
+	errRDBChecksum      = errors.New("RDB checksum mismatch")
+	errRDBUnsupported   = errors.New("unsupported RDB type")
+)
+
+// rdbError 记录解析 RDB 失败时所在的偏移量和键名，方便定位损坏位置。
+type rdbError struct {
+	offset int64
+	key    string
+	err    error
+}
+
+func (e *rdbError) Error() string {
+	if e.key != "" {
+		return fmt.Sprintf("rdb: offset %d (key %q): %v", e.offset, e.key, e.err)
+	}
+	return fmt.Sprintf("rdb: offset %d: %v", e.offset, e.err)
+}
+
+func (e *rdbError) Unwrap() error {
+	return e.err
+}
+
+// rdbEncoder 按 RDB 格式写出数据，并在写出时累计 CRC64 校验和。
+// 写入错误由 bufio.Writer 保留，在 flush 时统一返回。
+type rdbEncoder struct {
+	w   *bufio.Writer
+	crc uint64
+}
+
+// 创建一个新的 RDB 编码器。
+func newRDBEncoder(w io.Writer) *rdbEncoder {
+	return &rdbEncoder{w: bufio.NewWriter(w)}
+}
+
+func (e *rdbEncoder) write(p []byte) {
+	e.crc = crc64Update(e.crc, p)
+	e.w.Write(p)
+}
+
+func (e *rdbEncoder) writeByte(b byte) {
+	e.write([]byte{b})
+}
+
+// 写出文件头 "REDIS" 和四位版本号。
+func (e *rdbEncoder) writeHeader() {
+	e.write([]byte(fmt.Sprintf("REDIS%04d", RDB_VERSION)))
+}
+
+// 写出一个长度值，按大小选择 6/14/32/64 位编码。
+func (e *rdbEncoder) writeLen(n uint64) {
+	switch {
+	case n < 1<<6:
+		e.writeByte(byte(n) | RDB_6BITLEN<<6)
+	case n < 1<<14:
+		e.write([]byte{byte(n>>8) | RDB_14BITLEN<<6, byte(n)})
+	case n <= math.MaxUint32:
+		buf := []byte{RDB_32BITLEN, 0, 0, 0, 0}
+		binary.BigEndian.PutUint32(buf[1:], uint32(n))
+		e.write(buf)
+	default:
+		buf := []byte{RDB_64BITLEN, 0, 0, 0, 0, 0, 0, 0, 0}
+		binary.BigEndian.PutUint64(buf[1:], n)
+		e.write(buf)
+	}
+}
+
+// 写出一个字符串：能表示为整数时用整数编码，较长时尝试 LZF 压缩，否则原样写出。
+func (e *rdbEncoder) writeString(s string) {
+	if len(s) <= 11 {
+		if buf := rdbEncodeInteger(s); buf != nil {
+			e.write(buf)
+			return
+		}
+	}
+	if len(s) > 20 {
+		if comp := lzfCompress([]byte(s)); comp != nil && len(comp) <= len(s)-4 {
+			e.writeByte(RDB_ENCVAL<<6 | RDB_ENC_LZF)
+			e.writeLen(uint64(len(comp)))
+			e.writeLen(uint64(len(s)))
+			e.write(comp)
+			return
+		}
+	}
+	e.writeLen(uint64(len(s)))
+	e.write([]byte(s))
+}
+
+// 如果 s 是可以无损还原的 32 位整数，返回它的整数编码，否则返回 nil。
+func rdbEncodeInteger(s string) []byte {
+	v, err := strconv.ParseInt(s, 10, 32)
+	if err != nil || strconv.FormatInt(v, 10) != s {
+		return nil
+	}
+	switch {
+	case v >= math.MinInt8 && v <= math.MaxInt8:
+		return []byte{RDB_ENCVAL<<6 | RDB_ENC_INT8, byte(v)}
+	case v >= math.MinInt16 && v <= math.MaxInt16:
+		return []byte{RDB_ENCVAL<<6 | RDB_ENC_INT16, byte(v), byte(v >> 8)}
+	default:
+		return []byte{RDB_ENCVAL<<6 | RDB_ENC_INT32, byte(v), byte(v >> 8), byte(v >> 16), byte(v >> 24)}
+	}
+}
+
+// 写出一个 AUX 辅助字段。
+func (e *rdbEncoder) writeAux(key, value string) {
+	e.writeByte(RDB_OPCODE_AUX)
+	e.writeString(key)
+	e.writeString(value)
+}
+
+// 写出一个函数库的代码。
+func (e *rdbEncoder) writeFunction(code string) {
+	e.writeByte(RDB_OPCODE_FUNCTION2)
+	e.writeString(code)
+}
+
+// 写出 SELECTDB 操作码。
+func (e *rdbEncoder) writeSelectDB(dbid int) {
+	e.writeByte(RDB_OPCODE_SELECTDB)
+	e.writeLen(uint64(dbid))
+}
+
+// 写出 RESIZEDB 操作码，告诉加载方键和过期键的数量。
+func (e *rdbEncoder) writeResizeDB(size, expires int) {
+	e.writeByte(RDB_OPCODE_RESIZEDB)
+	e.writeLen(uint64(size))
+	e.writeLen(uint64(expires))
+}
+
+// 写出一个键值对，expireAt 是毫秒时间戳，-1 表示没有过期时间。
+func (e *rdbEncoder) writeObject(key string, o *robj, expireAt int64) error {
+	if expireAt != -1 {
+		buf := make([]byte, 9)
+		buf[0] = RDB_OPCODE_EXPIRETIME_MS
+		binary.LittleEndian.PutUint64(buf[1:], uint64(expireAt))
+		e.write(buf)
+	}
+
+	switch o.rtype {
+	case OBJ_STRING:
+		e.writeByte(RDB_TYPE_STRING)
+		e.writeString(key)
+		e.writeString(o.ptr.(string))
+	case OBJ_LIST, OBJ_SET:
+		if o.rtype == OBJ_LIST {
+			e.writeByte(RDB_TYPE_LIST)
+		} else {
+			e.writeByte(RDB_TYPE_SET)
+		}
+		e.writeString(key)
+		items := o.ptr.([]string)
+		e.writeLen(uint64(len(items)))
+		for _, item := range items {
+			e.writeString(item)
+		}
+	case OBJ_ZSET:
+		e.writeByte(RDB_TYPE_ZSET_2)
+		e.writeString(key)
+		members := o.ptr.(map[string]float64)
+		e.writeLen(uint64(len(members)))
+		buf := make([]byte, 8)
+		for member, score := range members {
+			e.writeString(member)
+			binary.LittleEndian.PutUint64(buf, math.Float64bits(score))
+			e.write(buf)
+		}
+	case OBJ_HASH:
+		e.writeByte(RDB_TYPE_HASH)
+		e.writeString(key)
+		fields := o.ptr.(map[string]string)
+		e.writeLen(uint64(len(fields)))
+		for field, value := range fields {
+			e.writeString(field)
+			e.writeString(value)
+		}
+	default:
+		return fmt.Errorf("%w: object type %d", errRDBUnsupported, o.rtype)
+	}
+	return nil
+}
+
+// 写出 EOF 操作码和校验和，并刷新缓冲区。
+func (e *rdbEncoder) writeEOF() error {
+	e.writeByte(RDB_OPCODE_EOF)
+	buf := make([]byte, 8)
+	binary.LittleEndian.PutUint64(buf, e.crc)
+	e.w.Write(buf) // 校验和本身不计入校验
+	return e.w.Flush()
+}
+
+// rdbEntry 表示从 RDB 文件中读出的一条记录。
+type rdbEntry struct {
+	opcode      byte   // 对象的 RDB_TYPE_* 或者 RDB_OPCODE_*
+	offset      int64  // 记录在文件中的起始偏移量
+	key         string // 键名或 AUX 字段名
+	value       string // AUX 字段值或函数库代码
+	obj         *robj  // 键对应的对象
+	expireAt    int64  // 过期时间（毫秒时间戳），-1 表示永不过期
+	lruIdle     int64  // IDLE 记录的空闲秒数，-1 表示没有记录
+	lfuFreq     int    // FREQ 记录的访问频率，-1 表示没有记录
+	dbid        int    // SELECTDB 选择的数据库编号
+	dbSize      uint64 // RESIZEDB 提示的键数量
+	expiresSize uint64 // RESIZEDB 提示的过期键数量
+}
+
+// 判断这条记录是否是一个键值对。
+func (e *rdbEntry) isKey() bool {
+	return e.obj != nil
+}
+
+// rdbDecoder 按顺序读取 RDB 文件中的记录，同时累计 CRC64 并跟踪当前偏移量。
+type rdbDecoder struct {
+	r       *bufio.Reader
+	crc     uint64
+	offset  int64
+	version int
+	key     string // 正在解析的键，出错时用于报告
+}
+
+// 创建一个新的 RDB 解码器。
+func newRDBDecoder(r io.Reader) *rdbDecoder {
+	return &rdbDecoder{r: bufio.NewReader(r)}
+}
+
+func (d *rdbDecoder) read(n int) ([]byte, error) {
+	if n < 0 {
+		return nil, fmt.Errorf("%w: negative length", errRDBInvalidFormat)
+	}
+	var buf []byte
+	if n <= 1<<20 {
+		buf = make([]byte, n)
+		if _, err := io.ReadFull(d.r, buf); err != nil {
+			return nil, io.ErrUnexpectedEOF
+		}
+	} else {
+		// 长度可能来自损坏的文件，分块读取，避免一次分配巨大的内存
+		var b bytes.Buffer
+		if copied, _ := io.CopyN(&b, d.r, int64(n)); copied != int64(n) {
+			return nil, io.ErrUnexpectedEOF
+		}
+		buf = b.Bytes()
+	}
+	d.crc = crc64Update(d.crc, buf)
+	d.offset += int64(n)
+	return buf, nil
+}
+
+func (d *rdbDecoder) readByte() (byte, error) {
+	buf, err := d.read(1)
+	if err != nil {
+		return 0, err
+	}
+	return buf[0], nil
+}
+
+// 包装错误，附带当前偏移量和键名。
+func (d *rdbDecoder) fail(err error) error {
+	var re *rdbError
+	if errors.As(err, &re) {
+		return err
+	}
+	return &rdbError{offset: d.offset, key: d.key, err: err}
+}
+
+// 读取并校验文件头，返回文件的 RDB 版本。
+func (d *rdbDecoder) readHeader() (int, error) {
+	buf, err := d.read(9)
+	if err != nil {
+		return 0, d.fail(err)
+	}
+	if string(buf[:5]) != "REDIS" {
+		return 0, d.fail(fmt.Errorf("%w: wrong signature", errRDBInvalidFormat))
+	}
+	version, err := strconv.Atoi(string(buf[5:]))
+	if err != nil || version < 1 || version > RDB_VERSION {
+		return 0, d.fail(fmt.Errorf("%w: can't handle RDB format version %s", errRDBInvalidFormat, buf[5:]))
+	}
+	d.version = version
+	return version, nil
+}
+
+// 读取一个长度值；如果是特殊编码，返回编码类型并把 encoded 置为 true。
+func (d *rdbDecoder) readLenEnc() (n uint64, encoded bool, err error) {
+	b, err := d.readByte()
+	if err != nil {
+		return 0, false, err
+	}
+	switch b >> 6 {
+	case RDB_ENCVAL:
+		return uint64(b & 0x3f), true, nil
+	case RDB_6BITLEN:
+		return uint64(b & 0x3f), false, nil
+	case RDB_14BITLEN:
+		next, err := d.readByte()
+		if err != nil {
+			return 0, false, err
+		}
+		return uint64(b&0x3f)<<8 | uint64(next), false, nil
+	}
+	switch b {
+	case RDB_32BITLEN:
+		buf, err := d.read(4)
+		if err != nil {
+			return 0, false, err
+		}
+		return uint64(binary.BigEndian.Uint32(buf)), false, nil
+	case RDB_64BITLEN:
+		buf, err := d.read(8)
+		if err != nil {
+			return 0, false, err
+		}
+		return binary.BigEndian.Uint64(buf), false, nil
+	}
+	return 0, false, fmt.Errorf("%w: unknown length encoding %#x", errRDBInvalidFormat, b)
+}
+
+// 读取一个普通长度值。
+func (d *rdbDecoder) readLen() (uint64, error) {
+	n, encoded, err := d.readLenEnc()
+	if err != nil {
+		return 0, err
+	}
+	if encoded {
+		return 0, fmt.Errorf("%w: unexpected string encoding", errRDBInvalidFormat)
+	}
+	return n, nil
+}
+
+// 读取一个字符串，处理整数编码和 LZF 压缩。
+func (d *rdbDecoder) readString() (string, error) {
+	n, encoded, err := d.readLenEnc()
+	if err != nil {
+		return "", err
+	}
+	if !encoded {
+		buf, err := d.read(int(n))
+		if err != nil {
+			return "", err
+		}
+		return string(buf), nil
+	}
+
+	switch n {
+	case RDB_ENC_INT8:
+		buf, err := d.read(1)
+		if err != nil {
+			return "", err
+		}
+		return strconv.Itoa(int(int8(buf[0]))), nil
+	case RDB_ENC_INT16:
+		buf, err := d.read(2)
+		if err != nil {
+			return "", err
+		}
+		return strconv.Itoa(int(int16(binary.LittleEndian.Uint16(buf)))), nil
+	case RDB_ENC_INT32:
+		buf, err := d.read(4)
+		if err != nil {
+			return "", err
+		}
+		return strconv.Itoa(int(int32(binary.LittleEndian.Uint32(buf)))), nil
+	case RDB_ENC_LZF:
+		clen, err := d.readLen()
+		if err != nil {
+			return "", err
+		}
+		ulen, err := d.readLen()
+		if err != nil {
+			return "", err
+		}
+		if ulen > RDB_MAX_STRING_LEN {
+			return "", fmt.Errorf("%w: LZF uncompressed length %d out of range", errRDBInvalidFormat, ulen)
+		}
+		comp, err := d.read(int(clen))
+		if err != nil {
+			return "", err
+		}
+		out, err := lzfDecompress(comp, int(ulen))
+		if err != nil {
+			return "", err
+		}
+		return string(out), nil
+	}
+	return "", fmt.Errorf("%w: unknown string encoding %d", errRDBInvalidFormat, n)
+}
+
+// 读取旧格式中以字符串保存的 double。
+func (d *rdbDecoder) readDoubleString() (float64, error) {
+	n, err := d.readByte()
+	if err != nil {
+		return 0, err
+	}
+	switch n {
+	case 253:
+		return math.NaN(), nil
+	case 254:
+		return math.Inf(1), nil
+	case 255:
+		return math.Inf(-1), nil
+	}
+	buf, err := d.read(int(n))
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseFloat(string(buf), 64)
+}
+
+// 读取以 8 字节小端保存的二进制 double。
+func (d *rdbDecoder) readBinaryDouble() (float64, error) {
+	buf, err := d.read(8)
+	if err != nil {
+		return 0, err
+	}
+	return math.Float64frombits(binary.LittleEndian.Uint64(buf)), nil
+}
+
+// 读取 n 个字符串。
+func (d *rdbDecoder) readStrings() ([]string, error) {
+	n, err := d.readLen()
+	if err != nil {
+		return nil, err
+	}
+	items := make([]string, 0, min(n, 1024))
+	for i := uint64(0); i < n; i++ {
+		s, err := d.readString()
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, s)
+	}
+	return items, nil
+}
+
+// 读取下一条记录，读到 EOF 操作码并通过校验后返回 io.EOF。
+func (d *rdbDecoder) next() (*rdbEntry, error) {
+	e := &rdbEntry{offset: d.offset, expireAt: -1, lruIdle: -1, lfuFreq: -1}
+	d.key = ""
+	for {
+		opcode, err := d.readByte()
+		if err != nil {
+			return nil, d.fail(err)
+		}
+		e.opcode = opcode
+
+		switch opcode {
+		case RDB_OPCODE_EXPIRETIME_MS:
+			buf, err := d.read(8)
+			if err != nil {
+				return nil, d.fail(err)
+			}
+			e.expireAt = int64(binary.LittleEndian.Uint64(buf))
+			continue
+		case RDB_OPCODE_EXPIRETIME:
+			buf, err := d.read(4)
+			if err != nil {
+				return nil, d.fail(err)
+			}
+			e.expireAt = int64(int32(binary.LittleEndian.Uint32(buf))) * 1000
+			continue
+		case RDB_OPCODE_IDLE:
+			idle, err := d.readLen()
+			if err != nil {
+				return nil, d.fail(err)
+			}
+			e.lruIdle = int64(idle)
+			continue
+		case RDB_OPCODE_FREQ:
+			freq, err := d.readByte()
+			if err != nil {
+				return nil, d.fail(err)
+			}
+			e.lfuFreq = int(freq)
+			continue
+		case RDB_OPCODE_AUX:
+			if e.key, err = d.readString(); err != nil {
+				return nil, d.fail(err)
+			}
+			if e.value, err = d.readString(); err != nil {
+				return nil, d.fail(err)
+			}
+			return e, nil
+		case RDB_OPCODE_SELECTDB:
+			dbid, err := d.readLen()
+			if err != nil {
+				return nil, d.fail(err)
+			}
+			e.dbid = int(dbid)
+			return e, nil
+		case RDB_OPCODE_RESIZEDB:
+			if e.dbSize, err = d.readLen(); err != nil {
+				return nil, d.fail(err)
+			}
+			if e.expiresSize, err = d.readLen(); err != nil {
+				return nil, d.fail(err)
+			}
+			return e, nil
+		case RDB_OPCODE_FUNCTION2:
+			if e.value, err = d.readString(); err != nil {
+				return nil, d.fail(err)
+			}
+			return e, nil
+		case RDB_OPCODE_EOF:
+			if err := d.readChecksum(); err != nil {
+				return nil, d.fail(err)
+			}
+			return nil, io.EOF
+		case RDB_OPCODE_FUNCTION_PRE_GA, RDB_OPCODE_MODULE_AUX:
+			return nil, d.fail(fmt.Errorf("%w: opcode %d", errRDBUnsupported, opcode))
+		}
+
+		if d.key, err = d.readString(); err != nil {
+			return nil, d.fail(err)
+		}
+		e.key = d.key
+		if e.obj, err = d.readObject(opcode); err != nil {
+			return nil, d.fail(err)
+		}
+		return e, nil
+	}
+}
+
+// 读取文件末尾的校验和并与计算结果比较，校验和为 0 表示保存时关闭了校验。
+func (d *rdbDecoder) readChecksum() error {
+	if d.version < 5 {
+		return nil
+	}
+	expected := d.crc
+	buf := make([]byte, 8)
+	if _, err := io.ReadFull(d.r, buf); err != nil {
+		return io.ErrUnexpectedEOF
+	}
+	d.offset += 8
+	sum := binary.LittleEndian.Uint64(buf)
+	if sum != 0 && sum != expected {
+		return fmt.Errorf("%w: expected %016x, got %016x", errRDBChecksum, sum, expected)
+	}
+	return nil
+}
+
+// 按类型读取一个对象。
+func (d *rdbDecoder) readObject(rdbType byte) (*robj, error) {
+	switch rdbType {
+	case RDB_TYPE_STRING:
+		s, err := d.readString()
+		if err != nil {
+			return nil, err
+		}
+		return createObject(OBJ_STRING, s), nil
+	case RDB_TYPE_LIST:
+		items, err := d.readStrings()
+		if err != nil {
+			return nil, err
+		}
+		return createObject(OBJ_LIST, items), nil
+	case RDB_TYPE_SET:
+		items, err := d.readStrings()
+		if err != nil {
+			return nil, err
+		}
+		return createObject(OBJ_SET, items), nil
+	case RDB_TYPE_ZSET, RDB_TYPE_ZSET_2:
+		n, err := d.readLen()
+		if err != nil {
+			return nil, err
+		}
+		members := make(map[string]float64, min(n, 1024))
+		for i := uint64(0); i < n; i++ {
+			member, err := d.readString()
+			if err != nil {
+				return nil, err
+			}
+			var score float64
+			if rdbType == RDB_TYPE_ZSET {
+				score, err = d.readDoubleString()
+			} else {
+				score, err = d.readBinaryDouble()
+			}
+			if err != nil {
+				return nil, err
+			}
+			members[member] = score
+		}
+		return createObject(OBJ_ZSET, members), nil
+	case RDB_TYPE_HASH:
+		n, err := d.readLen()
+		if err != nil {
+			return nil, err
+		}
+		fields := make(map[string]string, min(n, 1024))
+		for i := uint64(0); i < n; i++ {
+			field, err := d.readString()
+			if err != nil {
+				return nil, err
+			}
+			value, err := d.readString()
+			if err != nil {
+				return nil, err
+			}
+			fields[field] = value
+		}
+		return createObject(OBJ_HASH, fields), nil
+	case RDB_TYPE_LIST_QUICKLIST, RDB_TYPE_LIST_QUICKLIST_2:
+		return d.readQuicklist(rdbType)
+	case RDB_TYPE_LIST_ZIPLIST, RDB_TYPE_SET_INTSET, RDB_TYPE_ZSET_ZIPLIST, RDB_TYPE_HASH_ZIPLIST,
+		RDB_TYPE_HASH_LISTPACK, RDB_TYPE_ZSET_LISTPACK, RDB_TYPE_SET_LISTPACK:
+		blob, err := d.readString()
+		if err != nil {
+			return nil, err
+		}
+		return decodePackedObject(rdbType, []byte(blob))
+	}
+	return nil, fmt.Errorf("%w: %d", errRDBUnsupported, rdbType)
+}
+
+// 读取 quicklist 编码的列表，每个节点是一个 ziplist 或 listpack。
+func (d *rdbDecoder) readQuicklist(rdbType byte) (*robj, error) {
+	n, err := d.readLen()
+	if err != nil {
+		return nil, err
+	}
+	var items []string
+	for i := uint64(0); i < n; i++ {
+		container := uint64(QUICKLIST_NODE_CONTAINER_PACKED)
+		if rdbType == RDB_TYPE_LIST_QUICKLIST_2 {
+			if container, err = d.readLen(); err != nil {
+				return nil, err
+			}
+		}
+		blob, err := d.readString()
+		if err != nil {
+			return nil, err
+		}
+		var node []string
+		switch {
+		case container == QUICKLIST_NODE_CONTAINER_PLAIN:
+			node = []string{blob}
+		case rdbType == RDB_TYPE_LIST_QUICKLIST:
+			node, err = ziplistEntries([]byte(blob))
+		default:
+			node, err = listpackEntries([]byte(blob))
+		}
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, node...)
+	}
+	return createObject(OBJ_LIST, items), nil
+}
+
+// 把 ziplist / listpack / intset 编码的紧凑对象展开成普通对象。
+func decodePackedObject(rdbType byte, blob []byte) (*robj, error) {
+	var entries []string
+	var err error
+	switch rdbType {
+	case RDB_TYPE_SET_INTSET:
+		entries, err = intsetEntries(blob)
+	case RDB_TYPE_LIST_ZIPLIST, RDB_TYPE_ZSET_ZIPLIST, RDB_TYPE_HASH_ZIPLIST:
+		entries, err = ziplistEntries(blob)
+	default:
+		entries, err = listpackEntries(blob)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	switch rdbType {
+	case RDB_TYPE_LIST_ZIPLIST:
+		return createObject(OBJ_LIST, entries), nil
+	case RDB_TYPE_SET_INTSET, RDB_TYPE_SET_LISTPACK:
+		return createObject(OBJ_SET, entries), nil
+	}
+	if len(entries)%2 != 0 {
+		return nil, fmt.Errorf("%w: odd number of entries in packed pairs", errRDBInvalidFormat)
+	}
+	if rdbType == RDB_TYPE_HASH_ZIPLIST || rdbType == RDB_TYPE_HASH_LISTPACK {
+		fields := make(map[string]string, len(entries)/2)
+		for i := 0; i < len(entries); i += 2 {
+			fields[entries[i]] = entries[i+1]
+		}
+		return createObject(OBJ_HASH, fields), nil
+	}
+	members := make(map[string]float64, len(entries)/2)
+	for i := 0; i < len(entries); i += 2 {
+		score, err := strconv.ParseFloat(entries[i+1], 64)
+		if err != nil {
+			return nil, fmt.Errorf("%w: bad zset score %q", errRDBInvalidFormat, entries[i+1])
+		}
+		members[entries[i]] = score
+	}
+	return createObject(OBJ_ZSET, members), nil
+}
+
+// 解析 intset：4 字节编码宽度、4 字节元素个数，后面是小端整数。
+func intsetEntries(blob []byte) ([]string, error) {
+	if len(blob) < 8 {
+		return nil, fmt.Errorf("%w: intset too short", errRDBInvalidFormat)
+	}
+	width := int(binary.LittleEndian.Uint32(blob))
+	n := int(binary.LittleEndian.Uint32(blob[4:]))
+	if (width != 2 && width != 4 && width != 8) || len(blob) != 8+width*n {
+		return nil, fmt.Errorf("%w: bad intset header", errRDBInvalidFormat)
+	}
+	entries := make([]string, 0, n)
+	for p := 8; p < len(blob); p += width {
+		var v int64
+		switch width {
+		case 2:
+			v = int64(int16(binary.LittleEndian.Uint16(blob[p:])))
+		case 4:
+			v = int64(int32(binary.LittleEndian.Uint32(blob[p:])))
+		default:
+			v = int64(binary.LittleEndian.Uint64(blob[p:]))
+		}
+		entries = append(entries, strconv.FormatInt(v, 10))
+	}
+	return entries, nil
+}
+
+// 解析 ziplist 中的所有元素。
+func ziplistEntries(blob []byte) ([]string, error) {
+	bad := fmt.Errorf("%w: corrupt ziplist", errRDBInvalidFormat)
+	if len(blob) < 11 || int(binary.LittleEndian.Uint32(blob)) != len(blob) {
+		return nil, bad
+	}
+	var entries []string
+	p := 10
+	for {
+		if p >= len(blob) {
+			return nil, bad
+		}
+		if blob[p] == 0xff {
+			break
+		}
+		// 跳过前一个元素的长度
+		if blob[p] == 0xfe {
+			p += 5
+		} else {
+			p++
+		}
+		if p >= len(blob) {
+			return nil, bad
+		}
+
+		enc := blob[p]
+		var strLen, intLen int
+		switch {
+		case enc>>6 == 0:
+			strLen, p = int(enc&0x3f), p+1
+		case enc>>6 == 1:
+			if p+2 > len(blob) {
+				return nil, bad
+			}
+			strLen, p = int(enc&0x3f)<<8|int(blob[p+1]), p+2
+		case enc == 0x80:
+			if p+5 > len(blob) {
+				return nil, bad
+			}
+			strLen, p = int(binary.BigEndian.Uint32(blob[p+1:])), p+5
+		case enc == 0xc0:
+			intLen, p = 2, p+1
+		case enc == 0xd0:
+			intLen, p = 4, p+1
+		case enc == 0xe0:
+			intLen, p = 8, p+1
+		case enc == 0xf0:
+			intLen, p = 3, p+1
+		case enc == 0xfe:
+			intLen, p = 1, p+1
+		case enc >= 0xf1 && enc <= 0xfd:
+			entries = append(entries, strconv.Itoa(int(enc&0x0f)-1))
+			p++
+			continue
+		default:
+			return nil, bad
+		}
+
+		if intLen > 0 {
+			if p+intLen > len(blob) {
+				return nil, bad
+			}
+			entries = append(entries, strconv.FormatInt(littleEndianInt(blob[p:p+intLen]), 10))
+			p += intLen
+			continue
+		}
+		if p+strLen > len(blob) {
+			return nil, bad
+		}
+		entries = append(entries, string(blob[p:p+strLen]))
+		p += strLen
+	}
+	return entries, nil
+}
+
+// 解析 listpack 中的所有元素。
+func listpackEntries(blob []byte) ([]string, error) {
+	bad := fmt.Errorf("%w: corrupt listpack", errRDBInvalidFormat)
+	if len(blob) < 7 || int(binary.LittleEndian.Uint32(blob)) != len(blob) {
+		return nil, bad
+	}
+	var entries []string
+	p := 6
+	for {
+		if p >= len(blob) {
+			return nil, bad
+		}
+		enc := blob[p]
+		if enc == 0xff {
+			break
+		}
+
+		var value string
+		var hdrLen, dataLen int
+		switch {
+		case enc&0x80 == 0: // 7 位无符号整数
+			value, hdrLen = strconv.Itoa(int(enc&0x7f)), 1
+		case enc&0xc0 == 0x80: // 6 位长度字符串
+			hdrLen, dataLen = 1, int(enc&0x3f)
+		case enc&0xe0 == 0xc0: // 13 位有符号整数
+			if p+2 > len(blob) {
+				return nil, bad
+			}
+			v := int(enc&0x1f)<<8 | int(blob[p+1])
+			if v >= 1<<12 {
+				v -= 1 << 13
+			}
+			value, hdrLen = strconv.Itoa(v), 2
+		case enc&0xf0 == 0xe0: // 12 位长度字符串
+			if p+2 > len(blob) {
+				return nil, bad
+			}
+			hdrLen, dataLen = 2, int(enc&0x0f)<<8|int(blob[p+1])
+		case enc == 0xf0: // 32 位长度字符串
+			if p+5 > len(blob) {
+				return nil, bad
+			}
+			hdrLen, dataLen = 5, int(binary.LittleEndian.Uint32(blob[p+1:]))
+		case enc >= 0xf1 && enc <= 0xf4: // 16/24/32/64 位整数
+			width := []int{2, 3, 4, 8}[enc-0xf1]
+			if p+1+width > len(blob) {
+				return nil, bad
+			}
+			value, hdrLen = strconv.FormatInt(littleEndianInt(blob[p+1:p+1+width]), 10), 1+width
+		default:
+			return nil, bad
+		}
+
+		if p+hdrLen+dataLen > len(blob) {
+			return nil, bad
+		}
+		if dataLen > 0 || enc&0xc0 == 0x80 || enc&0xf0 == 0xe0 || enc == 0xf0 {
+			value = string(blob[p+hdrLen : p+hdrLen+dataLen])
+		}
+		entries = append(entries, value)
+		p += hdrLen + dataLen + listpackBacklenSize(hdrLen+dataLen)
+	}
+	return entries, nil
+}
+
+// 计算 listpack 元素尾部反向长度字段占用的字节数。
+func listpackBacklenSize(l int) int {
+	switch {
+	case l < 128:
+		return 1
+	case l < 16384:
+		return 2
+	case l < 2097152:
+		return 3
+	case l < 268435456:
+		return 4
+	default:
+		return 5
+	}
+}
+
+// 把 1 到 8 字节的小端补码解析为有符号整数。
+func littleEndianInt(b []byte) int64 {
+	var v uint64
+	for i := len(b) - 1; i >= 0; i-- {
+		v = v<<8 | uint64(b[i])
+	}
+	shift := 64 - 8*uint(len(b))
+	return int64(v<<shift) >> shift
+}
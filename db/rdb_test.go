@@ -0,0 +1,218 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"math"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// 编码再解码所有支持的类型，覆盖整数编码、LZF 压缩、过期时间和多个数据库。
+func TestRDBEncodeDecodeAllTypes(t *testing.T) {
+	expireAt := time.Now().Add(time.Hour).UnixMilli()
+	want := map[int]map[string]*robj{
+		0: {
+			"str":    createObject(OBJ_STRING, "hello"),
+			"int":    createObject(OBJ_STRING, "-12345"),
+			"empty":  createObject(OBJ_STRING, ""),
+			"lzf":    createObject(OBJ_STRING, strings.Repeat("abcdefgh", 500)),
+			"binary": createObject(OBJ_STRING, "a\x00b\r\nc\xff"),
+			"list":   createObject(OBJ_LIST, []string{"a", "b", "a", "100"}),
+			"set":    createObject(OBJ_SET, []string{"x", "y", "1"}),
+			"zset":   createObject(OBJ_ZSET, map[string]float64{"a": 1.5, "b": -2, "c": math.Inf(1), "d": 0}),
+			"hash":   createObject(OBJ_HASH, map[string]string{"f1": "v1", "f2": "", "n": "42"}),
+		},
+		5: {
+			"str":  createObject(OBJ_STRING, "db5"),
+			"list": createObject(OBJ_LIST, []string{strings.Repeat("z", 100)}),
+		},
+	}
+	expires := map[int]map[string]int64{
+		0: {"str": expireAt, "hash": expireAt},
+		5: {"list": expireAt},
+	}
+
+	var buf bytes.Buffer
+	enc := newRDBEncoder(&buf)
+	enc.writeHeader()
+	for _, dbid := range []int{0, 5} {
+		enc.writeSelectDB(dbid)
+		enc.writeResizeDB(len(want[dbid]), len(expires[dbid]))
+		for key, o := range want[dbid] {
+			exp, ok := expires[dbid][key]
+			if !ok {
+				exp = -1
+			}
+			if err := enc.writeObject(key, o, exp); err != nil {
+				t.Fatalf("writeObject(%q): %v", key, err)
+			}
+		}
+	}
+	if err := enc.writeEOF(); err != nil {
+		t.Fatal(err)
+	}
+
+	got := map[int]map[string]*robj{}
+	gotExpires := map[int]map[string]int64{}
+	dec := newRDBDecoder(&buf)
+	if _, err := dec.readHeader(); err != nil {
+		t.Fatal(err)
+	}
+	dbid := 0
+	for {
+		entry, err := dec.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if entry.opcode == RDB_OPCODE_SELECTDB {
+			dbid = entry.dbid
+		}
+		if !entry.isKey() {
+			continue
+		}
+		if got[dbid] == nil {
+			got[dbid], gotExpires[dbid] = map[string]*robj{}, map[string]int64{}
+		}
+		got[dbid][entry.key] = entry.obj
+		if entry.expireAt != -1 {
+			gotExpires[dbid][entry.key] = entry.expireAt
+		}
+	}
+
+	for dbid, keys := range want {
+		if len(got[dbid]) != len(keys) {
+			t.Errorf("db %d: got %d keys, want %d", dbid, len(got[dbid]), len(keys))
+		}
+		for key, o := range keys {
+			g := got[dbid][key]
+			if g == nil {
+				t.Errorf("db %d: key %q missing", dbid, key)
+				continue
+			}
+			if g.rtype != o.rtype || !reflect.DeepEqual(normalizeObject(g), normalizeObject(o)) {
+				t.Errorf("db %d key %q: got %v (type %d), want %v (type %d)", dbid, key, g.ptr, g.rtype, o.ptr, o.rtype)
+			}
+		}
+	}
+	if !reflect.DeepEqual(gotExpires, expires) {
+		t.Errorf("expires: got %v, want %v", gotExpires, expires)
+	}
+}
+
+// 集合的元素顺序不固定，比较前排序。
+func normalizeObject(o *robj) interface{} {
+	if o.rtype == OBJ_SET {
+		items := append([]string(nil), o.ptr.([]string)...)
+		sort.Strings(items)
+		return items
+	}
+	return o.ptr
+}
+
+// 通过 saveRDB 和 loadRDB 保存并重新载入数据库：所有类型的键、过期时间和函数库被恢复，
+// 已经过期的键不会载入。
+func TestRDBSaveLoad(t *testing.T) {
+	dir := t.TempDir()
+	rdbFile := filepath.Join(dir, "dump.rdb")
+	expireAt := time.UnixMilli(time.Now().Add(time.Hour).UnixMilli())
+	lib := "#!lua name=mylib\nredis.register_function('f', function() return 1 end)"
+
+	db := newRedisDb(rdbFile, dir, "appendonly.aof")
+	db.setKey("a", "1")
+	db.setKey("b", strings.Repeat("long value ", 100))
+	db.setKey("volatile", "v")
+	db.setKey("expired", "gone")
+	db.mu.Lock()
+	db.dbSetExpire("volatile", expireAt)
+	db.dbSetExpire("expired", time.Now().Add(-time.Second))
+	db.dbSet("list", db.newObject(OBJ_LIST, []string{"x"}))
+	db.dbSet("hash", db.newObject(OBJ_HASH, map[string]string{"f": "v"}))
+	db.dbSet("zset", db.newObject(OBJ_ZSET, map[string]float64{"m": 1.5}))
+	db.functions["mylib"] = lib
+	db.mu.Unlock()
+	if err := db.saveRDB(); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded := newRedisDb(rdbFile, dir, "appendonly.aof")
+	if err := loaded.loadRDB(); err != nil {
+		t.Fatal(err)
+	}
+	for key, want := range map[string]string{"a": "1", "b": strings.Repeat("long value ", 100), "volatile": "v"} {
+		if got, ok := loaded.getKey(key, LOOKUP_NOTOUCH); !ok || got != want {
+			t.Errorf("key %q: got %q, %v; want %q", key, got, ok, want)
+		}
+	}
+	if _, ok := loaded.data["expired"]; ok {
+		t.Errorf("expired key should not be loaded")
+	}
+	for _, key := range []string{"list", "hash", "zset"} {
+		if got, want := loaded.data[key], db.data[key]; got == nil || got.rtype != want.rtype || !reflect.DeepEqual(got.ptr, want.ptr) {
+			t.Errorf("key %q: got %v, want %v", key, got, want.ptr)
+		}
+	}
+	if got := loaded.expires["volatile"]; !got.Equal(expireAt) {
+		t.Errorf("volatile expires at %v, want %v", got, expireAt)
+	}
+	if len(loaded.expires) != 1 {
+		t.Errorf("got %d expires, want 1", len(loaded.expires))
+	}
+	if loaded.functions["mylib"] != lib {
+		t.Errorf("function library not restored: %q", loaded.functions["mylib"])
+	}
+}
+
+// 损坏的 LZF 长度返回格式错误而不是 panic。
+func TestRDBCorruptLZFLength(t *testing.T) {
+	for _, ulen := range []uint64{1 << 62, math.MaxUint64 - 4, 1000} {
+		var buf bytes.Buffer
+		enc := newRDBEncoder(&buf)
+		enc.writeHeader()
+		enc.writeSelectDB(0)
+		enc.writeByte(RDB_TYPE_STRING)
+		enc.writeString("k")
+		enc.writeByte(RDB_ENCVAL<<6 | RDB_ENC_LZF)
+		enc.writeLen(3)
+		enc.writeLen(ulen)
+		enc.write([]byte{0, 'a', 'b'})
+		enc.writeEOF()
+
+		dec := newRDBDecoder(&buf)
+		if _, err := dec.readHeader(); err != nil {
+			t.Fatal(err)
+		}
+		var err error
+		for err == nil {
+			_, err = dec.next()
+		}
+		if err == io.EOF {
+			t.Errorf("ulen %d: corrupt string was accepted", ulen)
+		}
+	}
+}
+
+// 数据库 0 以外的键不能载入，加载失败而不是丢掉这些键。
+func TestRDBLoadRejectsOtherDatabases(t *testing.T) {
+	var buf bytes.Buffer
+	enc := newRDBEncoder(&buf)
+	enc.writeHeader()
+	enc.writeSelectDB(0)
+	enc.writeObject("a", createObject(OBJ_STRING, "1"), -1)
+	enc.writeSelectDB(5)
+	enc.writeObject("b", createObject(OBJ_LIST, []string{"x"}), -1)
+	enc.writeEOF()
+
+	db := newRedisDb("", t.TempDir(), "appendonly.aof")
+	err := db.loadRDBFrom(newRDBDecoder(&buf))
+	if err == nil || !strings.Contains(err.Error(), "db 5") {
+		t.Errorf("got error %v, want one naming db 5", err)
+	}
+}
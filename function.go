@@ -0,0 +1,667 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	lua "github.com/yuin/gopher-lua"
+	"github.com/yuin/gopher-lua/parse"
+)
+
+// 函数的标志，通过 redis.register_function 的 flags 指定
+const (
+	SCRIPT_FLAG_NO_WRITES        = 1 << 0 // 不执行写命令，可以用 FCALL_RO 调用
+	SCRIPT_FLAG_ALLOW_OOM        = 1 << 1 // 内存超过上限时仍然可以执行
+	SCRIPT_FLAG_ALLOW_STALE      = 1 << 2 // 以下三个标志与复制和集群有关，这里只做兼容，没有效果
+	SCRIPT_FLAG_NO_CLUSTER       = 1 << 3
+	SCRIPT_FLAG_ALLOW_CROSS_SLOT = 1 << 4
+)
+
+var scriptFlagNames = []struct {
+	flag int
+	name string
+}{
+	{SCRIPT_FLAG_NO_WRITES, "no-writes"},
+	{SCRIPT_FLAG_ALLOW_OOM, "allow-oom"},
+	{SCRIPT_FLAG_ALLOW_STALE, "allow-stale"},
+	{SCRIPT_FLAG_NO_CLUSTER, "no-cluster"},
+	{SCRIPT_FLAG_ALLOW_CROSS_SLOT, "allow-cross-slot-keys"},
+}
+
+// 加载函数库代码的时间上限，超过后放弃加载
+const FUNCTION_LOAD_TIMEOUT = 500 * time.Millisecond
+
+// functionLibrary 是一个编译好的函数库。
+type functionLibrary struct {
+	name      string
+	engine    string
+	code      string
+	functions map[string]*functionInfo
+}
+
+// functionInfo 是函数库中通过 redis.register_function 注册的一个函数。
+type functionInfo struct {
+	name        string
+	library     *functionLibrary
+	callback    *lua.LFunction
+	flags       int // SCRIPT_FLAG_*
+	description string
+}
+
+// 库名和函数名只能包含字母、数字和下划线。
+func functionsVerifyName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, ch := range name {
+		if !(ch >= 'a' && ch <= 'z' || ch >= 'A' && ch <= 'Z' || ch >= '0' && ch <= '9' || ch == '_') {
+			return false
+		}
+	}
+	return true
+}
+
+// 把 Lua 的错误转换为错误消息，去掉调用栈。
+func luaErrorMessage(err error) string {
+	var apiErr *lua.ApiError
+	if errors.As(err, &apiErr) {
+		return apiErr.Object.String()
+	}
+	return err.Error()
+}
+
+// 编译并执行函数库代码，收集代码中通过 redis.register_function 注册的函数。
+// 加载时 redis 表中只有 register_function 和 log，代码不能执行命令也不能创建全局变量。
+func (ls *luaScripting) createFunctionLibrary(code string) (*functionLibrary, error) {
+	engine, name, err := functionExtractLibraryMetadata(code)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.EqualFold(engine, "lua") {
+		return nil, fmt.Errorf("ERR Engine '%s' not found", engine)
+	}
+	if !functionsVerifyName(name) {
+		return nil, errors.New("ERR Library names can only contain letters, numbers, or underscores(_) and must be at least one character long")
+	}
+	// 去掉第一行的元数据，保留换行使行号不变
+	_, body, _ := strings.Cut(code, "\n")
+	chunk, err := parse.Parse(strings.NewReader("\n"+body), "@user_function")
+	if err != nil {
+		return nil, fmt.Errorf("ERR Error compiling function: %v", err)
+	}
+	proto, err := lua.Compile(chunk, "@user_function")
+	if err != nil {
+		return nil, fmt.Errorf("ERR Error compiling function: %v", err)
+	}
+
+	if ls.fL == nil {
+		ls.fL = ls.createState()
+	}
+	L := ls.fL
+	lib := &functionLibrary{name: name, engine: "LUA", code: code, functions: make(map[string]*functionInfo)}
+	runtimeRedis := L.G.Global.RawGetString("redis")
+	loadRedis := L.NewTable()
+	L.SetFuncs(loadRedis, map[string]lua.LGFunction{
+		"register_function": func(L *lua.LState) int {
+			return luaRegisterFunction(L, lib)
+		},
+		"log": luaLog,
+	})
+	for name, v := range map[string]int{"LOG_DEBUG": LL_DEBUG, "LOG_VERBOSE": LL_VERBOSE, "LOG_NOTICE": LL_NOTICE, "LOG_WARNING": LL_WARNING} {
+		loadRedis.RawSetString(name, lua.LNumber(v))
+	}
+
+	// 每个库有自己的环境：读取时回退到全局表，不能创建新的全局变量
+	env := L.NewTable()
+	env.RawSetString("redis", loadRedis)
+	mt := L.NewTable()
+	mt.RawSetString("__index", L.G.Global)
+	L.SetField(mt, "__newindex", L.NewFunction(func(L *lua.LState) int {
+		L.RaiseError("Attempt to modify a readonly table")
+		return 0
+	}))
+	L.SetMetatable(env, mt)
+	fn := L.NewFunctionFromProto(proto)
+	fn.Env = env
+
+	ctx, cancel := context.WithTimeout(context.Background(), FUNCTION_LOAD_TIMEOUT)
+	defer cancel()
+	L.SetContext(ctx)
+	L.Push(fn)
+	err = L.PCall(0, 0, nil)
+	L.RemoveContext()
+	L.SetTop(0)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, errors.New("ERR FUNCTION LOAD timeout")
+		}
+		return nil, fmt.Errorf("ERR Error registering functions: %s", luaErrorMessage(err))
+	}
+	// 加载完成后函数通过同一个环境访问完整的 redis 表
+	env.RawSetString("redis", runtimeRedis)
+	if len(lib.functions) == 0 {
+		return nil, errors.New("ERR No functions registered")
+	}
+	return lib, nil
+}
+
+// redis.register_function(name, callback) 或
+// redis.register_function{function_name = name, callback = callback, flags = {...}, description = text}
+func luaRegisterFunction(L *lua.LState, lib *functionLibrary) int {
+	f := &functionInfo{library: lib}
+	switch L.GetTop() {
+	case 1:
+		t := L.CheckTable(1)
+		t.ForEach(func(k, v lua.LValue) {
+			switch k.String() {
+			case "function_name":
+				f.name = lua.LVAsString(v)
+			case "callback":
+				f.callback, _ = v.(*lua.LFunction)
+			case "description":
+				f.description = lua.LVAsString(v)
+			case "flags":
+				flags, ok := v.(*lua.LTable)
+				if !ok {
+					L.RaiseError("flags argument to redis.register_function must be a table representing function flags")
+				}
+				flags.ForEach(func(_, v lua.LValue) {
+					found := false
+					for _, sf := range scriptFlagNames {
+						if lua.LVAsString(v) == sf.name {
+							f.flags |= sf.flag
+							found = true
+						}
+					}
+					if !found {
+						L.RaiseError("unknown flag given")
+					}
+				})
+			default:
+				L.RaiseError("unknown argument given to redis.register_function")
+			}
+		})
+	case 2:
+		f.name = L.CheckString(1)
+		f.callback = L.CheckFunction(2)
+	default:
+		L.RaiseError("wrong number of arguments to redis.register_function")
+	}
+	switch {
+	case f.name == "":
+		L.RaiseError("redis.register_function must get a function name argument")
+	case f.callback == nil:
+		L.RaiseError("redis.register_function must get a callback argument")
+	case !functionsVerifyName(f.name):
+		L.RaiseError("Function names can only contain letters, numbers, or underscores(_) and must be at least one character long")
+	case lib.functions[f.name] != nil:
+		L.RaiseError("Function already exists in the library")
+	}
+	lib.functions[f.name] = f
+	return 0
+}
+
+// 把新编译的函数库加入 libraries 和 functions。replace 为 false 时同名的库已经存在则失败，
+// 函数名不能与其他库中的函数重复。出错时两个表可能已被修改，调用方应传入副本。
+func addFunctionLibraries(libraries map[string]*functionLibrary, functions map[string]*functionInfo, libs []*functionLibrary, replace bool) error {
+	for _, lib := range libs {
+		old := libraries[lib.name]
+		if old != nil && !replace {
+			return fmt.Errorf("ERR Library '%s' already exists", lib.name)
+		}
+		if old != nil {
+			for name := range old.functions {
+				delete(functions, name)
+			}
+		}
+		for name := range lib.functions {
+			if functions[name] != nil {
+				return fmt.Errorf("ERR Function %s already exists", name)
+			}
+		}
+		for name, f := range lib.functions {
+			functions[name] = f
+		}
+		libraries[lib.name] = lib
+	}
+	return nil
+}
+
+// 复制当前的函数库和函数表，调用方需持有 ls.mu。
+func (ls *luaScripting) copyFunctionsLocked() (map[string]*functionLibrary, map[string]*functionInfo) {
+	libraries := make(map[string]*functionLibrary, len(ls.libraries))
+	for name, lib := range ls.libraries {
+		libraries[name] = lib
+	}
+	functions := make(map[string]*functionInfo, len(ls.functions))
+	for name, f := range ls.functions {
+		functions[name] = f
+	}
+	return libraries, functions
+}
+
+// 替换函数库和函数表，并记录它们对应的数据库函数库版本号。
+func (ls *luaScripting) setFunctions(libraries map[string]*functionLibrary, functions map[string]*functionInfo, version int64) {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	ls.libraries = libraries
+	ls.functions = functions
+	ls.functionsVersion = version
+}
+
+// 数据库中的函数库发生变化（从 RDB 或 AOF 加载）后重新编译全部函数库。调用方需独占执行。
+func (ls *luaScripting) ensureFunctions() error {
+	codes, version := ls.server.db.functionLibraries()
+	ls.mu.Lock()
+	current := ls.functionsVersion
+	ls.mu.Unlock()
+	if version == current {
+		return nil
+	}
+
+	names := make([]string, 0, len(codes))
+	for name := range codes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	ls.fL = nil // 重新创建虚拟机，旧的函数不再可用
+	libs := make([]*functionLibrary, 0, len(names))
+	for _, name := range names {
+		lib, err := ls.createFunctionLibrary(codes[name])
+		if err != nil {
+			return fmt.Errorf("ERR Failed loading library '%s': %s", name, strings.TrimPrefix(err.Error(), "ERR "))
+		}
+		libs = append(libs, lib)
+	}
+	libraries, functions := make(map[string]*functionLibrary), make(map[string]*functionInfo)
+	if err := addFunctionLibraries(libraries, functions, libs, false); err != nil {
+		return err
+	}
+	ls.setFunctions(libraries, functions, version)
+	return nil
+}
+
+// 加载一个函数库，返回库名。调用方需独占执行。
+func (ls *luaScripting) functionLoad(code string, replace bool) (string, error) {
+	if err := ls.ensureFunctions(); err != nil {
+		return "", err
+	}
+	lib, err := ls.createFunctionLibrary(code)
+	if err != nil {
+		return "", err
+	}
+	ls.mu.Lock()
+	libraries, functions := ls.copyFunctionsLocked()
+	ls.mu.Unlock()
+	if err := addFunctionLibraries(libraries, functions, []*functionLibrary{lib}, replace); err != nil {
+		return "", err
+	}
+	ls.setFunctions(libraries, functions, ls.server.db.setFunctionLibrary(lib.name, code))
+	return lib.name, nil
+}
+
+// 删除一个函数库。调用方需独占执行。
+func (ls *luaScripting) functionDelete(name string) error {
+	if err := ls.ensureFunctions(); err != nil {
+		return err
+	}
+	ls.mu.Lock()
+	libraries, functions := ls.copyFunctionsLocked()
+	ls.mu.Unlock()
+	lib := libraries[name]
+	if lib == nil {
+		return errors.New("ERR Library not found")
+	}
+	delete(libraries, name)
+	for fname := range lib.functions {
+		delete(functions, fname)
+	}
+	ls.setFunctions(libraries, functions, ls.server.db.deleteFunctionLibrary(name))
+	return nil
+}
+
+// 删除所有函数库。调用方需独占执行。
+func (ls *luaScripting) functionFlush() {
+	ls.fL = nil
+	ls.setFunctions(make(map[string]*functionLibrary), make(map[string]*functionInfo), ls.server.db.flushFunctions())
+}
+
+// 从 FUNCTION DUMP 的内容恢复函数库，policy 为 FLUSH、APPEND 或 REPLACE。
+// 所有函数库都编译成功且没有冲突时才会生效。调用方需独占执行。
+func (ls *luaScripting) functionRestore(payload []byte, policy string) error {
+	codes, err := functionsRestorePayload(payload)
+	if err != nil {
+		return err
+	}
+	if err := ls.ensureFunctions(); err != nil {
+		return err
+	}
+	libs := make([]*functionLibrary, 0, len(codes))
+	for _, code := range codes {
+		lib, err := ls.createFunctionLibrary(code)
+		if err != nil {
+			return err
+		}
+		libs = append(libs, lib)
+	}
+
+	ls.mu.Lock()
+	libraries, functions := ls.copyFunctionsLocked()
+	ls.mu.Unlock()
+	if policy == "FLUSH" {
+		libraries, functions = make(map[string]*functionLibrary), make(map[string]*functionInfo)
+	}
+	if err := addFunctionLibraries(libraries, functions, libs, policy == "REPLACE"); err != nil {
+		return err
+	}
+
+	if len(libs) == 0 && policy != "FLUSH" {
+		return nil
+	}
+	// 恢复的结果作为一个事务写入 AOF
+	db := ls.server.db
+	db.propagateMulti()
+	var version int64
+	if policy == "FLUSH" {
+		version = db.flushFunctions()
+	}
+	for _, lib := range libs {
+		version = db.setFunctionLibrary(lib.name, lib.code)
+	}
+	db.propagateExec()
+	ls.setFunctions(libraries, functions, version)
+	return nil
+}
+
+// 按名称查找函数。
+func (ls *luaScripting) lookupFunction(name string) *functionInfo {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	return ls.functions[name]
+}
+
+// 执行函数并返回它的回复，函数的参数是键和其他参数组成的两个数组。
+func (ls *luaScripting) runFunction(caller *redisClient, f *functionInfo, command, keys, argv []string) Reply {
+	L := ls.fL
+	rctx := &scriptRunCtx{
+		name:     f.name,
+		function: true,
+		command:  command,
+		caller:   caller,
+		readonly: f.flags&SCRIPT_FLAG_NO_WRITES != 0,
+		allowOOM: f.flags&SCRIPT_FLAG_ALLOW_OOM != 0,
+	}
+	return ls.execute(L, rctx, f.callback, luaStringArray(L, keys), luaStringArray(L, argv))
+}
+
+// 按被调用函数的标志计算 FCALL 的实际命令标志：没有 no-writes 标志的函数视为写命令，
+// 同时没有 allow-oom 标志时在内存超过上限时拒绝执行。
+func fcallGetCommandFlags(s *redisServer, args []string, flags int) int {
+	f := s.lua.lookupFunction(args[1])
+	if f == nil || flags&CMD_READONLY != 0 || f.flags&SCRIPT_FLAG_NO_WRITES != 0 {
+		return flags
+	}
+	flags |= CMD_WRITE
+	if f.flags&SCRIPT_FLAG_ALLOW_OOM == 0 {
+		flags |= CMD_DENYOOM
+	}
+	return flags
+}
+
+// FCALL 和 FCALL_RO 的公共部分。
+func fcallGenericCommand(c *redisClient, args []string, readonly bool) {
+	ls := c.server.lua
+	if err := ls.ensureFunctions(); err != nil {
+		c.writeError(err.Error())
+		return
+	}
+	f := ls.lookupFunction(args[1])
+	if f == nil {
+		c.writeError("ERR Function not found")
+		return
+	}
+	keys, argv, errReply := scriptGetKeys(args)
+	if errReply != "" {
+		c.writeError(errReply)
+		return
+	}
+	if readonly && f.flags&SCRIPT_FLAG_NO_WRITES == 0 {
+		c.writeError("ERR Can not execute a script with write flag using *_ro command.")
+		return
+	}
+	c.writeReply(ls.runFunction(c, f, args, keys, argv))
+}
+
+// FCALL function numkeys [key ...] [arg ...]
+func fcallCommand(c *redisClient, args []string) {
+	fcallGenericCommand(c, args, false)
+}
+
+// FCALL_RO function numkeys [key ...] [arg ...]
+func fcallRoCommand(c *redisClient, args []string) {
+	fcallGenericCommand(c, args, true)
+}
+
+// FUNCTION 的子命令不存在时执行。
+func functionCommand(c *redisClient, args []string) {
+	c.writeError(fmt.Sprintf("ERR unknown subcommand or wrong number of arguments for '%s'. Try FUNCTION HELP.", args[1]))
+}
+
+// FUNCTION LOAD [REPLACE] function-code
+func functionLoadCommand(c *redisClient, args []string) {
+	replace := false
+	if len(args) > 3 {
+		if len(args) > 4 || !strings.EqualFold(args[2], "REPLACE") {
+			c.writeError(fmt.Sprintf("ERR Unknown option given: %s", args[2]))
+			return
+		}
+		replace = true
+	}
+	name, err := c.server.lua.functionLoad(args[len(args)-1], replace)
+	if err != nil {
+		c.writeError(err.Error())
+		return
+	}
+	c.writeBulk(name)
+}
+
+// FUNCTION DELETE library-name
+func functionDeleteCommand(c *redisClient, args []string) {
+	if err := c.server.lua.functionDelete(args[2]); err != nil {
+		c.writeError(err.Error())
+		return
+	}
+	c.writeStatus("OK")
+}
+
+// FUNCTION FLUSH [ASYNC|SYNC]
+func functionFlushCommand(c *redisClient, args []string) {
+	if len(args) > 3 || (len(args) == 3 && !strings.EqualFold(args[2], "SYNC") && !strings.EqualFold(args[2], "ASYNC")) {
+		c.writeError("ERR FUNCTION FLUSH only supports SYNC|ASYNC option")
+		return
+	}
+	c.server.lua.functionFlush()
+	c.writeStatus("OK")
+}
+
+// FUNCTION DUMP
+func functionDumpCommand(c *redisClient, args []string) {
+	libs, _ := c.server.db.functionLibraries()
+	names := make([]string, 0, len(libs))
+	for name := range libs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	codes := make([]string, len(names))
+	for i, name := range names {
+		codes[i] = libs[name]
+	}
+	c.writeBulk(string(functionsDump(codes)))
+}
+
+// FUNCTION RESTORE serialized-value [FLUSH|APPEND|REPLACE]
+func functionRestoreCommand(c *redisClient, args []string) {
+	policy := "APPEND"
+	if len(args) > 4 {
+		c.writeError("ERR syntax error")
+		return
+	}
+	if len(args) == 4 {
+		policy = strings.ToUpper(args[3])
+		if policy != "FLUSH" && policy != "APPEND" && policy != "REPLACE" {
+			c.writeError("ERR Wrong restore policy given, value should be either FLUSH, APPEND or REPLACE.")
+			return
+		}
+	}
+	if err := c.server.lua.functionRestore([]byte(args[2]), policy); err != nil {
+		c.writeError(err.Error())
+		return
+	}
+	c.writeStatus("OK")
+}
+
+// FUNCTION LIST [LIBRARYNAME library-name-pattern] [WITHCODE]
+func functionListCommand(c *redisClient, args []string) {
+	withCode, pattern := false, ""
+	for j := 2; j < len(args); j++ {
+		switch {
+		case strings.EqualFold(args[j], "WITHCODE") && !withCode:
+			withCode = true
+		case strings.EqualFold(args[j], "LIBRARYNAME") && pattern == "" && j+1 < len(args):
+			pattern = args[j+1]
+			j++
+		default:
+			c.writeError(fmt.Sprintf("ERR Unknown argument %s", args[j]))
+			return
+		}
+	}
+
+	ls := c.server.lua
+	ls.mu.Lock()
+	libraries := make([]*functionLibrary, 0, len(ls.libraries))
+	for _, lib := range ls.libraries {
+		if pattern == "" || stringMatch(pattern, lib.name, false) {
+			libraries = append(libraries, lib)
+		}
+	}
+	ls.mu.Unlock()
+	sort.Slice(libraries, func(i, j int) bool { return libraries[i].name < libraries[j].name })
+
+	items := make([]Reply, 0, len(libraries))
+	for _, lib := range libraries {
+		names := make([]string, 0, len(lib.functions))
+		for name := range lib.functions {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		functions := make([]Reply, 0, len(names))
+		for _, name := range names {
+			f := lib.functions[name]
+			var description Reply = &NullReply{}
+			if f.description != "" {
+				description = &BulkStringReply{Value: f.description}
+			}
+			flags := []Reply{}
+			for _, sf := range scriptFlagNames {
+				if f.flags&sf.flag != 0 {
+					flags = append(flags, &SimpleStringReply{Value: sf.name})
+				}
+			}
+			functions = append(functions, &MapReply{Value: []Reply{
+				&BulkStringReply{Value: "name"}, &BulkStringReply{Value: f.name},
+				&BulkStringReply{Value: "description"}, description,
+				&BulkStringReply{Value: "flags"}, &SetReply{Value: flags},
+			}})
+		}
+		item := []Reply{
+			&BulkStringReply{Value: "library_name"}, &BulkStringReply{Value: lib.name},
+			&BulkStringReply{Value: "engine"}, &BulkStringReply{Value: lib.engine},
+			&BulkStringReply{Value: "functions"}, &ArrayReply{Value: functions},
+		}
+		if withCode {
+			item = append(item, &BulkStringReply{Value: "library_code"}, &BulkStringReply{Value: lib.code})
+		}
+		items = append(items, &MapReply{Value: item})
+	}
+	c.writeReply(&ArrayReply{Value: items})
+}
+
+// FUNCTION STATS：正在执行的函数以及函数库和函数的数量。
+func functionStatsCommand(c *redisClient, args []string) {
+	ls := c.server.lua
+	ls.mu.Lock()
+	var running Reply = &NullReply{}
+	if rctx := ls.running; rctx != nil && rctx.function {
+		command := make([]Reply, len(rctx.command))
+		for i, arg := range rctx.command {
+			command[i] = &BulkStringReply{Value: arg}
+		}
+		running = &MapReply{Value: []Reply{
+			&BulkStringReply{Value: "name"}, &BulkStringReply{Value: rctx.name},
+			&BulkStringReply{Value: "command"}, &ArrayReply{Value: command},
+			&BulkStringReply{Value: "duration_ms"}, &IntegerReply{Value: time.Since(rctx.start).Milliseconds()},
+		}}
+	}
+	librariesCount, functionsCount := len(ls.libraries), len(ls.functions)
+	ls.mu.Unlock()
+
+	c.writeReply(&MapReply{Value: []Reply{
+		&BulkStringReply{Value: "running_script"}, running,
+		&BulkStringReply{Value: "engines"}, &MapReply{Value: []Reply{
+			&BulkStringReply{Value: "LUA"}, &MapReply{Value: []Reply{
+				&BulkStringReply{Value: "libraries_count"}, &IntegerReply{Value: int64(librariesCount)},
+				&BulkStringReply{Value: "functions_count"}, &IntegerReply{Value: int64(functionsCount)},
+			}},
+		}},
+	}})
+}
+
+// FUNCTION KILL
+func functionKillCommand(c *redisClient, args []string) {
+	c.writeReply(c.server.lua.kill(true))
+}
+
+// FUNCTION HELP
+func functionHelpCommand(c *redisClient, args []string) {
+	c.writeHelp([]string{
+		"FUNCTION <subcommand> [<arg> [value] [opt] ...]. Subcommands are:",
+		"LOAD [REPLACE] <FUNCTION CODE>",
+		"    Create a new library with the given library name and code.",
+		"DELETE <LIBRARY NAME>",
+		"    Delete the given library.",
+		"LIST [LIBRARYNAME PATTERN] [WITHCODE]",
+		"    Return general information on all the libraries:",
+		"    * Library name",
+		"    * The engine used to run the Library",
+		"    * Library code (if WITHCODE is given)",
+		"    * Functions list",
+		"    It also possible to get only function that matches a pattern using LIBRARYNAME argument.",
+		"STATS",
+		"    Return information about the current function running:",
+		"    * Function name",
+		"    * Command used to run the function",
+		"    * Duration in MS that the function is running",
+		"    If no function is running, return nil",
+		"    In addition, returns a list of available engines.",
+		"KILL",
+		"    Kill the current running function.",
+		"FLUSH [ASYNC|SYNC]",
+		"    Delete all the libraries.",
+		"DUMP",
+		"    Return a serialized payload representing the current libraries, can be restored using FUNCTION RESTORE command",
+		"RESTORE <PAYLOAD> [FLUSH|APPEND|REPLACE]",
+		"    Restore the libraries represented by the given payload, it is possible to give a restore policy to",
+		"    control how to handle existing libraries (default APPEND):",
+		"    * FLUSH: delete all existing libraries.",
+		"    * APPEND: appends the restored libraries to the existing libraries. On collision, abort.",
+		"    * REPLACE: appends the restored libraries to the existing libraries, On collision, replace the old",
+		"      libraries with the new libraries (notice that even on this option there is a chance of failure",
+		"      in case of functions name collision with another library).",
+		"HELP",
+		"    Print this help.",
+	})
+}
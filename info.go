@@ -0,0 +1,207 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// 表示 INFO 输出中的一个段落。
+type infoSection struct {
+	name string
+	gen  func(s *redisServer, b *strings.Builder)
+}
+
+// 定义了 INFO 支持的段落，按输出顺序排列。
+var infoSections = []infoSection{
+	{name: "server", gen: (*redisServer).infoServer},
+	{name: "clients", gen: (*redisServer).infoClients},
+	{name: "memory", gen: (*redisServer).infoMemory},
+	{name: "persistence", gen: (*redisServer).infoPersistence},
+	{name: "stats", gen: (*redisServer).infoStats},
+}
+
+// 生成 INFO 命令的输出；section 为空、"all"、"default" 或 "everything" 时输出所有段落。
+func (s *redisServer) genRedisInfoString(section string) string {
+	section = strings.ToLower(section)
+	all := section == "" || section == "all" || section == "default" || section == "everything"
+	var b strings.Builder
+	for _, sec := range infoSections {
+		if !all && sec.name != section {
+			continue
+		}
+		if b.Len() > 0 {
+			b.WriteString("\r\n")
+		}
+		fmt.Fprintf(&b, "# %s\r\n", strings.ToUpper(sec.name[:1])+sec.name[1:])
+		sec.gen(s, &b)
+	}
+	return b.String()
+}
+
+// 写入一个 "name:value" 字段。
+func infoField(b *strings.Builder, name string, value interface{}) {
+	fmt.Fprintf(b, "%s:%v\r\n", name, value)
+}
+
+func (s *redisServer) infoServer(b *strings.Builder) {
+	uptime := time.Since(s.startTime)
+	infoField(b, "redis_version", REDIS_VERSION)
+	infoField(b, "redis_mode", "standalone")
+	infoField(b, "os", runtime.GOOS+" "+runtime.GOARCH)
+	infoField(b, "go_version", runtime.Version())
+	infoField(b, "process_id", os.Getpid())
+	infoField(b, "tcp_port", s.port)
+	for i, l := range s.listeners {
+		infoField(b, fmt.Sprintf("listener%d", i), l.info())
+	}
+	infoField(b, "uptime_in_seconds", int64(uptime.Seconds()))
+	infoField(b, "uptime_in_days", int64(uptime.Hours()/24))
+	infoField(b, "hz", CONFIG_DEFAULT_HZ)
+}
+
+func (s *redisServer) infoPersistence(b *strings.Builder) {
+	dirty := s.db.dirtyCount()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	status := "ok"
+	if !s.lastBgsaveOK {
+		status = "err"
+	}
+	current := int64(-1)
+	if s.rdbChildRunning {
+		current = int64(time.Since(s.rdbSaveTimeStart).Seconds())
+	}
+	last := int64(-1)
+	if s.rdbSaveTimeLast >= 0 {
+		last = int64(s.rdbSaveTimeLast.Seconds())
+	}
+	infoField(b, "loading", 0)
+	infoField(b, "rdb_changes_since_last_save", dirty)
+	infoField(b, "rdb_bgsave_in_progress", boolToInt(s.rdbChildRunning))
+	infoField(b, "rdb_bgsave_scheduled", boolToInt(s.rdbBgsaveScheduled))
+	infoField(b, "rdb_last_save_time", s.lastSave.Unix())
+	infoField(b, "rdb_last_bgsave_status", status)
+	infoField(b, "rdb_last_bgsave_time_sec", last)
+	infoField(b, "rdb_current_bgsave_time_sec", current)
+	infoField(b, "rdb_saves", s.rdbSaves)
+	aofRewriteStatus := "ok"
+	if !s.lastBgrewriteOK {
+		aofRewriteStatus = "err"
+	}
+	aofCurrent := int64(-1)
+	if s.aofChildRunning {
+		aofCurrent = int64(time.Since(s.aofRewriteTimeStart).Seconds())
+	}
+	aofLast := int64(-1)
+	if s.aofRewriteTimeLast >= 0 {
+		aofLast = int64(s.aofRewriteTimeLast.Seconds())
+	}
+	aof := s.db.appendOnly()
+	aofStatus := "ok"
+	if aof != nil && aof.lastWriteError() != nil {
+		aofStatus = "err"
+	}
+	infoField(b, "aof_enabled", boolToInt(aof != nil))
+	infoField(b, "aof_rewrite_in_progress", boolToInt(s.aofChildRunning))
+	infoField(b, "aof_rewrite_scheduled", boolToInt(s.aofRewriteScheduled))
+	infoField(b, "aof_last_rewrite_time_sec", aofLast)
+	infoField(b, "aof_current_rewrite_time_sec", aofCurrent)
+	infoField(b, "aof_last_bgrewrite_status", aofRewriteStatus)
+	infoField(b, "aof_rewrites", s.aofRewrites)
+	infoField(b, "aof_last_write_status", aofStatus)
+	if aof != nil {
+		infoField(b, "aof_current_size", aof.currentSize())
+		infoField(b, "aof_base_size", aof.rewriteBaseSize())
+		infoField(b, "aof_pending_rewrite", boolToInt(s.aofRewriteScheduled))
+	}
+}
+
+func (s *redisServer) infoClients(b *strings.Builder) {
+	connected := s.numClients()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	infoField(b, "connected_clients", connected)
+	infoField(b, "maxclients", s.config.maxclients)
+}
+
+func (s *redisServer) infoMemory(b *strings.Builder) {
+	mh := s.getMemoryOverheadData()
+	s.mu.Lock()
+	maxmemory, policy := s.config.maxmemory, s.config.maxmemoryPolicy
+	s.mu.Unlock()
+	infoField(b, "used_memory", mh.totalAllocated)
+	infoField(b, "used_memory_human", bytesToHuman(mh.totalAllocated))
+	infoField(b, "used_memory_rss", mh.rss)
+	infoField(b, "used_memory_rss_human", bytesToHuman(mh.rss))
+	infoField(b, "used_memory_peak", mh.peakAllocated)
+	infoField(b, "used_memory_peak_human", bytesToHuman(mh.peakAllocated))
+	infoField(b, "used_memory_peak_perc", fmt.Sprintf("%.2f%%", mh.peakPerc))
+	infoField(b, "used_memory_overhead", mh.overheadTotal)
+	infoField(b, "used_memory_dataset", mh.datasetBytes)
+	infoField(b, "used_memory_dataset_perc", fmt.Sprintf("%.2f%%", mh.datasetPerc))
+	infoField(b, "allocator_allocated", mh.allocatorAllocated)
+	infoField(b, "allocator_active", mh.allocatorActive)
+	infoField(b, "allocator_resident", mh.allocatorResident)
+	infoField(b, "maxmemory", maxmemory)
+	infoField(b, "maxmemory_human", bytesToHuman(maxmemory))
+	infoField(b, "maxmemory_policy", maxmemoryPolicyName(policy))
+	infoField(b, "mem_fragmentation_ratio", fmt.Sprintf("%.2f", mh.fragmentation))
+	infoField(b, "mem_fragmentation_bytes", mh.rss-mh.totalAllocated)
+	infoField(b, "mem_allocator", "go-"+runtime.Version())
+}
+
+func (s *redisServer) infoStats(b *strings.Builder) {
+	trackingKeys, trackingItems, trackingPrefixes := s.tracking.stats()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	infoField(b, "total_connections_received", s.statNumConnections)
+	infoField(b, "rejected_connections", s.statRejectedConn)
+	infoField(b, "evicted_keys", s.statEvictedKeys)
+	infoField(b, "client_output_buffer_limit_disconnections", atomic.LoadInt64(&s.statClientOutbufLimitDisconnections))
+	for i, l := range s.listeners {
+		infoField(b, fmt.Sprintf("listener%d", i), l.stats())
+	}
+	infoField(b, "tracking_total_keys", trackingKeys)
+	infoField(b, "tracking_total_items", trackingItems)
+	infoField(b, "tracking_total_prefixes", trackingPrefixes)
+}
+
+// INFO [section]
+func infoCommand(c *redisClient, args []string) {
+	if len(args) > 2 {
+		c.writeError("ERR syntax error")
+		return
+	}
+	section := ""
+	if len(args) == 2 {
+		section = args[1]
+	}
+	c.writeReply(&VerbatimStringReply{Format: "txt", Value: c.server.genRedisInfoString(section)})
+}
+
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// 把字节数转换为易读的形式，例如 1.50M。
+func bytesToHuman(n int64) string {
+	d := float64(n)
+	switch {
+	case n < 1024:
+		return fmt.Sprintf("%dB", n)
+	case n < 1024*1024:
+		return fmt.Sprintf("%.2fK", d/1024)
+	case n < 1024*1024*1024:
+		return fmt.Sprintf("%.2fM", d/(1024*1024))
+	case n < 1024*1024*1024*1024:
+		return fmt.Sprintf("%.2fG", d/(1024*1024*1024))
+	}
+	return fmt.Sprintf("%.2fT", d/(1024*1024*1024*1024))
+}
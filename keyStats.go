@@ -0,0 +1,271 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// 分析键空间的模式，与 redis-cli 的 --bigkeys、--memkeys 和 --hotkeys 对应
+const (
+	KEYSTATS_BIGKEYS = "bigkeys" // 按元素数量（字符串为字节数）找出最大的键
+	KEYSTATS_MEMKEYS = "memkeys" // 按估算内存找出最大的键
+	KEYSTATS_HOTKEYS = "hotkeys" // 按 LFU 访问频率找出最热的键
+)
+
+// 每批分析的键数量，每批之间释放执行锁和数据库锁，避免长时间阻塞其他客户端
+const KEYSTATS_BATCH_SIZE = 128
+
+// 默认取样的元素数量和 hotkeys 默认输出的键数量
+const (
+	KEYSTATS_DEFAULT_SAMPLES = 5
+	KEYSTATS_DEFAULT_HOTKEYS = 16
+)
+
+// keyTypeStats 是某一种类型的键的汇总。
+type keyTypeStats struct {
+	keys    int64     // 键的数量
+	size    int64     // 元素数量或内存之和
+	biggest []keyInfo // 最大的若干个键，从大到小排列
+}
+
+// keyAnalysis 保存一次键空间分析的结果。
+type keyAnalysis struct {
+	mode        string
+	top         int                     // 每种类型（hotkeys 为全部）保留的键数量
+	sampled     int64                   // 分析过的键数量
+	totalKeyLen int64                   // 键名的总长度
+	types       map[uint8]*keyTypeStats // 按类型汇总
+	hot         []keyInfo               // 访问频率最高的键，从高到低排列
+}
+
+// 按模式返回键的大小：bigkeys 为元素数量，memkeys 为估算内存。
+func (ka *keyAnalysis) keySize(info *keyInfo) int64 {
+	if ka.mode == KEYSTATS_MEMKEYS {
+		return info.memory
+	}
+	return info.length
+}
+
+// 把 info 按 less 插入有序列表 list，列表最多保留 n 个元素。
+func insertTopKey(list []keyInfo, info keyInfo, n int, less func(a, b *keyInfo) bool) []keyInfo {
+	k := sort.Search(len(list), func(i int) bool { return less(&list[i], &info) })
+	if k >= n {
+		return list
+	}
+	if len(list) < n {
+		list = append(list, keyInfo{})
+	}
+	copy(list[k+1:], list[k:])
+	list[k] = info
+	return list
+}
+
+// 把一个键计入分析结果。
+func (ka *keyAnalysis) add(info keyInfo) {
+	ka.sampled++
+	ka.totalKeyLen += int64(len(info.key))
+	if ka.mode == KEYSTATS_HOTKEYS {
+		ka.hot = insertTopKey(ka.hot, info, ka.top, func(a, b *keyInfo) bool { return a.freq < b.freq })
+		return
+	}
+	ts := ka.types[info.rtype]
+	if ts == nil {
+		ts = &keyTypeStats{}
+		ka.types[info.rtype] = ts
+	}
+	ts.keys++
+	ts.size += ka.keySize(&info)
+	ts.biggest = insertTopKey(ts.biggest, info, ka.top, func(a, b *keyInfo) bool {
+		return ka.keySize(a) < ka.keySize(b)
+	})
+}
+
+// 逐批分析整个键空间。键名先做一次快照，之后每批只短暂持有共享的执行锁和数据库读锁，
+// 等待独占执行的 EXEC 和脚本可以在批次之间执行。分析期间被删除的键会被跳过，新写入的键不会被统计。
+// 命令带有 CMD_BATCHED 标志，调用时没有持有执行锁。
+func (s *redisServer) analyzeKeyspace(mode string, top, samples int) *keyAnalysis {
+	ka := &keyAnalysis{mode: mode, top: top, types: make(map[uint8]*keyTypeStats)}
+	s.db.lockShared()
+	keys := s.db.keyNames()
+	s.db.unlockShared()
+	for i := 0; i < len(keys); i += KEYSTATS_BATCH_SIZE {
+		end := min(i+KEYSTATS_BATCH_SIZE, len(keys))
+		s.db.lockShared()
+		infos := s.db.keyStats(keys[i:end], samples)
+		s.db.unlockShared()
+		for _, info := range infos {
+			ka.add(info)
+		}
+	}
+	return ka
+}
+
+// 返回类型在输出中使用的单位。
+func keyTypeUnit(t uint8, mode string) string {
+	if mode == KEYSTATS_MEMKEYS {
+		return "bytes"
+	}
+	switch t {
+	case OBJ_STRING:
+		return "bytes"
+	case OBJ_LIST:
+		return "items"
+	case OBJ_HASH:
+		return "fields"
+	}
+	return "members"
+}
+
+// 按 redis-cli 的格式输出分析结果。
+func (ka *keyAnalysis) String() string {
+	var b strings.Builder
+	if ka.mode == KEYSTATS_HOTKEYS {
+		b.WriteString("# Scanning the entire keyspace to find hot keys as well as\n# average sizes per key type.\n\n")
+	} else {
+		b.WriteString("# Scanning the entire keyspace to find biggest keys as well as\n# average sizes per key type.\n\n")
+	}
+	b.WriteString("-------- summary -------\n\n")
+	fmt.Fprintf(&b, "Sampled %d keys in the keyspace!\n", ka.sampled)
+
+	if ka.mode == KEYSTATS_HOTKEYS {
+		b.WriteString("\n")
+		for _, info := range ka.hot {
+			fmt.Fprintf(&b, "hot key found with counter: %d\tkeyname: %s\n", info.freq, strconv.Quote(info.key))
+		}
+		return strings.TrimSuffix(b.String(), "\n")
+	}
+
+	avgKeyLen := 0.0
+	if ka.sampled > 0 {
+		avgKeyLen = float64(ka.totalKeyLen) / float64(ka.sampled)
+	}
+	fmt.Fprintf(&b, "Total key length in bytes is %d (avg len %.2f)\n\n", ka.totalKeyLen, avgKeyLen)
+	types := []uint8{OBJ_STRING, OBJ_LIST, OBJ_SET, OBJ_ZSET, OBJ_HASH}
+	for _, t := range types {
+		ts := ka.types[t]
+		if ts == nil {
+			continue
+		}
+		for i, info := range ts.biggest {
+			if i == 0 {
+				fmt.Fprintf(&b, "Biggest %6s found '%s' has %d %s\n", objTypeName(t), info.key, ka.keySize(&info), keyTypeUnit(t, ka.mode))
+			} else {
+				fmt.Fprintf(&b, "     #%-2d %6s found '%s' has %d %s\n", i+1, objTypeName(t), info.key, ka.keySize(&info), keyTypeUnit(t, ka.mode))
+			}
+		}
+	}
+	b.WriteString("\n")
+	for _, t := range types {
+		ts := ka.types[t]
+		if ts == nil {
+			ts = &keyTypeStats{}
+		}
+		perc, avg := 0.0, 0.0
+		if ka.sampled > 0 {
+			perc = float64(ts.keys) * 100 / float64(ka.sampled)
+		}
+		if ts.keys > 0 {
+			avg = float64(ts.size) / float64(ts.keys)
+		}
+		fmt.Fprintf(&b, "%d %ss with %d %s (%05.2f%% of keys, avg size %.2f)\n",
+			ts.keys, objTypeName(t), ts.size, keyTypeUnit(t, ka.mode), perc, avg)
+	}
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+// KEYSTATS BIGKEYS|MEMKEYS|HOTKEYS [TOP <count>] [SAMPLES <count>]
+func keystatsCommand(c *redisClient, args []string) {
+	if len(args) < 2 {
+		c.writeError("ERR wrong number of arguments for 'KEYSTATS' command")
+		return
+	}
+	mode := strings.ToLower(args[1])
+	if mode != KEYSTATS_BIGKEYS && mode != KEYSTATS_MEMKEYS && mode != KEYSTATS_HOTKEYS {
+		c.writeError(fmt.Sprintf("ERR unknown subcommand '%s'. Try BIGKEYS, MEMKEYS or HOTKEYS.", args[1]))
+		return
+	}
+	top, samples := 1, KEYSTATS_DEFAULT_SAMPLES
+	if mode == KEYSTATS_HOTKEYS {
+		top = KEYSTATS_DEFAULT_HOTKEYS
+	}
+	for j := 2; j < len(args); j += 2 {
+		if j+1 >= len(args) {
+			c.writeError("ERR syntax error")
+			return
+		}
+		n, err := strconv.Atoi(args[j+1])
+		switch {
+		case strings.EqualFold(args[j], "TOP") && err == nil && n > 0:
+			top = n
+		case strings.EqualFold(args[j], "SAMPLES") && err == nil && n >= 0:
+			samples = n
+		case err != nil || n < 0:
+			c.writeError("ERR value is out of range, must be positive")
+			return
+		default:
+			c.writeError("ERR syntax error")
+			return
+		}
+	}
+	if mode == KEYSTATS_HOTKEYS && !c.server.db.lfuEnabled() {
+		c.writeError("ERR An LFU maxmemory policy is not selected, access frequency not tracked. Please note that when switching between policies at runtime LRU and LFU data will take some time to adjust.")
+		return
+	}
+	c.writeReply(&VerbatimStringReply{Format: "txt", Value: c.server.analyzeKeyspace(mode, top, samples).String()})
+}
+
+// bigkeys|memkeys|hotkeys [-h <host>] [-p <port>] [--top <count>]：连接服务器执行 KEYSTATS 并输出结果。
+func keystatsCliMain(mode, argv0 string, args []string) int {
+	host, port, top := "127.0.0.1", "6379", ""
+	for i := 0; i < len(args); i++ {
+		if i+1 >= len(args) {
+			fmt.Fprintf(os.Stderr, "Usage: %s [-h <host>] [-p <port>] [--top <count>]\n", argv0)
+			return 1
+		}
+		switch args[i] {
+		case "-h":
+			host = args[i+1]
+		case "-p":
+			port = args[i+1]
+		case "--top":
+			top = args[i+1]
+		default:
+			fmt.Fprintf(os.Stderr, "Usage: %s [-h <host>] [-p <port>] [--top <count>]\n", argv0)
+			return 1
+		}
+		i++
+	}
+
+	conn, err := net.Dial("tcp", net.JoinHostPort(host, port))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Could not connect to Redis at %s:%s: %v\n", host, port, err)
+		return 1
+	}
+	defer conn.Close()
+	command := RESPValue{Type: Array, Array: []RESPValue{
+		{Type: BulkString, Str: "KEYSTATS"}, {Type: BulkString, Str: mode},
+	}}
+	if top != "" {
+		command.Array = append(command.Array, RESPValue{Type: BulkString, Str: "TOP"}, RESPValue{Type: BulkString, Str: top})
+	}
+	if _, err := command.WriteTo(conn); err != nil {
+		fmt.Fprintf(os.Stderr, "Error sending command: %v\n", err)
+		return 1
+	}
+	reply, err := ParseRESP(bufio.NewReader(conn))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error reading reply: %v\n", err)
+		return 1
+	}
+	if reply.Type == Error {
+		fmt.Fprintf(os.Stderr, "(error) %s\n", reply.Str)
+		return 1
+	}
+	fmt.Println(strings.ReplaceAll(reply.Str, "\r\n", "\n"))
+	return 0
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// KEYSTATS 只在每批之间短暂持有执行锁，等待中的 EXEC 不需要等整个分析结束。
+func TestKeystatsLetsExecRunBetweenBatches(t *testing.T) {
+	chdirTemp(t)
+	s := newRedisServer(newRedisConfig())
+	for i := 0; i < 10*KEYSTATS_BATCH_SIZE; i++ {
+		s.db.setKey("key:"+strconv.Itoa(i), "v")
+	}
+	scanner, writer := newTestClient(t, s), newTestClient(t, s)
+	commandReply(writer, "MULTI")
+	commandReply(writer, "SET", "k", "v")
+
+	// 持有数据库写锁，让 KEYSTATS 停在第一批，然后让 EXEC 排队等待独占执行
+	s.db.mu.Lock()
+	done := make(chan string, 2)
+	go func() {
+		commandReply(scanner, "KEYSTATS", "BIGKEYS")
+		done <- "KEYSTATS"
+	}()
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		commandReply(writer, "EXEC")
+		done <- "EXEC"
+	}()
+	time.Sleep(150 * time.Millisecond)
+	s.db.mu.Unlock()
+
+	if first := <-done; first != "EXEC" {
+		t.Errorf("%s finished first, want EXEC to run between KEYSTATS batches", first)
+	}
+	<-done
+	if !strings.Contains(string(scanner.outBuf), "Sampled 1280 keys") {
+		t.Errorf("unexpected KEYSTATS reply %q", scanner.outBuf)
+	}
+}
+
+// KEYSTATS 自己获取执行锁，不能在事务中使用。
+func TestKeystatsNotAllowedInMulti(t *testing.T) {
+	chdirTemp(t)
+	s := newRedisServer(newRedisConfig())
+	c := newTestClient(t, s)
+	commandReply(c, "MULTI")
+	if got, want := commandReply(c, "KEYSTATS", "BIGKEYS"), "-ERR Command not allowed inside a transaction\r\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if got := commandReply(c, "EXEC"); !strings.HasPrefix(got, "-EXECABORT") {
+		t.Errorf("EXEC after a rejected command: got %q", got)
+	}
+}
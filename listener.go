@@ -0,0 +1,210 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// bind 最多可以指定的地址数量
+const CONFIG_BINDADDR_MAX = 16
+
+// 一类连接的监听器，TCP 监听器可以绑定多个地址，每个地址对应一个 net.Listener。
+type connListener struct {
+	name      string         // 连接类型：tcp、tls 或 unix
+	bindaddr  []string       // 配置的地址，TCP 为 bind 的地址（可能带有表示可选的 "-" 前缀），unix 为套接字路径
+	port      int            // TCP 端口，unix 为 0
+	tls       bool           // 接受的连接是否使用 TLS
+	listeners []net.Listener // 实际监听的套接字
+
+	// 统计信息，原子地更新
+	accepted  int64 // 接受的连接数量
+	rejected  int64 // 因 maxclients 被拒绝的连接数量
+	connected int64 // 当前的连接数量
+}
+
+// 从监听器接受的一个连接。
+type acceptedConn struct {
+	conn     net.Conn
+	listener *connListener
+}
+
+// 按配置创建所有监听器：port 和 tls-port 不为 0 时分别在 bind 的每个地址上监听明文和 TLS 连接，
+// 设置了 unixsocket 时监听 Unix 套接字。
+func (s *redisServer) initListeners() error {
+	tcp := []*connListener{
+		{name: "tcp", bindaddr: s.config.bindaddr, port: s.config.port},
+		{name: "tls", bindaddr: s.config.bindaddr, port: s.config.tlsPort, tls: true},
+	}
+	for _, l := range tcp {
+		if l.port == 0 {
+			continue
+		}
+		s.listeners = append(s.listeners, l)
+		if err := l.listenTCP(); err != nil {
+			s.closeListeners()
+			return err
+		}
+	}
+	if s.config.unixsocket != "" {
+		l := &connListener{name: "unix", bindaddr: []string{s.config.unixsocket}}
+		if err := l.listenUnix(s.config.unixsocketperm); err != nil {
+			s.closeListeners()
+			return err
+		}
+		s.listeners = append(s.listeners, l)
+	}
+	if len(s.listeners) == 0 {
+		return errors.New("Configured to not listen anywhere, exiting.")
+	}
+	return nil
+}
+
+// 监听 bind 的每个地址。"*" 表示所有 IPv4 地址，"::*" 表示所有 IPv6 地址；
+// 带有 "-" 前缀的地址是可选的，系统不支持或者地址不存在时跳过。
+func (l *connListener) listenTCP() error {
+	for _, addr := range l.bindaddr {
+		optional := strings.HasPrefix(addr, "-")
+		addr = strings.TrimPrefix(addr, "-")
+		network := "tcp"
+		switch addr {
+		case "*":
+			network, addr = "tcp4", "0.0.0.0"
+		case "::*":
+			network, addr = "tcp6", "::"
+		}
+		ln, err := net.Listen(network, net.JoinHostPort(addr, strconv.Itoa(l.port)))
+		if err != nil {
+			if optional && isAddrUnavailable(err) {
+				continue
+			}
+			return fmt.Errorf("Could not create server TCP listening socket %s:%d: %w", addr, l.port, err)
+		}
+		l.listeners = append(l.listeners, ln)
+	}
+	if len(l.listeners) == 0 {
+		return fmt.Errorf("Failed listening on port %d (%s), aborting.", l.port, l.name)
+	}
+	return nil
+}
+
+// 可选的地址绑定失败时可以忽略的错误：系统不支持这类地址或者本机没有这个地址。
+func isAddrUnavailable(err error) bool {
+	return errors.Is(err, syscall.EADDRNOTAVAIL) || errors.Is(err, syscall.EAFNOSUPPORT) ||
+		errors.Is(err, syscall.EPROTONOSUPPORT)
+}
+
+// 监听 Unix 套接字，先删除上次运行留下的套接字文件，perm 不为 0 时修改文件权限。
+func (l *connListener) listenUnix(perm os.FileMode) error {
+	path := l.bindaddr[0]
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("Failed opening Unix socket: %w", err)
+	}
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return fmt.Errorf("Failed opening Unix socket: %w", err)
+	}
+	if perm != 0 {
+		if err := os.Chmod(path, perm); err != nil {
+			ln.Close()
+			return fmt.Errorf("Failed opening Unix socket: %w", err)
+		}
+	}
+	l.listeners = append(l.listeners, ln)
+	return nil
+}
+
+// 关闭监听器的所有套接字。
+func (l *connListener) close() {
+	for _, ln := range l.listeners {
+		ln.Close()
+	}
+}
+
+// 关闭所有监听器。
+func (s *redisServer) closeListeners() {
+	for _, l := range s.listeners {
+		l.close()
+	}
+}
+
+// Accept 出错后重试的最短和最长等待时间，与 net/http 的 Server.Serve 相同
+const (
+	ACCEPT_RETRY_MIN_DELAY = 5 * time.Millisecond
+	ACCEPT_RETRY_MAX_DELAY = time.Second
+)
+
+// 每个套接字一个协程接受连接，交给同一个 accept 循环处理。closeListeners 关闭所有套接字后返回。
+func (s *redisServer) acceptLoop() {
+	accepted := make(chan acceptedConn)
+	var wg sync.WaitGroup
+	for _, l := range s.listeners {
+		for _, ln := range l.listeners {
+			wg.Add(1)
+			go func(l *connListener, ln net.Listener) {
+				defer wg.Done()
+				var delay time.Duration
+				for {
+					conn, err := ln.Accept() // 接受客户端连接
+					if err != nil {
+						if errors.Is(err, net.ErrClosed) {
+							return
+						}
+						// 文件描述符耗尽（EMFILE、ENFILE）等错误通常会持续一段时间，按指数退避重试，避免空转
+						delay = min(max(2*delay, ACCEPT_RETRY_MIN_DELAY), ACCEPT_RETRY_MAX_DELAY)
+						fmt.Printf("Error accepting connection: %v; retrying in %v\n", err, delay)
+						time.Sleep(delay)
+						continue
+					}
+					delay = 0
+					accepted <- acceptedConn{conn: conn, listener: l}
+				}
+			}(l, ln)
+		}
+	}
+	go func() {
+		wg.Wait()
+		close(accepted)
+	}()
+	for a := range accepted {
+		s.acceptClient(a.conn, a.listener)
+	}
+}
+
+// 返回客户端的远端地址和本地地址，Unix 套接字的两端都显示为 "路径:0"。
+func connAddrs(conn net.Conn) (addr, laddr string) {
+	if conn == nil {
+		return "", ""
+	}
+	if _, ok := conn.(*net.UnixConn); ok {
+		path := conn.LocalAddr().String() + ":0"
+		return path, path
+	}
+	return conn.RemoteAddr().String(), conn.LocalAddr().String()
+}
+
+// INFO 中描述监听器的一行，格式与 Redis 相同，例如 name=tcp,bind=127.0.0.1,bind=-::1,port=6379。
+func (l *connListener) info() string {
+	var b strings.Builder
+	b.WriteString("name=" + l.name)
+	for _, addr := range l.bindaddr {
+		b.WriteString(",bind=" + addr)
+	}
+	if l.port != 0 {
+		b.WriteString(",port=" + strconv.Itoa(l.port))
+	}
+	return b.String()
+}
+
+// INFO 中监听器的统计信息。
+func (l *connListener) stats() string {
+	return fmt.Sprintf("name=%s,connected_clients=%d,total_connections_received=%d,rejected_connections=%d",
+		l.name, atomic.LoadInt64(&l.connected), atomic.LoadInt64(&l.accepted), atomic.LoadInt64(&l.rejected))
+}
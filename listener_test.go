@@ -0,0 +1,50 @@
+package main
+
+import (
+	"net"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// 前 failures 次 Accept 返回 EMFILE，之后表现为已关闭的监听器。
+type failingListener struct {
+	net.Listener
+	failures int
+	calls    int
+}
+
+func (l *failingListener) Accept() (net.Conn, error) {
+	l.calls++
+	if l.calls <= l.failures {
+		return nil, &net.OpError{Op: "accept", Net: "tcp", Err: syscall.EMFILE}
+	}
+	return nil, net.ErrClosed
+}
+
+// Accept 出错时按指数退避重试，所有套接字关闭后 acceptLoop 返回。
+func TestAcceptLoopBacksOffAndReturns(t *testing.T) {
+	chdirTemp(t)
+	s := newRedisServer(newRedisConfig())
+	ln := &failingListener{failures: 4}
+	s.listeners = []*connListener{{name: "tcp", listeners: []net.Listener{ln}}}
+
+	start := time.Now()
+	done := make(chan struct{})
+	go func() {
+		s.acceptLoop()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("acceptLoop did not return after the listener was closed")
+	}
+	// 5ms + 10ms + 20ms + 40ms
+	if elapsed := time.Since(start); elapsed < 75*time.Millisecond {
+		t.Errorf("4 failed accepts took %v, want at least 75ms of backoff", elapsed)
+	}
+	if ln.calls != 5 {
+		t.Errorf("Accept called %d times, want 5", ln.calls)
+	}
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"runtime/debug"
+	"strconv"
+	"strings"
+)
+
+// MEMORY USAGE 默认取样的元素数量
+const MEMORY_USAGE_DEFAULT_SAMPLES = 5
+
+// redisMemOverhead 汇总了 MEMORY STATS、MEMORY DOCTOR 和 INFO memory 使用的内存数据。
+// total 等数据集统计来自对象的估算，allocator 和 rss 来自 Go 运行时。
+type redisMemOverhead struct {
+	peakAllocated      int64   // 估算内存的峰值
+	totalAllocated     int64   // 估算的内存总量，即 used_memory
+	overheadTotal      int64   // 哈希表本身等非数据的开销
+	overheadMain       int64   // data 中 map 元素的开销
+	overheadExpires    int64   // expires 占用的内存
+	datasetBytes       int64   // 数据本身占用的内存
+	keys               int64   // 键的数量
+	bytesPerKey        int64   // 平均每个键占用的内存
+	datasetPerc        float64 // 数据占总量的百分比
+	peakPerc           float64 // 当前总量占峰值的百分比
+	allocatorAllocated int64   // 堆上已分配对象占用的内存
+	allocatorActive    int64   // 正在使用的堆内存页
+	allocatorResident  int64   // 从操作系统获取且没有归还的堆内存
+	rss                int64   // 从操作系统获取且没有归还的全部内存
+	fragmentation      float64 // rss 与估算总量之比
+}
+
+// 读取当前的内存数据，同时更新内存峰值。
+func (s *redisServer) getMemoryOverheadData() *redisMemOverhead {
+	stats := s.db.memoryStats()
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+
+	mh := &redisMemOverhead{
+		totalAllocated:     stats.used,
+		overheadMain:       stats.overheadMain,
+		overheadExpires:    stats.overheadExpires,
+		keys:               stats.keys,
+		allocatorAllocated: int64(ms.HeapAlloc),
+		allocatorActive:    int64(ms.HeapInuse),
+		allocatorResident:  int64(ms.HeapSys - ms.HeapReleased),
+		rss:                int64(ms.Sys - ms.HeapReleased),
+	}
+	mh.peakAllocated = s.updatePeakMemory(mh.totalAllocated)
+	mh.overheadTotal = mh.overheadMain + mh.overheadExpires
+	mh.datasetBytes = max(mh.totalAllocated-mh.overheadTotal, 0)
+	if mh.keys > 0 {
+		mh.bytesPerKey = mh.totalAllocated / mh.keys
+	}
+	if mh.totalAllocated > 0 {
+		mh.datasetPerc = float64(mh.datasetBytes) * 100 / float64(mh.totalAllocated)
+		mh.fragmentation = float64(mh.rss) / float64(mh.totalAllocated)
+	}
+	if mh.peakAllocated > 0 {
+		mh.peakPerc = float64(mh.totalAllocated) * 100 / float64(mh.peakAllocated)
+	}
+	return mh
+}
+
+// 用当前估算的内存更新峰值，返回更新后的峰值。
+func (s *redisServer) updatePeakMemory(used int64) int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if used > s.statPeakMemory {
+		s.statPeakMemory = used
+	}
+	return s.statPeakMemory
+}
+
+// 根据内存数据给出诊断报告，没有发现问题时说明一切正常。
+func (s *redisServer) getMemoryDoctorReport() string {
+	mh := s.getMemoryOverheadData()
+	if mh.totalAllocated < 1024*1024*5 {
+		return "Hi Sam, this instance is empty or is using very little memory, my issues detector can't be used in these conditions. Please, leave for your mission on Earth and fill it with some data. The new Sam and I will be back to our programming as soon as I finished rebooting."
+	}
+
+	var issues []string
+	if mh.peakAllocated > 0 && float64(mh.totalAllocated)/float64(mh.peakAllocated) < 0.5 {
+		issues = append(issues, " * Peak memory: In the past this instance used more than 150% the memory that is currently using. The allocator is normally not able to release memory after a peak, so you can expect to see a big fragmentation ratio, however this is actually harmless and is only due to the memory peak, and if the Redis instance Resident Set Size (RSS) is currently bigger than expected, the memory will be used as soon as you fill the Redis instance with more data. If the memory peak was only occasional and you want to try to reclaim memory, please try the MEMORY PURGE command, otherwise the only other option is to shutdown and restart the instance.")
+	}
+	if mh.allocatorAllocated > 0 && float64(mh.allocatorActive)/float64(mh.allocatorAllocated) > 1.1 && mh.allocatorActive-mh.allocatorAllocated > 10*1024*1024 {
+		issues = append(issues, fmt.Sprintf(" * High allocator fragmentation: This instance has an allocator internal fragmentation greater than 1.1 (this means that the heap in use is more than 10%% bigger than the live objects). This is usually caused by freed objects the garbage collector hasn't reclaimed yet, or by many small objects left in otherwise empty spans. You can try running MEMORY PURGE to force a garbage collection. Allocator active/allocated: %d/%d bytes.", mh.allocatorActive, mh.allocatorAllocated))
+	}
+	if mh.allocatorResident > 0 && float64(mh.allocatorResident)/float64(mh.allocatorActive) > 1.1 && mh.allocatorResident-mh.allocatorActive > 10*1024*1024 {
+		issues = append(issues, " * High allocator RSS overhead: This instance has non-active heap memory that is still resident (more than 10% and 10MB). The Go runtime returns free memory to the operating system lazily, so RSS may stay high after keys were deleted. You can try running MEMORY PURGE to release it now.")
+	}
+	if mh.fragmentation > 1.4 && mh.rss-mh.totalAllocated > 10*1024*1024 {
+		issues = append(issues, fmt.Sprintf(" * High total RSS: This instance has a memory fragmentation and RSS overhead greater than 1.4 (this means that the Resident Set Size of the process is more than 40%% bigger than the memory used by the dataset). Part of this is the Go runtime itself and the objects the garbage collector hasn't reclaimed yet. RSS/used memory: %d/%d bytes.", mh.rss, mh.totalAllocated))
+	}
+	if len(issues) == 0 {
+		return "Hi Sam, I can't find any memory issue in your instance. I can only account for what occurs on this base."
+	}
+	return "Sam, I detected a few issues in this Redis instance memory implants:\n\n" +
+		strings.Join(issues, "\n\n") +
+		"\n\nI'm here to keep you safe, Sam. I want to help you."
+}
+
+// 以文本形式返回 Go 运行时的内存分配统计，对应 Redis 中分配器输出的统计信息。
+func getMallocStats() string {
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	var b strings.Builder
+	fmt.Fprintf(&b, "___ Begin Go runtime statistics (%s) ___\n", runtime.Version())
+	fmt.Fprintf(&b, "Allocated: %d, active: %d, resident: %d, mapped: %d, retained: %d\n",
+		ms.HeapAlloc, ms.HeapInuse, ms.HeapSys-ms.HeapReleased, ms.Sys, ms.HeapReleased)
+	fmt.Fprintf(&b, "Heap: idle: %d, objects: %d, mallocs: %d, frees: %d\n",
+		ms.HeapIdle, ms.HeapObjects, ms.Mallocs, ms.Frees)
+	fmt.Fprintf(&b, "Stack: inuse: %d, sys: %d\n", ms.StackInuse, ms.StackSys)
+	fmt.Fprintf(&b, "Metadata: mspan: %d, mcache: %d, gc: %d, other: %d\n",
+		ms.MSpanInuse, ms.MCacheInuse, ms.GCSys, ms.OtherSys)
+	fmt.Fprintf(&b, "GC: cycles: %d, forced: %d, next target: %d, pause total: %dns, cpu fraction: %.6f\n",
+		ms.NumGC, ms.NumForcedGC, ms.NextGC, ms.PauseTotalNs, ms.GCCPUFraction)
+	b.WriteString("bins:           size     nmalloc       nfree\n")
+	for _, bs := range ms.BySize {
+		if bs.Mallocs == 0 {
+			continue
+		}
+		fmt.Fprintf(&b, "              %5d %11d %11d\n", bs.Size, bs.Mallocs, bs.Frees)
+	}
+	b.WriteString("--- End Go runtime statistics ---")
+	return b.String()
+}
+
+// MEMORY <subcommand> [<arg> ...]
+func memoryCommand(c *redisClient, args []string) {
+	if len(args) < 2 {
+		c.writeError("ERR wrong number of arguments for 'MEMORY' command")
+		return
+	}
+	s := c.server
+	switch sub := strings.ToUpper(args[1]); {
+	case sub == "USAGE" && len(args) >= 3:
+		samples := MEMORY_USAGE_DEFAULT_SAMPLES
+		for j := 3; j < len(args); j++ {
+			if strings.EqualFold(args[j], "SAMPLES") && j+1 < len(args) {
+				n, err := strconv.Atoi(args[j+1])
+				if err != nil || n < 0 {
+					c.writeError("ERR value is out of range, must be positive")
+					return
+				}
+				samples = n // 0 表示统计全部元素
+				j++
+			} else {
+				c.writeError("ERR syntax error")
+				return
+			}
+		}
+		usage, ok := s.db.memoryUsage(args[2], samples)
+		if !ok {
+			c.writeNull()
+			return
+		}
+		c.writeInteger(usage)
+	case sub == "STATS" && len(args) == 2:
+		mh := s.getMemoryOverheadData()
+		// 只有 0 号数据库，与 Redis 一样把哈希表开销放在 db.0 下
+		c.writeReply(&MapReply{Value: []Reply{
+			&BulkStringReply{Value: "peak.allocated"}, &IntegerReply{Value: mh.peakAllocated},
+			&BulkStringReply{Value: "total.allocated"}, &IntegerReply{Value: mh.totalAllocated},
+			&BulkStringReply{Value: "overhead.total"}, &IntegerReply{Value: mh.overheadTotal},
+			&BulkStringReply{Value: "keys.count"}, &IntegerReply{Value: mh.keys},
+			&BulkStringReply{Value: "keys.bytes-per-key"}, &IntegerReply{Value: mh.bytesPerKey},
+			&BulkStringReply{Value: "dataset.bytes"}, &IntegerReply{Value: mh.datasetBytes},
+			&BulkStringReply{Value: "dataset.percentage"}, &DoubleReply{Value: mh.datasetPerc},
+			&BulkStringReply{Value: "peak.percentage"}, &DoubleReply{Value: mh.peakPerc},
+			&BulkStringReply{Value: "allocator.allocated"}, &IntegerReply{Value: mh.allocatorAllocated},
+			&BulkStringReply{Value: "allocator.active"}, &IntegerReply{Value: mh.allocatorActive},
+			&BulkStringReply{Value: "allocator.resident"}, &IntegerReply{Value: mh.allocatorResident},
+			&BulkStringReply{Value: "fragmentation"}, &DoubleReply{Value: mh.fragmentation},
+			&BulkStringReply{Value: "fragmentation.bytes"}, &IntegerReply{Value: mh.rss - mh.totalAllocated},
+			&BulkStringReply{Value: "db.0"}, &MapReply{Value: []Reply{
+				&BulkStringReply{Value: "overhead.hashtable.main"}, &IntegerReply{Value: mh.overheadMain},
+				&BulkStringReply{Value: "overhead.hashtable.expires"}, &IntegerReply{Value: mh.overheadExpires},
+			}},
+		}})
+	case sub == "DOCTOR" && len(args) == 2:
+		c.writeReply(&VerbatimStringReply{Format: "txt", Value: s.getMemoryDoctorReport()})
+	case sub == "MALLOC-STATS" && len(args) == 2:
+		c.writeReply(&VerbatimStringReply{Format: "txt", Value: getMallocStats()})
+	case sub == "PURGE" && len(args) == 2:
+		debug.FreeOSMemory()
+		c.writeStatus("OK")
+	case sub == "HELP" && len(args) == 2:
+		c.writeHelp([]string{
+			"MEMORY <subcommand> [<arg> [value] [opt] ...]. Subcommands are:",
+			"DOCTOR",
+			"    Return memory problems reports.",
+			"MALLOC-STATS",
+			"    Return internal statistics report from the memory allocator.",
+			"PURGE",
+			"    Attempt to purge dirty pages for reclamation by the allocator.",
+			"STATS",
+			"    Return information about the memory usage of the server.",
+			"USAGE <key> [SAMPLES <count>]",
+			"    Return memory in bytes used by <key> and its value. Nested values are",
+			"    sampled up to <count> times (default: 5, 0 means sample all).",
+			"HELP",
+			"    Print this help.",
+		})
+	default:
+		c.writeError(fmt.Sprintf("ERR unknown subcommand or wrong number of arguments for '%s'. Try MEMORY HELP.", args[1]))
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+// multiCmd 是事务中排队的一条命令。
+type multiCmd struct {
+	cmd  *redisCommand
+	args []string
+}
+
+// 判断命令是否是事务控制命令，这些命令在 MULTI 之后直接执行而不排队。
+func isTransactionCommand(cmd *redisCommand) bool {
+	switch cmd.name {
+	case "MULTI", "EXEC", "DISCARD", "WATCH":
+		return true
+	}
+	return false
+}
+
+// 把命令加入事务队列。
+func (c *redisClient) queueMultiCommand(cmd *redisCommand, args []string) {
+	c.mstate = append(c.mstate, multiCmd{cmd: cmd, args: args})
+}
+
+// 排队时出现错误，标记事务在 EXEC 时失败。
+func (c *redisClient) flagTransaction() {
+	if c.flags&CLIENT_MULTI != 0 {
+		c.flags |= CLIENT_DIRTY_EXEC
+	}
+}
+
+// 清空事务状态并取消所有 WATCH。
+func (c *redisClient) discardTransaction() {
+	c.mstate = nil
+	c.flags &^= CLIENT_MULTI | CLIENT_DIRTY_EXEC
+	c.server.db.unwatchAllKeys(c)
+}
+
+// MULTI
+func multiCommand(c *redisClient, args []string) {
+	if c.flags&CLIENT_MULTI != 0 {
+		c.writeError("ERR MULTI calls can not be nested")
+		return
+	}
+	c.flags |= CLIENT_MULTI
+	c.writeStatus("OK")
+}
+
+// DISCARD
+func discardCommand(c *redisClient, args []string) {
+	if c.flags&CLIENT_MULTI == 0 {
+		c.writeError("ERR DISCARD without MULTI")
+		return
+	}
+	c.discardTransaction()
+	c.writeStatus("OK")
+}
+
+// EXEC：依次执行排队的命令。命令以 CMD_EXCLUSIVE 执行，期间没有其他命令能修改数据。
+// 排队时出现过错误时放弃事务，WATCH 的键被修改或过期时返回 nil。
+func execCommand(c *redisClient, args []string) {
+	if c.flags&CLIENT_MULTI == 0 {
+		c.writeError("ERR EXEC without MULTI")
+		return
+	}
+	if c.flags&CLIENT_DIRTY_EXEC != 0 {
+		c.discardTransaction()
+		c.writeError("EXECABORT Transaction discarded because of previous errors.")
+		return
+	}
+	db := c.server.db
+	if db.watchedKeysDirty(c) {
+		c.discardTransaction()
+		c.writeReply(&NullArrayReply{})
+		return
+	}
+
+	// 先取消 WATCH，事务自己的修改不影响之后的 WATCH
+	mstate := c.mstate
+	c.discardTransaction()
+	// 事务中有写命令时用 MULTI 和 EXEC 包住写入 AOF 的命令，加载时整个事务要么都执行要么都不执行
+	write := false
+	for _, mc := range mstate {
+		if mc.cmd.flags&CMD_WRITE != 0 {
+			write = true
+			break
+		}
+	}
+	if write {
+		db.propagateMulti()
+	}
+	// 每条命令写出自己的回复，合起来是一个数组
+	c.writeArrayLen(len(mstate))
+	for _, mc := range mstate {
+		c.call(mc.cmd, mc.args, c)
+	}
+	if write {
+		db.propagateExec()
+	}
+}
+
+// WATCH key [key ...]
+func watchCommand(c *redisClient, args []string) {
+	if c.flags&CLIENT_MULTI != 0 {
+		c.writeError("ERR WATCH inside MULTI is not allowed")
+		return
+	}
+	c.server.db.watchKeys(c, args[1:])
+	c.writeStatus("OK")
+}
+
+// UNWATCH
+func unwatchCommand(c *redisClient, args []string) {
+	c.server.db.unwatchAllKeys(c)
+	c.writeStatus("OK")
+}
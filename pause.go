@@ -0,0 +1,145 @@
+package main
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CLIENT PAUSE 的暂停类型
+const (
+	PAUSE_NONE  = 0
+	PAUSE_WRITE = 1 // 暂停会修改数据的命令，同时暂停过期键的删除和键的淘汰
+	PAUSE_ALL   = 2 // 暂停所有命令
+)
+
+// 服务器的暂停状态。暂停期间等待的客户端在 unpaused 关闭或到达结束时间时重新检查。
+type pauseState struct {
+	mu       sync.Mutex
+	typ      int           // 暂停类型（PAUSE_*）
+	end      time.Time     // 暂停的结束时间
+	unpaused chan struct{} // CLIENT UNPAUSE 时关闭并换成新的通道
+}
+
+func newPauseState() *pauseState {
+	return &pauseState{unpaused: make(chan struct{})}
+}
+
+// 暂停到 end 为止。已经在暂停时保留更严格的类型和更晚的结束时间。
+func (p *pauseState) pause(typ int, end time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.typ != PAUSE_NONE && time.Now().Before(p.end) {
+		if p.typ > typ {
+			typ = p.typ
+		}
+		if p.end.After(end) {
+			end = p.end
+		}
+	}
+	p.typ, p.end = typ, end
+}
+
+// 结束暂停，唤醒所有等待的客户端。
+func (p *pauseState) unpause() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.typ = PAUSE_NONE
+	close(p.unpaused)
+	p.unpaused = make(chan struct{})
+}
+
+// 返回当前生效的暂停类型和结束时间，以及暂停结束时会被关闭的通道。
+func (p *pauseState) current() (int, time.Time, <-chan struct{}) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.typ != PAUSE_NONE && !time.Now().Before(p.end) {
+		p.typ = PAUSE_NONE
+	}
+	return p.typ, p.end, p.unpaused
+}
+
+// 是否暂停了写入，暂停所有命令时同样暂停写入。
+func (p *pauseState) writePaused() bool {
+	typ, _, _ := p.current()
+	return typ != PAUSE_NONE
+}
+
+// 服务器暂停期间阻塞客户端的命令，直到暂停结束。调用方不能持有执行锁。
+func (c *redisClient) waitPause(cmd *redisCommand, args []string, flags int) {
+	if cmd.name == "CLIENT|UNPAUSE" {
+		return
+	}
+	for {
+		typ, end, unpaused := c.server.pause.current()
+		if typ == PAUSE_NONE || (typ == PAUSE_WRITE && !c.mayWriteCommand(cmd, flags)) {
+			return
+		}
+		timer := time.NewTimer(time.Until(end))
+		select {
+		case <-unpaused:
+		case <-timer.C:
+		}
+		timer.Stop()
+	}
+}
+
+// 判断命令在 CLIENT PAUSE WRITE 期间是否需要等待：会修改数据或者可能产生复制流的命令，
+// EXEC 按事务中排队的命令判断。
+func (c *redisClient) mayWriteCommand(cmd *redisCommand, flags int) bool {
+	if flags&CMD_WRITE != 0 {
+		return true
+	}
+	switch cmd.name {
+	case "EVAL", "EVALSHA", "FCALL", "PUBLISH", "SPUBLISH":
+		return true
+	case "EXEC":
+		for _, mc := range c.mstate {
+			if c.mayWriteCommand(mc.cmd, mc.cmd.commandFlags(c.server, mc.args)) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// CLIENT PAUSE timeout [WRITE|ALL]
+func clientPauseCommand(c *redisClient, args []string) {
+	ms, err := strconv.ParseInt(args[2], 10, 64)
+	if err != nil {
+		c.writeError("ERR timeout is not an integer or out of range")
+		return
+	}
+	if ms < 0 {
+		c.writeError("ERR timeout is negative")
+		return
+	}
+	if ms > math.MaxInt64/int64(time.Millisecond) {
+		c.writeError("ERR timeout is out of range")
+		return
+	}
+	typ := PAUSE_ALL
+	if len(args) == 4 {
+		switch strings.ToUpper(args[3]) {
+		case "WRITE":
+			typ = PAUSE_WRITE
+		case "ALL":
+		default:
+			c.writeError("ERR syntax error")
+			return
+		}
+	} else if len(args) > 4 {
+		c.writeError("ERR syntax error")
+		return
+	}
+	c.server.pause.pause(typ, time.Now().Add(time.Duration(ms)*time.Millisecond))
+	c.writeStatus("OK")
+}
+
+// CLIENT UNPAUSE
+func clientUnpauseCommand(c *redisClient, args []string) {
+	c.server.pause.unpause()
+	c.writeStatus("OK")
+}
@@ -0,0 +1,374 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// pubsubState 保存服务器上所有频道、模式和分片频道的订阅关系。
+// 客户端自己的订阅集合同样由 mu 保护。
+type pubsubState struct {
+	mu            sync.RWMutex
+	channels      map[string]map[*redisClient]struct{} // 频道 → 订阅它的客户端
+	patterns      map[string]map[*redisClient]struct{} // 模式 → 订阅它的客户端
+	shardChannels map[string]map[*redisClient]struct{} // 分片频道 → 订阅它的客户端
+}
+
+func newPubsubState() *pubsubState {
+	return &pubsubState{
+		channels:      make(map[string]map[*redisClient]struct{}),
+		patterns:      make(map[string]map[*redisClient]struct{}),
+		shardChannels: make(map[string]map[*redisClient]struct{}),
+	}
+}
+
+// pubsubKind 描述一类订阅：服务器上的订阅表、客户端自己的订阅集合以及回复中使用的名称。
+type pubsubKind struct {
+	server      func(ps *pubsubState) map[string]map[*redisClient]struct{}
+	client      func(c *redisClient) *map[string]struct{}
+	subscribe   string
+	unsubscribe string
+}
+
+var (
+	pubsubChannelKind = &pubsubKind{
+		server:      func(ps *pubsubState) map[string]map[*redisClient]struct{} { return ps.channels },
+		client:      func(c *redisClient) *map[string]struct{} { return &c.pubsubChannels },
+		subscribe:   "subscribe",
+		unsubscribe: "unsubscribe",
+	}
+	pubsubPatternKind = &pubsubKind{
+		server:      func(ps *pubsubState) map[string]map[*redisClient]struct{} { return ps.patterns },
+		client:      func(c *redisClient) *map[string]struct{} { return &c.pubsubPatterns },
+		subscribe:   "psubscribe",
+		unsubscribe: "punsubscribe",
+	}
+	pubsubShardKind = &pubsubKind{
+		server:      func(ps *pubsubState) map[string]map[*redisClient]struct{} { return ps.shardChannels },
+		client:      func(c *redisClient) *map[string]struct{} { return &c.pubsubShardChannels },
+		subscribe:   "ssubscribe",
+		unsubscribe: "sunsubscribe",
+	}
+)
+
+// 客户端订阅的数量：分片频道单独计数，频道和模式合并计数，与 Redis 的回复一致。调用方需持有 ps.mu。
+func (c *redisClient) subscriptionCount(kind *pubsubKind) int {
+	if kind == pubsubShardKind {
+		return len(c.pubsubShardChannels)
+	}
+	return len(c.pubsubChannels) + len(c.pubsubPatterns)
+}
+
+// 根据订阅数量更新客户端的订阅状态，调用方需持有 ps.mu。
+func (c *redisClient) updatePubsubFlag() {
+	if len(c.pubsubChannels)+len(c.pubsubPatterns)+len(c.pubsubShardChannels) > 0 {
+		c.flags |= CLIENT_PUBSUB
+	} else {
+		c.flags &^= CLIENT_PUBSUB
+	}
+}
+
+// 订阅回复：[kind, name, count]，name 为空表示没有任何订阅时的退订回复。
+func pubsubReply(kind, name string, count int) Reply {
+	var nameReply Reply = &NullReply{}
+	if name != "" {
+		nameReply = &BulkStringReply{Value: name}
+	}
+	return &PushReply{Value: []Reply{
+		&BulkStringReply{Value: kind}, nameReply, &IntegerReply{Value: int64(count)},
+	}}
+}
+
+// 订阅一组频道、模式或分片频道，并写出每个名称的订阅回复。
+// 回复在持有 ps.mu 时放入输出缓冲区，总是先于这个频道上发布的消息到达客户端。
+func (ps *pubsubState) subscribe(c *redisClient, kind *pubsubKind, names []string) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	subs := kind.client(c)
+	if *subs == nil {
+		*subs = make(map[string]struct{})
+	}
+	table := kind.server(ps)
+	for _, name := range names {
+		if _, ok := (*subs)[name]; !ok {
+			(*subs)[name] = struct{}{}
+			clients := table[name]
+			if clients == nil {
+				clients = make(map[*redisClient]struct{})
+				table[name] = clients
+			}
+			clients[c] = struct{}{}
+		}
+		c.updatePubsubFlag()
+		c.writeReply(pubsubReply(kind.subscribe, name, c.subscriptionCount(kind)))
+	}
+}
+
+// 退订一组频道、模式或分片频道，names 为空时退订这一类的全部订阅，返回每个名称的退订回复。
+func (ps *pubsubState) unsubscribe(c *redisClient, kind *pubsubKind, names []string) []Reply {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	subs := kind.client(c)
+	if len(names) == 0 {
+		for name := range *subs {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		if len(names) == 0 {
+			return []Reply{pubsubReply(kind.unsubscribe, "", c.subscriptionCount(kind))}
+		}
+	}
+	replies := make([]Reply, 0, len(names))
+	table := kind.server(ps)
+	for _, name := range names {
+		if _, ok := (*subs)[name]; ok {
+			delete(*subs, name)
+			delete(table[name], c)
+			if len(table[name]) == 0 {
+				delete(table, name)
+			}
+		}
+		c.updatePubsubFlag()
+		replies = append(replies, pubsubReply(kind.unsubscribe, name, c.subscriptionCount(kind)))
+	}
+	return replies
+}
+
+// 客户端断开连接时取消它的全部订阅。
+func (ps *pubsubState) unsubscribeAll(c *redisClient) {
+	for _, kind := range []*pubsubKind{pubsubChannelKind, pubsubPatternKind, pubsubShardKind} {
+		ps.unsubscribe(c, kind, nil)
+	}
+}
+
+// 依次写出一组回复。
+func (c *redisClient) writeReplies(replies []Reply) {
+	for _, r := range replies {
+		c.writeReply(r)
+	}
+}
+
+// 向频道发布消息，返回收到消息的客户端数量。shard 为 true 时发布到分片频道，只有 SSUBSCRIBE 的客户端会收到。
+// 消息只是放入各个客户端的输出缓冲区，由客户端的写出协程写出，慢速的订阅者不会阻塞发布者。
+// 键空间通知会在持有数据库锁时调用这里，所以不能再获取 s.mu。
+func (s *redisServer) publish(channel, message string, shard bool) int {
+	ps := s.pubsub
+	ps.mu.RLock()
+	defer ps.mu.RUnlock()
+	receivers := 0
+	if shard {
+		msg := &PushReply{Value: []Reply{
+			&BulkStringReply{Value: "smessage"}, &BulkStringReply{Value: channel}, &BulkStringReply{Value: message},
+		}}
+		for c := range ps.shardChannels[channel] {
+			c.addReply(msg, CLIENT_TYPE_PUBSUB)
+			receivers++
+		}
+		return receivers
+	}
+	if clients := ps.channels[channel]; len(clients) > 0 {
+		msg := &PushReply{Value: []Reply{
+			&BulkStringReply{Value: "message"}, &BulkStringReply{Value: channel}, &BulkStringReply{Value: message},
+		}}
+		for c := range clients {
+			c.addReply(msg, CLIENT_TYPE_PUBSUB)
+			receivers++
+		}
+	}
+	for pattern, clients := range ps.patterns {
+		if !stringMatch(pattern, channel, false) {
+			continue
+		}
+		msg := &PushReply{Value: []Reply{
+			&BulkStringReply{Value: "pmessage"}, &BulkStringReply{Value: pattern},
+			&BulkStringReply{Value: channel}, &BulkStringReply{Value: message},
+		}}
+		for c := range clients {
+			c.addReply(msg, CLIENT_TYPE_PUBSUB)
+			receivers++
+		}
+	}
+	return receivers
+}
+
+// 判断命令能否在订阅状态下执行。
+func isPubsubAllowedCommand(cmd *redisCommand) bool {
+	switch cmd.name {
+	case "SUBSCRIBE", "PSUBSCRIBE", "SSUBSCRIBE", "UNSUBSCRIBE", "PUNSUBSCRIBE", "SUNSUBSCRIBE", "PING", "QUIT", "RESET":
+		return true
+	}
+	return false
+}
+
+// SUBSCRIBE channel [channel ...]
+func subscribeCommand(c *redisClient, args []string) {
+	c.server.pubsub.subscribe(c, pubsubChannelKind, args[1:])
+}
+
+// UNSUBSCRIBE [channel [channel ...]]
+func unsubscribeCommand(c *redisClient, args []string) {
+	c.writeReplies(c.server.pubsub.unsubscribe(c, pubsubChannelKind, args[1:]))
+}
+
+// PSUBSCRIBE pattern [pattern ...]
+func psubscribeCommand(c *redisClient, args []string) {
+	c.server.pubsub.subscribe(c, pubsubPatternKind, args[1:])
+}
+
+// PUNSUBSCRIBE [pattern [pattern ...]]
+func punsubscribeCommand(c *redisClient, args []string) {
+	c.writeReplies(c.server.pubsub.unsubscribe(c, pubsubPatternKind, args[1:]))
+}
+
+// SSUBSCRIBE shardchannel [shardchannel ...]
+func ssubscribeCommand(c *redisClient, args []string) {
+	c.server.pubsub.subscribe(c, pubsubShardKind, args[1:])
+}
+
+// SUNSUBSCRIBE [shardchannel [shardchannel ...]]
+func sunsubscribeCommand(c *redisClient, args []string) {
+	c.writeReplies(c.server.pubsub.unsubscribe(c, pubsubShardKind, args[1:]))
+}
+
+// PUBLISH channel message
+func publishCommand(c *redisClient, args []string) {
+	c.writeReply(&IntegerReply{Value: int64(c.server.publish(args[1], args[2], false))})
+}
+
+// SPUBLISH shardchannel message
+func spublishCommand(c *redisClient, args []string) {
+	c.writeReply(&IntegerReply{Value: int64(c.server.publish(args[1], args[2], true))})
+}
+
+// PUBSUB 的子命令不存在时执行。
+func pubsubCommand(c *redisClient, args []string) {
+	c.writeError(fmt.Sprintf("ERR unknown subcommand or wrong number of arguments for '%s'. Try PUBSUB HELP.", args[1]))
+}
+
+// 返回表中匹配 pattern 的名称，pattern 为空时返回全部。
+func (ps *pubsubState) matchingNames(table map[string]map[*redisClient]struct{}, pattern string) Reply {
+	ps.mu.RLock()
+	names := make([]string, 0, len(table))
+	for name := range table {
+		if pattern == "" || stringMatch(pattern, name, false) {
+			names = append(names, name)
+		}
+	}
+	ps.mu.RUnlock()
+	sort.Strings(names)
+	items := make([]Reply, len(names))
+	for i, name := range names {
+		items[i] = &BulkStringReply{Value: name}
+	}
+	return &ArrayReply{Value: items}
+}
+
+// 返回每个名称及其订阅者数量。
+func (ps *pubsubState) subscriberCounts(table map[string]map[*redisClient]struct{}, names []string) Reply {
+	ps.mu.RLock()
+	defer ps.mu.RUnlock()
+	items := make([]Reply, 0, len(names)*2)
+	for _, name := range names {
+		items = append(items, &BulkStringReply{Value: name}, &IntegerReply{Value: int64(len(table[name]))})
+	}
+	return &ArrayReply{Value: items}
+}
+
+// PUBSUB CHANNELS [pattern]
+func pubsubChannelsCommand(c *redisClient, args []string) {
+	if len(args) > 3 {
+		pubsubCommand(c, args)
+		return
+	}
+	ps := c.server.pubsub
+	pattern := ""
+	if len(args) == 3 {
+		pattern = args[2]
+	}
+	c.writeReply(ps.matchingNames(ps.channels, pattern))
+}
+
+// PUBSUB SHARDCHANNELS [pattern]
+func pubsubShardChannelsCommand(c *redisClient, args []string) {
+	if len(args) > 3 {
+		pubsubCommand(c, args)
+		return
+	}
+	ps := c.server.pubsub
+	pattern := ""
+	if len(args) == 3 {
+		pattern = args[2]
+	}
+	c.writeReply(ps.matchingNames(ps.shardChannels, pattern))
+}
+
+// PUBSUB NUMSUB [channel [channel ...]]
+func pubsubNumsubCommand(c *redisClient, args []string) {
+	ps := c.server.pubsub
+	c.writeReply(ps.subscriberCounts(ps.channels, args[2:]))
+}
+
+// PUBSUB SHARDNUMSUB [shardchannel [shardchannel ...]]
+func pubsubShardNumsubCommand(c *redisClient, args []string) {
+	ps := c.server.pubsub
+	c.writeReply(ps.subscriberCounts(ps.shardChannels, args[2:]))
+}
+
+// PUBSUB NUMPAT：被订阅的不同模式的数量。
+func pubsubNumpatCommand(c *redisClient, args []string) {
+	ps := c.server.pubsub
+	ps.mu.RLock()
+	n := len(ps.patterns)
+	ps.mu.RUnlock()
+	c.writeReply(&IntegerReply{Value: int64(n)})
+}
+
+// PUBSUB HELP
+func pubsubHelpCommand(c *redisClient, args []string) {
+	c.writeHelp([]string{
+		"PUBSUB <subcommand> [<arg> [value] [opt] ...]. Subcommands are:",
+		"CHANNELS [<pattern>]",
+		"    Return the currently active channels matching a <pattern> (default: '*').",
+		"NUMPAT",
+		"    Return number of subscriptions to patterns.",
+		"NUMSUB [<channel> ...]",
+		"    Return the number of subscribers for the specified channels, excluding",
+		"    pattern subscriptions(default: no channels).",
+		"SHARDCHANNELS [<pattern>]",
+		"    Return the currently active shard level channels matching a <pattern> (default: '*').",
+		"SHARDNUMSUB [<shardchannel> ...]",
+		"    Return the number of subscribers for the specified shard level channel(s)",
+		"HELP",
+		"    Print this help.",
+	})
+}
+
+// PING [message]：RESP2 的订阅状态下回复 [pong, message]。
+func pingCommand(c *redisClient, args []string) {
+	if c.flags&CLIENT_PUBSUB != 0 && c.resp == 2 {
+		message := ""
+		if len(args) == 2 {
+			message = args[1]
+		}
+		c.writeReply(&ArrayReply{Value: []Reply{&BulkStringReply{Value: "pong"}, &BulkStringReply{Value: message}}})
+		return
+	}
+	if len(args) == 2 {
+		c.writeBulk(args[1])
+		return
+	}
+	c.writeStatus("PONG")
+}
+
+// QUIT：回复 OK 后关闭连接。
+func quitCommand(c *redisClient, args []string) {
+	c.writeStatus("OK")
+	c.flags |= CLIENT_CLOSE_AFTER_REPLY
+}
+
+// 订阅状态下执行其他命令时的错误。
+func pubsubCommandNotAllowed(name string) string {
+	return fmt.Sprintf("ERR Can't execute '%s': only (P|S)SUBSCRIBE / (P|S)UNSUBSCRIBE / PING / QUIT / RESET are allowed in this context", strings.ToLower(name))
+}
@@ -0,0 +1,368 @@
+package main
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"time"
+	"unicode/utf8"
+)
+
+// rdbRecord 是导出和导入时一个键的 JSON 表示，每行一个。
+// 键或值中含有非 UTF-8 数据时，记录中的所有字符串都以 base64 编码，encoding 为 "base64"。
+type rdbRecord struct {
+	DB       int             `json:"db"`
+	Key      string          `json:"key"`
+	Type     string          `json:"type"`
+	TTL      int64           `json:"ttl"`            // 剩余生存时间（毫秒），-1 表示永不过期
+	Size     int64           `json:"size,omitempty"` // 在 RDB 文件中占用的字节数，导入时忽略
+	Encoding string          `json:"encoding,omitempty"`
+	Value    json.RawMessage `json:"value"`
+}
+
+var errRecordValue = errors.New("value doesn't match the type")
+
+// 对对象中的每个字符串应用 f，返回一个新的对象。
+func mapObjectStrings(o *robj, f func(string) (string, error)) (*robj, error) {
+	var err error
+	conv := func(s string) string {
+		if err != nil {
+			return ""
+		}
+		var r string
+		r, err = f(s)
+		return r
+	}
+	var ptr interface{}
+	switch v := o.ptr.(type) {
+	case string:
+		ptr = conv(v)
+	case []string:
+		items := make([]string, len(v))
+		for i, item := range v {
+			items[i] = conv(item)
+		}
+		ptr = items
+	case map[string]float64:
+		members := make(map[string]float64, len(v))
+		for member, score := range v {
+			members[conv(member)] = score
+		}
+		ptr = members
+	case map[string]string:
+		fields := make(map[string]string, len(v))
+		for field, value := range v {
+			fields[conv(field)] = conv(value)
+		}
+		ptr = fields
+	default:
+		return nil, fmt.Errorf("%w: object type %d", errRDBUnsupported, o.rtype)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return createObject(o.rtype, ptr), nil
+}
+
+// 判断键和对象中的字符串是否都是合法的 UTF-8。
+func objectIsUTF8(key string, o *robj) bool {
+	ok := utf8.ValidString(key)
+	mapObjectStrings(o, func(s string) (string, error) {
+		ok = ok && utf8.ValidString(s)
+		return s, nil
+	})
+	return ok
+}
+
+// 把对象的值转换为 JSON：字符串、列表和集合为字符串或数组，哈希为对象，
+// 有序集合为成员到分值的对象，无穷大的分值写作 "inf" 和 "-inf"。
+func objectToJSON(o *robj) (json.RawMessage, error) {
+	if o.rtype != OBJ_ZSET {
+		return json.Marshal(o.ptr)
+	}
+	members := make(map[string]interface{}, len(o.ptr.(map[string]float64)))
+	for member, score := range o.ptr.(map[string]float64) {
+		switch {
+		case math.IsInf(score, 1):
+			members[member] = "inf"
+		case math.IsInf(score, -1):
+			members[member] = "-inf"
+		default:
+			members[member] = score
+		}
+	}
+	return json.Marshal(members)
+}
+
+// 按类型名解析 JSON 值，返回对应的对象。
+func objectFromJSON(typ string, value json.RawMessage) (*robj, error) {
+	switch typ {
+	case "string":
+		var s string
+		if err := json.Unmarshal(value, &s); err != nil {
+			return nil, errRecordValue
+		}
+		return createObject(OBJ_STRING, s), nil
+	case "list", "set":
+		var items []string
+		if err := json.Unmarshal(value, &items); err != nil {
+			return nil, errRecordValue
+		}
+		if typ == "list" {
+			return createObject(OBJ_LIST, items), nil
+		}
+		return createObject(OBJ_SET, items), nil
+	case "zset":
+		var raw map[string]interface{}
+		if err := json.Unmarshal(value, &raw); err != nil {
+			return nil, errRecordValue
+		}
+		members := make(map[string]float64, len(raw))
+		for member, v := range raw {
+			switch score := v.(type) {
+			case float64:
+				members[member] = score
+			case string:
+				f, err := strconv.ParseFloat(score, 64)
+				if err != nil {
+					return nil, errRecordValue
+				}
+				members[member] = f
+			default:
+				return nil, errRecordValue
+			}
+		}
+		return createObject(OBJ_ZSET, members), nil
+	case "hash":
+		var fields map[string]string
+		if err := json.Unmarshal(value, &fields); err != nil {
+			return nil, errRecordValue
+		}
+		return createObject(OBJ_HASH, fields), nil
+	}
+	return nil, fmt.Errorf("unknown type '%s'", typ)
+}
+
+// 把 RDB 中的一个键转换为导出记录；二进制数据以 base64 编码。
+func newRDBRecord(dbid int, entry *rdbEntry, size int64, now int64) (*rdbRecord, error) {
+	rec := &rdbRecord{DB: dbid, Key: entry.key, Type: objTypeName(entry.obj.rtype), TTL: -1, Size: size}
+	if entry.expireAt != -1 {
+		rec.TTL = entry.expireAt - now
+	}
+	obj := entry.obj
+	if !objectIsUTF8(entry.key, obj) {
+		rec.Encoding = "base64"
+		rec.Key = base64.StdEncoding.EncodeToString([]byte(entry.key))
+		obj, _ = mapObjectStrings(obj, func(s string) (string, error) {
+			return base64.StdEncoding.EncodeToString([]byte(s)), nil
+		})
+	}
+	var err error
+	rec.Value, err = objectToJSON(obj)
+	return rec, err
+}
+
+// 把导入记录转换为键名、对象和过期时间（毫秒时间戳，-1 表示永不过期）。
+func (rec *rdbRecord) object(now int64) (string, *robj, int64, error) {
+	obj, err := objectFromJSON(rec.Type, rec.Value)
+	if err != nil {
+		return "", nil, 0, err
+	}
+	key := rec.Key
+	switch rec.Encoding {
+	case "":
+	case "base64":
+		decode := func(s string) (string, error) {
+			b, err := base64.StdEncoding.DecodeString(s)
+			return string(b), err
+		}
+		if key, err = decode(key); err != nil {
+			return "", nil, 0, err
+		}
+		if obj, err = mapObjectStrings(obj, decode); err != nil {
+			return "", nil, 0, err
+		}
+	default:
+		return "", nil, 0, fmt.Errorf("unknown encoding '%s'", rec.Encoding)
+	}
+	expireAt := int64(-1)
+	if rec.TTL >= 0 {
+		expireAt = now + rec.TTL
+	}
+	return key, obj, expireAt, nil
+}
+
+// 逐个读取 RDB 文件中的键交给 fn，已经过期的键被跳过。
+func rdbForEachKey(filename string, fn func(dbid int, entry *rdbEntry, size int64) error) error {
+	file, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	dec := newRDBDecoder(file)
+	if _, err := dec.readHeader(); err != nil {
+		return err
+	}
+	now := time.Now().UnixMilli()
+	dbid := 0
+	for {
+		entry, err := dec.next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if entry.opcode == RDB_OPCODE_SELECTDB {
+			dbid = entry.dbid
+		}
+		if !entry.isKey() || (entry.expireAt != -1 && entry.expireAt < now) {
+			continue
+		}
+		if err := fn(dbid, entry, dec.offset-entry.offset); err != nil {
+			return err
+		}
+	}
+}
+
+// 把 RDB 文件导出为每行一个 JSON 对象。
+func rdbExportJSON(filename string, out io.Writer) error {
+	w := bufio.NewWriter(out)
+	enc := json.NewEncoder(w)
+	now := time.Now().UnixMilli()
+	err := rdbForEachKey(filename, func(dbid int, entry *rdbEntry, size int64) error {
+		rec, err := newRDBRecord(dbid, entry, size, now)
+		if err != nil {
+			return err
+		}
+		return enc.Encode(rec)
+	})
+	if ferr := w.Flush(); err == nil {
+		err = ferr
+	}
+	return err
+}
+
+// 把 RDB 文件导出为 CSV，列为 db,key,type,ttl,size,value；字符串以外的值写成 JSON。
+func rdbExportCSV(filename string, out io.Writer) error {
+	w := csv.NewWriter(out)
+	w.Write([]string{"db", "key", "type", "ttl", "size", "value"})
+	now := time.Now().UnixMilli()
+	err := rdbForEachKey(filename, func(dbid int, entry *rdbEntry, size int64) error {
+		ttl := int64(-1)
+		if entry.expireAt != -1 {
+			ttl = entry.expireAt - now
+		}
+		var value string
+		if entry.obj.rtype == OBJ_STRING {
+			value = entry.obj.ptr.(string)
+		} else {
+			raw, err := objectToJSON(entry.obj)
+			if err != nil {
+				return err
+			}
+			value = string(raw)
+		}
+		return w.Write([]string{strconv.Itoa(dbid), entry.key, objTypeName(entry.obj.rtype),
+			strconv.FormatInt(ttl, 10), strconv.FormatInt(size, 10), value})
+	})
+	w.Flush()
+	if err == nil {
+		err = w.Error()
+	}
+	return err
+}
+
+// 从每行一个 JSON 对象的输入生成 RDB 文件，写入临时文件后原子地替换 filename。
+func rdbImportJSON(in io.Reader, filename string) (int, error) {
+	count := 0
+	tmpName := fmt.Sprintf("temp-import-%d.rdb", os.Getpid())
+	err := atomicWriteFile(filename, tmpName, func(w io.Writer) error {
+		enc := newRDBEncoder(w)
+		enc.writeHeader()
+		rdbSaveInfoAuxFields(enc, false)
+		now := time.Now().UnixMilli()
+		dbid := -1
+		dec := json.NewDecoder(bufio.NewReader(in))
+		for {
+			var rec rdbRecord
+			err := dec.Decode(&rec)
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return fmt.Errorf("record %d: %w", count+1, err)
+			}
+			key, obj, expireAt, err := rec.object(now)
+			if err != nil {
+				return fmt.Errorf("record %d (key %q): %w", count+1, rec.Key, err)
+			}
+			if rec.DB != dbid {
+				enc.writeSelectDB(rec.DB)
+				dbid = rec.DB
+			}
+			if err := enc.writeObject(key, obj, expireAt); err != nil {
+				return fmt.Errorf("record %d (key %q): %w", count+1, rec.Key, err)
+			}
+			count++
+		}
+		return enc.writeEOF()
+	})
+	return count, err
+}
+
+// rdb-export [--format json|csv] <rdb-file>：把 RDB 文件导出到标准输出。
+func rdbExportMain(argv0 string, args []string) int {
+	format := "json"
+	if len(args) == 3 && args[0] == "--format" {
+		format, args = args[1], args[2:]
+	}
+	if len(args) != 1 || (format != "json" && format != "csv") {
+		fmt.Fprintf(os.Stderr, "Usage: %s [--format json|csv] <rdb-file>\n", argv0)
+		return 1
+	}
+	var err error
+	if format == "json" {
+		err = rdbExportJSON(args[0], os.Stdout)
+	} else {
+		err = rdbExportCSV(args[0], os.Stdout)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error exporting %s: %v\n", args[0], err)
+		return 1
+	}
+	return 0
+}
+
+// rdb-import <json-file|-> <rdb-file>：从 JSON 记录生成 RDB 文件。
+func rdbImportMain(argv0 string, args []string) int {
+	if len(args) != 2 {
+		fmt.Fprintf(os.Stderr, "Usage: %s <json-file|-> <rdb-file>\n", argv0)
+		return 1
+	}
+	in := os.Stdin
+	if args[0] != "-" {
+		file, err := os.Open(args[0])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Cannot open %s: %v\n", args[0], err)
+			return 1
+		}
+		defer file.Close()
+		in = file
+	}
+	count, err := rdbImportJSON(in, args[1])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error importing into %s: %v\n", args[1], err)
+		return 1
+	}
+	fmt.Printf("Imported %d keys into %s\n", count, args[1])
+	return 0
+}
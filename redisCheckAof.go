@@ -0,0 +1,210 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// aofCheckResult 记录检查一个 AOF 文件的结果。
+type aofCheckResult struct {
+	size     int64            // 文件大小
+	okUpTo   int64            // 最后一条完整命令的结束位置，修复时截断到这里
+	err      error            // 第一个错误，文件完好时为 nil
+	rdbErr   bool             // 错误出现在 RDB 前导中，无法通过截断修复
+	commands map[string]int64 // 每种命令的数量
+}
+
+// 检查单个 AOF 文件：先检查可能存在的 RDB 前导，再逐条读取命令。
+// MULTI 之后直到文件末尾都没有 EXEC 时，同样视为不完整，截断位置退回到 MULTI 之前。
+func aofCheckFile(filename string, out io.Writer) (*aofCheckResult, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	res := &aofCheckResult{size: info.Size(), commands: make(map[string]int64)}
+	br := bufio.NewReader(file)
+	reader := newAOFReader(br)
+	if sig, _ := br.Peek(5); string(sig) == "REDIS" {
+		fmt.Fprintln(out, "The AOF appears to start with an RDB preamble.\nChecking the RDB preamble to start:")
+		dec := newRDBDecoder(br)
+		stats, err := rdbCheck(dec, out)
+		if err != nil {
+			rdbCheckPrintError(out, err)
+			res.err, res.rdbErr = err, true
+			return res, nil
+		}
+		stats.print(out)
+		fmt.Fprintln(out, "RDB preamble is OK, proceeding with AOF tail...")
+		reader.offset = dec.offset
+	}
+
+	multiStart := int64(-1)
+	for {
+		start := reader.offset
+		args, err := reader.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			res.okUpTo, res.err = start, err
+			if err == io.ErrUnexpectedEOF {
+				res.err = fmt.Errorf("unexpected EOF at offset %d", start)
+			}
+			break
+		}
+		name := strings.ToUpper(args[0])
+		res.commands[name]++
+		switch name {
+		case "MULTI":
+			if multiStart != -1 {
+				res.okUpTo, res.err = start, fmt.Errorf("unexpected MULTI at offset %d", start)
+				return res, nil
+			}
+			multiStart = start
+		case "EXEC":
+			if multiStart == -1 {
+				res.okUpTo, res.err = start, fmt.Errorf("unexpected EXEC at offset %d", start)
+				return res, nil
+			}
+			multiStart = -1
+		}
+		res.okUpTo = reader.offset
+	}
+	if multiStart != -1 {
+		res.okUpTo = multiStart
+		if res.err == nil {
+			res.err = fmt.Errorf("reached EOF before reading EXEC for MULTI at offset %d", multiStart)
+		}
+	}
+	return res, nil
+}
+
+// 输出每种命令的数量。
+func (res *aofCheckResult) printStats(out io.Writer) {
+	names := make([]string, 0, len(res.commands))
+	var total int64
+	for name, n := range res.commands {
+		names = append(names, name)
+		total += n
+	}
+	sort.Strings(names)
+	fmt.Fprintf(out, "[info] %d commands\n", total)
+	for _, name := range names {
+		fmt.Fprintf(out, "[info] %s: %d\n", name, res.commands[name])
+	}
+}
+
+// 检查一个 AOF 文件并在需要时修复，只有最后一个文件允许截断修复。文件最终可用时返回 true。
+func redisCheckAofFile(filename string, fix, last bool, in *bufio.Reader, out io.Writer) bool {
+	res, err := aofCheckFile(filename, out)
+	if err != nil {
+		fmt.Fprintf(out, "Cannot open file %s: %v\n", filename, err)
+		return false
+	}
+	res.printStats(out)
+	if res.err == nil {
+		fmt.Fprintf(out, "AOF %s is valid\n", filename)
+		return true
+	}
+
+	if res.rdbErr {
+		fmt.Fprintln(out, "RDB preamble of AOF file is not sane, aborting.")
+		return false
+	}
+	fmt.Fprintf(out, "%v\n", res.err)
+	fmt.Fprintf(out, "AOF analyzed: filename=%s, size=%d, ok_up_to=%d, diff=%d\n",
+		filename, res.size, res.okUpTo, res.size-res.okUpTo)
+	if !last {
+		fmt.Fprintf(out, "AOF %s is not the last file and can't be fixed by truncating it\n", filename)
+		return false
+	}
+	if !fix {
+		fmt.Fprintf(out, "AOF %s is not valid. Use the --fix option to try fixing it.\n", filename)
+		return false
+	}
+
+	fmt.Fprintf(out, "This will shrink the AOF %s from %d bytes, with %d bytes, to %d bytes\n",
+		filename, res.size, res.size-res.okUpTo, res.okUpTo)
+	fmt.Fprint(out, "Continue? [y/N]: ")
+	answer, _ := in.ReadString('\n')
+	if !strings.HasPrefix(strings.ToLower(strings.TrimSpace(answer)), "y") {
+		fmt.Fprintln(out, "Aborting...")
+		return false
+	}
+	if err := os.Truncate(filename, res.okUpTo); err != nil {
+		fmt.Fprintf(out, "Failed to truncate AOF %s: %v\n", filename, err)
+		return false
+	}
+	fmt.Fprintf(out, "Successfully truncated AOF %s\n", filename)
+	return true
+}
+
+// 检查多文件 AOF：按清单依次检查基础文件和增量文件。
+func redisCheckMultiPartAof(manifestPath string, fix bool, in *bufio.Reader, out io.Writer) bool {
+	dir := filepath.Dir(manifestPath)
+	file, err := os.Open(manifestPath)
+	if err != nil {
+		fmt.Fprintf(out, "Cannot open file %s: %v\n", manifestPath, err)
+		return false
+	}
+	m, err := parseAOFManifest(file)
+	file.Close()
+	if err != nil {
+		fmt.Fprintf(out, "Invalid AOF manifest file format: %v\n", err)
+		return false
+	}
+
+	fmt.Fprintln(out, "Start checking Multi Part AOF")
+	if m.base != nil {
+		fmt.Fprintf(out, "Start to check BASE AOF %s\n", m.base.fileName)
+		if !redisCheckAofFile(filepath.Join(dir, m.base.fileName), fix, len(m.incrList) == 0, in, out) {
+			return false
+		}
+	}
+	if len(m.incrList) > 0 {
+		fmt.Fprintln(out, "Start to check INCR files.")
+	}
+	for i, ai := range m.incrList {
+		if !redisCheckAofFile(filepath.Join(dir, ai.fileName), fix, i == len(m.incrList)-1, in, out) {
+			return false
+		}
+	}
+	fmt.Fprintln(out, "All AOF files and manifest are valid")
+	return true
+}
+
+// check-aof [--fix] <file.manifest|file.aof>：离线检查 AOF，文件完好（或修复成功）时返回 0，否则返回 1。
+func redisCheckAofMain(argv0 string, args []string) int {
+	fix := false
+	if len(args) == 2 && args[0] == "--fix" {
+		fix = true
+		args = args[1:]
+	}
+	if len(args) != 1 {
+		fmt.Fprintf(os.Stderr, "Usage: %s [--fix] <file.manifest|file.aof>\n", argv0)
+		return 1
+	}
+
+	in := bufio.NewReader(os.Stdin)
+	var ok bool
+	if strings.HasSuffix(args[0], MANIFEST_NAME_SUFFIX) {
+		ok = redisCheckMultiPartAof(args[0], fix, in, os.Stdout)
+	} else {
+		ok = redisCheckAofFile(args[0], fix, true, in, os.Stdout)
+	}
+	if !ok {
+		return 1
+	}
+	return 0
+}
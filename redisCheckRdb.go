@@ -0,0 +1,139 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"time"
+)
+
+// rdbCheckStats 记录检查 RDB 时的统计信息。
+type rdbCheckStats struct {
+	keys           int64
+	expires        int64
+	alreadyExpired int64
+	types          map[uint8]*rdbTypeStats
+}
+
+// 某一种对象类型的键数量和在文件中占用的字节数。
+type rdbTypeStats struct {
+	keys  int64
+	bytes int64
+}
+
+// 按顺序读取 dec 中的全部记录并校验格式和校验和，把过程和统计信息写到 out。
+// 返回时 dec.offset 指向 RDB 数据末尾，可以继续读取之后的内容（AOF 的 RDB 前导）。
+// 解码器遇到没有预料到的损坏而 panic 时，同样报告为出错的偏移量和键。
+func rdbCheck(dec *rdbDecoder, out io.Writer) (stats *rdbCheckStats, err error) {
+	stats = &rdbCheckStats{types: make(map[uint8]*rdbTypeStats)}
+	defer func() {
+		if r := recover(); r != nil {
+			err = dec.fail(fmt.Errorf("%w: %v", errRDBInvalidFormat, r))
+		}
+	}()
+	version, err := dec.readHeader()
+	if err != nil {
+		return stats, err
+	}
+	fmt.Fprintf(out, "[offset %d] RDB version %d\n", dec.offset, version)
+	now := time.Now().UnixMilli()
+	for {
+		entry, err := dec.next()
+		if err == io.EOF {
+			fmt.Fprintf(out, "[offset %d] Checksum OK\n", dec.offset)
+			return stats, nil
+		}
+		if err != nil {
+			return stats, err
+		}
+		switch {
+		case entry.opcode == RDB_OPCODE_AUX:
+			fmt.Fprintf(out, "[offset %d] AUX FIELD %s = '%s'\n", entry.offset, entry.key, entry.value)
+		case entry.opcode == RDB_OPCODE_SELECTDB:
+			fmt.Fprintf(out, "[offset %d] Selecting DB ID %d\n", entry.offset, entry.dbid)
+		case entry.opcode == RDB_OPCODE_FUNCTION2:
+			fmt.Fprintf(out, "[offset %d] Function library (%d bytes)\n", entry.offset, len(entry.value))
+		case entry.isKey():
+			stats.keys++
+			if entry.expireAt != -1 {
+				stats.expires++
+				if entry.expireAt < now {
+					stats.alreadyExpired++
+				}
+			}
+			ts := stats.types[entry.obj.rtype]
+			if ts == nil {
+				ts = &rdbTypeStats{}
+				stats.types[entry.obj.rtype] = ts
+			}
+			ts.keys++
+			ts.bytes += dec.offset - entry.offset
+		}
+	}
+}
+
+// 输出统计信息。
+func (stats *rdbCheckStats) print(out io.Writer) {
+	fmt.Fprintf(out, "[info] %d keys read\n", stats.keys)
+	fmt.Fprintf(out, "[info] %d expires\n", stats.expires)
+	fmt.Fprintf(out, "[info] %d already expired\n", stats.alreadyExpired)
+	types := make([]uint8, 0, len(stats.types))
+	for t := range stats.types {
+		types = append(types, t)
+	}
+	sort.Slice(types, func(i, j int) bool { return types[i] < types[j] })
+	for _, t := range types {
+		ts := stats.types[t]
+		fmt.Fprintf(out, "[info] %s: %d keys, %d bytes\n", objTypeName(t), ts.keys, ts.bytes)
+	}
+}
+
+// 输出检查失败的位置：出错的偏移量以及正在读取的键。
+func rdbCheckPrintError(out io.Writer, err error) {
+	fmt.Fprintln(out, "--- RDB ERROR DETECTED ---")
+	var re *rdbError
+	if errors.As(err, &re) {
+		fmt.Fprintf(out, "[offset %d] %v\n", re.offset, re.err)
+		if re.key != "" {
+			fmt.Fprintf(out, "[additional info] Reading key '%s'\n", re.key)
+		}
+	} else {
+		fmt.Fprintf(out, "%v\n", err)
+	}
+}
+
+// 检查一个 RDB 文件，文件完好时返回 nil。
+func redisCheckRdb(filename string, out io.Writer) error {
+	file, err := os.Open(filename)
+	if err != nil {
+		fmt.Fprintf(out, "Cannot check RDB file %s: %v\n", filename, err)
+		return err
+	}
+	defer file.Close()
+
+	fmt.Fprintf(out, "[offset 0] Checking RDB file %s\n", filename)
+	stats, err := rdbCheck(newRDBDecoder(file), out)
+	if err != nil {
+		rdbCheckPrintError(out, err)
+		stats.print(out)
+		fmt.Fprintln(out, "--- RDB ERROR DETECTED ---")
+		return err
+	}
+	fmt.Fprintln(out, "\\o/ RDB looks OK! \\o/")
+	stats.print(out)
+	return nil
+}
+
+// check-rdb <rdb-file-name>：离线检查 RDB 文件，文件完好时返回 0，否则返回 1。
+func redisCheckRdbMain(argv0 string, args []string) int {
+	if len(args) != 1 {
+		fmt.Fprintf(os.Stderr, "Usage: %s <rdb-file-name>\n", argv0)
+		return 1
+	}
+	if redisCheckRdb(args[0], os.Stdout) != nil {
+		return 1
+	}
+	return 0
+}
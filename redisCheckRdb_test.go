@@ -0,0 +1,38 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// 损坏的文件报告出错的偏移量和键，而不是 panic。
+func TestRedisCheckRdbCorruptFile(t *testing.T) {
+	var buf bytes.Buffer
+	enc := newRDBEncoder(&buf)
+	enc.writeHeader()
+	enc.writeSelectDB(0)
+	enc.writeByte(RDB_TYPE_STRING)
+	enc.writeString("k")
+	enc.writeByte(RDB_ENCVAL<<6 | RDB_ENC_LZF)
+	enc.writeLen(3)
+	enc.writeLen(1 << 62)
+	enc.write([]byte{0, 'a', 'b'})
+	enc.writeEOF()
+	filename := filepath.Join(t.TempDir(), "dump.rdb")
+	if err := os.WriteFile(filename, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var out strings.Builder
+	if redisCheckRdb(filename, &out) == nil {
+		t.Fatalf("corrupt file passed the check:\n%s", out.String())
+	}
+	for _, want := range []string{"--- RDB ERROR DETECTED ---", "[offset 25]", "Reading key 'k'"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("output does not contain %q:\n%s", want, out.String())
+		}
+	}
+}
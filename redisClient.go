@@ -1,64 +1,787 @@
 package main
 
 import (
-	"bufio"
+	"crypto/tls"
 	"fmt"
 	"net"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
 )
 
 // 表示一个 Redis 客户端连接，包含网络连接和服务器引用。
 type redisClient struct {
+	id     int64 // 客户端 ID，从 1 开始递增，不会重复使用
 	conn   net.Conn // 客户端的网络连接
 	server *redisServer // 服务器引用
+
+	flags       int          // 客户端状态标志（CLIENT_*）
+	mstate      []multiCmd   // MULTI 之后排队等待 EXEC 的命令
+	watchedKeys []watchedKey // WATCH 的键，由 db.mu 保护
+	dirtyCAS    bool         // WATCH 的键是否被修改过，由 db.mu 保护
+	replies     []Reply      // 脚本客户端收到的回复，由 redis.call 读取
+	resp        int          // 客户端使用的协议版本，2 或 3，由 HELLO 切换，切换时持有 tracking.mu 和 outMu
+	name        string       // HELLO SETNAME 或 CLIENT SETNAME 设置的客户端名称
+	libName     string       // CLIENT SETINFO 设置的客户端库名称
+	libVer      string       // CLIENT SETINFO 设置的客户端库版本
+
+	// 订阅的频道、模式和分片频道，由 pubsub.mu 保护
+	pubsubChannels      map[string]struct{}
+	pubsubPatterns      map[string]struct{}
+	pubsubShardChannels map[string]struct{}
+
+	// 输出缓冲区，由 outMu 保护。命令的回复、发布的消息和失效消息都先放入这里，再由写出协程写到连接上
+	outMu                    sync.Mutex
+	outBuf                   []byte        // 等待写出的数据
+	outSpare                 []byte        // 上一次写出用过的缓冲区，留给下一次使用
+	outWriting               int64         // 正在写出的字节数，写完之前仍然计入输出缓冲区
+	obufSoftLimitReachedTime time.Time     // 开始持续超过软上限的时间
+	outClosed                bool          // 连接已经关闭或者超过了上限，不再接收数据
+	outNotify                chan struct{} // 有新的数据等待写出
+
+	// 客户端缓存的跟踪状态，只由客户端自己的协程修改，修改时持有 tracking.mu
+	trackingFlags       int                 // 跟踪模式（CLIENT_TRACKING*）
+	trackingRedirect    int64               // 失效消息发往的客户端 ID，0 表示没有重定向
+	trackingRedirBroken bool                // 重定向的客户端已经断开，由其他客户端的协程设置
+	trackingCaching     bool                // CLIENT CACHING yes/no 之后的下一条命令
+	trackingPrefixes    map[string]struct{} // 广播模式关注的前缀
+
+	readErr error // 读取请求的协程退出的原因，关闭 requests 之后才由连接的协程读取
+
+	ctime    time.Time     // 连接建立的时间
+	fd       int           // 连接的文件描述符，无法获取时为 -1
+	addr     string        // 客户端的地址
+	laddr    string        // 客户端连接的本地地址
+	listener *connListener // 接受连接的监听器，伪客户端为 nil
+	infoMu   sync.Mutex    // 保护 info
+	info     clientInfo    // 供其他协程读取的客户端状态
+}
+
+// 客户端状态的快照，由客户端自己的协程在执行命令前后更新，
+// CLIENT LIST 和空闲超时检查在其他协程中读取它，而不是直接读取客户端的字段。
+type clientInfo struct {
+	lastInteraction time.Time // 最近一次开始或结束执行命令的时间
+	executing       bool      // 正在执行命令（包括等待执行锁）
+	lastCmd         string    // 最近执行的命令，小写，子命令形如 client|list
+	name            string
+	libName         string
+	libVer          string
+	flags           int
+	trackingFlags   int
+	redirect        int64
+	resp            int
+	multi           int // 事务中排队的命令数量，不在事务中时为 -1
+	watch           int
+	sub, psub, ssub int
 }
 
+// 客户端状态标志
+const (
+	CLIENT_MULTI             = 1 << 0  // 处于 MULTI 上下文中
+	CLIENT_DIRTY_EXEC        = 1 << 1  // 排队时出现错误，EXEC 会失败
+	CLIENT_SCRIPT            = 1 << 2  // 执行脚本中 redis.call 的伪客户端
+	CLIENT_PUBSUB            = 1 << 3  // 订阅了频道或模式，只能执行订阅相关的命令
+	CLIENT_CLOSE_AFTER_REPLY = 1 << 4  // 回复当前命令后关闭连接（QUIT）
+	CLIENT_NO_EVICT          = 1 << 5  // CLIENT NO-EVICT on
+	CLIENT_NO_TOUCH          = 1 << 6  // 命令不更新键的访问时间和访问频率（CLIENT NO-TOUCH on）
+	CLIENT_REPLY_OFF         = 1 << 7  // 不发送回复（CLIENT REPLY OFF）
+	CLIENT_REPLY_SKIP_NEXT   = 1 << 8  // 不发送下一条命令的回复（CLIENT REPLY SKIP）
+	CLIENT_REPLY_SKIP        = 1 << 9  // 不发送当前命令的回复
+	CLIENT_UNIX_SOCKET       = 1 << 10 // 通过 Unix 套接字连接
+)
+
 // 创建一个新的 Redis 客户端实例。
 func newRedisClient(conn net.Conn, server *redisServer) *redisClient {
-	return &redisClient{
-		conn:   conn,
-		server: server,
+	now := time.Now()
+	c := &redisClient{
+		id:        atomic.AddInt64(&server.nextClientID, 1),
+		conn:      conn,
+		resp:      2,
+		server:    server,
+		outNotify: make(chan struct{}, 1),
+		ctime:     now,
+		fd:        connFd(conn),
+	}
+	c.addr, c.laddr = connAddrs(conn)
+	c.info = clientInfo{lastInteraction: now, resp: 2, multi: -1}
+	return c
+}
+
+// 返回连接的文件描述符，没有连接或者无法获取时返回 -1。
+func connFd(conn net.Conn) int {
+	if tc, ok := conn.(*tls.Conn); ok {
+		conn = tc.NetConn()
+	}
+	sc, ok := conn.(syscall.Conn)
+	if !ok {
+		return -1
+	}
+	raw, err := sc.SyscallConn()
+	if err != nil {
+		return -1
+	}
+	fd := -1
+	raw.Control(func(f uintptr) { fd = int(f) })
+	return fd
+}
+
+// 更新供其他协程读取的客户端状态，cmd 为开始执行的命令，命令执行结束时为 nil。
+func (c *redisClient) updateInfo(cmd *redisCommand) {
+	c.infoMu.Lock()
+	defer c.infoMu.Unlock()
+	info := &c.info
+	info.lastInteraction = time.Now()
+	info.executing = cmd != nil
+	if cmd != nil {
+		info.lastCmd = strings.ToLower(cmd.name)
+	}
+	info.name, info.libName, info.libVer = c.name, c.libName, c.libVer
+	info.flags = c.flags
+	info.trackingFlags = c.trackingFlags
+	info.redirect = c.trackingRedirect
+	info.resp = c.resp
+	info.multi = -1
+	if c.flags&CLIENT_MULTI != 0 {
+		info.multi = len(c.mstate)
 	}
+	info.watch = len(c.watchedKeys)
+	info.sub, info.psub, info.ssub = len(c.pubsubChannels), len(c.pubsubPatterns), len(c.pubsubShardChannels)
 }
 
-// 处理客户端请求，读取并解析命令。
+// 处理客户端请求，读取并解析命令。请求在单独的协程中读取，回复由写出协程写到连接上，
+// 连接的协程执行命令时不会因为读写连接而阻塞。
 func (c *redisClient) handleRequest() {
 	defer c.conn.Close() // 确保连接在处理完请求后关闭
+	defer c.server.db.unwatchAllKeys(c)
+	defer c.server.pubsub.unsubscribeAll(c)
+	defer c.server.tracking.disable(c)
+	defer c.server.unlinkClient(c)
 
-	reader := bufio.NewReader(c.conn) // 使用 bufio 包来高效读取客户端请求
+	// 关闭连接之前写出剩余的回复，例如 QUIT 的回复
+	stop, written := make(chan struct{}), make(chan struct{})
+	go c.writeLoop(stop, written)
+	defer func() {
+		close(stop)
+		<-written
+		c.outMu.Lock()
+		c.outClosed = true
+		c.outBuf = nil
+		c.outMu.Unlock()
+	}()
+
+	requests := make(chan []string)
+	done := make(chan struct{})
+	defer close(done)
+	go c.readRequests(requests, done)
+	for request := range requests {
+		c.processCommand(request) // 处理客户端的命令
+		if c.flags&CLIENT_CLOSE_AFTER_REPLY != 0 {
+			return
+		}
+	}
+	// 与 Redis 一样回复协议错误后关闭连接
+	if perr, isProtoErr := c.readErr.(*ProtocolError); isProtoErr {
+		c.writeError("ERR " + perr.Error())
+	}
+}
+
+// 读取客户端请求交给连接的协程处理，连接关闭或出错时关闭 requests，出错的原因保存在 readErr。
+func (c *redisClient) readRequests(requests chan<- []string, done <-chan struct{}) {
+	defer close(requests)
+	reader := NewReader(c.conn)
 	for {
-		request, err := reader.ReadString('\n') // 读取客户端请求行，直到遇到换行符
+		reader.Limits.MaxBulkLen = atomic.LoadInt64(&c.server.protoMaxBulkLen)
+		args, err := reader.ReadCommand()
 		if err != nil {
 			fmt.Println("Error reading from client:", err)
+			c.readErr = err
+			return
+		}
+		// 参数指向读缓冲区，复制之后交给连接的协程
+		select {
+		case requests <- ArgsToStrings(args):
+		case <-done:
 			return
 		}
-		request = strings.TrimSpace(request) // 清除请求的换行符
-
-		c.processCommand(request) // 处理客户端的命令
 	}
 }
 
 // 处理客户端发送的命令。
-func (c *redisClient) processCommand(command string) {
-	args := strings.Fields(command) // 拆分命令和参数
+func (c *redisClient) processCommand(args []string) {
 	if len(args) == 0 {
 		return // 如果没有命令，则直接返回
 	}
-	cmd := strings.ToUpper(args[0]) // 获取命令名称（如 SET、GET 等）
+	cmdDef := lookupCommandArgs(args) // 根据命令名称（如 SET、GET 等）查找命令定义
+	c.updateInfo(cmdDef)
+	defer c.updateInfo(nil)
+	// CLIENT REPLY SKIP 跳过的是它之后那条命令的回复
+	if c.flags&CLIENT_REPLY_SKIP_NEXT != 0 {
+		c.flags = c.flags&^CLIENT_REPLY_SKIP_NEXT | CLIENT_REPLY_SKIP
+	}
+	defer func() { c.flags &^= CLIENT_REPLY_SKIP }()
+	if cmdDef == nil {
+		// 对于未识别的命令，返回错误响应
+		c.flagTransaction()
+		c.writeError("ERR unknown command '" + strings.ToUpper(args[0]) + "'")
+		return
+	}
+	if (cmdDef.arity > 0 && len(args) != cmdDef.arity) || len(args) < -cmdDef.arity {
+		c.flagTransaction()
+		c.writeError(fmt.Sprintf("ERR wrong number of arguments for '%s' command", cmdDef.name))
+		return
+	}
+
+	// RESP2 的订阅状态下只能执行订阅相关的命令，RESP3 的推送消息与回复可以区分，没有这个限制
+	if c.flags&CLIENT_PUBSUB != 0 && c.resp == 2 && !isPubsubAllowedCommand(cmdDef) {
+		c.writeError(pubsubCommandNotAllowed(cmdDef.name))
+		return
+	}
+	flags := cmdDef.commandFlags(c.server, args)
+
+	// 脚本执行超时后只接受 SCRIPT KILL 等少数命令，这些命令不持有执行锁
+	if flags&CMD_ALLOW_BUSY == 0 && c.server.lua.timedOut() {
+		c.flagTransaction()
+		c.writeError(c.server.lua.busyError())
+		return
+	}
+	// 分批执行的命令自己获取执行锁，在 EXEC 中执行时会与 EXEC 持有的锁死锁
+	if c.flags&CLIENT_MULTI != 0 && flags&CMD_NO_MULTI != 0 {
+		c.flagTransaction()
+		c.writeError("ERR Command not allowed inside a transaction")
+		return
+	}
+	// CLIENT PAUSE 期间在获取执行锁之前等待，不阻塞正在执行的命令和 CLIENT UNPAUSE
+	c.waitPause(cmdDef, args, flags)
+	// EXEC 和脚本独占执行，其他命令之间可以并发
+	switch {
+	case flags&(CMD_ALLOW_BUSY|CMD_BATCHED) != 0:
+	case flags&CMD_EXCLUSIVE != 0:
+		c.server.db.lockExclusive()
+		defer c.server.db.unlockExclusive()
+	default:
+		c.server.db.lockShared()
+		defer c.server.db.unlockShared()
+	}
+	// 设置了 maxmemory 时先尝试淘汰，仍然超过上限则拒绝可能增加内存的命令
+	if !c.server.performEvictions() && flags&CMD_DENYOOM != 0 {
+		c.flagTransaction()
+		c.writeError("OOM command not allowed when used memory > 'maxmemory'.")
+		return
+	}
+	// 事务中除了事务控制命令以外都放入队列，等到 EXEC 时再执行
+	if c.flags&CLIENT_MULTI != 0 && !isTransactionCommand(cmdDef) {
+		c.queueMultiCommand(cmdDef, args)
+		c.writeStatus("QUEUED")
+		return
+	}
+	c.call(cmdDef, args, c) // 执行命令处理函数
+	// CLIENT CACHING 只对下一条命令生效，事务中对整个事务生效
+	if cmdDef.name != "CLIENT|CACHING" && c.flags&CLIENT_MULTI == 0 {
+		c.trackingCaching = false
+	}
+}
+
+// 执行一条命令，之后按命令读取和修改的键维护客户端缓存的失效表。
+// caller 是发出命令的客户端，脚本中的命令由伪客户端执行，caller 为执行脚本的客户端。
+func (c *redisClient) call(cmd *redisCommand, args []string, caller *redisClient) {
+	cmd.handler(c, args)
+	c.server.trackingAfterCommand(caller, cmd, args)
+}
 
-	// 遍历命令定义，找到匹配的命令并执行
-	for _, cmdDef := range commands {
-		if cmd == cmdDef.name {
-			cmdDef.handler(c, args) // 执行命令处理函数
+// 向客户端发送回复，RESP2 客户端收到的 RESP3 类型先转换为 RESP2 的类型。
+// 脚本的伪客户端保存回复供 redis.call 读取，AOF 加载使用的伪客户端没有网络连接，回复直接丢弃。
+func (c *redisClient) writeReply(r Reply) {
+	// CLIENT REPLY OFF 和 SKIP 不影响推送消息
+	if c.flags&(CLIENT_REPLY_OFF|CLIENT_REPLY_SKIP) != 0 {
+		if _, push := r.(*PushReply); !push {
 			return
 		}
 	}
-	// 对于未识别的命令，返回错误响应
-	c.writeResponse("ERR unknown command '" + cmd + "'")
+	if c.conn == nil {
+		if c.flags&CLIENT_SCRIPT == 0 {
+			return
+		}
+		if c.resp < 3 {
+			if r = ToRESP2(r); r == nil {
+				return
+			}
+		}
+		c.replies = append(c.replies, r)
+		return
+	}
+	c.addReply(r, c.outputBufferClass())
+}
+
+// 发送状态回复，例如 OK。
+func (c *redisClient) writeStatus(status string) {
+	c.writeReply(&SimpleStringReply{Value: status})
+}
+
+// 发送错误回复，msg 以错误码开头，例如 ERR。
+func (c *redisClient) writeError(msg string) {
+	c.writeReply(&ErrorReply{Value: msg})
+}
+
+// 发送批量字符串回复。
+func (c *redisClient) writeBulk(s string) {
+	c.writeReply(&BulkStringReply{Value: s})
+}
+
+// 发送整数回复。
+func (c *redisClient) writeInteger(n int64) {
+	c.writeReply(&IntegerReply{Value: n})
+}
+
+// 发送空值回复，RESP2 中为空的批量字符串。
+func (c *redisClient) writeNull() {
+	c.writeReply(&NullReply{})
+}
+
+// 发送数组回复的头部，之后的 n 个回复是数组的元素，用于 EXEC 这样由其他命令写出元素的回复。
+func (c *redisClient) writeArrayLen(n int) {
+	if c.conn != nil && c.flags&(CLIENT_REPLY_OFF|CLIENT_REPLY_SKIP) == 0 {
+		c.addReplyArrayLen(n, c.outputBufferClass())
+	}
 }
 
-// 向客户端发送响应。
-func (c *redisClient) writeResponse(response string) {
-	// 将响应写入客户端连接
-	c.conn.Write([]byte(response + "\r\n"))
-}
\ No newline at end of file
+// 按行发送 HELP 的帮助信息，每一行是一个状态回复。
+func (c *redisClient) writeHelp(lines []string) {
+	items := make([]Reply, len(lines))
+	for i, line := range lines {
+		items[i] = &SimpleStringReply{Value: line}
+	}
+	c.writeReply(&ArrayReply{Value: items})
+}
+
+// HELLO [protover [AUTH username password] [SETNAME clientname]]：切换协议版本并回复服务器信息。
+// 服务器没有设置密码，AUTH 只接受 default 用户。
+func helloCommand(c *redisClient, args []string) {
+	ver := int64(0)
+	if len(args) >= 2 {
+		var err error
+		ver, err = strconv.ParseInt(args[1], 10, 64)
+		if err != nil {
+			c.writeError("ERR Protocol version is not an integer or out of range")
+			return
+		}
+	}
+	var user, name string
+	setname := false
+	for i := 2; i < len(args); i++ {
+		more := len(args) - i - 1
+		switch opt := strings.ToUpper(args[i]); {
+		case opt == "AUTH" && more >= 2:
+			user = args[i+1]
+			i += 2
+		case opt == "SETNAME" && more >= 1:
+			name, setname = args[i+1], true
+			i++
+		default:
+			c.writeError(fmt.Sprintf("ERR Syntax error in HELLO option '%s'", args[i]))
+			return
+		}
+	}
+	if len(args) >= 2 && (ver < 2 || ver > 3) {
+		c.writeError("NOPROTO unsupported protocol version")
+		return
+	}
+	if user != "" && user != "default" {
+		c.writeError("WRONGPASS invalid username-password pair or user is disabled.")
+		return
+	}
+	if setname {
+		if !validateClientName(name) {
+			c.writeError("ERR Client names cannot contain spaces, newlines or special characters.")
+			return
+		}
+		c.name = name
+	}
+	if ver != 0 {
+		t := c.server.tracking
+		t.mu.Lock()
+		c.outMu.Lock() // 发布消息的协程按 c.resp 编码
+		c.resp = int(ver)
+		c.outMu.Unlock()
+		t.mu.Unlock()
+	}
+	c.writeReply(&MapReply{Value: []Reply{
+		&BulkStringReply{Value: "server"}, &BulkStringReply{Value: "redis"},
+		&BulkStringReply{Value: "version"}, &BulkStringReply{Value: REDIS_VERSION},
+		&BulkStringReply{Value: "proto"}, &IntegerReply{Value: int64(c.resp)},
+		&BulkStringReply{Value: "id"}, &IntegerReply{Value: c.id},
+		&BulkStringReply{Value: "mode"}, &BulkStringReply{Value: "standalone"},
+		&BulkStringReply{Value: "role"}, &BulkStringReply{Value: "master"},
+		&BulkStringReply{Value: "modules"}, &ArrayReply{Value: []Reply{}},
+	}})
+}
+
+// 客户端名称不能包含空格、换行等特殊字符。
+func validateClientName(name string) bool {
+	for i := 0; i < len(name); i++ {
+		if name[i] < '!' || name[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// CLIENT <subcommand>：子命令不存在时的错误。
+func clientCommand(c *redisClient, args []string) {
+	c.writeError(fmt.Sprintf("ERR unknown subcommand or wrong number of arguments for '%s'. Try CLIENT HELP.", args[1]))
+}
+
+// CLIENT ID
+func clientIdCommand(c *redisClient, args []string) {
+	c.writeReply(&IntegerReply{Value: c.id})
+}
+
+// 返回查找键时使用的选项（LOOKUP_*）。
+func (c *redisClient) lookupFlags() int {
+	if c.flags&CLIENT_NO_TOUCH != 0 {
+		return LOOKUP_NOTOUCH
+	}
+	return LOOKUP_NONE
+}
+
+// 返回客户端的类别（CLIENT_TYPE_*），在其他协程中调用时读取的是快照。
+func (c *redisClient) clientType() int {
+	c.infoMu.Lock()
+	defer c.infoMu.Unlock()
+	if c.info.flags&CLIENT_PUBSUB != 0 {
+		return CLIENT_TYPE_PUBSUB
+	}
+	return CLIENT_TYPE_NORMAL
+}
+
+// CLIENT LIST [TYPE normal|master|replica|pubsub] [ID client-id [client-id ...]]
+func clientListCommand(c *redisClient, args []string) {
+	typ := -1
+	var ids map[int64]bool
+	switch {
+	case len(args) == 4 && strings.EqualFold(args[2], "TYPE"):
+		if typ = getClientTypeByName(args[3]); typ == -1 {
+			c.writeError(fmt.Sprintf("ERR Unknown client type '%s'", args[3]))
+			return
+		}
+	case len(args) > 3 && strings.EqualFold(args[2], "ID"):
+		ids = make(map[int64]bool)
+		for _, arg := range args[3:] {
+			id, err := strconv.ParseInt(arg, 10, 64)
+			if err != nil || id <= 0 {
+				c.writeError("ERR Invalid client ID")
+				return
+			}
+			ids[id] = true
+		}
+	case len(args) != 2:
+		c.writeError("ERR syntax error")
+		return
+	}
+	var b strings.Builder
+	for _, client := range c.server.clientList() {
+		if (typ != -1 && client.clientType() != typ) || (ids != nil && !ids[client.id]) {
+			continue
+		}
+		b.WriteString(client.infoString())
+		b.WriteString("\n")
+	}
+	c.writeReply(&VerbatimStringReply{Format: "txt", Value: b.String()})
+}
+
+// CLIENT INFO
+func clientInfoCommand(c *redisClient, args []string) {
+	c.writeReply(&VerbatimStringReply{Format: "txt", Value: c.infoString() + "\n"})
+}
+
+// CLIENT SETNAME connection-name
+func clientSetnameCommand(c *redisClient, args []string) {
+	if !validateClientName(args[2]) {
+		c.writeError("ERR Client names cannot contain spaces, newlines or special characters.")
+		return
+	}
+	c.name = args[2]
+	c.writeStatus("OK")
+}
+
+// CLIENT GETNAME
+func clientGetnameCommand(c *redisClient, args []string) {
+	if c.name == "" {
+		c.writeNull()
+		return
+	}
+	c.writeBulk(c.name)
+}
+
+// CLIENT SETINFO LIB-NAME libname | LIB-VER libver
+func clientSetinfoCommand(c *redisClient, args []string) {
+	attr, value := strings.ToLower(args[2]), args[3]
+	if attr != "lib-name" && attr != "lib-ver" {
+		c.writeError(fmt.Sprintf("ERR Unrecognized option '%s'", args[2]))
+		return
+	}
+	if !validateClientName(value) {
+		c.writeError(fmt.Sprintf("ERR %s cannot contain spaces, newlines or special characters.", attr))
+		return
+	}
+	if attr == "lib-name" {
+		c.libName = value
+	} else {
+		c.libVer = value
+	}
+	c.writeStatus("OK")
+}
+
+// CLIENT KILL ip:port
+// CLIENT KILL [ID client-id] [TYPE type] [USER username] [ADDR ip:port] [LADDR ip:port] [SKIPME yes|no] [MAXAGE seconds]
+func clientKillCommand(c *redisClient, args []string) {
+	var (
+		id     int64
+		typ    = -1
+		addr   string
+		laddr  string
+		maxage int64
+		skipme = true
+	)
+	if len(args) == 3 {
+		// 旧的形式只按地址匹配，不跳过自己，找不到时返回错误
+		addr, skipme = args[2], false
+	} else if len(args)%2 != 0 {
+		c.writeError("ERR syntax error")
+		return
+	} else {
+		for i := 2; i < len(args); i += 2 {
+			value := args[i+1]
+			switch strings.ToUpper(args[i]) {
+			case "ID":
+				n, err := strconv.ParseInt(value, 10, 64)
+				if err != nil || n <= 0 {
+					c.writeError("ERR client-id should be greater than 0")
+					return
+				}
+				id = n
+			case "TYPE":
+				if typ = getClientTypeByName(value); typ == -1 {
+					c.writeError(fmt.Sprintf("ERR Unknown client type '%s'", value))
+					return
+				}
+			case "ADDR":
+				addr = value
+			case "LADDR":
+				laddr = value
+			case "USER":
+				// 只有 default 用户，所有客户端都以它的身份连接
+				if value != "default" {
+					c.writeError(fmt.Sprintf("ERR No such user '%s'", value))
+					return
+				}
+			case "SKIPME":
+				switch strings.ToLower(value) {
+				case "yes":
+					skipme = true
+				case "no":
+					skipme = false
+				default:
+					c.writeError("ERR syntax error")
+					return
+				}
+			case "MAXAGE":
+				n, err := strconv.ParseInt(value, 10, 64)
+				if err != nil {
+					c.writeError("ERR value is not an integer or out of range")
+					return
+				}
+				maxage = n
+			default:
+				c.writeError("ERR syntax error")
+				return
+			}
+		}
+	}
+
+	killed := 0
+	now := time.Now()
+	for _, client := range c.server.clientList() {
+		switch {
+		case id != 0 && client.id != id,
+			typ != -1 && client.clientType() != typ,
+			addr != "" && client.addr != addr,
+			laddr != "" && client.laddr != laddr,
+			maxage > 0 && int64(now.Sub(client.ctime).Seconds()) < maxage,
+			skipme && client == c:
+			continue
+		}
+		if client == c {
+			c.flags |= CLIENT_CLOSE_AFTER_REPLY // 先回复再关闭自己的连接
+		} else {
+			client.conn.Close() // 读取请求的协程随之出错退出，连接的协程负责清理
+		}
+		killed++
+	}
+	if len(args) == 3 {
+		if killed == 0 {
+			c.writeError("ERR No such client")
+		} else {
+			c.writeStatus("OK")
+		}
+		return
+	}
+	c.writeInteger(int64(killed))
+}
+
+// CLIENT NO-EVICT on|off
+func clientNoEvictCommand(c *redisClient, args []string) {
+	clientSetFlagCommand(c, args[2], CLIENT_NO_EVICT)
+}
+
+// CLIENT NO-TOUCH on|off
+func clientNoTouchCommand(c *redisClient, args []string) {
+	clientSetFlagCommand(c, args[2], CLIENT_NO_TOUCH)
+}
+
+// 按 on|off 打开或关闭客户端的一个标志。
+func clientSetFlagCommand(c *redisClient, value string, flag int) {
+	switch strings.ToLower(value) {
+	case "on":
+		c.flags |= flag
+	case "off":
+		c.flags &^= flag
+	default:
+		c.writeError("ERR syntax error")
+		return
+	}
+	c.writeStatus("OK")
+}
+
+// CLIENT REPLY ON|OFF|SKIP
+func clientReplyCommand(c *redisClient, args []string) {
+	switch strings.ToLower(args[2]) {
+	case "on":
+		c.flags &^= CLIENT_REPLY_OFF | CLIENT_REPLY_SKIP_NEXT
+		c.writeStatus("OK")
+	case "off":
+		c.flags |= CLIENT_REPLY_OFF
+	case "skip":
+		if c.flags&CLIENT_REPLY_OFF == 0 {
+			c.flags |= CLIENT_REPLY_SKIP_NEXT
+		}
+	default:
+		c.writeError("ERR syntax error")
+	}
+}
+
+// 生成 CLIENT LIST 中描述一个客户端的一行。
+func (c *redisClient) infoString() string {
+	c.infoMu.Lock()
+	info := c.info
+	c.infoMu.Unlock()
+
+	var flags strings.Builder
+	if info.flags&CLIENT_MULTI != 0 {
+		flags.WriteByte('x')
+	}
+	if info.flags&CLIENT_PUBSUB != 0 {
+		flags.WriteByte('P')
+	}
+	if info.trackingFlags&CLIENT_TRACKING != 0 {
+		flags.WriteByte('t')
+	}
+	if info.flags&CLIENT_CLOSE_AFTER_REPLY != 0 {
+		flags.WriteByte('c')
+	}
+	if info.flags&CLIENT_NO_EVICT != 0 {
+		flags.WriteByte('e')
+	}
+	if info.flags&CLIENT_NO_TOUCH != 0 {
+		flags.WriteByte('T')
+	}
+	if info.flags&CLIENT_UNIX_SOCKET != 0 {
+		flags.WriteByte('U')
+	}
+	if flags.Len() == 0 {
+		flags.WriteByte('N')
+	}
+	redirect := int64(-1)
+	if info.trackingFlags&CLIENT_TRACKING != 0 {
+		redirect = info.redirect
+	}
+	cmd := info.lastCmd
+	if cmd == "" {
+		cmd = "NULL"
+	}
+	c.outMu.Lock()
+	omem := c.outputBufferSize()
+	c.outMu.Unlock()
+	now := time.Now()
+	return fmt.Sprintf("id=%d addr=%s laddr=%s fd=%d name=%s age=%d idle=%d flags=%s db=0 "+
+		"sub=%d psub=%d ssub=%d multi=%d watch=%d omem=%d cmd=%s user=default redir=%d resp=%d lib-name=%s lib-ver=%s",
+		c.id, c.addr, c.laddr, c.fd, info.name,
+		int64(now.Sub(c.ctime).Seconds()), int64(now.Sub(info.lastInteraction).Seconds()), flags.String(),
+		info.sub, info.psub, info.ssub, info.multi, info.watch, omem, cmd, redirect, info.resp, info.libName, info.libVer)
+}
+
+// CLIENT HELP
+func clientHelpCommand(c *redisClient, args []string) {
+	c.writeHelp([]string{
+		"CLIENT <subcommand> [<arg> [value] [opt] ...]. Subcommands are:",
+		"CACHING (YES|NO)",
+		"    Enable/disable tracking of the keys for next command in OPTIN/OPTOUT modes.",
+		"GETREDIR",
+		"    Return the client ID we are redirecting to when tracking is enabled.",
+		"GETNAME",
+		"    Return the name of the current connection.",
+		"ID",
+		"    Return the ID of the current connection.",
+		"INFO",
+		"    Return information about the current client connection.",
+		"KILL <ip:port>",
+		"    Kill connection made from <ip:port>.",
+		"KILL <option> <value> [<option> <value> [...]]",
+		"    Kill connections. Options are:",
+		"    * ADDR (<ip:port>|<unixsocket>:0)",
+		"      Kill connections made from the specified address",
+		"    * LADDR (<ip:port>|<unixsocket>:0)",
+		"      Kill connections made to specified local address",
+		"    * TYPE (NORMAL|MASTER|REPLICA|PUBSUB)",
+		"      Kill connections by type.",
+		"    * USER <username>",
+		"      Kill connections authenticated by <username>.",
+		"    * SKIPME (YES|NO)",
+		"      Skip killing current connection (default: yes).",
+		"    * ID <client-id>",
+		"      Kill connections by client id.",
+		"    * MAXAGE <maxage>",
+		"      Kill connections older than the specified age.",
+		"LIST [options ...]",
+		"    Return information about client connections. Options:",
+		"    * TYPE (NORMAL|MASTER|REPLICA|PUBSUB)",
+		"      Return clients of specified type.",
+		"    * ID <client-id> [<client-id> ...]",
+		"      Return clients of specified IDs only.",
+		"UNPAUSE",
+		"    Stop the current client pause, resuming traffic.",
+		"PAUSE <timeout> [WRITE|ALL]",
+		"    Suspend all, or just write, clients for <timeout> milliseconds.",
+		"REPLY (ON|OFF|SKIP)",
+		"    Control the replies sent to the current connection.",
+		"SETNAME <name>",
+		"    Assign the name <name> to the current connection.",
+		"SETINFO <option> <value>",
+		"    Set client meta attr. Options are:",
+		"    * LIB-NAME: the client lib name.",
+		"    * LIB-VER: the client lib version.",
+		"NO-EVICT (ON|OFF)",
+		"    Protect current client connection from eviction.",
+		"NO-TOUCH (ON|OFF)",
+		"    Will not touch LRU/LFU stats when this mode is on.",
+		"TRACKING (ON|OFF) [REDIRECT <id>] [BCAST] [PREFIX <prefix> [...]]",
+		"         [OPTIN] [OPTOUT] [NOLOOP]",
+		"    Control server assisted client side caching.",
+		"TRACKINGINFO",
+		"    Report tracking status for the current connection.",
+		"HELP",
+		"    Print this help.",
+	})
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"net"
+	"strings"
+	"testing"
+)
+
+// 创建一个连接到内存管道的客户端。没有启动写出协程，回复留在输出缓冲区中。
+func newTestClient(t *testing.T, s *redisServer) *redisClient {
+	conn, peer := net.Pipe()
+	t.Cleanup(func() {
+		conn.Close()
+		peer.Close()
+	})
+	return newRedisClient(conn, s)
+}
+
+// 执行一条命令并返回它写入输出缓冲区的回复。
+func commandReply(c *redisClient, args ...string) string {
+	c.outBuf = c.outBuf[:0]
+	c.processCommand(args)
+	return string(c.outBuf)
+}
+
+// HELLO 3 之后 Map 和 Double 以 RESP3 的原生类型返回，RESP2 客户端收到展开的数组和批量字符串。
+func TestRESP3NativeShapes(t *testing.T) {
+	chdirTemp(t)
+	s := newRedisServer(newRedisConfig())
+	tests := []struct {
+		protover string
+		config   string // CONFIG GET maxmemory-policy 的回复
+		percent  string // MEMORY STATS 中 dataset.percentage 的值的类型前缀
+	}{
+		{"2", "*2\r\n$16\r\nmaxmemory-policy\r\n$10\r\nnoeviction\r\n", "$"},
+		{"3", "%1\r\n$16\r\nmaxmemory-policy\r\n$10\r\nnoeviction\r\n", ","},
+	}
+	for _, tt := range tests {
+		c := newTestClient(t, s)
+		commandReply(c, "HELLO", tt.protover)
+		if got := commandReply(c, "CONFIG", "GET", "maxmemory-policy"); got != tt.config {
+			t.Errorf("RESP%s CONFIG GET: got %q, want %q", tt.protover, got, tt.config)
+		}
+		stats := commandReply(c, "MEMORY", "STATS")
+		if !strings.HasPrefix(stats, tt.config[:1]) {
+			t.Errorf("RESP%s MEMORY STATS: got %q, want a reply starting with %q", tt.protover, stats, tt.config[:1])
+		}
+		if want := "$18\r\ndataset.percentage\r\n" + tt.percent; !strings.Contains(stats, want) {
+			t.Errorf("RESP%s MEMORY STATS: %q does not contain %q", tt.protover, stats, want)
+		}
+	}
+}
@@ -2,73 +2,463 @@ package main
 
 import (
 	"fmt"
+	"strconv"
 	"strings"
 	"time"
 )
 
-// 表示一个 Redis 命令的定义，包含命令名称和处理函数。
+// 命令标志
+const (
+	CMD_WRITE      = 1 << 0 // 会修改数据
+	CMD_READONLY   = 1 << 1 // 只读取数据
+	CMD_DENYOOM    = 1 << 2 // 可能增加内存占用，超过 maxmemory 时拒绝执行
+	CMD_EXCLUSIVE  = 1 << 3 // 独占执行，执行期间其他命令和后台任务都要等待
+	CMD_NOSCRIPT   = 1 << 4 // 不能在脚本中调用
+	CMD_ALLOW_BUSY = 1 << 5 // 脚本执行超时后仍然可以执行，不持有执行锁
+	CMD_NO_MULTI   = 1 << 6 // 不能在事务中使用
+	CMD_BATCHED    = 1 << 7 // 不持有执行锁，命令每处理一批数据时自己获取，批次之间 EXEC 和脚本可以执行
+)
+
+// 表示一个 Redis 命令的定义，包含命令名称、标志和处理函数。
 type redisCommand struct {
 	name    string // 命令名称（如 SET、GET）
+	arity   int    // 参数个数（包括命令名），负数 -N 表示至少 N 个
+	flags   int    // 命令标志（CMD_*）
 	handler func(client *redisClient, args []string) // 命令处理函数
+
+	subcommands []*redisCommand                                  // 子命令，名称为 "命令|子命令"，各自有参数个数和标志
+	getFlags    func(s *redisServer, args []string, flags int) int // 按参数计算实际标志，例如 FCALL 按被调用函数的标志
+	keys        keySpec                                            // 参数中键的位置，客户端缓存据此记录读取和修改的键
+}
+
+// 命令参数中键的位置：从第 first 个参数到第 last 个参数，每 step 个参数一个键。
+// last 为负数时从末尾倒数，first 为 0 表示命令没有键。
+type keySpec struct {
+	first, last, step int
 }
 
 // 定义了支持的 Redis 命令及其处理逻辑。
 var commands = []*redisCommand{
 	{
-		name: "SET",
+		name:  "SET",
+		arity: 3,
+		flags: CMD_WRITE | CMD_DENYOOM,
+		keys:  keySpec{1, 1, 1},
 		handler: func(c *redisClient, args []string) {
 			// SET 命令的处理逻辑
 			if len(args) != 3 {
-				c.writeResponse("ERR wrong number of arguments for 'SET' command")
+				c.writeError("ERR wrong number of arguments for 'SET' command")
 				return
 			}
 			c.server.db.setKey(args[1], args[2]) // 调用数据库的 setKey 方法
-			c.writeResponse("OK")
+			c.writeStatus("OK")
 		},
 	},
 	{
-		name: "GET",
+		name:  "GET",
+		arity: 2,
+		flags: CMD_READONLY,
+		keys:  keySpec{1, 1, 1},
 		handler: func(c *redisClient, args []string) {
 			// GET 命令的处理逻辑
 			if len(args) != 2 {
-				c.writeResponse("ERR wrong number of arguments for 'GET' command")
+				c.writeError("ERR wrong number of arguments for 'GET' command")
 				return
 			}
-			value := c.server.db.getKey(args[1]) // 调用数据库的 getKey 方法
-			if value == "" {
-				c.writeResponse("(nil)") // 如果没有值，则返回 nil
+			value, ok := c.server.db.getKey(args[1], c.lookupFlags()) // 调用数据库的 getKey 方法
+			if !ok {
+				c.writeNull() // 如果没有值，则返回 nil
 			} else {
-				c.writeResponse(value)
+				c.writeBulk(value)
 			}
 		},
 	},
 	{
-		name: "DEL",
+		name:  "DEL",
+		arity: 2,
+		flags: CMD_WRITE,
+		keys:  keySpec{1, 1, 1},
 		handler: func(c *redisClient, args []string) {
 			// DEL 命令的处理逻辑
 			if len(args) != 2 {
-				c.writeResponse("ERR wrong number of arguments for 'DEL' command")
+				c.writeError("ERR wrong number of arguments for 'DEL' command")
 				return
 			}
 			c.server.db.deleteKey(args[1]) // 调用数据库的 deleteKey 方法
-			c.writeResponse("OK")
+			c.writeStatus("OK")
 		},
 	},
 	{
-		name: "EXPIRE",
+		name:  "EXPIRE",
+		arity: 3,
+		flags: CMD_WRITE,
+		keys:  keySpec{1, 1, 1},
 		handler: func(c *redisClient, args []string) {
 			// EXPIRE 命令的处理逻辑
 			if len(args) != 3 {
-				c.writeResponse("ERR wrong number of arguments for 'EXPIRE' command")
+				c.writeError("ERR wrong number of arguments for 'EXPIRE' command")
 				return
 			}
 			expireTime, err := time.ParseDuration(args[2] + "s") // 解析过期时间
 			if err != nil {
-				c.writeResponse("ERR invalid expire time")
+				c.writeError("ERR invalid expire time")
+				return
+			}
+			// 键不存在时返回 0
+			if c.server.db.setExpire(args[1], expireTime) {
+				c.writeInteger(1)
+			} else {
+				c.writeInteger(0)
+			}
+		},
+	},
+	{
+		name:  "PEXPIREAT",
+		arity: 3,
+		flags: CMD_WRITE,
+		keys:  keySpec{1, 1, 1},
+		handler: func(c *redisClient, args []string) {
+			// PEXPIREAT 命令的处理逻辑，AOF 用它记录绝对过期时间
+			if len(args) != 3 {
+				c.writeError("ERR wrong number of arguments for 'PEXPIREAT' command")
+				return
+			}
+			when, err := strconv.ParseInt(args[2], 10, 64) // 解析毫秒时间戳
+			if err != nil {
+				c.writeError("ERR value is not an integer or out of range")
+				return
+			}
+			if c.server.db.setExpireAt(args[1], time.UnixMilli(when)) {
+				c.writeInteger(1)
+			} else {
+				c.writeInteger(0)
+			}
+		},
+	},
+	{
+		name:  "RENAME",
+		arity: 3,
+		flags: CMD_WRITE,
+		keys:  keySpec{1, 2, 1},
+		handler: func(c *redisClient, args []string) {
+			// RENAME 命令的处理逻辑
+			if !c.server.db.renameKey(args[1], args[2]) { // 调用数据库的 renameKey 方法
+				c.writeError("ERR no such key")
+				return
+			}
+			c.writeStatus("OK")
+		},
+	},
+	{
+		name: "SELECT",
+		arity: 2,
+		handler: func(c *redisClient, args []string) {
+			// SELECT 命令的处理逻辑，目前只有 0 号数据库
+			if len(args) != 2 {
+				c.writeError("ERR wrong number of arguments for 'SELECT' command")
+				return
+			}
+			id, err := strconv.Atoi(args[1])
+			if err != nil {
+				c.writeError("ERR value is not an integer or out of range")
+				return
+			}
+			if id != 0 {
+				c.writeError("ERR DB index is out of range")
 				return
 			}
-			c.server.db.setExpire(args[1], expireTime) // 调用数据库的 setExpire 方法
-			c.writeResponse("OK")
+			c.writeStatus("OK")
+		},
+	},
+	{
+		name:    "SAVE",
+		arity:   1,
+		flags:   CMD_NOSCRIPT,
+		handler: saveCommand,
+	},
+	{
+		name:    "BGSAVE",
+		arity:   -1,
+		flags:   CMD_NOSCRIPT,
+		handler: bgsaveCommand,
+	},
+	{
+		name:    "BGREWRITEAOF",
+		arity:   1,
+		flags:   CMD_NOSCRIPT,
+		handler: bgrewriteaofCommand,
+	},
+	{
+		name:    "LASTSAVE",
+		arity:   1,
+		handler: lastsaveCommand,
+	},
+	{
+		name:    "INFO",
+		arity:   -1,
+		handler: infoCommand,
+	},
+	{
+		name:    "CONFIG",
+		arity:   -2,
+		flags:   CMD_NOSCRIPT,
+		handler: configCommand,
+	},
+	{
+		name:    "MEMORY",
+		arity:   -2,
+		handler: memoryCommand,
+	},
+	{
+		name:    "MULTI",
+		arity:   1,
+		flags:   CMD_NOSCRIPT,
+		handler: multiCommand,
+	},
+	{
+		name:    "EXEC",
+		arity:   1,
+		flags:   CMD_EXCLUSIVE | CMD_NOSCRIPT,
+		handler: execCommand,
+	},
+	{
+		name:    "DISCARD",
+		arity:   1,
+		flags:   CMD_NOSCRIPT,
+		handler: discardCommand,
+	},
+	{
+		name:    "WATCH",
+		arity:   -2,
+		flags:   CMD_NOSCRIPT,
+		handler: watchCommand,
+	},
+	{
+		name:    "UNWATCH",
+		arity:   1,
+		flags:   CMD_NOSCRIPT,
+		handler: unwatchCommand,
+	},
+	{
+		name:    "EVAL",
+		arity:   -3,
+		flags:   CMD_EXCLUSIVE | CMD_NOSCRIPT,
+		handler: evalCommand,
+	},
+	{
+		name:    "EVALSHA",
+		arity:   -3,
+		flags:   CMD_EXCLUSIVE | CMD_NOSCRIPT,
+		handler: evalShaCommand,
+	},
+	{
+		name:    "EVAL_RO",
+		arity:   -3,
+		flags:   CMD_EXCLUSIVE | CMD_NOSCRIPT | CMD_READONLY,
+		handler: evalRoCommand,
+	},
+	{
+		name:    "EVALSHA_RO",
+		arity:   -3,
+		flags:   CMD_EXCLUSIVE | CMD_NOSCRIPT | CMD_READONLY,
+		handler: evalShaRoCommand,
+	},
+	{
+		name:    "SCRIPT",
+		arity:   -2,
+		flags:   CMD_NOSCRIPT | CMD_ALLOW_BUSY,
+		handler: scriptCommand,
+	},
+	{
+		name:     "FCALL",
+		arity:    -3,
+		flags:    CMD_EXCLUSIVE | CMD_NOSCRIPT,
+		handler:  fcallCommand,
+		getFlags: fcallGetCommandFlags,
+	},
+	{
+		name:     "FCALL_RO",
+		arity:    -3,
+		flags:    CMD_EXCLUSIVE | CMD_NOSCRIPT | CMD_READONLY,
+		handler:  fcallRoCommand,
+		getFlags: fcallGetCommandFlags,
+	},
+	{
+		name:    "FUNCTION",
+		arity:   -2,
+		flags:   CMD_NOSCRIPT,
+		handler: functionCommand,
+		subcommands: []*redisCommand{
+			{name: "FUNCTION|LOAD", arity: -3, flags: CMD_EXCLUSIVE | CMD_NOSCRIPT | CMD_WRITE | CMD_DENYOOM, handler: functionLoadCommand},
+			{name: "FUNCTION|DELETE", arity: 3, flags: CMD_EXCLUSIVE | CMD_NOSCRIPT | CMD_WRITE, handler: functionDeleteCommand},
+			{name: "FUNCTION|FLUSH", arity: -2, flags: CMD_EXCLUSIVE | CMD_NOSCRIPT | CMD_WRITE, handler: functionFlushCommand},
+			{name: "FUNCTION|RESTORE", arity: -3, flags: CMD_EXCLUSIVE | CMD_NOSCRIPT | CMD_WRITE | CMD_DENYOOM, handler: functionRestoreCommand},
+			{name: "FUNCTION|DUMP", arity: 2, flags: CMD_NOSCRIPT, handler: functionDumpCommand},
+			{name: "FUNCTION|LIST", arity: -2, flags: CMD_NOSCRIPT, handler: functionListCommand},
+			{name: "FUNCTION|STATS", arity: 2, flags: CMD_NOSCRIPT | CMD_ALLOW_BUSY, handler: functionStatsCommand},
+			{name: "FUNCTION|KILL", arity: 2, flags: CMD_NOSCRIPT | CMD_ALLOW_BUSY, handler: functionKillCommand},
+			{name: "FUNCTION|HELP", arity: 2, flags: CMD_NOSCRIPT, handler: functionHelpCommand},
+		},
+	},
+	{
+		name:    "PING",
+		arity:   -1,
+		handler: pingCommand,
+	},
+	{
+		name:    "QUIT",
+		arity:   -1,
+		flags:   CMD_ALLOW_BUSY,
+		handler: quitCommand,
+	},
+	{
+		name:    "SUBSCRIBE",
+		arity:   -2,
+		flags:   CMD_NOSCRIPT,
+		handler: subscribeCommand,
+	},
+	{
+		name:    "UNSUBSCRIBE",
+		arity:   -1,
+		flags:   CMD_NOSCRIPT,
+		handler: unsubscribeCommand,
+	},
+	{
+		name:    "PSUBSCRIBE",
+		arity:   -2,
+		flags:   CMD_NOSCRIPT,
+		handler: psubscribeCommand,
+	},
+	{
+		name:    "PUNSUBSCRIBE",
+		arity:   -1,
+		flags:   CMD_NOSCRIPT,
+		handler: punsubscribeCommand,
+	},
+	{
+		name:    "SSUBSCRIBE",
+		arity:   -2,
+		flags:   CMD_NOSCRIPT,
+		handler: ssubscribeCommand,
+	},
+	{
+		name:    "SUNSUBSCRIBE",
+		arity:   -1,
+		flags:   CMD_NOSCRIPT,
+		handler: sunsubscribeCommand,
+	},
+	{
+		name:    "PUBLISH",
+		arity:   3,
+		handler: publishCommand,
+	},
+	{
+		name:    "SPUBLISH",
+		arity:   3,
+		handler: spublishCommand,
+	},
+	{
+		name:    "PUBSUB",
+		arity:   -2,
+		handler: pubsubCommand,
+		subcommands: []*redisCommand{
+			{name: "PUBSUB|CHANNELS", arity: -2, handler: pubsubChannelsCommand},
+			{name: "PUBSUB|NUMSUB", arity: -2, handler: pubsubNumsubCommand},
+			{name: "PUBSUB|NUMPAT", arity: 2, handler: pubsubNumpatCommand},
+			{name: "PUBSUB|SHARDCHANNELS", arity: -2, handler: pubsubShardChannelsCommand},
+			{name: "PUBSUB|SHARDNUMSUB", arity: -2, handler: pubsubShardNumsubCommand},
+			{name: "PUBSUB|HELP", arity: 2, handler: pubsubHelpCommand},
+		},
+	},
+	{
+		name:    "HELLO",
+		arity:   -1,
+		flags:   CMD_NOSCRIPT | CMD_ALLOW_BUSY,
+		handler: helloCommand,
+	},
+	{
+		name:    "CLIENT",
+		arity:   -2,
+		handler: clientCommand,
+		subcommands: []*redisCommand{
+			{name: "CLIENT|ID", arity: 2, flags: CMD_NOSCRIPT, handler: clientIdCommand},
+			{name: "CLIENT|LIST", arity: -2, flags: CMD_NOSCRIPT, handler: clientListCommand},
+			{name: "CLIENT|INFO", arity: 2, flags: CMD_NOSCRIPT, handler: clientInfoCommand},
+			{name: "CLIENT|SETNAME", arity: 3, flags: CMD_NOSCRIPT, handler: clientSetnameCommand},
+			{name: "CLIENT|GETNAME", arity: 2, flags: CMD_NOSCRIPT, handler: clientGetnameCommand},
+			{name: "CLIENT|SETINFO", arity: 4, flags: CMD_NOSCRIPT, handler: clientSetinfoCommand},
+			{name: "CLIENT|KILL", arity: -3, flags: CMD_NOSCRIPT, handler: clientKillCommand},
+			{name: "CLIENT|PAUSE", arity: -3, flags: CMD_NOSCRIPT, handler: clientPauseCommand},
+			{name: "CLIENT|UNPAUSE", arity: 2, flags: CMD_NOSCRIPT, handler: clientUnpauseCommand},
+			{name: "CLIENT|NO-EVICT", arity: 3, flags: CMD_NOSCRIPT, handler: clientNoEvictCommand},
+			{name: "CLIENT|NO-TOUCH", arity: 3, flags: CMD_NOSCRIPT, handler: clientNoTouchCommand},
+			{name: "CLIENT|REPLY", arity: 3, flags: CMD_NOSCRIPT, handler: clientReplyCommand},
+			{name: "CLIENT|TRACKING", arity: -3, flags: CMD_NOSCRIPT, handler: clientTrackingCommand},
+			{name: "CLIENT|CACHING", arity: 3, flags: CMD_NOSCRIPT, handler: clientCachingCommand},
+			{name: "CLIENT|GETREDIR", arity: 2, flags: CMD_NOSCRIPT, handler: clientGetredirCommand},
+			{name: "CLIENT|TRACKINGINFO", arity: 2, flags: CMD_NOSCRIPT, handler: clientTrackinginfoCommand},
+			{name: "CLIENT|HELP", arity: 2, flags: CMD_NOSCRIPT, handler: clientHelpCommand},
 		},
 	},
-}
\ No newline at end of file
+	{
+		name:    "KEYSTATS",
+		arity:   -2,
+		flags:   CMD_READONLY | CMD_NOSCRIPT | CMD_NO_MULTI | CMD_BATCHED,
+		handler: keystatsCommand,
+	},
+}
+
+// 按大写名称索引的命令表。命令处理函数（例如脚本中的 redis.call）也会查找命令，
+// 所以在 init 中建立索引，避免 commands 的初始化依赖它自己。
+var commandTable map[string]*redisCommand
+
+func init() {
+	commandTable = make(map[string]*redisCommand, len(commands))
+	for _, cmd := range commands {
+		commandTable[cmd.name] = cmd
+	}
+}
+
+// 按名称查找命令定义，名称不区分大小写，找不到时返回 nil。
+func lookupCommand(name string) *redisCommand {
+	return commandTable[strings.ToUpper(name)]
+}
+
+// 按命令行查找命令定义：带有子命令的命令返回对应子命令的定义，
+// 子命令不存在时返回命令本身，由它的处理函数报告错误。
+func lookupCommandArgs(args []string) *redisCommand {
+	cmd := lookupCommand(args[0])
+	if cmd == nil || len(cmd.subcommands) == 0 || len(args) < 2 {
+		return cmd
+	}
+	for _, sub := range cmd.subcommands {
+		if strings.EqualFold(sub.name, cmd.name+"|"+args[1]) {
+			return sub
+		}
+	}
+	return cmd
+}
+
+// 返回命令这一次执行的实际标志。
+func (cmd *redisCommand) commandFlags(s *redisServer, args []string) int {
+	if cmd.getFlags != nil {
+		return cmd.getFlags(s, args, cmd.flags)
+	}
+	return cmd.flags
+}
+
+// 按 keys 返回命令参数中的键。
+func (cmd *redisCommand) getKeys(args []string) []string {
+	if cmd.keys.first == 0 {
+		return nil
+	}
+	last := cmd.keys.last
+	if last < 0 {
+		last += len(args)
+	}
+	var keys []string
+	for i := cmd.keys.first; i <= last && i < len(args); i += cmd.keys.step {
+		keys = append(keys, args[i])
+	}
+	return keys
+}
@@ -1,58 +1,336 @@
 package main
 
 import (
+	"crypto/tls"
 	"fmt"
+	"log"
 	"net"
+	"os"
+	"sort"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
+// 服务器对外声明的 Redis 版本，写入 RDB 的 AUX 字段
+const REDIS_VERSION = "7.2.0"
+
+// 服务器定时任务的执行频率（每秒次数）
+const CONFIG_DEFAULT_HZ = 10
+
 // 表示一个 Redis 服务器实例，包含主机地址、端口、数据库和客户端列表。
 type redisServer struct {
-	host          string // 服务器主机地址
-	port          int // 服务器端口
-	db            *redisDb // 数据库实例
-	activeClients []*redisClient // 当前活跃的客户端列表
+	port      int             // TCP 端口，0 表示不监听 TCP
+	listeners []*connListener // 监听器，启动之后不再变化
+	db        *redisDb        // 数据库实例
+	config    *redisConfig    // 服务器配置
+	startTime time.Time       // 服务器启动时间
+
+	mu sync.Mutex // 保护配置和下面的持久化状态
+
+	// RDB 持久化状态
+	lastSave           time.Time     // 最近一次成功保存的时间
+	lastBgsaveTry      time.Time     // 最近一次尝试 BGSAVE 的时间
+	lastBgsaveOK       bool          // 最近一次 BGSAVE 是否成功
+	rdbChildRunning    bool          // 是否有后台保存正在进行
+	rdbSaveRunning     bool          // 是否有前台 SAVE 正在进行
+	rdbBgsaveScheduled bool          // 是否有等待执行的 BGSAVE
+	rdbSaveTimeStart   time.Time     // 当前后台保存的开始时间
+	rdbSaveTimeLast    time.Duration // 上一次后台保存的耗时，-1 表示还没有执行过
+	rdbSaves           int64         // 成功保存的次数
+
+	// AOF 重写状态
+	aofChildRunning     bool          // 是否有后台重写正在进行
+	aofRewriteScheduled bool          // 是否有等待执行的重写
+	aofRewriteTimeStart time.Time     // 当前重写的开始时间
+	aofRewriteTimeLast  time.Duration // 上一次重写的耗时，-1 表示还没有执行过
+	lastBgrewriteOK     bool          // 最近一次重写是否成功
+	aofRewrites         int64         // 成功重写的次数
+
+	// 统计信息
+	statEvictedKeys    int64 // 因 maxmemory 被淘汰的键数量
+	statPeakMemory     int64 // 估算内存的峰值
+	statNumConnections int64 // 接受的连接数量
+	statRejectedConn   int64 // 因 maxclients 被拒绝的连接数量
+
+	statClientOutbufLimitDisconnections int64 // 因超过输出缓冲区上限被断开的客户端数量，原子递增
+
+	lua      *luaScripting  // Lua 脚本环境
+	pubsub   *pubsubState   // 发布订阅的订阅关系
+	tracking *trackingState // 客户端缓存的失效表
+	pause    *pauseState    // CLIENT PAUSE 的暂停状态
+
+	nextClientID    int64                  // 最近分配的客户端 ID，原子递增
+	protoMaxBulkLen int64                  // proto-max-bulk-len 的副本，读取请求的协程原子地读取
+	tlsConfig       atomic.Value           // 新的 TLS 连接使用的配置（*tls.Config），CONFIG SET 修改证书时替换
+	obufLimits      atomic.Value           // client-output-buffer-limit 的副本（*[CLIENT_TYPE_COUNT]clientBufferLimit），写入回复的协程原子地读取
+	clientsMu       sync.Mutex             // 保护 clients
+	clients         map[int64]*redisClient // 按 ID 索引已连接的客户端
+}
+
+// 创建一个新的 Redis 服务器实例，并从 RDB 或 AOF 文件加载数据。
+func newRedisServer(config *redisConfig) *redisServer {
+	now := time.Now()
+	s := &redisServer{
+		port:               config.port,
+		db:                 newRedisDb(config.dbfilename, config.appenddirname, config.appendfilename),
+		config:             config,
+		startTime:          now,
+		lastSave:           now,
+		lastBgsaveOK:       true,
+		rdbSaveTimeLast:    -1,
+		aofRewriteTimeLast: -1,
+		lastBgrewriteOK:    true,
+		clients:            make(map[int64]*redisClient),
+	}
+	s.lua = newLuaScripting(s)
+	s.pubsub = newPubsubState()
+	s.tracking = newTrackingState()
+	s.pause = newPauseState()
+	s.db.notifyPublish = func(channel, message string) { s.publish(channel, message, false) }
+	s.db.trackingInvalidate = func(key string) { s.trackingInvalidateKey(nil, key) }
+	s.db.writePaused = s.pause.writePaused
+	s.applyEvictionConfig()
+	s.applyNotifyConfig()
+	s.applyClientOutputBufferConfig()
+	s.applyProtoConfig()
+	if err := s.applyTLSConfig(); err != nil {
+		log.Fatal("Failed to configure TLS: ", err)
+	}
+	s.loadDataFromDisk()
+	// 从 RDB 载入的函数库只有代码，启动时编译，代码有错误时拒绝启动
+	if err := s.lua.ensureFunctions(); err != nil {
+		log.Fatal("Failed loading functions: ", err)
+	}
+	return s
+}
+
+// 把淘汰策略和 LFU 参数同步到数据库，调用方需持有 s.mu（启动时除外）。
+func (s *redisServer) applyEvictionConfig() error {
+	s.db.setEvictionParams(s.config.maxmemoryPolicy, s.config.lfuLogFactor, s.config.lfuDecayTime)
+	return nil
+}
+
+// 把开启的键空间通知类别同步到数据库，调用方需持有 s.mu（启动时除外）。
+func (s *redisServer) applyNotifyConfig() error {
+	s.db.setNotifyKeyspaceEvents(s.config.notifyKeyspaceEvents)
+	return nil
+}
+
+// 同步各类客户端的输出缓冲区上限，写入回复和发布消息时不必再获取 s.mu。
+func (s *redisServer) applyClientOutputBufferConfig() error {
+	limits := s.config.clientOutputBufferLimits
+	s.obufLimits.Store(&limits)
+	return nil
+}
+
+// 把请求参数的长度上限同步给读取请求的协程，它们不获取 s.mu。
+func (s *redisServer) applyProtoConfig() error {
+	atomic.StoreInt64(&s.protoMaxBulkLen, s.config.protoMaxBulkLen)
+	return nil
+}
+
+// 设置了 maxmemory 时淘汰键直到内存不超过上限，仍然超过上限时返回 false。
+func (s *redisServer) performEvictions() bool {
+	s.mu.Lock()
+	maxmemory, samples := s.config.maxmemory, s.config.maxmemorySamples
+	s.mu.Unlock()
+	// 暂停写入期间数据不能变化，不淘汰键
+	if maxmemory == 0 || s.pause.writePaused() {
+		return true
+	}
+	evicted, ok := s.db.performEvictions(maxmemory, samples)
+	if evicted > 0 {
+		s.mu.Lock()
+		s.statEvictedKeys += evicted
+		s.mu.Unlock()
+	}
+	return ok
+}
+
+// 开启 AOF 时 AOF 文件记录的数据最完整，只从 AOF 加载；否则从 RDB 加载。文件损坏时直接退出。
+func (s *redisServer) loadDataFromDisk() {
+	start := time.Now()
+	if s.config.appendonly {
+		err := s.db.loadAOF(s.aofLoaderExec(), s.config.aofLoadTruncated)
+		if err != nil && !os.IsNotExist(err) {
+			log.Fatal("Fatal error loading the append only file: ", err)
+		}
+		if err == nil {
+			log.Printf("DB loaded from append only file: %.3f seconds", time.Since(start).Seconds())
+		}
+		if err := s.db.openAOF(s.config.appendfsync, s.config.aofUseRDBPreamble); err != nil {
+			log.Fatal("Can't open the append-only file: ", err)
+		}
+		return
+	}
+	err := s.db.loadRDB()
+	if err != nil && !os.IsNotExist(err) {
+		log.Fatal("Fatal error loading the DB: ", err)
+	}
+	if err == nil {
+		log.Printf("DB loaded from disk: %.3f seconds", time.Since(start).Seconds())
+	}
 }
 
-// 创建一个新的 Redis 服务器实例，并加载 RDB 和 AOF 文件。
-func newRedisServer(host string, port int, rdbFile, aofFile string) *redisServer {
-	db := newRedisDb(rdbFile, aofFile)
-	// 加载 RDB 和 AOF 文件
-	db.loadRDB()
-	db.loadAOF()
-	return &redisServer{
-		host: host,
-		port: port,
-		db:   db,
+// 返回 AOF 加载时执行命令的函数：命令通过一个没有网络连接的伪客户端执行，回复被丢弃。
+func (s *redisServer) aofLoaderExec() func(args []string) error {
+	fakeClient := newRedisClient(nil, s)
+	return func(args []string) error {
+		cmd := lookupCommandArgs(args)
+		if cmd == nil {
+			return fmt.Errorf("unknown command '%s' reading the append only file", args[0])
+		}
+		// AOF 中的 MULTI 和 EXEC 之间的命令同样先排队，EXEC 时一起执行
+		if fakeClient.flags&CLIENT_MULTI != 0 && !isTransactionCommand(cmd) {
+			fakeClient.queueMultiCommand(cmd, args)
+			return nil
+		}
+		cmd.handler(fakeClient, args)
+		return nil
 	}
 }
 
+// 登记一个已连接的客户端。
+func (s *redisServer) linkClient(c *redisClient) {
+	s.clientsMu.Lock()
+	defer s.clientsMu.Unlock()
+	s.clients[c.id] = c
+}
+
+// 客户端断开连接时取消登记。
+func (s *redisServer) unlinkClient(c *redisClient) {
+	s.clientsMu.Lock()
+	defer s.clientsMu.Unlock()
+	delete(s.clients, c.id)
+	if c.listener != nil {
+		atomic.AddInt64(&c.listener.connected, -1)
+	}
+}
+
+// 返回已连接的客户端数量。
+func (s *redisServer) numClients() int {
+	s.clientsMu.Lock()
+	defer s.clientsMu.Unlock()
+	return len(s.clients)
+}
+
+// 返回所有已连接的客户端，按 ID 排序，即按连接的先后顺序。
+func (s *redisServer) clientList() []*redisClient {
+	s.clientsMu.Lock()
+	list := make([]*redisClient, 0, len(s.clients))
+	for _, c := range s.clients {
+		list = append(list, c)
+	}
+	s.clientsMu.Unlock()
+	sort.Slice(list, func(i, j int) bool { return list[i].id < list[j].id })
+	return list
+}
+
+// 按 ID 查找已连接的客户端，不存在时返回 nil。
+func (s *redisServer) lookupClientByID(id int64) *redisClient {
+	s.clientsMu.Lock()
+	defer s.clientsMu.Unlock()
+	return s.clients[id]
+}
+
 // 启动一个协程定期清理过期键。
 func (s *redisServer) cleanExpiredKeys() {
 	go s.db.cleanExpiredKeys()
 }
 
-// start 启动 Redis 服务器，监听客户端连接并处理请求。
-func (s *redisServer) start() {
-	address := fmt.Sprintf("%s:%d", s.host, s.port)
-	ln, err := net.Listen("tcp", address) // 监听 TCP 端口
-	if err != nil {
-		fmt.Println("Error starting server:", err)
+// 服务器的定时任务，每秒执行 CONFIG_DEFAULT_HZ 次，负责按规则触发后台保存、AOF 重写等周期性工作。
+func (s *redisServer) serverCron() {
+	ticker := time.NewTicker(time.Second / CONFIG_DEFAULT_HZ)
+	defer ticker.Stop()
+	for range ticker.C {
+		s.db.lockShared() // 不在事务执行的中途保存快照
+		s.rdbCron()
+		s.aofCron()
+		s.db.unlockShared()
+		s.db.flushAppendOnlyFile()
+		s.updatePeakMemory(s.db.memoryUsed())
+		s.clientsCron()
+	}
+}
+
+// 关闭空闲时间超过 timeout 的客户端，订阅了频道的客户端和正在执行命令的客户端除外。
+func (s *redisServer) clientsCron() {
+	s.mu.Lock()
+	maxidle := time.Duration(s.config.maxidletime) * time.Second
+	s.mu.Unlock()
+	if maxidle == 0 {
 		return
 	}
-	defer ln.Close()
-	fmt.Println("Server started on", address)
+	now := time.Now()
+	for _, c := range s.clientList() {
+		c.infoMu.Lock()
+		idle := !c.info.executing && c.info.flags&CLIENT_PUBSUB == 0 && now.Sub(c.info.lastInteraction) > maxidle
+		c.infoMu.Unlock()
+		if idle {
+			log.Printf("Closing idle client id=%d addr=%s", c.id, c.addr)
+			c.conn.Close() // 读取请求的协程随之出错退出，连接的协程负责清理
+		}
+	}
+}
 
-	s.cleanExpiredKeys() // 启动过期键清理协程
+// 处理监听器 l 新接受的连接：设置 TCP keepalive，已连接的客户端达到 maxclients 时回复错误后关闭。
+// TLS 监听器的连接在这里包装为 TLS 连接，握手在第一次读写时进行，不阻塞 accept 循环。
+func (s *redisServer) acceptClient(conn net.Conn, l *connListener) {
+	s.mu.Lock()
+	maxclients, keepalive := s.config.maxclients, s.config.tcpKeepalive
+	s.mu.Unlock()
+	if tc, ok := conn.(*net.TCPConn); ok {
+		tc.SetKeepAlive(keepalive > 0)
+		if keepalive > 0 {
+			tc.SetKeepAlivePeriod(time.Duration(keepalive) * time.Second)
+		}
+	}
+	if l.tls {
+		conn = tls.Server(conn, s.tlsConfig.Load().(*tls.Config))
+	}
+	// 只有接受连接的协程会增加客户端，检查之后到登记之前数量不会超过上限
+	if s.numClients() >= maxclients {
+		// TLS 连接写入错误之前需要握手，放到单独的协程中
+		go func() {
+			conn.SetDeadline(time.Now().Add(time.Second))
+			conn.Write([]byte("-ERR max number of clients reached\r\n"))
+			conn.Close()
+		}()
+		s.mu.Lock()
+		s.statRejectedConn++
+		s.mu.Unlock()
+		atomic.AddInt64(&l.rejected, 1)
+		return
+	}
+	s.mu.Lock()
+	s.statNumConnections++
+	s.mu.Unlock()
+	atomic.AddInt64(&l.accepted, 1)
+	atomic.AddInt64(&l.connected, 1)
+	client := newRedisClient(conn, s) // 创建新的客户端实例
+	client.listener = l
+	if l.name == "unix" {
+		client.flags |= CLIENT_UNIX_SOCKET
+	}
+	s.linkClient(client)
+	go client.handleRequest() // 使用 Goroutine 处理客户端请求
+}
 
-	for {
-		conn, err := ln.Accept() // 接受客户端连接
-		if err != nil {
-			fmt.Println("Error accepting connection:", err)
-			continue
+// start 启动 Redis 服务器，监听客户端连接并处理请求，closeListeners 关闭所有监听器后返回。
+func (s *redisServer) start() error {
+	if err := s.initListeners(); err != nil {
+		return err
+	}
+	for _, l := range s.listeners {
+		for _, ln := range l.listeners {
+			fmt.Println("Server started on", ln.Addr())
 		}
-		client := newRedisClient(conn, s) // 创建新的客户端实例
-		s.activeClients = append(s.activeClients, client)
-		go client.handleRequest() // 使用 Goroutine 处理客户端请求
 	}
-}
\ No newline at end of file
+
+	s.cleanExpiredKeys() // 启动过期键清理协程
+	go s.serverCron()    // 启动定时任务
+
+	s.acceptLoop()
+	return nil
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testFunctionLibrary = "#!lua name=mylib\nredis.register_function('f', function() return 1 end)"
+
+// 切换到临时目录，测试结束后切换回来。数据文件的路径都相对于工作目录。
+func chdirTemp(t *testing.T) {
+	t.Helper()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+}
+
+// 在临时目录中启动一个开启 AOF 的服务器，不监听端口，fsync 策略为 always。
+func newAOFTestServer(t *testing.T, loadTruncated bool) *redisServer {
+	return newAOFTestServerFsync(t, loadTruncated, AOF_FSYNC_ALWAYS)
+}
+
+func newAOFTestServerFsync(t *testing.T, loadTruncated bool, fsync string) *redisServer {
+	t.Helper()
+	cfg := newRedisConfig()
+	cfg.appendonly = true
+	cfg.appendfsync = fsync
+	cfg.aofLoadTruncated = loadTruncated
+	s := newRedisServer(cfg)
+	t.Cleanup(s.db.stopAppendOnly)
+	return s
+}
+
+// 通过伪客户端执行命令，命令像来自网络一样写入 AOF。
+func runCommands(s *redisServer, cmds ...[]string) {
+	c := newRedisClient(nil, s)
+	for _, args := range cmds {
+		c.processCommand(args)
+	}
+}
+
+// 写入数据、重启服务器，从 AOF 恢复的数据与重启前一致。
+func TestAOFRestartRestoresState(t *testing.T) {
+	chdirTemp(t)
+	s := newAOFTestServer(t, false)
+	runCommands(s,
+		[]string{"SET", "a", "1"},
+		[]string{"SET", "binary", "x y\r\nz\x00"},
+		[]string{"SET", "deleted", "v"},
+		[]string{"DEL", "deleted"},
+		[]string{"SET", "volatile", "v"},
+		[]string{"EXPIRE", "volatile", "3600"},
+		[]string{"SET", "old", "v"},
+		[]string{"RENAME", "old", "new"},
+		[]string{"MULTI"},
+		[]string{"SET", "m1", "1"},
+		[]string{"SET", "m2", "2"},
+		[]string{"EXEC"},
+		[]string{"FUNCTION", "LOAD", testFunctionLibrary},
+	)
+	wantExpire := s.db.expires["volatile"]
+	s.db.stopAppendOnly()
+
+	s = newAOFTestServer(t, false)
+	for key, want := range map[string]string{"a": "1", "binary": "x y\r\nz\x00", "volatile": "v", "new": "v", "m1": "1", "m2": "2"} {
+		if got, ok := s.db.getKey(key, LOOKUP_NOTOUCH); !ok || got != want {
+			t.Errorf("key %q: got %q, %v; want %q", key, got, ok, want)
+		}
+	}
+	for _, key := range []string{"deleted", "old"} {
+		if _, ok := s.db.data[key]; ok {
+			t.Errorf("key %q should not exist", key)
+		}
+	}
+	if got := s.db.expires["volatile"]; got.UnixMilli() != wantExpire.UnixMilli() {
+		t.Errorf("volatile expires at %v, want %v", got, wantExpire)
+	}
+	if s.db.functions["mylib"] != testFunctionLibrary {
+		t.Errorf("function library not restored")
+	}
+}
+
+// 最后一条命令不完整时，按 aof-load-truncated 截掉它或者报告出错的偏移量。
+func TestAOFLoadTruncated(t *testing.T) {
+	chdirTemp(t)
+	s := newAOFTestServer(t, false)
+	runCommands(s, []string{"SET", "a", "1"}, []string{"SET", "b", "2"})
+	s.db.stopAppendOnly()
+
+	m, err := loadAOFManifest(s.db.aofDir, s.db.aofFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	incr := filepath.Join(s.db.aofDir, m.lastIncr().fileName)
+	info, err := os.Stat(incr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.OpenFile(incr, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString("*3\r\n$3\r\nSET\r\n$1\r\nc\r\n$5\r\nab")
+	f.Close()
+
+	cfg := newRedisConfig()
+	strict := newRedisServer(cfg) // 不开启 AOF，不会在启动时加载
+	err = strict.db.loadAOF(strict.aofLoaderExec(), false)
+	var aofErr *aofError
+	if !errors.As(err, &aofErr) || aofErr.offset != info.Size() {
+		t.Fatalf("got error %v, want an aofError at offset %d", err, info.Size())
+	}
+
+	s = newAOFTestServer(t, true)
+	for key, want := range map[string]string{"a": "1", "b": "2"} {
+		if got, ok := s.db.getKey(key, LOOKUP_NOTOUCH); !ok || got != want {
+			t.Errorf("key %q: got %q, %v; want %q", key, got, ok, want)
+		}
+	}
+	if _, ok := s.db.data["c"]; ok {
+		t.Errorf("truncated command was executed")
+	}
+}
+
+// 大于 AOF 缓冲区的写入由 bufio 直接写到文件，always 策略下同样要 fsync。
+func TestAOFAlwaysSyncsLargeWrite(t *testing.T) {
+	chdirTemp(t)
+	s := newAOFTestServer(t, false)
+	aof := s.db.appendOnly()
+	aof.mu.Lock()
+	aof.lastFsync = time.Time{}
+	aof.mu.Unlock()
+
+	value := strings.Repeat("v", AOF_WRITE_BUFFER_SIZE+1)
+	runCommands(s, []string{"SET", "big", value})
+	aof.mu.Lock()
+	defer aof.mu.Unlock()
+	if aof.lastFsync.IsZero() || aof.unsynced {
+		t.Errorf("large SET was not fsynced: lastFsync=%v unsynced=%v", aof.lastFsync, aof.unsynced)
+	}
+	data, err := os.ReadFile(aof.file.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(data, []byte(value)) {
+		t.Errorf("large SET is missing from the AOF file")
+	}
+}
+
+// everysec 策略下写命令在回复发出之前已经写入 AOF 文件，不需要等待 serverCron。
+func TestAOFWrittenBeforeReply(t *testing.T) {
+	chdirTemp(t)
+	s := newAOFTestServerFsync(t, false, AOF_FSYNC_EVERYSEC)
+	conn, peer := net.Pipe()
+	defer peer.Close()
+	go newRedisClient(conn, s).handleRequest()
+
+	peer.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err := peer.Write([]byte("*3\r\n$3\r\nSET\r\n$3\r\nkey\r\n$5\r\nvalue\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	if line, err := bufio.NewReader(peer).ReadString('\n'); err != nil || line != "+OK\r\n" {
+		t.Fatalf("got reply %q, %v", line, err)
+	}
+	data, err := os.ReadFile(s.db.appendOnly().file.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(data, []byte("$3\r\nkey\r\n$5\r\nvalue\r\n")) {
+		t.Errorf("acknowledged SET is not in the AOF file: %q", data)
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"log"
+	"sync/atomic"
+	"time"
+)
+
+// 写出之后保留下来重复使用的输出缓冲区的最大容量，更大的缓冲区交给 GC 回收
+const PROTO_REPLY_BUFFER_KEEP = 64 * 1024
+
+// 返回客户端所属的输出缓冲区类别，在客户端自己的协程中调用。
+func (c *redisClient) outputBufferClass() int {
+	if c.flags&CLIENT_PUBSUB != 0 {
+		return CLIENT_TYPE_PUBSUB
+	}
+	return CLIENT_TYPE_NORMAL
+}
+
+// 把回复编码后放入输出缓冲区，按客户端当前的协议版本编码，然后检查 class 类客户端的上限。
+// 客户端自己的协程写入命令的回复，发布消息和发送失效消息的协程写入推送消息。
+func (c *redisClient) addReply(r Reply, class int) {
+	c.outMu.Lock()
+	defer c.outMu.Unlock()
+	if c.outClosed {
+		return
+	}
+	if c.resp < 3 {
+		if r = ToRESP2(r); r == nil {
+			return
+		}
+	}
+	c.outBuf = r.AppendRESP(c.outBuf)
+	c.afterAddReply(class)
+}
+
+// 在输出缓冲区中写入数组长度，之后的 n 个回复是数组的元素。
+func (c *redisClient) addReplyArrayLen(n int, class int) {
+	c.outMu.Lock()
+	defer c.outMu.Unlock()
+	if c.outClosed {
+		return
+	}
+	c.outBuf = AppendAggregateLen(c.outBuf, Array, n)
+	c.afterAddReply(class)
+}
+
+// 写入数据之后检查输出缓冲区上限并通知写出协程，调用方需持有 outMu。
+func (c *redisClient) afterAddReply(class int) {
+	if c.checkClientOutputBufferLimits(c.server.clientOutputBufferLimit(class)) {
+		c.closeClientOnOutputBufferLimitReached()
+		return
+	}
+	select {
+	case c.outNotify <- struct{}{}:
+	default:
+	}
+}
+
+// 输出缓冲区占用的字节数：等待写出的数据加上正在写出的数据。调用方需持有 outMu。
+func (c *redisClient) outputBufferSize() int64 {
+	return int64(len(c.outBuf)) + c.outWriting
+}
+
+// 判断输出缓冲区是否超过上限：超过硬上限，或者持续超过软上限达到指定秒数。调用方需持有 outMu。
+func (c *redisClient) checkClientOutputBufferLimits(limit clientBufferLimit) bool {
+	used := c.outputBufferSize()
+	if limit.hard > 0 && used >= limit.hard {
+		return true
+	}
+	if limit.soft == 0 || used < limit.soft {
+		c.obufSoftLimitReachedTime = time.Time{}
+		return false
+	}
+	if c.obufSoftLimitReachedTime.IsZero() {
+		c.obufSoftLimitReachedTime = time.Now()
+		return false
+	}
+	return time.Since(c.obufSoftLimitReachedTime) >= time.Duration(limit.softSeconds)*time.Second
+}
+
+// 超过输出缓冲区上限时丢弃未写出的数据并关闭连接，读取请求的协程随之出错退出，连接的协程负责清理。
+// 调用方需持有 outMu。
+func (c *redisClient) closeClientOnOutputBufferLimitReached() {
+	log.Printf("Client id=%d addr=%s closed for overcoming of output buffer limits.", c.id, c.addr)
+	atomic.AddInt64(&c.server.statClientOutbufLimitDisconnections, 1)
+	c.outClosed = true
+	c.outBuf = nil
+	c.conn.Close()
+}
+
+// 写出协程：把输出缓冲区中的数据写到连接上，stop 关闭后写出剩余的数据再退出。
+// 写入阻塞时回复继续在缓冲区中累积，由输出缓冲区上限限制。
+func (c *redisClient) writeLoop(stop <-chan struct{}, done chan<- struct{}) {
+	defer close(done)
+	for {
+		select {
+		case <-c.outNotify:
+			if !c.flushOutput() {
+				return
+			}
+		case <-stop:
+			c.flushOutput()
+			return
+		}
+	}
+}
+
+// 写出缓冲区中的全部数据，写入出错时关闭连接并返回 false。
+func (c *redisClient) flushOutput() bool {
+	c.outMu.Lock()
+	buf := c.outBuf
+	c.outBuf = c.outSpare[:0]
+	c.outSpare = nil
+	c.outWriting = int64(len(buf))
+	c.outMu.Unlock()
+	if len(buf) == 0 {
+		return true
+	}
+	c.server.db.flushAppendOnlyFile() // 回复中确认的写命令先写入 AOF
+	_, err := c.conn.Write(buf)
+
+	c.outMu.Lock()
+	defer c.outMu.Unlock()
+	c.outWriting = 0
+	if cap(buf) <= PROTO_REPLY_BUFFER_KEEP {
+		c.outSpare = buf[:0]
+	}
+	if err != nil {
+		c.outClosed = true
+		c.outBuf = nil
+		c.conn.Close()
+		return false
+	}
+	return true
+}
+
+// 返回一类客户端的输出缓冲区上限。
+func (s *redisServer) clientOutputBufferLimit(class int) clientBufferLimit {
+	limits := s.obufLimits.Load().(*[CLIENT_TYPE_COUNT]clientBufferLimit)
+	return limits[class]
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// 判断客户端是否因为超过输出缓冲区上限而被关闭。
+func outputClosed(c *redisClient) bool {
+	c.outMu.Lock()
+	defer c.outMu.Unlock()
+	return c.outClosed
+}
+
+// 对端不读取时回复在输出缓冲区中累积，超过硬上限立即断开连接并计入统计。
+func TestOutputBufferHardLimit(t *testing.T) {
+	chdirTemp(t)
+	s := newRedisServer(newRedisConfig())
+	c := newTestClient(t, s)
+	commandReply(c, "CONFIG", "SET", "client-output-buffer-limit", "normal 1kb 0 0")
+	commandReply(c, "SET", "big", strings.Repeat("x", 600))
+
+	c.processCommand([]string{"GET", "big"})
+	if outputClosed(c) {
+		t.Fatal("client closed below the hard limit")
+	}
+	c.processCommand([]string{"GET", "big"})
+	if !outputClosed(c) {
+		t.Fatal("client not closed after reaching the hard limit")
+	}
+	if n := atomic.LoadInt64(&s.statClientOutbufLimitDisconnections); n != 1 {
+		t.Errorf("client_output_buffer_limit_disconnections = %d, want 1", n)
+	}
+	if _, err := c.conn.Write([]byte("x")); err == nil {
+		t.Error("connection still open after reaching the hard limit")
+	}
+}
+
+// 超过软上限的时间达到 softSeconds 才断开，中途用量降到软上限以下会重新计时。
+func TestOutputBufferSoftLimit(t *testing.T) {
+	chdirTemp(t)
+	s := newRedisServer(newRedisConfig())
+	c := newTestClient(t, s)
+	commandReply(c, "CONFIG", "SET", "client-output-buffer-limit", "normal 0 1kb 10")
+	commandReply(c, "SET", "big", strings.Repeat("x", 2048))
+
+	c.processCommand([]string{"GET", "big"})
+	if outputClosed(c) {
+		t.Fatal("client closed as soon as it reached the soft limit")
+	}
+	if c.obufSoftLimitReachedTime.IsZero() {
+		t.Fatal("soft limit time not recorded")
+	}
+
+	// 用量降到软上限以下后重新计时
+	c.obufSoftLimitReachedTime = time.Now().Add(-time.Minute)
+	commandReply(c, "PING")
+	if !c.obufSoftLimitReachedTime.IsZero() {
+		t.Fatal("soft limit time not reset after usage dropped below the soft limit")
+	}
+	c.processCommand([]string{"GET", "big"})
+	c.processCommand([]string{"GET", "big"})
+	if outputClosed(c) {
+		t.Fatal("client closed before the soft limit window elapsed")
+	}
+
+	// 持续超过软上限达到 softSeconds 之后断开
+	c.obufSoftLimitReachedTime = time.Now().Add(-11 * time.Second)
+	c.processCommand([]string{"GET", "big"})
+	if !outputClosed(c) {
+		t.Fatal("client not closed after staying over the soft limit")
+	}
+	if n := atomic.LoadInt64(&s.statClientOutbufLimitDisconnections); n != 1 {
+		t.Errorf("client_output_buffer_limit_disconnections = %d, want 1", n)
+	}
+}
+
+// 订阅了频道的客户端按 pubsub 类别的上限检查，其他客户端按 normal 类别。
+func TestOutputBufferPubsubClass(t *testing.T) {
+	chdirTemp(t)
+	s := newRedisServer(newRedisConfig())
+	normal := newTestClient(t, s)
+	subscriber := newTestClient(t, s)
+	commandReply(normal, "CONFIG", "SET", "client-output-buffer-limit", "normal 0 0 0 pubsub 1kb 0 0")
+
+	if class := subscriber.outputBufferClass(); class != CLIENT_TYPE_NORMAL {
+		t.Fatalf("class before SUBSCRIBE = %d, want normal", class)
+	}
+	commandReply(subscriber, "SUBSCRIBE", "news")
+	if class := subscriber.outputBufferClass(); class != CLIENT_TYPE_PUBSUB {
+		t.Fatalf("class after SUBSCRIBE = %d, want pubsub", class)
+	}
+
+	message := strings.Repeat("x", 2048)
+	commandReply(normal, "SET", "big", message)
+	normal.processCommand([]string{"GET", "big"})
+	normal.processCommand([]string{"GET", "big"})
+	if outputClosed(normal) {
+		t.Error("normal client closed by the pubsub limit")
+	}
+	commandReply(normal, "PUBLISH", "news", message)
+	if !outputClosed(subscriber) {
+		t.Error("subscriber not closed after exceeding the pubsub limit")
+	}
+	if n := atomic.LoadInt64(&s.statClientOutbufLimitDisconnections); n != 1 {
+		t.Errorf("client_output_buffer_limit_disconnections = %d, want 1", n)
+	}
+}
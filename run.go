@@ -1,22 +1,56 @@
 package main
 
 import (
-    "fmt"
-    "log"
-    "net"
-    "time"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
 )
 
 func main() {
-	rdbFile := "dump.rdb" // RDB 持久化文件路径
-	aofFile := "appendonly.aof" // AOF 持久化文件路径
+	// 离线工具：redis-server check-rdb dump.rdb，或把可执行文件命名为 redis-check-rdb 使用
+	if code, ok := toolMain(os.Args); ok {
+		os.Exit(code)
+	}
+
+	// 读取配置文件和命令行参数，例如：redis-server redis.conf --port 6380
+	config := newRedisConfig()
+	if err := config.loadFromArgs(os.Args[1:]); err != nil {
+		log.Fatal(err)
+	}
+	if err := os.Chdir(config.dir); err != nil {
+		log.Fatal("Can't chdir to '", config.dir, "': ", err)
+	}
 
 	// 创建一个新的 Redis 服务器实例
-	server := newRedisServer("localhost", 6379, rdbFile, aofFile)
-	log.Println("Redis server started on :6379")
+	server := newRedisServer(config)
+	log.Println("Redis server started")
 
 	// 启动服务器
 	if err := server.start(); err != nil {
 		log.Fatal(err)
 	}
-}
\ No newline at end of file
+}
+
+// 按可执行文件名或第一个参数选择离线工具，不是离线工具时返回 false。
+func toolMain(args []string) (int, bool) {
+	name := filepath.Base(args[0])
+	switch {
+	case strings.Contains(name, "redis-check-rdb"):
+		return redisCheckRdbMain(args[0], args[1:]), true
+	case strings.Contains(name, "redis-check-aof"):
+		return redisCheckAofMain(args[0], args[1:]), true
+	case len(args) > 1 && args[1] == "check-rdb":
+		return redisCheckRdbMain(args[0]+" check-rdb", args[2:]), true
+	case len(args) > 1 && args[1] == "check-aof":
+		return redisCheckAofMain(args[0]+" check-aof", args[2:]), true
+	case len(args) > 1 && args[1] == "rdb-export":
+		return rdbExportMain(args[0]+" rdb-export", args[2:]), true
+	case len(args) > 1 && args[1] == "rdb-import":
+		return rdbImportMain(args[0]+" rdb-import", args[2:]), true
+	case len(args) > 1 && (args[1] == "--bigkeys" || args[1] == "--memkeys" || args[1] == "--hotkeys"):
+		return keystatsCliMain(args[1][2:], args[0]+" "+args[1], args[2:]), true
+	}
+	return 0, false
+}
@@ -0,0 +1,576 @@
+package main
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	lua "github.com/yuin/gopher-lua"
+	"github.com/yuin/gopher-lua/parse"
+)
+
+// redis.log 的日志级别
+const (
+	LL_DEBUG   = 0
+	LL_VERBOSE = 1
+	LL_NOTICE  = 2
+	LL_WARNING = 3
+)
+
+// scriptRunCtx 记录正在执行的脚本。
+type scriptRunCtx struct {
+	name      string       // EVAL 脚本的 SHA1 或函数名
+	function  bool         // 由 FCALL 或 FCALL_RO 执行的函数
+	command   []string     // 执行脚本的命令，用于 FUNCTION STATS
+	caller    *redisClient // 执行脚本的客户端，脚本中读取和修改的键记在它的名下
+	start     time.Time
+	threshold time.Duration // busy-reply-threshold，超过后其他命令返回 BUSY
+	readonly  bool          // 由 EVAL_RO 或 EVALSHA_RO 执行，或者函数带有 no-writes 标志，不允许写命令
+	allowOOM  bool          // 函数带有 allow-oom 标志，内存超过上限时仍然可以执行写命令
+	oom       bool          // 开始执行时内存超过上限，不允许可能增加内存的命令
+	wrote     bool          // 已经执行过写命令，此后不能再被 SCRIPT KILL 终止
+	timedOut  bool          // 已经超过 busy-reply-threshold
+	killed    bool          // 被 SCRIPT KILL 终止
+	cancel    context.CancelFunc
+}
+
+// luaScripting 是服务器的 Lua 脚本环境：脚本缓存、函数库、Lua 虚拟机和正在执行的脚本。
+// 脚本、函数和修改函数库的命令以 CMD_EXCLUSIVE 执行，虚拟机和伪客户端只会被一个协程使用；
+// 缓存、函数库和执行状态由 mu 保护，可以在脚本执行期间被 SCRIPT 和 FUNCTION 命令访问。
+type luaScripting struct {
+	server *redisServer
+	L      *lua.LState  // EVAL 使用的虚拟机，第一次执行脚本时创建
+	fL     *lua.LState  // 函数库使用的虚拟机，第一次加载函数库时创建
+	client *redisClient // 执行 redis.call 的伪客户端
+
+	mu               sync.Mutex
+	scripts          map[string]*lua.FunctionProto // 按 SHA1 保存编译好的脚本
+	libraries        map[string]*functionLibrary   // 按库名保存编译好的函数库
+	functions        map[string]*functionInfo      // 所有函数库中的函数，函数名全局唯一
+	functionsVersion int64                         // 已编译的函数库对应的数据库函数库版本号
+	running          *scriptRunCtx                 // 正在执行的脚本，没有时为 nil
+}
+
+func newLuaScripting(s *redisServer) *luaScripting {
+	return &luaScripting{
+		server:    s,
+		scripts:   make(map[string]*lua.FunctionProto),
+		libraries: make(map[string]*functionLibrary),
+		functions: make(map[string]*functionInfo),
+	}
+}
+
+// 返回字符串的 SHA1 十六进制表示。
+func sha1hex(s string) string {
+	sum := sha1.Sum([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+// 编译脚本并加入缓存，返回脚本的 SHA1。
+func (ls *luaScripting) createScript(body string) (string, *lua.FunctionProto, error) {
+	sha := sha1hex(body)
+	ls.mu.Lock()
+	proto := ls.scripts[sha]
+	ls.mu.Unlock()
+	if proto != nil {
+		return sha, proto, nil
+	}
+	chunk, err := parse.Parse(strings.NewReader(body), "@user_script")
+	if err != nil {
+		return "", nil, err
+	}
+	proto, err = lua.Compile(chunk, "@user_script")
+	if err != nil {
+		return "", nil, err
+	}
+	ls.mu.Lock()
+	ls.scripts[sha] = proto
+	ls.mu.Unlock()
+	return sha, proto, nil
+}
+
+// 按 SHA1 查找缓存的脚本。
+func (ls *luaScripting) lookupScript(sha string) *lua.FunctionProto {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	return ls.scripts[strings.ToLower(sha)]
+}
+
+// 清空脚本缓存。
+func (ls *luaScripting) flush() {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	ls.scripts = make(map[string]*lua.FunctionProto)
+}
+
+// 判断是否有脚本执行超过了 busy-reply-threshold，第一次超过时记录日志。
+func (ls *luaScripting) timedOut() bool {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	rctx := ls.running
+	if rctx == nil || time.Since(rctx.start) < rctx.threshold {
+		return false
+	}
+	if !rctx.timedOut {
+		rctx.timedOut = true
+		what := "Script SHA1"
+		if rctx.function {
+			what = "Script name"
+		}
+		log.Printf("Slow script detected: still in execution after %d milliseconds. You can try killing the script using the %s command. %s is: %s",
+			time.Since(rctx.start).Milliseconds(), rctx.killCommand(), what, rctx.name)
+	}
+	return true
+}
+
+// 返回终止这个脚本使用的命令。
+func (rctx *scriptRunCtx) killCommand() string {
+	if rctx.function {
+		return "FUNCTION KILL"
+	}
+	return "SCRIPT KILL"
+}
+
+// 脚本执行超时后其他命令得到的错误。
+func (ls *luaScripting) busyError() string {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	kill := "SCRIPT KILL"
+	if ls.running != nil {
+		kill = ls.running.killCommand()
+	}
+	return fmt.Sprintf("BUSY Redis is busy running a script. You can only call %s or SHUTDOWN NOSAVE.", kill)
+}
+
+// 终止正在执行的脚本，function 表示由 FUNCTION KILL 调用，只能终止函数，SCRIPT KILL 只能终止 EVAL 脚本。
+// 已经执行过写命令的脚本不能终止。
+func (ls *luaScripting) kill(function bool) Reply {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	switch {
+	case ls.running == nil || ls.running.function != function:
+		return &ErrorReply{Value: "NOTBUSY No scripts in execution right now."}
+	case ls.running.wrote:
+		return &ErrorReply{Value: "UNKILLABLE Sorry the script already executed write commands against the dataset. You can either wait the script termination or kill the server in a hard way using the SHUTDOWN NOSAVE command."}
+	}
+	ls.running.killed = true
+	ls.running.cancel()
+	return &SimpleStringReply{Value: "OK"}
+}
+
+// 创建 Lua 虚拟机：只加载 base、table、string 和 math 库，注册 redis 库，并禁止脚本创建或访问未定义的全局变量。
+func (ls *luaScripting) createState() *lua.LState {
+	L := lua.NewState(lua.Options{SkipOpenLibs: true})
+	for _, lib := range []struct {
+		name string
+		open lua.LGFunction
+	}{
+		{lua.BaseLibName, lua.OpenBase},
+		{lua.TabLibName, lua.OpenTable},
+		{lua.StringLibName, lua.OpenString},
+		{lua.MathLibName, lua.OpenMath},
+	} {
+		L.Push(L.NewFunction(lib.open))
+		L.Push(lua.LString(lib.name))
+		L.Call(1, 0)
+	}
+	// 去掉可以访问文件系统或加载模块的函数
+	for _, name := range []string{"dofile", "loadfile", "module", "require"} {
+		L.SetGlobal(name, lua.LNil)
+	}
+
+	redis := L.NewTable()
+	L.SetFuncs(redis, map[string]lua.LGFunction{
+		"call":  func(L *lua.LState) int { return ls.redisCall(L, true) },
+		"pcall": func(L *lua.LState) int { return ls.redisCall(L, false) },
+		"error_reply": func(L *lua.LState) int {
+			L.Push(luaStatusTable(L, "err", L.CheckString(1)))
+			return 1
+		},
+		"status_reply": func(L *lua.LState) int {
+			L.Push(luaStatusTable(L, "ok", L.CheckString(1)))
+			return 1
+		},
+		"sha1hex": func(L *lua.LState) int {
+			L.Push(lua.LString(sha1hex(L.CheckString(1))))
+			return 1
+		},
+		"log": luaLog,
+		// 脚本总是以效果的形式写入 AOF，保留这两个函数只是为了兼容旧脚本
+		"replicate_commands": func(L *lua.LState) int {
+			L.Push(lua.LTrue)
+			return 1
+		},
+		"set_repl": func(L *lua.LState) int { return 0 },
+	})
+	for name, v := range map[string]int{
+		"LOG_DEBUG": LL_DEBUG, "LOG_VERBOSE": LL_VERBOSE, "LOG_NOTICE": LL_NOTICE, "LOG_WARNING": LL_WARNING,
+		"REPL_NONE": 0, "REPL_AOF": 1, "REPL_SLAVE": 2, "REPL_REPLICA": 2, "REPL_ALL": 3,
+	} {
+		redis.RawSetString(name, lua.LNumber(v))
+	}
+	L.SetGlobal("redis", redis)
+
+	mt := L.NewTable()
+	L.SetField(mt, "__newindex", L.NewFunction(func(L *lua.LState) int {
+		L.RaiseError("Script attempted to create global variable '%s'", L.ToString(2))
+		return 0
+	}))
+	L.SetField(mt, "__index", L.NewFunction(func(L *lua.LState) int {
+		L.RaiseError("Script attempted to access nonexistent global variable '%s'", L.ToString(2))
+		return 0
+	}))
+	L.SetMetatable(L.G.Global, mt)
+	return L
+}
+
+// redis.log(level, message, ...)
+func luaLog(L *lua.LState) int {
+	if L.GetTop() < 2 {
+		L.RaiseError("redis.log() requires two arguments or more.")
+	}
+	parts := make([]string, 0, L.GetTop()-1)
+	for i := 2; i <= L.GetTop(); i++ {
+		parts = append(parts, L.ToString(i))
+	}
+	log.Printf("<script> %s", strings.Join(parts, " "))
+	return 0
+}
+
+// 返回 {field = msg} 形式的表，用于表示状态回复和错误回复。
+func luaStatusTable(L *lua.LState, field, msg string) *lua.LTable {
+	t := L.NewTable()
+	t.RawSetString(field, lua.LString(msg))
+	return t
+}
+
+// redis.call 和 redis.pcall：执行一条命令并把回复转换为 Lua 值。
+// 出错时 redis.call 抛出 {err = msg} 形式的错误，redis.pcall 把它作为返回值。
+func (ls *luaScripting) redisCall(L *lua.LState, raise bool) int {
+	fail := func(msg string) int {
+		t := luaStatusTable(L, "err", msg)
+		if raise {
+			L.Error(t, 1)
+		}
+		L.Push(t)
+		return 1
+	}
+
+	argc := L.GetTop()
+	if argc == 0 {
+		return fail("ERR Please specify at least one argument for this redis lib call")
+	}
+	args := make([]string, argc)
+	for i := 1; i <= argc; i++ {
+		switch v := L.Get(i).(type) {
+		case lua.LString:
+			args[i-1] = string(v)
+		case lua.LNumber:
+			args[i-1] = v.String()
+		default:
+			return fail("ERR Lua redis lib command arguments must be strings or integers")
+		}
+	}
+
+	cmd := lookupCommandArgs(args)
+	switch {
+	case cmd == nil:
+		return fail("ERR Unknown Redis command called from script")
+	case (cmd.arity > 0 && argc != cmd.arity) || argc < -cmd.arity:
+		return fail("ERR Wrong number of args calling Redis command from script")
+	case cmd.flags&CMD_NOSCRIPT != 0:
+		return fail("ERR This Redis command is not allowed from script")
+	}
+
+	ls.mu.Lock()
+	rctx := ls.running
+	ls.mu.Unlock()
+	if cmd.flags&CMD_WRITE != 0 {
+		if rctx.readonly {
+			return fail("ERR Write commands are not allowed from read-only scripts.")
+		}
+		if rctx.oom && !rctx.allowOOM && cmd.flags&CMD_DENYOOM != 0 {
+			return fail("OOM command not allowed when used memory > 'maxmemory'.")
+		}
+		// 脚本以效果的形式写入 AOF：第一条写命令之前写入 MULTI，脚本结束后写入 EXEC
+		ls.mu.Lock()
+		wrote := rctx.wrote
+		rctx.wrote = true
+		ls.mu.Unlock()
+		if !wrote {
+			ls.server.db.propagateMulti()
+		}
+	}
+
+	ls.client.replies = nil
+	ls.client.call(cmd, args, rctx.caller)
+	reply := ls.client.replies[len(ls.client.replies)-1]
+	if er, ok := reply.(*ErrorReply); ok {
+		return fail(er.Value)
+	}
+	L.Push(replyToLua(L, reply))
+	return 1
+}
+
+// 执行 EVAL 脚本并返回脚本的回复。
+func (ls *luaScripting) run(caller *redisClient, sha string, proto *lua.FunctionProto, keys, argv []string, readonly bool) Reply {
+	if ls.L == nil {
+		ls.L = ls.createState()
+	}
+	L := ls.L
+	L.G.Global.RawSetString("KEYS", luaStringArray(L, keys))
+	L.G.Global.RawSetString("ARGV", luaStringArray(L, argv))
+	return ls.execute(L, &scriptRunCtx{name: sha, caller: caller, readonly: readonly}, L.NewFunctionFromProto(proto))
+}
+
+// 在 L 中以 args 为参数调用 fn 并返回它的回复，执行期间 rctx 作为正在执行的脚本，
+// 可以被 SCRIPT KILL 或 FUNCTION KILL 终止。
+func (ls *luaScripting) execute(L *lua.LState, rctx *scriptRunCtx, fn *lua.LFunction, args ...lua.LValue) Reply {
+	if ls.client == nil {
+		ls.client = newRedisClient(nil, ls.server)
+		ls.client.flags |= CLIENT_SCRIPT
+	}
+	// 脚本中的命令与执行脚本的客户端一样遵循 CLIENT NO-TOUCH
+	ls.client.flags &^= CLIENT_NO_TOUCH
+	if rctx.caller != nil {
+		ls.client.flags |= rctx.caller.flags & CLIENT_NO_TOUCH
+	}
+	s := ls.server
+	s.mu.Lock()
+	threshold := time.Duration(s.config.busyReplyThreshold) * time.Millisecond
+	s.mu.Unlock()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	rctx.start = time.Now()
+	rctx.threshold = threshold
+	rctx.oom = !s.performEvictions()
+	rctx.cancel = cancel
+	if threshold == 0 {
+		rctx.threshold = time.Duration(1<<63 - 1) // 0 表示不限制
+	}
+	ls.mu.Lock()
+	ls.running = rctx
+	ls.mu.Unlock()
+	L.SetContext(ctx)
+	defer func() {
+		L.RemoveContext()
+		cancel()
+		L.SetTop(0)
+		ls.mu.Lock()
+		ls.running = nil
+		ls.mu.Unlock()
+		if rctx.wrote {
+			s.db.propagateExec()
+		}
+		if rctx.timedOut {
+			log.Printf("Script %s finished after %d milliseconds", rctx.name, time.Since(rctx.start).Milliseconds())
+		}
+	}()
+
+	L.Push(fn)
+	for _, arg := range args {
+		L.Push(arg)
+	}
+	if err := L.PCall(len(args), 1, nil); err != nil {
+		ls.mu.Lock()
+		killed := rctx.killed
+		ls.mu.Unlock()
+		if killed {
+			return &ErrorReply{Value: fmt.Sprintf("ERR Script killed by user with %s...", rctx.killCommand())}
+		}
+		msg := err.Error()
+		var apiErr *lua.ApiError
+		if errors.As(err, &apiErr) {
+			if t, ok := apiErr.Object.(*lua.LTable); ok {
+				if msg, ok := t.RawGetString("err").(lua.LString); ok {
+					return &ErrorReply{Value: string(msg)}
+				}
+			}
+			msg = apiErr.Object.String()
+		}
+		if rctx.function {
+			return &ErrorReply{Value: fmt.Sprintf("ERR Error running function %s: %s", rctx.name, msg)}
+		}
+		return &ErrorReply{Value: fmt.Sprintf("ERR Error running script (call to f_%s): %s", rctx.name, msg)}
+	}
+	return luaToReply(L.Get(-1))
+}
+
+// 把字符串数组转换为 Lua 数组。
+func luaStringArray(L *lua.LState, items []string) *lua.LTable {
+	t := L.CreateTable(len(items), 0)
+	for _, item := range items {
+		t.Append(lua.LString(item))
+	}
+	return t
+}
+
+// 按 Redis 的规则把回复转换为 Lua 值：整数为数字，批量字符串为字符串，nil 为 false，
+// 数组为表，状态回复和错误回复分别为 {ok = ...} 和 {err = ...}。
+func replyToLua(L *lua.LState, r Reply) lua.LValue {
+	switch v := r.(type) {
+	case *IntegerReply:
+		return lua.LNumber(v.Value)
+	case *BulkStringReply:
+		return lua.LString(v.Value)
+	case *NullBulkReply:
+		return lua.LFalse
+	case *SimpleStringReply:
+		return luaStatusTable(L, "ok", v.Value)
+	case *ErrorReply:
+		return luaStatusTable(L, "err", v.Value)
+	case *ArrayReply:
+		t := L.CreateTable(len(v.Value), 0)
+		for _, item := range v.Value {
+			t.Append(replyToLua(L, item))
+		}
+		return t
+	}
+	return lua.LFalse
+}
+
+// 按 Redis 的规则把 Lua 值转换为回复：数字截断为整数，true 为 1，false 和 nil 为 nil，
+// 带 err 或 ok 字段的表为错误回复或状态回复，其他表按数组转换，遇到第一个 nil 为止。
+func luaToReply(v lua.LValue) Reply {
+	switch v := v.(type) {
+	case lua.LString:
+		return &BulkStringReply{Value: string(v)}
+	case lua.LNumber:
+		return &IntegerReply{Value: int64(v)}
+	case lua.LBool:
+		if v {
+			return &IntegerReply{Value: 1}
+		}
+	case *lua.LTable:
+		if msg, ok := v.RawGetString("err").(lua.LString); ok {
+			return &ErrorReply{Value: string(msg)}
+		}
+		if msg, ok := v.RawGetString("ok").(lua.LString); ok {
+			return &SimpleStringReply{Value: string(msg)}
+		}
+		var items []Reply
+		for i := 1; ; i++ {
+			item := v.RawGetInt(i)
+			if item == lua.LNil {
+				break
+			}
+			items = append(items, luaToReply(item))
+		}
+		return &ArrayReply{Value: items}
+	}
+	return &NullReply{}
+}
+
+// 解析 EVAL 和 FCALL 的 numkeys 参数，把其后的参数分为键和其他参数，出错时返回错误回复。
+func scriptGetKeys(args []string) (keys, argv []string, errReply string) {
+	numkeys, err := strconv.Atoi(args[2])
+	switch {
+	case err != nil:
+		return nil, nil, "ERR value is not an integer or out of range"
+	case numkeys > len(args)-3:
+		return nil, nil, "ERR Number of keys can't be greater than number of args"
+	case numkeys < 0:
+		return nil, nil, "ERR Number of keys can't be negative"
+	}
+	return args[3 : 3+numkeys], args[3+numkeys:], ""
+}
+
+// EVAL、EVALSHA 以及只读版本的公共部分：解析 numkeys、找到脚本并执行。
+func evalGenericCommand(c *redisClient, args []string, evalsha, readonly bool) {
+	keys, argv, errReply := scriptGetKeys(args)
+	if errReply != "" {
+		c.writeError(errReply)
+		return
+	}
+
+	ls := c.server.lua
+	var sha string
+	var proto *lua.FunctionProto
+	var err error
+	if evalsha {
+		sha = strings.ToLower(args[1])
+		if proto = ls.lookupScript(sha); proto == nil {
+			c.writeError("NOSCRIPT No matching script. Please use EVAL.")
+			return
+		}
+	} else {
+		sha, proto, err = ls.createScript(args[1])
+		if err != nil {
+			c.writeError(fmt.Sprintf("ERR Error compiling script (new function): %v", err))
+			return
+		}
+	}
+	c.writeReply(ls.run(c, sha, proto, keys, argv, readonly))
+}
+
+// EVAL script numkeys [key ...] [arg ...]
+func evalCommand(c *redisClient, args []string) {
+	evalGenericCommand(c, args, false, false)
+}
+
+// EVALSHA sha1 numkeys [key ...] [arg ...]
+func evalShaCommand(c *redisClient, args []string) {
+	evalGenericCommand(c, args, true, false)
+}
+
+// EVAL_RO script numkeys [key ...] [arg ...]
+func evalRoCommand(c *redisClient, args []string) {
+	evalGenericCommand(c, args, false, true)
+}
+
+// EVALSHA_RO sha1 numkeys [key ...] [arg ...]
+func evalShaRoCommand(c *redisClient, args []string) {
+	evalGenericCommand(c, args, true, true)
+}
+
+// SCRIPT LOAD|EXISTS|FLUSH|KILL|HELP
+func scriptCommand(c *redisClient, args []string) {
+	ls := c.server.lua
+	switch sub := strings.ToUpper(args[1]); {
+	case sub == "LOAD" && len(args) == 3:
+		sha, _, err := ls.createScript(args[2])
+		if err != nil {
+			c.writeError(fmt.Sprintf("ERR Error compiling script (new function): %v", err))
+			return
+		}
+		c.writeBulk(sha)
+	case sub == "EXISTS" && len(args) >= 3:
+		exists := make([]Reply, len(args)-2)
+		for i, sha := range args[2:] {
+			exists[i] = &IntegerReply{Value: int64(boolToInt(ls.lookupScript(sha) != nil))}
+		}
+		c.writeReply(&ArrayReply{Value: exists})
+	case sub == "FLUSH" && len(args) <= 3:
+		if len(args) == 3 && !strings.EqualFold(args[2], "SYNC") && !strings.EqualFold(args[2], "ASYNC") {
+			c.writeError("ERR SCRIPT FLUSH only support SYNC|ASYNC option")
+			return
+		}
+		ls.flush()
+		c.writeStatus("OK")
+	case sub == "KILL" && len(args) == 2:
+		c.writeReply(ls.kill(false))
+	case sub == "HELP" && len(args) == 2:
+		c.writeHelp([]string{
+			"SCRIPT <subcommand> [<arg> [value] [opt] ...]. Subcommands are:",
+			"EXISTS <sha1> [<sha1> ...]",
+			"    Return information about the existence of the scripts in the script cache.",
+			"FLUSH [ASYNC|SYNC]",
+			"    Flush the Lua scripts cache.",
+			"KILL",
+			"    Kill the currently executing Lua script.",
+			"LOAD <script>",
+			"    Load a script into the scripts cache without executing it.",
+			"HELP",
+			"    Print this help.",
+		})
+	default:
+		c.writeError(fmt.Sprintf("ERR unknown subcommand or wrong number of arguments for '%s'. Try SCRIPT HELP.", args[1]))
+	}
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// 上一次 BGSAVE 失败后，自动保存至少间隔这么久才会重试
+const CONFIG_BGSAVE_RETRY_DELAY = 5 * time.Second
+
+var errBgsaveInProgress = errors.New("ERR Background save already in progress")
+
+// 判断是否有后台持久化任务正在运行，调用方需持有 s.mu。
+func (s *redisServer) hasActiveChild() bool {
+	return s.rdbChildRunning || s.rdbSaveRunning || s.aofChildRunning
+}
+
+// 判断是否有 RDB 保存正在进行（前台或后台），调用方需持有 s.mu。
+func (s *redisServer) rdbSaveInProgress() bool {
+	return s.rdbChildRunning || s.rdbSaveRunning
+}
+
+// 在前台保存 RDB 文件，保存期间阻塞所有写操作。已经有 RDB 保存在进行时返回 errBgsaveInProgress。
+func (s *redisServer) rdbSave() error {
+	s.mu.Lock()
+	if s.rdbSaveInProgress() {
+		s.mu.Unlock()
+		return errBgsaveInProgress
+	}
+	s.rdbSaveRunning = true
+	s.mu.Unlock()
+
+	err := s.db.saveRDB()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rdbSaveRunning = false
+	if err != nil {
+		fmt.Println("Error saving DB on disk:", err)
+		return err
+	}
+	s.lastSave = time.Now()
+	s.lastBgsaveOK = true
+	s.rdbSaves++
+	fmt.Println("DB saved on disk")
+	return nil
+}
+
+// 启动后台保存：先复制一份时间点一致的数据，再在新的协程中写入 RDB 文件，调用方需持有 s.mu。
+func (s *redisServer) rdbSaveBackground() error {
+	if s.rdbSaveInProgress() {
+		return errBgsaveInProgress
+	}
+	data, expires, functions, dirty := s.db.snapshot()
+	filename := s.db.rdbFilename()
+	s.lastBgsaveTry = time.Now()
+	s.rdbChildRunning = true
+	s.rdbSaveTimeStart = time.Now()
+	fmt.Println("Background saving started")
+
+	go func() {
+		err := rdbSaveFile(filename, data, expires, functions)
+		s.backgroundSaveDone(err, dirty)
+	}()
+	return nil
+}
+
+// 后台保存结束后更新持久化状态。
+func (s *redisServer) backgroundSaveDone(err error, dirty int64) {
+	if err == nil {
+		s.db.clearDirty(dirty)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rdbChildRunning = false
+	s.rdbSaveTimeLast = time.Since(s.rdbSaveTimeStart)
+	if err != nil {
+		fmt.Println("Background saving error:", err)
+		s.lastBgsaveOK = false
+		return
+	}
+	fmt.Println("Background saving terminated with success")
+	s.lastSave = time.Now()
+	s.lastBgsaveOK = true
+	s.rdbSaves++
+}
+
+// 由 serverCron 调用：执行被推迟的 BGSAVE，并检查 save 规则是否满足。
+func (s *redisServer) rdbCron() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.hasActiveChild() {
+		return
+	}
+
+	now := time.Now()
+	canRetry := s.lastBgsaveOK || now.Sub(s.lastBgsaveTry) > CONFIG_BGSAVE_RETRY_DELAY
+	if s.rdbBgsaveScheduled && canRetry {
+		if s.rdbSaveBackground() == nil {
+			s.rdbBgsaveScheduled = false
+		}
+		return
+	}
+
+	dirty := s.db.dirtyCount()
+	for _, sp := range s.config.saveParams {
+		if dirty >= int64(sp.changes) && now.Sub(s.lastSave) > time.Duration(sp.seconds)*time.Second && canRetry {
+			fmt.Printf("%d changes in %d seconds. Saving...\n", sp.changes, sp.seconds)
+			s.rdbSaveBackground()
+			break
+		}
+	}
+}
+
+// SAVE：在前台同步保存。
+func saveCommand(c *redisClient, args []string) {
+	if len(args) != 1 {
+		c.writeError("ERR wrong number of arguments for 'SAVE' command")
+		return
+	}
+	if err := c.server.rdbSave(); err != nil {
+		if err == errBgsaveInProgress {
+			c.writeError(err.Error())
+		} else {
+			c.writeError("ERR " + err.Error())
+		}
+		return
+	}
+	c.writeStatus("OK")
+}
+
+// BGSAVE [SCHEDULE]：在后台保存；有其他后台任务时，带 SCHEDULE 会等它结束后再执行。
+func bgsaveCommand(c *redisClient, args []string) {
+	schedule := false
+	if len(args) > 1 {
+		if len(args) == 2 && strings.ToUpper(args[1]) == "SCHEDULE" {
+			schedule = true
+		} else {
+			c.writeError("ERR syntax error")
+			return
+		}
+	}
+
+	s := c.server
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch {
+	case s.rdbSaveInProgress():
+		c.writeError(errBgsaveInProgress.Error())
+	case s.hasActiveChild():
+		if !schedule {
+			c.writeError("ERR Another child process is active (AOF?): can't BGSAVE right now. " +
+				"Use BGSAVE SCHEDULE in order to schedule a BGSAVE whenever possible.")
+			return
+		}
+		s.rdbBgsaveScheduled = true
+		c.writeStatus("Background saving scheduled")
+	default:
+		if err := s.rdbSaveBackground(); err != nil {
+			c.writeError(err.Error())
+			return
+		}
+		c.writeStatus("Background saving started")
+	}
+}
+
+// LASTSAVE：返回最近一次成功保存的 UNIX 时间戳。
+func lastsaveCommand(c *redisClient, args []string) {
+	if len(args) != 1 {
+		c.writeError("ERR wrong number of arguments for 'LASTSAVE' command")
+		return
+	}
+	c.server.mu.Lock()
+	lastSave := c.server.lastSave.Unix()
+	c.server.mu.Unlock()
+	c.writeInteger(lastSave)
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// tls-protocols 中的协议名称
+var tlsProtocolVersions = map[string]uint16{
+	"tlsv1":   tls.VersionTLS10,
+	"tlsv1.1": tls.VersionTLS11,
+	"tlsv1.2": tls.VersionTLS12,
+	"tlsv1.3": tls.VersionTLS13,
+}
+
+// tls-auth-clients 的取值
+const (
+	TLS_CLIENT_AUTH_NO       = "no"       // 不要求客户端证书
+	TLS_CLIENT_AUTH_YES      = "yes"      // 要求并验证客户端证书（双向 TLS）
+	TLS_CLIENT_AUTH_OPTIONAL = "optional" // 客户端提供证书时验证
+)
+
+// 解析 tls-protocols，例如 "TLSv1.2 TLSv1.3"，返回允许的最低和最高版本。为空时使用 TLSv1.2 和 TLSv1.3。
+// Go 只能设置版本范围，列出的版本不连续时中间的版本同样被允许。
+func parseTLSProtocols(value string) (min, max uint16, err error) {
+	fields := strings.Fields(value)
+	if len(fields) == 0 {
+		return tls.VersionTLS12, tls.VersionTLS13, nil
+	}
+	for _, name := range fields {
+		v, ok := tlsProtocolVersions[strings.ToLower(name)]
+		if !ok {
+			return 0, 0, fmt.Errorf("invalid tls-protocols specified '%s'", name)
+		}
+		if min == 0 || v < min {
+			min = v
+		}
+		if v > max {
+			max = v
+		}
+	}
+	return min, max, nil
+}
+
+// 解析 tls-ciphers：以冒号分隔的 TLSv1.2 及以下版本的加密套件，可以使用 IANA 名称
+// （TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256）或 OpenSSL 名称（ECDHE-RSA-AES128-GCM-SHA256）。
+// TLSv1.3 的加密套件由 Go 决定，不能配置。为空时使用 Go 的默认值。
+func parseTLSCiphers(value string) ([]uint16, error) {
+	if value == "" {
+		return nil, nil
+	}
+	var ids []uint16
+	for _, name := range strings.Split(value, ":") {
+		id, ok := lookupCipherSuite(strings.TrimSpace(name))
+		if !ok {
+			return nil, fmt.Errorf("unknown cipher suite '%s'", name)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// OpenSSL 名称与 Go 支持的 TLSv1.2 加密套件的对应关系
+var opensslCipherNames = map[string]uint16{
+	"ECDHE-ECDSA-AES128-GCM-SHA256": tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+	"ECDHE-RSA-AES128-GCM-SHA256":   tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+	"ECDHE-ECDSA-AES256-GCM-SHA384": tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+	"ECDHE-RSA-AES256-GCM-SHA384":   tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+	"ECDHE-ECDSA-CHACHA20-POLY1305": tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256,
+	"ECDHE-RSA-CHACHA20-POLY1305":   tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256,
+	"ECDHE-ECDSA-AES128-SHA":        tls.TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA,
+	"ECDHE-RSA-AES128-SHA":          tls.TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA,
+	"ECDHE-ECDSA-AES256-SHA":        tls.TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA,
+	"ECDHE-RSA-AES256-SHA":          tls.TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA,
+}
+
+// 按名称查找 Go 支持的加密套件，不包括不安全的套件。
+func lookupCipherSuite(name string) (uint16, bool) {
+	if id, ok := opensslCipherNames[strings.ToUpper(name)]; ok {
+		return id, true
+	}
+	for _, cs := range tls.CipherSuites() {
+		if strings.EqualFold(cs.Name, name) {
+			return cs.ID, true
+		}
+	}
+	return 0, false
+}
+
+// 按配置创建 TLS 配置：加载证书和私钥，需要验证客户端证书时加载 CA 证书。
+func newTLSConfig(cfg *redisConfig) (*tls.Config, error) {
+	if cfg.tlsCertFile == "" || cfg.tlsKeyFile == "" {
+		return nil, errors.New("tls-cert-file and tls-key-file must be specified")
+	}
+	cert, err := tls.LoadX509KeyPair(cfg.tlsCertFile, cfg.tlsKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load certificate %s or private key %s: %w", cfg.tlsCertFile, cfg.tlsKeyFile, err)
+	}
+	min, max, err := parseTLSProtocols(cfg.tlsProtocols)
+	if err != nil {
+		return nil, err
+	}
+	ciphers, err := parseTLSCiphers(cfg.tlsCiphers)
+	if err != nil {
+		return nil, err
+	}
+	conf := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   min,
+		MaxVersion:   max,
+		CipherSuites: ciphers,
+	}
+	switch cfg.tlsAuthClients {
+	case TLS_CLIENT_AUTH_YES:
+		conf.ClientAuth = tls.RequireAndVerifyClientCert
+	case TLS_CLIENT_AUTH_OPTIONAL:
+		conf.ClientAuth = tls.VerifyClientCertIfGiven
+	default:
+		conf.ClientAuth = tls.NoClientCert
+	}
+	if conf.ClientAuth != tls.NoClientCert {
+		if cfg.tlsCACertFile == "" {
+			return nil, errors.New("tls-ca-cert-file must be specified when tls-auth-clients is enabled")
+		}
+		pem, err := os.ReadFile(cfg.tlsCACertFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load CA certificate(s) file %s: %w", cfg.tlsCACertFile, err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no CA certificates found in %s", cfg.tlsCACertFile)
+		}
+		conf.ClientCAs = pool
+	}
+	return conf, nil
+}
+
+// 按当前配置重新创建 TLS 配置，之后接受的连接使用新的证书，已有的连接不受影响。
+// 没有开启 TLS 时什么都不做。调用方需持有 s.mu（启动时除外）。
+func (s *redisServer) applyTLSConfig() error {
+	if s.config.tlsPort == 0 {
+		return nil
+	}
+	conf, err := newTLSConfig(s.config)
+	if err != nil {
+		return fmt.Errorf("Unable to update TLS configuration: %w", err)
+	}
+	s.tlsConfig.Store(conf)
+	return nil
+}
@@ -0,0 +1,290 @@
+package main
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// 测试用的证书和私钥，PEM 文件保存在 dir 中。
+type testCert struct {
+	cert     *x509.Certificate
+	key      *ecdsa.PrivateKey
+	certFile string
+	keyFile  string
+}
+
+// 生成证书并写入 dir/name.crt 和 dir/name.key。parent 为 nil 时生成自签名的 CA 证书。
+func newTestCert(t *testing.T, dir, name string, parent *testCert, usage x509.ExtKeyUsage) *testCert {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}
+	signer, signerKey := tmpl, key
+	if parent == nil {
+		tmpl.IsCA = true
+		tmpl.BasicConstraintsValid = true
+		tmpl.KeyUsage |= x509.KeyUsageCertSign
+	} else {
+		tmpl.ExtKeyUsage = []x509.ExtKeyUsage{usage}
+		tmpl.IPAddresses = []net.IP{net.ParseIP("127.0.0.1")}
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &testCert{
+		cert:     cert,
+		key:      key,
+		certFile: filepath.Join(dir, name+".crt"),
+		keyFile:  filepath.Join(dir, name+".key"),
+	}
+	writePEM(t, c.certFile, "CERTIFICATE", der)
+	writePEM(t, c.keyFile, "EC PRIVATE KEY", keyDER)
+	return c
+}
+
+func writePEM(t *testing.T, filename, typ string, der []byte) {
+	t.Helper()
+	if err := os.WriteFile(filename, pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// 测试使用的一套证书：CA 签发的两张服务器证书和一张客户端证书，另一个 CA 签发的客户端证书。
+type testCerts struct {
+	ca, server, server2, client, untrusted *testCert
+}
+
+func newTestCerts(t *testing.T) *testCerts {
+	dir := t.TempDir()
+	ca := newTestCert(t, dir, "ca", nil, 0)
+	other := newTestCert(t, dir, "other-ca", nil, 0)
+	return &testCerts{
+		ca:        ca,
+		server:    newTestCert(t, dir, "server", ca, x509.ExtKeyUsageServerAuth),
+		server2:   newTestCert(t, dir, "server2", ca, x509.ExtKeyUsageServerAuth),
+		client:    newTestCert(t, dir, "client", ca, x509.ExtKeyUsageClientAuth),
+		untrusted: newTestCert(t, dir, "untrusted", other, x509.ExtKeyUsageClientAuth),
+	}
+}
+
+// 返回一个当前空闲的本地端口。
+func freePort(t *testing.T) int {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	return ln.Addr().(*net.TCPAddr).Port
+}
+
+// 在临时目录中启动同时监听明文端口和 TLS 端口的服务器，返回两个端口的地址。
+func startTLSServer(t *testing.T, certs *testCerts, authClients string) (s *redisServer, addr, tlsAddr string) {
+	t.Helper()
+	chdirTemp(t)
+	cfg := newRedisConfig()
+	cfg.bindaddr = []string{"127.0.0.1"}
+	cfg.port, cfg.tlsPort = freePort(t), freePort(t)
+	cfg.tlsCertFile, cfg.tlsKeyFile = certs.server.certFile, certs.server.keyFile
+	cfg.tlsCACertFile = certs.ca.certFile
+	cfg.tlsAuthClients = authClients
+	s = newRedisServer(cfg)
+	if err := s.initListeners(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(s.closeListeners)
+	go s.acceptLoop()
+	return s, net.JoinHostPort("127.0.0.1", strconv.Itoa(cfg.port)), net.JoinHostPort("127.0.0.1", strconv.Itoa(cfg.tlsPort))
+}
+
+// 信任测试 CA 的客户端 TLS 配置，client 不为 nil 时出示客户端证书。
+// 不使用 Certificates：Go 只发送服务器接受的 CA 签发的证书，无法测试出示不可信证书的情况。
+func clientTLSConfig(certs *testCerts, client *testCert) *tls.Config {
+	pool := x509.NewCertPool()
+	pool.AddCert(certs.ca.cert)
+	conf := &tls.Config{RootCAs: pool}
+	if client != nil {
+		cert := &tls.Certificate{Certificate: [][]byte{client.cert.Raw}, PrivateKey: client.key}
+		conf.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) { return cert, nil }
+	}
+	return conf
+}
+
+// 发送一条命令并读取回复。
+func sendCommand(conn net.Conn, args ...string) (RESPValue, error) {
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	cmd := AppendAggregateLen(nil, Array, len(args))
+	for _, arg := range args {
+		cmd = AppendBulkString(cmd, arg)
+	}
+	if _, err := conn.Write(cmd); err != nil {
+		return RESPValue{}, err
+	}
+	return ParseRESP(bufio.NewReader(conn))
+}
+
+// 建立 TLS 连接并执行 PING。TLSv1.3 中服务器在握手之后才验证客户端证书，失败在第一次读取时出现。
+func tlsPing(t *testing.T, addr string, conf *tls.Config) (*tls.Conn, error) {
+	t.Helper()
+	conn, err := tls.DialWithDialer(&net.Dialer{Timeout: 5 * time.Second}, "tcp", addr, conf)
+	if err != nil {
+		return nil, err
+	}
+	t.Cleanup(func() { conn.Close() })
+	if v, err := sendCommand(conn, "PING"); err != nil || v.Str != "PONG" {
+		return nil, &net.OpError{Op: "ping", Net: "tcp", Err: err}
+	}
+	return conn, nil
+}
+
+// 明文端口和 TLS 端口同时工作，两个端口上的客户端看到相同的数据；端口不接受另一种协议的连接。
+func TestTLSAndPlaintextSideBySide(t *testing.T) {
+	certs := newTestCerts(t)
+	_, addr, tlsAddr := startTLSServer(t, certs, TLS_CLIENT_AUTH_NO)
+
+	plain, err := net.DialTimeout("tcp", addr, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer plain.Close()
+	if v, err := sendCommand(plain, "SET", "k", "plaintext"); err != nil || v.Str != "OK" {
+		t.Fatalf("SET over plaintext: %+v, %v", v, err)
+	}
+	conn, err := tlsPing(t, tlsAddr, clientTLSConfig(certs, nil))
+	if err != nil {
+		t.Fatalf("TLS connection failed: %v", err)
+	}
+	if v, err := sendCommand(conn, "GET", "k"); err != nil || v.Str != "plaintext" {
+		t.Errorf("GET over TLS: %+v, %v", v, err)
+	}
+	if v, err := sendCommand(conn, "CLIENT", "INFO"); err != nil || !strings.Contains(v.Str, " laddr="+tlsAddr+" ") {
+		t.Errorf("CLIENT INFO over TLS: %+v, %v", v, err)
+	}
+
+	if _, err := tlsPing(t, addr, clientTLSConfig(certs, nil)); err == nil {
+		t.Error("TLS handshake with the plaintext port succeeded")
+	}
+	raw, err := net.DialTimeout("tcp", tlsAddr, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer raw.Close()
+	if v, err := sendCommand(raw, "PING"); err == nil && v.Str == "PONG" {
+		t.Error("plaintext PING on the TLS port succeeded")
+	}
+}
+
+// tls-auth-clients 为 yes 时必须出示可信的客户端证书，no 时不检查，optional 时只验证出示的证书。
+func TestTLSAuthClients(t *testing.T) {
+	tests := []struct {
+		authClients string
+		client      string // 客户端出示的证书："" 表示不出示
+		wantOK      bool
+	}{
+		{TLS_CLIENT_AUTH_YES, "", false},
+		{TLS_CLIENT_AUTH_YES, "client", true},
+		{TLS_CLIENT_AUTH_YES, "untrusted", false},
+		{TLS_CLIENT_AUTH_NO, "", true},
+		{TLS_CLIENT_AUTH_NO, "client", true},
+		{TLS_CLIENT_AUTH_NO, "untrusted", true},
+		{TLS_CLIENT_AUTH_OPTIONAL, "", true},
+		{TLS_CLIENT_AUTH_OPTIONAL, "client", true},
+		{TLS_CLIENT_AUTH_OPTIONAL, "untrusted", false},
+	}
+	certs := newTestCerts(t)
+	clients := map[string]*testCert{"": nil, "client": certs.client, "untrusted": certs.untrusted}
+	for _, tt := range tests {
+		t.Run(tt.authClients+"/"+tt.client, func(t *testing.T) {
+			_, _, tlsAddr := startTLSServer(t, certs, tt.authClients)
+			_, err := tlsPing(t, tlsAddr, clientTLSConfig(certs, clients[tt.client]))
+			if (err == nil) != tt.wantOK {
+				t.Errorf("got error %v, want success %v", err, tt.wantOK)
+			}
+		})
+	}
+}
+
+// CONFIG SET 修改证书后新的 TLS 连接使用新证书，已有的连接不受影响；
+// 证书文件不存在时 CONFIG SET 失败，配置和证书保持原样。
+func TestTLSConfigSetReloadsCertificate(t *testing.T) {
+	certs := newTestCerts(t)
+	_, addr, tlsAddr := startTLSServer(t, certs, TLS_CLIENT_AUTH_NO)
+	plain, err := net.DialTimeout("tcp", addr, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer plain.Close()
+	serverSerial := func() *big.Int {
+		t.Helper()
+		conn, err := tlsPing(t, tlsAddr, clientTLSConfig(certs, nil))
+		if err != nil {
+			t.Fatalf("TLS connection failed: %v", err)
+		}
+		return conn.ConnectionState().PeerCertificates[0].SerialNumber
+	}
+
+	old, err := tlsPing(t, tlsAddr, clientTLSConfig(certs, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := old.ConnectionState().PeerCertificates[0].SerialNumber; got.Cmp(certs.server.cert.SerialNumber) != 0 {
+		t.Fatalf("server presented serial %v, want %v", got, certs.server.cert.SerialNumber)
+	}
+	v, err := sendCommand(plain, "CONFIG", "SET", "tls-cert-file", certs.server2.certFile, "tls-key-file", certs.server2.keyFile)
+	if err != nil || v.Str != "OK" {
+		t.Fatalf("CONFIG SET: %+v, %v", v, err)
+	}
+	if got := serverSerial(); got.Cmp(certs.server2.cert.SerialNumber) != 0 {
+		t.Errorf("after reload server presented serial %v, want %v", got, certs.server2.cert.SerialNumber)
+	}
+	if v, err := sendCommand(old, "PING"); err != nil || v.Str != "PONG" {
+		t.Errorf("existing TLS connection after reload: %+v, %v", v, err)
+	}
+
+	missing := filepath.Join(t.TempDir(), "missing.crt")
+	if v, err := sendCommand(plain, "CONFIG", "SET", "tls-cert-file", missing); err != nil || v.Type != Error {
+		t.Errorf("CONFIG SET with a missing certificate: %+v, %v", v, err)
+	}
+	if v, err := sendCommand(plain, "CONFIG", "GET", "tls-cert-file"); err != nil || len(v.Array) != 2 || v.Array[1].Str != certs.server2.certFile {
+		t.Errorf("CONFIG GET after failed reload: %+v, %v", v, err)
+	}
+	if got := serverSerial(); got.Cmp(certs.server2.cert.SerialNumber) != 0 {
+		t.Errorf("after failed reload server presented serial %v, want %v", got, certs.server2.cert.SerialNumber)
+	}
+}
@@ -0,0 +1,403 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// 客户端缓存失效消息在 RESP2 下使用的频道，RESP3 的客户端直接收到推送消息
+const TRACKING_CHANNEL = "__redis__:invalidate"
+
+// 客户端缓存的跟踪模式，保存在 redisClient.trackingFlags 中
+const (
+	CLIENT_TRACKING        = 1 << 0 // 开启了 CLIENT TRACKING
+	CLIENT_TRACKING_BCAST  = 1 << 1 // 广播模式：按前缀通知，不记录读取的键
+	CLIENT_TRACKING_OPTIN  = 1 << 2 // 只记录 CLIENT CACHING yes 之后的命令读取的键
+	CLIENT_TRACKING_OPTOUT = 1 << 3 // 不记录 CLIENT CACHING no 之后的命令读取的键
+	CLIENT_TRACKING_NOLOOP = 1 << 4 // 不通知客户端自己修改的键
+)
+
+// trackingState 是服务器的失效表：默认模式下记录每个键被哪些客户端读取过，
+// 广播模式下记录每个前缀被哪些客户端关注。客户端的 trackingRedirBroken 同样由 mu 保护，
+// 其他客户端的协程发送失效消息时读取的 resp 在修改时也持有 mu。
+type trackingState struct {
+	mu       sync.Mutex
+	table    map[string]map[int64]struct{}        // 键 → 读取过它的客户端 ID
+	prefixes map[string]map[*redisClient]struct{} // 前缀 → 以广播模式关注它的客户端
+}
+
+func newTrackingState() *trackingState {
+	return &trackingState{
+		table:    make(map[string]map[int64]struct{}),
+		prefixes: make(map[string]map[*redisClient]struct{}),
+	}
+}
+
+// 为客户端开启跟踪。失效表中的客户端 ID 不随模式变化清理，多余的失效消息不影响正确性。
+func (t *trackingState) enable(c *redisClient, redirect int64, options int, prefixes []string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	c.trackingFlags = CLIENT_TRACKING | options
+	c.trackingRedirect = redirect
+	c.trackingRedirBroken = false
+	if options&CLIENT_TRACKING_BCAST == 0 {
+		return
+	}
+	if len(prefixes) == 0 {
+		prefixes = []string{""}
+	}
+	if c.trackingPrefixes == nil {
+		c.trackingPrefixes = make(map[string]struct{})
+	}
+	for _, prefix := range prefixes {
+		if _, ok := c.trackingPrefixes[prefix]; ok {
+			continue
+		}
+		c.trackingPrefixes[prefix] = struct{}{}
+		clients := t.prefixes[prefix]
+		if clients == nil {
+			clients = make(map[*redisClient]struct{})
+			t.prefixes[prefix] = clients
+		}
+		clients[c] = struct{}{}
+	}
+}
+
+// 关闭客户端的跟踪，客户端断开连接时同样调用。
+func (t *trackingState) disable(c *redisClient) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for prefix := range c.trackingPrefixes {
+		clients := t.prefixes[prefix]
+		delete(clients, c)
+		if len(clients) == 0 {
+			delete(t.prefixes, prefix)
+		}
+	}
+	c.trackingPrefixes = nil
+	c.trackingFlags = 0
+	c.trackingRedirect = 0
+	c.trackingRedirBroken = false
+	c.trackingCaching = false
+}
+
+// 检查广播模式的前缀之间以及与客户端已有的前缀之间是否重叠，重叠的前缀会让同一个键收到重复的通知。
+func (t *trackingState) checkPrefixCollisions(c *redisClient, prefixes []string) string {
+	overlap := func(a, b string) bool {
+		return strings.HasPrefix(a, b) || strings.HasPrefix(b, a)
+	}
+	for i, prefix := range prefixes {
+		for existing := range c.trackingPrefixes {
+			if overlap(prefix, existing) {
+				return fmt.Sprintf("ERR Prefix '%s' overlaps with an existing prefix '%s'. "+
+					"Prefixes for a single client must not overlap.", prefix, existing)
+			}
+		}
+		for _, other := range prefixes[i+1:] {
+			if overlap(prefix, other) {
+				return fmt.Sprintf("ERR Prefix '%s' overlaps with another provided prefix '%s'. "+
+					"Prefixes for a single client must not overlap.", prefix, other)
+			}
+		}
+	}
+	return ""
+}
+
+// 命令执行之后维护失效表：只读命令读取的键记录在 caller 名下，写命令修改的键通知所有读取过它们的客户端。
+// caller 是发出命令的客户端，脚本中的命令同样记在执行脚本的客户端名下。
+func (s *redisServer) trackingAfterCommand(caller *redisClient, cmd *redisCommand, args []string) {
+	if cmd.keys.first == 0 {
+		return
+	}
+	switch {
+	case cmd.flags&CMD_READONLY != 0:
+		if caller != nil && caller.trackingFlags&CLIENT_TRACKING != 0 && caller.trackingFlags&CLIENT_TRACKING_BCAST == 0 {
+			s.tracking.rememberKeys(caller, cmd.getKeys(args))
+		}
+	case cmd.flags&CMD_WRITE != 0:
+		for _, key := range cmd.getKeys(args) {
+			s.trackingInvalidateKey(caller, key)
+		}
+	}
+}
+
+// 把客户端读取的键记入失效表。OPTIN 模式下只记录 CLIENT CACHING yes 之后的命令，OPTOUT 模式下跳过 CLIENT CACHING no 之后的命令。
+func (t *trackingState) rememberKeys(c *redisClient, keys []string) {
+	optin := c.trackingFlags&CLIENT_TRACKING_OPTIN != 0
+	optout := c.trackingFlags&CLIENT_TRACKING_OPTOUT != 0
+	if (optin && !c.trackingCaching) || (optout && c.trackingCaching) {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, key := range keys {
+		ids := t.table[key]
+		if ids == nil {
+			ids = make(map[int64]struct{})
+			t.table[key] = ids
+		}
+		ids[c.id] = struct{}{}
+	}
+}
+
+// 键被修改或删除时通知读取过它的客户端以及关注它的前缀的广播模式客户端，
+// 并从失效表中移除这个键，客户端需要再次读取才会重新记录。writer 是修改键的客户端，可以为 nil。
+func (s *redisServer) trackingInvalidateKey(writer *redisClient, key string) {
+	t := s.tracking
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for prefix, clients := range t.prefixes {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		for c := range clients {
+			if c == writer && c.trackingFlags&CLIENT_TRACKING_NOLOOP != 0 {
+				continue
+			}
+			s.sendTrackingMessage(c, key)
+		}
+	}
+	ids, ok := t.table[key]
+	if !ok {
+		return
+	}
+	delete(t.table, key)
+	for id := range ids {
+		c := s.lookupClientByID(id)
+		if c == nil || c.trackingFlags&CLIENT_TRACKING == 0 || c.trackingFlags&CLIENT_TRACKING_BCAST != 0 {
+			continue // 客户端已经断开或者关闭了跟踪
+		}
+		if c == writer && c.trackingFlags&CLIENT_TRACKING_NOLOOP != 0 {
+			continue
+		}
+		s.sendTrackingMessage(c, key)
+	}
+}
+
+// 向客户端发送一条失效消息，调用方需持有 tracking.mu。
+// 设置了 REDIRECT 时发给重定向的客户端：RESP3 的客户端直接收到 invalidate 推送消息，
+// RESP2 的客户端需要订阅 __redis__:invalidate 才能收到。重定向的客户端已经断开时标记 trackingRedirBroken，
+// RESP3 的客户端会收到一条 tracking-redir-broken 推送消息。
+// RESP2 的连接不能在回复之间插入推送消息，没有重定向时不发送。
+func (s *redisServer) sendTrackingMessage(c *redisClient, key string) {
+	target := c
+	if c.trackingRedirect != 0 {
+		target = s.lookupClientByID(c.trackingRedirect)
+		if target == nil {
+			if !c.trackingRedirBroken && c.resp > 2 {
+				msg := &PushReply{Value: []Reply{
+					&BulkStringReply{Value: "tracking-redir-broken"}, &IntegerReply{Value: c.trackingRedirect},
+				}}
+				s.queueTrackingMessage(c, msg)
+			}
+			c.trackingRedirBroken = true
+			return
+		}
+	}
+	if target.resp > 2 {
+		msg := &PushReply{Value: []Reply{
+			&BulkStringReply{Value: "invalidate"},
+			&ArrayReply{Value: []Reply{&BulkStringReply{Value: key}}},
+		}}
+		s.queueTrackingMessage(target, msg)
+		return
+	}
+	if c.trackingRedirect == 0 {
+		return
+	}
+	ps := s.pubsub
+	ps.mu.RLock()
+	subscribed := len(target.pubsubChannels)+len(target.pubsubPatterns)+len(target.pubsubShardChannels) > 0
+	ps.mu.RUnlock()
+	if !subscribed {
+		return
+	}
+	msg := &PushReply{Value: []Reply{
+		&BulkStringReply{Value: "message"},
+		&BulkStringReply{Value: TRACKING_CHANNEL},
+		&ArrayReply{Value: []Reply{&BulkStringReply{Value: key}}},
+	}}
+	s.queueTrackingMessage(target, msg)
+}
+
+// 把失效消息放入客户端的输出缓冲区，按客户端的类别计入输出缓冲区上限。
+func (s *redisServer) queueTrackingMessage(c *redisClient, msg Reply) {
+	c.addReply(msg, c.clientType())
+}
+
+// 返回失效表中的键数量、所有键记录的客户端总数以及广播模式的前缀数量。
+func (t *trackingState) stats() (keys, items, prefixes int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, ids := range t.table {
+		items += len(ids)
+	}
+	return len(t.table), items, len(t.prefixes)
+}
+
+// CLIENT TRACKING <ON|OFF> [REDIRECT client-id] [PREFIX prefix ...] [BCAST] [OPTIN] [OPTOUT] [NOLOOP]
+func clientTrackingCommand(c *redisClient, args []string) {
+	var redirect int64
+	var prefixes []string
+	options := 0
+	for i := 3; i < len(args); i++ {
+		more := i+1 < len(args)
+		switch opt := strings.ToUpper(args[i]); {
+		case opt == "REDIRECT" && more:
+			i++
+			if redirect != 0 {
+				c.writeError("ERR A client can only redirect to a single other client")
+				return
+			}
+			id, err := strconv.ParseInt(args[i], 10, 64)
+			if err != nil {
+				c.writeError("ERR value is not an integer or out of range")
+				return
+			}
+			// 只在开启时检查目标客户端存在，之后它断开连接也可以
+			if c.server.lookupClientByID(id) == nil {
+				c.writeError("ERR The client ID you want redirect to does not exist")
+				return
+			}
+			redirect = id
+		case opt == "BCAST":
+			options |= CLIENT_TRACKING_BCAST
+		case opt == "OPTIN":
+			options |= CLIENT_TRACKING_OPTIN
+		case opt == "OPTOUT":
+			options |= CLIENT_TRACKING_OPTOUT
+		case opt == "NOLOOP":
+			options |= CLIENT_TRACKING_NOLOOP
+		case opt == "PREFIX" && more:
+			i++
+			prefixes = append(prefixes, args[i])
+		default:
+			c.writeError("ERR syntax error")
+			return
+		}
+	}
+
+	t := c.server.tracking
+	switch strings.ToUpper(args[2]) {
+	case "ON":
+		tracking := c.trackingFlags&CLIENT_TRACKING != 0
+		switch {
+		case options&CLIENT_TRACKING_BCAST == 0 && len(prefixes) > 0:
+			c.writeError("ERR PREFIX option requires BCAST mode to be enabled")
+		case tracking && (c.trackingFlags&CLIENT_TRACKING_BCAST != 0) != (options&CLIENT_TRACKING_BCAST != 0):
+			c.writeError("ERR You can't switch BCAST mode on/off before disabling tracking for this client, " +
+				"and then re-enabling it with a different mode.")
+		case options&CLIENT_TRACKING_BCAST != 0 && options&(CLIENT_TRACKING_OPTIN|CLIENT_TRACKING_OPTOUT) != 0:
+			c.writeError("ERR OPTIN and OPTOUT are not compatible with BCAST")
+		case options&CLIENT_TRACKING_OPTIN != 0 && options&CLIENT_TRACKING_OPTOUT != 0:
+			c.writeError("ERR You can't use both OPTIN and OPTOUT")
+		case tracking && (options&CLIENT_TRACKING_OPTIN != 0 && c.trackingFlags&CLIENT_TRACKING_OPTOUT != 0 ||
+			options&CLIENT_TRACKING_OPTOUT != 0 && c.trackingFlags&CLIENT_TRACKING_OPTIN != 0):
+			c.writeError("ERR You can't switch OPTIN/OPTOUT mode before disabling tracking for this client, " +
+				"and then re-enabling it with a different mode.")
+		default:
+			if options&CLIENT_TRACKING_BCAST != 0 {
+				t.mu.Lock()
+				errReply := t.checkPrefixCollisions(c, prefixes)
+				t.mu.Unlock()
+				if errReply != "" {
+					c.writeError(errReply)
+					return
+				}
+			}
+			t.enable(c, redirect, options, prefixes)
+			c.writeStatus("OK")
+		}
+	case "OFF":
+		t.disable(c)
+		c.writeStatus("OK")
+	default:
+		c.writeError("ERR syntax error")
+	}
+}
+
+// CLIENT CACHING <YES|NO>：只对下一条命令生效，事务中对整个事务生效。
+func clientCachingCommand(c *redisClient, args []string) {
+	if c.trackingFlags&CLIENT_TRACKING == 0 {
+		c.writeError("ERR CLIENT CACHING can be called only when the client is in tracking mode with OPTIN or OPTOUT mode enabled")
+		return
+	}
+	switch strings.ToUpper(args[2]) {
+	case "YES":
+		if c.trackingFlags&CLIENT_TRACKING_OPTIN == 0 {
+			c.writeError("ERR CLIENT CACHING YES is only valid when tracking is enabled in OPTIN mode.")
+			return
+		}
+	case "NO":
+		if c.trackingFlags&CLIENT_TRACKING_OPTOUT == 0 {
+			c.writeError("ERR CLIENT CACHING NO is only valid when tracking is enabled in OPTOUT mode.")
+			return
+		}
+	default:
+		c.writeError("ERR syntax error")
+		return
+	}
+	c.trackingCaching = true
+	c.writeStatus("OK")
+}
+
+// CLIENT GETREDIR：没有开启跟踪时返回 -1，开启了但没有重定向时返回 0。
+func clientGetredirCommand(c *redisClient, args []string) {
+	redirect := int64(-1)
+	if c.trackingFlags&CLIENT_TRACKING != 0 {
+		redirect = c.trackingRedirect
+	}
+	c.writeReply(&IntegerReply{Value: redirect})
+}
+
+// CLIENT TRACKINGINFO
+func clientTrackinginfoCommand(c *redisClient, args []string) {
+	t := c.server.tracking
+	t.mu.Lock()
+	flags := []Reply{}
+	if c.trackingFlags&CLIENT_TRACKING == 0 {
+		flags = append(flags, &BulkStringReply{Value: "off"})
+	} else {
+		flags = append(flags, &BulkStringReply{Value: "on"})
+		for _, f := range []struct {
+			flag int
+			name string
+		}{
+			{CLIENT_TRACKING_BCAST, "bcast"},
+			{CLIENT_TRACKING_OPTIN, "optin"},
+			{CLIENT_TRACKING_OPTOUT, "optout"},
+			{CLIENT_TRACKING_NOLOOP, "noloop"},
+		} {
+			if c.trackingFlags&f.flag != 0 {
+				flags = append(flags, &BulkStringReply{Value: f.name})
+			}
+		}
+		if c.trackingCaching {
+			if c.trackingFlags&CLIENT_TRACKING_OPTIN != 0 {
+				flags = append(flags, &BulkStringReply{Value: "caching-yes"})
+			} else {
+				flags = append(flags, &BulkStringReply{Value: "caching-no"})
+			}
+		}
+		if c.trackingRedirBroken {
+			flags = append(flags, &BulkStringReply{Value: "broken_redirect"})
+		}
+	}
+	redirect := int64(-1)
+	if c.trackingFlags&CLIENT_TRACKING != 0 {
+		redirect = c.trackingRedirect
+	}
+	prefixes := []Reply{}
+	for prefix := range c.trackingPrefixes {
+		prefixes = append(prefixes, &BulkStringReply{Value: prefix})
+	}
+	t.mu.Unlock()
+
+	c.writeReply(&MapReply{Value: []Reply{
+		&BulkStringReply{Value: "flags"}, &SetReply{Value: flags},
+		&BulkStringReply{Value: "redirect"}, &IntegerReply{Value: redirect},
+		&BulkStringReply{Value: "prefixes"}, &ArrayReply{Value: prefixes},
+	}})
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"bufio"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// 创建一个登记在服务器上的测试客户端，失效消息按 ID 查找客户端。
+func newTrackingClient(t *testing.T, s *redisServer) *redisClient {
+	c := newTestClient(t, s)
+	s.linkClient(c)
+	return c
+}
+
+// 取出客户端输出缓冲区中的数据，其他客户端的命令写入的失效消息也在其中。
+func takeOutput(c *redisClient) string {
+	c.outMu.Lock()
+	defer c.outMu.Unlock()
+	out := string(c.outBuf)
+	c.outBuf = c.outBuf[:0]
+	return out
+}
+
+// 在连接上发送一条命令并读取一个回复，同一个连接的回复和推送消息共用 r。
+func pipeCommand(conn net.Conn, r *bufio.Reader, args ...string) (RESPValue, error) {
+	cmd := AppendAggregateLen(nil, Array, len(args))
+	for _, arg := range args {
+		cmd = AppendBulkString(cmd, arg)
+	}
+	if _, err := conn.Write(cmd); err != nil {
+		return RESPValue{}, err
+	}
+	return ParseRESP(r)
+}
+
+// RESP3 客户端收到的 invalidate 推送消息。
+func invalidatePush(key string) string {
+	return ">2\r\n$10\r\ninvalidate\r\n*1\r\n$" + strconv.Itoa(len(key)) + "\r\n" + key + "\r\n"
+}
+
+// 默认模式下读取过的键被修改时通知一次，之后需要再次读取才会重新记录。
+func TestTrackingDefaultMode(t *testing.T) {
+	chdirTemp(t)
+	s := newRedisServer(newRedisConfig())
+	reader, writer := newTrackingClient(t, s), newTrackingClient(t, s)
+	commandReply(reader, "HELLO", "3")
+	commandReply(reader, "CLIENT", "TRACKING", "ON")
+	commandReply(reader, "GET", "key")
+	takeOutput(reader)
+
+	commandReply(writer, "SET", "other", "v")
+	if got := takeOutput(reader); got != "" {
+		t.Errorf("got %q for a key that was not read", got)
+	}
+	commandReply(writer, "SET", "key", "v")
+	if got, want := takeOutput(reader), invalidatePush("key"); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	commandReply(writer, "SET", "key", "v2")
+	if got := takeOutput(reader); got != "" {
+		t.Errorf("got %q for a key that was not read again", got)
+	}
+}
+
+// 广播模式按前缀通知，不需要读取过键，前缀之间不能重叠。
+func TestTrackingBcastPrefixes(t *testing.T) {
+	chdirTemp(t)
+	s := newRedisServer(newRedisConfig())
+	reader, writer := newTrackingClient(t, s), newTrackingClient(t, s)
+	commandReply(reader, "HELLO", "3")
+	if got := commandReply(reader, "CLIENT", "TRACKING", "ON", "BCAST", "PREFIX", "user:"); got != "+OK\r\n" {
+		t.Fatalf("CLIENT TRACKING: %q", got)
+	}
+	if got := commandReply(reader, "CLIENT", "TRACKING", "ON", "BCAST", "PREFIX", "use"); !strings.HasPrefix(got, "-ERR Prefix 'use' overlaps") {
+		t.Errorf("overlapping prefix: %q", got)
+	}
+	takeOutput(reader)
+
+	commandReply(writer, "SET", "user:1", "v")
+	if got, want := takeOutput(reader), invalidatePush("user:1"); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	commandReply(writer, "SET", "item:1", "v")
+	if got := takeOutput(reader); got != "" {
+		t.Errorf("got %q for a key outside the prefix", got)
+	}
+}
+
+// OPTIN 只记录 CLIENT CACHING yes 之后的一条命令，OPTOUT 跳过 CLIENT CACHING no 之后的一条命令。
+func TestTrackingOptinOptout(t *testing.T) {
+	chdirTemp(t)
+	s := newRedisServer(newRedisConfig())
+	optin, optout, writer := newTrackingClient(t, s), newTrackingClient(t, s), newTrackingClient(t, s)
+	commandReply(optin, "HELLO", "3")
+	commandReply(optin, "CLIENT", "TRACKING", "ON", "OPTIN")
+	if got := commandReply(optin, "CLIENT", "CACHING", "NO"); !strings.HasPrefix(got, "-ERR CLIENT CACHING NO is only valid") {
+		t.Errorf("CLIENT CACHING NO in OPTIN mode: %q", got)
+	}
+	commandReply(optin, "GET", "a")
+	commandReply(optin, "CLIENT", "CACHING", "YES")
+	commandReply(optin, "GET", "b")
+	commandReply(optin, "GET", "c")
+	takeOutput(optin)
+
+	commandReply(optout, "HELLO", "3")
+	commandReply(optout, "CLIENT", "TRACKING", "ON", "OPTOUT")
+	commandReply(optout, "GET", "a")
+	commandReply(optout, "CLIENT", "CACHING", "NO")
+	commandReply(optout, "GET", "b")
+	commandReply(optout, "GET", "c")
+	takeOutput(optout)
+
+	tests := []struct {
+		key            string
+		optinNotified  bool
+		optoutNotified bool
+	}{
+		{"a", false, true},
+		{"b", true, false},
+		{"c", false, true},
+	}
+	for _, tt := range tests {
+		commandReply(writer, "SET", tt.key, "v")
+		if got := takeOutput(optin) == invalidatePush(tt.key); got != tt.optinNotified {
+			t.Errorf("OPTIN client notified for %q: %v, want %v", tt.key, got, tt.optinNotified)
+		}
+		if got := takeOutput(optout) == invalidatePush(tt.key); got != tt.optoutNotified {
+			t.Errorf("OPTOUT client notified for %q: %v, want %v", tt.key, got, tt.optoutNotified)
+		}
+	}
+}
+
+// NOLOOP 的客户端修改自己读取过的键时不会收到通知，其他客户端修改时仍然收到。
+func TestTrackingNoloop(t *testing.T) {
+	chdirTemp(t)
+	s := newRedisServer(newRedisConfig())
+	loop, noloop, writer := newTrackingClient(t, s), newTrackingClient(t, s), newTrackingClient(t, s)
+	for _, c := range []*redisClient{loop, noloop} {
+		commandReply(c, "HELLO", "3")
+	}
+	commandReply(loop, "CLIENT", "TRACKING", "ON")
+	commandReply(noloop, "CLIENT", "TRACKING", "ON", "NOLOOP")
+
+	commandReply(loop, "GET", "key")
+	if got, want := commandReply(loop, "SET", "key", "v"), "+OK\r\n"+invalidatePush("key"); got != want {
+		t.Errorf("without NOLOOP: got %q, want %q", got, want)
+	}
+	commandReply(noloop, "GET", "key")
+	if got := commandReply(noloop, "SET", "key", "v"); got != "+OK\r\n" {
+		t.Errorf("with NOLOOP: got %q, want only the reply", got)
+	}
+	commandReply(noloop, "GET", "key")
+	takeOutput(noloop)
+	commandReply(writer, "SET", "key", "v")
+	if got, want := takeOutput(noloop), invalidatePush("key"); got != want {
+		t.Errorf("NOLOOP client modified by another client: got %q, want %q", got, want)
+	}
+}
+
+// RESP2 的客户端把失效消息重定向到订阅了 __redis__:invalidate 的客户端。
+func TestTrackingRedirectRESP2(t *testing.T) {
+	chdirTemp(t)
+	s := newRedisServer(newRedisConfig())
+	reader, subscriber, writer := newTrackingClient(t, s), newTrackingClient(t, s), newTrackingClient(t, s)
+	commandReply(subscriber, "SUBSCRIBE", TRACKING_CHANNEL)
+	id := strconv.FormatInt(subscriber.id, 10)
+	if got := commandReply(reader, "CLIENT", "TRACKING", "ON", "REDIRECT", id); got != "+OK\r\n" {
+		t.Fatalf("CLIENT TRACKING: %q", got)
+	}
+	if got := commandReply(reader, "CLIENT", "GETREDIR"); got != ":"+id+"\r\n" {
+		t.Errorf("CLIENT GETREDIR: %q", got)
+	}
+	commandReply(reader, "GET", "key")
+	takeOutput(reader)
+	takeOutput(subscriber)
+
+	commandReply(writer, "SET", "key", "v")
+	want := "*3\r\n$7\r\nmessage\r\n$20\r\n__redis__:invalidate\r\n*1\r\n$3\r\nkey\r\n"
+	if got := takeOutput(subscriber); got != want {
+		t.Errorf("redirected message: got %q, want %q", got, want)
+	}
+	if got := takeOutput(reader); got != "" {
+		t.Errorf("tracking client got %q", got)
+	}
+}
+
+// 重定向的客户端断开之后，RESP3 的客户端收到一次 tracking-redir-broken 推送消息。
+func TestTrackingRedirBroken(t *testing.T) {
+	chdirTemp(t)
+	s := newRedisServer(newRedisConfig())
+	reader, target, writer := newTrackingClient(t, s), newTrackingClient(t, s), newTrackingClient(t, s)
+	id := strconv.FormatInt(target.id, 10)
+	commandReply(reader, "HELLO", "3")
+	commandReply(reader, "CLIENT", "TRACKING", "ON", "REDIRECT", id)
+	commandReply(reader, "GET", "key")
+	commandReply(reader, "GET", "other")
+	takeOutput(reader)
+	s.unlinkClient(target)
+
+	commandReply(writer, "SET", "key", "v")
+	want := ">2\r\n$21\r\ntracking-redir-broken\r\n:" + id + "\r\n"
+	if got := takeOutput(reader); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	commandReply(writer, "SET", "other", "v")
+	if got := takeOutput(reader); got != "" {
+		t.Errorf("broken redirect reported twice: %q", got)
+	}
+	if got := commandReply(reader, "CLIENT", "TRACKINGINFO"); !strings.Contains(got, "broken_redirect") {
+		t.Errorf("CLIENT TRACKINGINFO: %q does not contain broken_redirect", got)
+	}
+}
+
+// 两个连接在各自的协程中执行命令：一个客户端读取键，另一个协程中的客户端修改它，失效消息送达读取的客户端。
+func TestTrackingInvalidateAcrossConnections(t *testing.T) {
+	chdirTemp(t)
+	s := newRedisServer(newRedisConfig())
+	connect := func() (net.Conn, *bufio.Reader) {
+		conn, peer := net.Pipe()
+		t.Cleanup(func() { peer.Close() })
+		c := newRedisClient(conn, s)
+		s.linkClient(c)
+		go c.handleRequest()
+		peer.SetDeadline(time.Now().Add(5 * time.Second))
+		return peer, bufio.NewReader(peer)
+	}
+	call := func(conn net.Conn, r *bufio.Reader, args ...string) RESPValue {
+		v, err := pipeCommand(conn, r, args...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+
+	readerConn, reader := connect()
+	call(readerConn, reader, "HELLO", "3")
+	call(readerConn, reader, "CLIENT", "TRACKING", "ON")
+	call(readerConn, reader, "GET", "key")
+
+	writerConn, writer := connect()
+	done := make(chan RESPValue)
+	go func() {
+		v, err := pipeCommand(writerConn, writer, "SET", "key", "v")
+		if err != nil {
+			v = RESPValue{Type: Error, Str: err.Error()}
+		}
+		done <- v
+	}()
+
+	msg, err := ParseRESP(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Type != Push || len(msg.Array) != 2 || msg.Array[0].Str != "invalidate" ||
+		len(msg.Array[1].Array) != 1 || msg.Array[1].Array[0].Str != "key" {
+		t.Errorf("got %+v, want an invalidate push for key", msg)
+	}
+	if v := <-done; v.Str != "OK" {
+		t.Errorf("SET: got %+v", v)
+	}
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// stringMatch 递归的最大深度，防止恶意的模式耗尽栈空间，与 Redis 相同
+const STRING_MATCH_MAX_NESTING = 1000
+
+// 判断 str 是否匹配 glob 风格的 pattern，支持 *、?、[...] 和 \ 转义，规则与 Redis 的 stringmatchlen 相同。
+func stringMatch(pattern, str string, nocase bool) bool {
+	skipLongerMatches := false
+	return stringMatchImpl(pattern, str, nocase, &skipLongerMatches, 0)
+}
+
+// 直接在子串上递归，不分配内存。某个 * 之后的模式与字符串的任何后缀都不匹配时设置 skipLongerMatches，
+// 前面的 * 再匹配更长的内容只会让剩下的模式从更靠后的位置开始，同样不可能匹配，可以立即结束，
+// 避免 a*a*a*...b 这类模式的指数级回溯。
+func stringMatchImpl(p, s string, nocase bool, skipLongerMatches *bool, nesting int) bool {
+	if nesting > STRING_MATCH_MAX_NESTING {
+		return false
+	}
+	for len(p) > 0 && len(s) > 0 {
+		switch p[0] {
+		case '*':
+			for len(p) > 1 && p[1] == '*' {
+				p = p[1:]
+			}
+			if len(p) == 1 {
+				return true // 末尾的 * 匹配剩下的所有内容
+			}
+			for ; len(s) > 0; s = s[1:] {
+				if stringMatchImpl(p[1:], s, nocase, skipLongerMatches, nesting+1) {
+					return true
+				}
+				if *skipLongerMatches {
+					return false
+				}
+			}
+			*skipLongerMatches = true
+			return false
+		case '?':
+			s = s[1:]
+		case '[':
+			p = p[1:]
+			not := len(p) > 0 && p[0] == '^'
+			if not {
+				p = p[1:]
+			}
+			match := false
+			for len(p) > 0 && p[0] != ']' {
+				switch {
+				case p[0] == '\\' && len(p) >= 2:
+					p = p[1:]
+					if p[0] == s[0] {
+						match = true
+					}
+				case len(p) >= 3 && p[1] == '-':
+					start, end, c := p[0], p[2], s[0]
+					if start > end {
+						start, end = end, start
+					}
+					if nocase {
+						start, end, c = lower(start), lower(end), lower(c)
+					}
+					p = p[2:]
+					if c >= start && c <= end {
+						match = true
+					}
+				default:
+					if equalByte(p[0], s[0], nocase) {
+						match = true
+					}
+				}
+				p = p[1:]
+			}
+			if len(p) == 0 {
+				return false // 缺少右括号，按不匹配处理
+			}
+			if not {
+				match = !match
+			}
+			if !match {
+				return false
+			}
+			s = s[1:]
+		case '\\':
+			if len(p) >= 2 {
+				p = p[1:]
+			}
+			fallthrough
+		default:
+			if !equalByte(p[0], s[0], nocase) {
+				return false
+			}
+			s = s[1:]
+		}
+		p = p[1:]
+	}
+	// 字符串已经用完，剩下的 * 都匹配空串
+	if len(s) == 0 {
+		for len(p) > 0 && p[0] == '*' {
+			p = p[1:]
+		}
+	}
+	return len(p) == 0 && len(s) == 0
+}
+
+func lower(c byte) byte {
+	if c >= 'A' && c <= 'Z' {
+		return c + 'a' - 'A'
+	}
+	return c
+}
+
+func equalByte(a, b byte, nocase bool) bool {
+	if nocase {
+		return lower(a) == lower(b)
+	}
+	return a == b
+}
+
+// 按 Redis sdssplitargs 的规则把一行文本拆分成参数，支持双引号（可含 \n、\xHH 等转义）和单引号。
+func splitArgs(line string) ([]string, error) {
+	var args []string
+	i := 0
+	for {
+		for i < len(line) && isSpace(line[i]) {
+			i++
+		}
+		if i >= len(line) {
+			return args, nil
+		}
+
+		var cur []byte
+		inq, insq := false, false // 是否处于双引号 / 单引号中
+		for done := false; !done; {
+			if inq {
+				if i >= len(line) {
+					return nil, errUnbalancedQuotes
+				}
+				switch {
+				case line[i] == '\\' && i+3 < len(line) && line[i+1] == 'x' && isHexDigit(line[i+2]) && isHexDigit(line[i+3]):
+					cur = append(cur, hexDigitValue(line[i+2])*16+hexDigitValue(line[i+3]))
+					i += 3
+				case line[i] == '\\' && i+1 < len(line):
+					i++
+					switch line[i] {
+					case 'n':
+						cur = append(cur, '\n')
+					case 'r':
+						cur = append(cur, '\r')
+					case 't':
+						cur = append(cur, '\t')
+					case 'b':
+						cur = append(cur, '\b')
+					case 'a':
+						cur = append(cur, '\a')
+					default:
+						cur = append(cur, line[i])
+					}
+				case line[i] == '"':
+					// 右引号后面必须是空白或行尾
+					if i+1 < len(line) && !isSpace(line[i+1]) {
+						return nil, errUnbalancedQuotes
+					}
+					done = true
+				default:
+					cur = append(cur, line[i])
+				}
+			} else if insq {
+				if i >= len(line) {
+					return nil, errUnbalancedQuotes
+				}
+				switch {
+				case line[i] == '\\' && i+1 < len(line) && line[i+1] == '\'':
+					i++
+					cur = append(cur, '\'')
+				case line[i] == '\'':
+					if i+1 < len(line) && !isSpace(line[i+1]) {
+						return nil, errUnbalancedQuotes
+					}
+					done = true
+				default:
+					cur = append(cur, line[i])
+				}
+			} else {
+				if i >= len(line) {
+					break
+				}
+				switch line[i] {
+				case ' ', '\n', '\r', '\t', 0:
+					done = true
+				case '"':
+					inq = true
+				case '\'':
+					insq = true
+				default:
+					cur = append(cur, line[i])
+				}
+			}
+			if i < len(line) {
+				i++
+			}
+		}
+		args = append(args, string(cur))
+	}
+}
+
+var errUnbalancedQuotes = errors.New("unbalanced quotes")
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\n' || c == '\r' || c == '\t' || c == '\v' || c == '\f'
+}
+
+func isHexDigit(c byte) bool {
+	return (c >= '0' && c <= '9') || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+}
+
+func hexDigitValue(c byte) byte {
+	switch {
+	case c >= '0' && c <= '9':
+		return c - '0'
+	case c >= 'a' && c <= 'f':
+		return c - 'a' + 10
+	default:
+		return c - 'A' + 10
+	}
+}
+
+// 原子地写入文件：先写入同目录下名为 tmpName 的临时文件并刷盘，再重命名为 filename。
+// 写入中途崩溃时原来的文件保持不变。
+func atomicWriteFile(filename, tmpName string, write func(w io.Writer) error) error {
+	dir := filepath.Dir(filename)
+	tmp := filepath.Join(dir, tmpName)
+	file, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if err = write(file); err == nil {
+		err = file.Sync()
+	}
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, filename)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	fsyncDir(dir)
+	return nil
+}
+
+// 刷新目录项，确保重命名本身也落盘；不支持的平台上忽略错误。
+func fsyncDir(dir string) {
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStringMatch(t *testing.T) {
+	tests := []struct {
+		pattern, str string
+		nocase       bool
+		want         bool
+	}{
+		{"*", "", false, true},
+		{"*", "anything", false, true},
+		{"", "", false, true},
+		{"", "a", false, false},
+		{"a*", "a", false, true},
+		{"a*b", "ab", false, true},
+		{"a*b", "axxb", false, true},
+		{"a*b", "axxbc", false, false},
+		{"*a*", "bab", false, true},
+		{"a*?", "a", false, false},
+		{"a**b*", "ab", false, true},
+		{"h?llo", "hello", false, true},
+		{"h?llo", "hllo", false, false},
+		{"h[ae]llo", "hallo", false, true},
+		{"h[^e]llo", "hello", false, false},
+		{"h[a-b]llo", "hbllo", false, true},
+		{"h[b-a]llo", "hallo", false, true},
+		{"h[A-Z]llo", "hello", true, true},
+		{"h[\\]]llo", "h]llo", false, true},
+		{"h[ab", "ha", false, false},
+		{"h\\*llo", "h*llo", false, true},
+		{"h\\*llo", "hello", false, false},
+		{"HELLO", "hello", true, true},
+		{"HELLO", "hello", false, false},
+		{"news.*", "news.tech", false, true},
+		{"*.tech", "news.sport", false, false},
+	}
+	for _, tt := range tests {
+		if got := stringMatch(tt.pattern, tt.str, tt.nocase); got != tt.want {
+			t.Errorf("stringMatch(%q, %q, %v) = %v, want %v", tt.pattern, tt.str, tt.nocase, got, tt.want)
+		}
+	}
+}
+
+// 多个 * 的模式不能产生指数级回溯，匹配过程不分配内存。
+func TestStringMatchPathological(t *testing.T) {
+	pattern := strings.Repeat("a*", 30) + "b"
+	str := strings.Repeat("a", 1000)
+	start := time.Now()
+	if stringMatch(pattern, str, false) {
+		t.Error("pattern without a b matched")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("matching took %v", elapsed)
+	}
+	if !stringMatch(pattern, str+"b", false) {
+		t.Error("pattern did not match")
+	}
+	// 超过嵌套深度限制的模式不匹配
+	if stringMatch(strings.Repeat("*a", STRING_MATCH_MAX_NESTING+1), strings.Repeat("a", STRING_MATCH_MAX_NESTING+1), false) {
+		t.Error("pattern nested deeper than the limit matched")
+	}
+	if allocs := testing.AllocsPerRun(10, func() { stringMatch(pattern, str, true) }); allocs != 0 {
+		t.Errorf("stringMatch allocated %v times", allocs)
+	}
+}